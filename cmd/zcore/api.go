@@ -5,6 +5,10 @@ License: HGL General License <https://evgnomon.org/docs/hgl>
 package main
 
 import (
+	"context"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/acl"
 	"github.com/evgnomon/zygote/lib/cluster/controller"
 	"github.com/evgnomon/zygote/lib/cluster/server"
 	"github.com/evgnomon/zygote/lib/cluster/http"
@@ -13,19 +17,27 @@ import (
 
 var logger = utils.NewLogger()
 
+const aclReloadInterval = 5 * time.Second
+
 func main() {
 	logger.Info("Starting Zygote API server...")
 	s, err := server.NewServer()
 	logger.FatalIfErr("Create server", err)
-	dbC, err := controller.NewSQLQueryController()
+	enforcer, err := acl.NewEnforcer(acl.DefaultPath())
+	logger.FatalIfErr("Load ACL policy", err)
+	go enforcer.Watch(context.Background(), aclReloadInterval)
+	dbC, err := controller.NewSQLQueryController(enforcer)
 	logger.FatalIfErr("Create database controller", err)
+	docC, err := controller.NewDocumentController(enforcer)
+	logger.FatalIfErr("Create document controller", err)
 	hw := controller.NewHelloWorldController()
-	rc, err := controller.NewRedisQueryController(nil)
+	rc, err := controller.NewRedisQueryController(nil, enforcer)
 	logger.FatalIfErr("Create redis controller", err)
 	tap := controller.NewRelayController("", "http://localhost:3000/")
 	docs := controller.NewRelayController("docs", "http://localhost:3001/")
 	err = s.AddControllers([]http.Controller{
 		dbC,
+		docC,
 		hw,
 		rc,
 		tap,
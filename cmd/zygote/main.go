@@ -27,6 +27,7 @@ func main() {
 			return cc.Action(nil)
 		},
 		Commands: []*cli.Command{
+			commands.BackupCommand(),
 			commands.BlueprintCommand(),
 			commands.BuildCommand(),
 			commands.CCommand(),
@@ -43,7 +44,11 @@ func main() {
 			commands.OpenDiffs(),
 			commands.OpenPackages(),
 			commands.QCommand(),
+			commands.ReshardCommand(),
+			commands.RestoreCommand(),
 			commands.SQLCommand(),
+			commands.SchemaCommand(),
+			commands.SecretsCommand(),
 			commands.SmokerCommand(),
 			commands.VaultCommand(),
 		},
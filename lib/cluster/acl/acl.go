@@ -0,0 +1,400 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+// Package acl decides which Redis commands and SQL statements the users of
+// the query API may run. Users are the common names of their client
+// certificates.
+package acl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// FileEnvVar overrides the path of the policy file
+const FileEnvVar = "ZCORE_ACL_FILE"
+
+// Effects of a rule
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// Resources a request runs against
+const (
+	ResourceMem = "mem"
+	ResourceSQL = "sql"
+)
+
+const anyone = "*"
+
+var logger = utils.NewLogger()
+
+// Policy is the typed model of a policy file. A request is denied when a
+// deny rule matches it, allowed when an allow rule matches it and decided by
+// the default effect otherwise.
+type Policy struct {
+	Default string              `toml:"default"`
+	Groups  map[string][]string `toml:"groups"`
+	Rules   []Rule              `toml:"rules"`
+}
+
+// Rule allows or denies Redis commands or SQL statements to users and
+// groups. A rule applies to Redis when it lists commands or keys and to SQL
+// when it lists statements or databases, an empty list matches anything.
+type Rule struct {
+	Name   string   `toml:"name"`
+	Effect string   `toml:"effect"`
+	Users  []string `toml:"users"`
+	Groups []string `toml:"groups"`
+	// MemCommands are command names such as GET or CONFIG SET, CONFIG
+	// covers all its subcommands
+	MemCommands []string `toml:"mem_commands"`
+	// MemKeys are glob patterns of keys, an allow rule needs every key of a
+	// command to match and a deny rule any of them. Commands without keys,
+	// such as FLUSHALL or SCAN, never match a rule with keys.
+	MemKeys []string `toml:"mem_keys"`
+	// SQLStatements are statement types such as SELECT or DROP DATABASE,
+	// DROP covers all its object types
+	SQLStatements []string `toml:"sql_statements"`
+	// SQLDatabases are glob patterns of the databases a statement uses
+	SQLDatabases []string `toml:"sql_databases"`
+}
+
+// AllowAll is the policy of a server without a policy file
+func AllowAll() *Policy {
+	return &Policy{Default: Allow}
+}
+
+// DefaultPath returns the policy file of the server, set by ZCORE_ACL_FILE
+// or under ~/.config/zygote
+func DefaultPath() string {
+	if path := os.Getenv(FileEnvVar); path != "" {
+		return path
+	}
+	return filepath.Join(utils.UserHome(), ".config", "zygote", "acl.toml")
+}
+
+// Parse decodes a policy document. The default effect is deny.
+func Parse(doc []byte) (*Policy, error) {
+	p := &Policy{Default: Deny}
+	if err := toml.Unmarshal(doc, p); err != nil {
+		return nil, fmt.Errorf("failed to parse ACL policy: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks the effects of the policy and its rules
+func (p *Policy) Validate() error {
+	if p.Default != Allow && p.Default != Deny {
+		return fmt.Errorf("default effect must be %s or %s, got %q", Allow, Deny, p.Default)
+	}
+	for i, r := range p.Rules {
+		if r.Effect != Allow && r.Effect != Deny {
+			return fmt.Errorf("rule %s: effect must be %s or %s, got %q", r.label(i), Allow, Deny, r.Effect)
+		}
+		if len(r.Users) == 0 && len(r.Groups) == 0 {
+			return fmt.Errorf("rule %s: users or groups are required", r.label(i))
+		}
+		if !r.appliesTo(ResourceMem) && !r.appliesTo(ResourceSQL) {
+			return fmt.Errorf("rule %s: mem or sql fields are required", r.label(i))
+		}
+		for _, g := range r.Groups {
+			if _, ok := p.Groups[g]; !ok {
+				return fmt.Errorf("rule %s: unknown group %s", r.label(i), g)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Rule) label(i int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+func (r *Rule) appliesTo(resource string) bool {
+	if resource == ResourceMem {
+		return len(r.MemCommands) > 0 || len(r.MemKeys) > 0
+	}
+	return len(r.SQLStatements) > 0 || len(r.SQLDatabases) > 0
+}
+
+func (p *Policy) covers(r *Rule, user string) bool {
+	if slices.Contains(r.Users, anyone) || slices.Contains(r.Users, user) {
+		return true
+	}
+	for _, g := range r.Groups {
+		if slices.Contains(p.Groups[g], user) {
+			return true
+		}
+	}
+	return false
+}
+
+// request is what a rule is matched against, an action such as a command or
+// a statement type and the keys or databases it touches
+type request struct {
+	resource string
+	action   string
+	targets  []string
+}
+
+func (r *Rule) actions(resource string) []string {
+	if resource == ResourceMem {
+		return r.MemCommands
+	}
+	return r.SQLStatements
+}
+
+func (r *Rule) targets(resource string) []string {
+	if resource == ResourceMem {
+		return r.MemKeys
+	}
+	return r.SQLDatabases
+}
+
+func (r *Rule) matches(req *request) bool {
+	if !r.appliesTo(req.resource) {
+		return false
+	}
+	if actions := r.actions(req.resource); len(actions) > 0 && !slices.ContainsFunc(actions, func(a string) bool {
+		return matchAction(a, req.action)
+	}) {
+		return false
+	}
+	patterns := r.targets(req.resource)
+	if len(patterns) == 0 {
+		return true
+	}
+	matchesAny := func(target string) bool {
+		return slices.ContainsFunc(patterns, func(p string) bool { return Glob(p, target) })
+	}
+	if r.Effect == Deny {
+		return slices.ContainsFunc(req.targets, matchesAny)
+	}
+	// a request without targets reaches beyond the patterns
+	if len(req.targets) == 0 {
+		return false
+	}
+	for _, t := range req.targets {
+		if !matchesAny(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAction reports whether a rule action covers a request action, as
+// CONFIG covers CONFIG SET
+func matchAction(pattern, action string) bool {
+	pattern = strings.ToUpper(strings.Join(strings.Fields(pattern), " "))
+	return pattern == anyone || pattern == action || strings.HasPrefix(action, pattern+" ")
+}
+
+// decide returns the denial of a request, nil if it is allowed
+func (p *Policy) decide(user string, req *request) *Denial {
+	allowed := p.Default == Allow
+	rule := "default"
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if !p.covers(r, user) || !r.matches(req) {
+			continue
+		}
+		if r.Effect == Deny {
+			allowed, rule = false, r.label(i)
+			break
+		}
+		if !allowed {
+			allowed, rule = true, r.label(i)
+		}
+	}
+	if allowed {
+		return nil
+	}
+	return &Denial{
+		Code:     "forbidden",
+		User:     user,
+		Resource: req.resource,
+		Action:   req.action,
+		Targets:  req.targets,
+		Rule:     rule,
+	}
+}
+
+// Denial is a request the policy does not allow. It is sent to the client as
+// the body of a 403 response.
+type Denial struct {
+	Code     string   `json:"error"`
+	User     string   `json:"user"`
+	Resource string   `json:"resource"`
+	Action   string   `json:"action"`
+	Targets  []string `json:"targets,omitempty"`
+	Rule     string   `json:"rule"`
+}
+
+func (d *Denial) Error() string {
+	msg := fmt.Sprintf("%s may not run %s on %s", d.User, d.Action, d.Resource)
+	if len(d.Targets) > 0 {
+		msg += fmt.Sprintf(" (%s)", strings.Join(d.Targets, ", "))
+	}
+	return fmt.Sprintf("%s, denied by rule %s", msg, d.Rule)
+}
+
+// Enforcer holds the policy of a file and reloads it when the file changes.
+// A nil enforcer allows everything.
+type Enforcer struct {
+	path   string
+	policy atomic.Pointer[Policy]
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+// NewEnforcer loads the policy of a file. Without the file every user may
+// run anything until it is created.
+func NewEnforcer(path string) (*Enforcer, error) {
+	e := &Enforcer{path: path}
+	e.policy.Store(AllowAll())
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload reads the policy file again if it changed. An invalid file leaves
+// the current policy in place.
+func (e *Enforcer) Reload() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	info, err := os.Stat(e.path)
+	if errors.Is(err, os.ErrNotExist) {
+		if e.modTime.IsZero() {
+			logger.Warning("No ACL policy, every user may run any query", utils.M{"path": e.path})
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(e.modTime) && info.Size() == e.size {
+		return nil
+	}
+	doc, err := os.ReadFile(e.path)
+	if err != nil {
+		return err
+	}
+	p, err := Parse(doc)
+	if err != nil {
+		return fmt.Errorf("%s: %w", e.path, err)
+	}
+	e.policy.Store(p)
+	e.modTime, e.size = info.ModTime(), info.Size()
+	logger.Info("Loaded ACL policy", utils.M{"path": e.path, "rules": len(p.Rules)})
+	return nil
+}
+
+// Watch reloads the policy file whenever it changes until the context is
+// done
+func (e *Enforcer) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.Reload(); err != nil {
+				logger.Error("Failed to reload ACL policy, keeping the current one", err)
+			}
+		}
+	}
+}
+
+// Policy returns the current policy
+func (e *Enforcer) Policy() *Policy {
+	if e == nil {
+		return AllowAll()
+	}
+	return e.policy.Load()
+}
+
+// AuthorizeMem returns a *Denial if the user may not run a Redis command
+func (e *Enforcer) AuthorizeMem(user string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("empty command")
+	}
+	if d := e.Policy().decide(user, memRequest(args)); d != nil {
+		return d
+	}
+	return nil
+}
+
+// AuthorizeSQL returns a *Denial if the user may not run a query on a
+// database. Each statement of the query is checked.
+func (e *Enforcer) AuthorizeSQL(user, database, query string) error {
+	statements, err := sqlRequests(query, database)
+	if err != nil {
+		return err
+	}
+	p := e.Policy()
+	for _, req := range statements {
+		if d := p.decide(user, req); d != nil {
+			return d
+		}
+	}
+	return nil
+}
+
+// Glob reports whether a name matches a pattern with the wildcards of Redis:
+// * matches any run of characters, ? one character and \ escapes
+func Glob(pattern, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			pattern = strings.TrimLeft(pattern, "*")
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if Glob(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if name == "" {
+				return false
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if name == "" || name[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return name == ""
+}
@@ -0,0 +1,332 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package acl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testPolicy = `
+default = "deny"
+
+[groups]
+ops = ["alice", "bob"]
+
+[[rules]]
+name = "ops run anything"
+effect = "allow"
+groups = ["ops"]
+mem_commands = ["*"]
+sql_statements = ["*"]
+
+[[rules]]
+name = "no flush"
+effect = "deny"
+users = ["*"]
+mem_commands = ["FLUSHALL", "FLUSHDB", "CONFIG"]
+
+[[rules]]
+name = "app cache"
+effect = "allow"
+users = ["app"]
+mem_commands = ["GET", "SET", "DEL", "MGET"]
+mem_keys = ["cache:*"]
+
+[[rules]]
+name = "app tables"
+effect = "allow"
+users = ["app"]
+sql_statements = ["SELECT", "INSERT", "UPDATE", "DELETE"]
+sql_databases = ["app_*"]
+
+[[rules]]
+name = "no system tables"
+effect = "deny"
+users = ["*"]
+sql_databases = ["mysql", "performance_schema"]
+sql_statements = ["DROP", "DELETE", "UPDATE", "INSERT"]
+`
+
+func TestPolicy(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Enforcer{}
+	e.policy.Store(p)
+	tests := []struct {
+		name string
+		user string
+		mem  []string
+		sql  string
+		rule string
+	}{
+		{name: "Group member", user: "alice", mem: []string{"INFO"}},
+		{name: "Deny wins over allow", user: "alice", mem: []string{"flushall"}, rule: "no flush"},
+		{name: "Subcommand", user: "bob", mem: []string{"CONFIG", "SET", "save", ""}, rule: "no flush"},
+		{name: "Allowed keys", user: "app", mem: []string{"MGET", "cache:a", "cache:b"}},
+		{name: "Key outside the pattern", user: "app", mem: []string{"MGET", "cache:a", "session:b"}, rule: "default"},
+		{name: "Command not listed", user: "app", mem: []string{"KEYS", "*"}, rule: "default"},
+		{name: "Unknown user", user: "eve", mem: []string{"GET", "cache:a"}, rule: "default"},
+		{name: "Allowed statement", user: "app", sql: "SELECT * FROM app_orders.items i JOIN app_users.users u ON i.u = u.id"},
+		{name: "Default database", user: "app", sql: "SELECT 1", rule: "default"},
+		{name: "Database outside the pattern", user: "app", sql: "SELECT * FROM app_orders.items, billing.invoices", rule: "default"},
+		{name: "Second statement", user: "app", sql: "SELECT * FROM app_a.t; DROP DATABASE app_a", rule: "default"},
+		{name: "Operator on system tables", user: "alice", sql: "DELETE FROM mysql.user", rule: "no system tables"},
+		{name: "Operator drops a database", user: "alice", sql: "DROP DATABASE IF EXISTS app_a"},
+		{name: "Executable comment", user: "alice", sql: "/*!50000 DROP TABLE mysql.user */", rule: "no system tables"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if tt.mem != nil {
+				err = e.AuthorizeMem(tt.user, tt.mem)
+			} else {
+				err = e.AuthorizeSQL(tt.user, "mysql", tt.sql)
+			}
+			var denial *Denial
+			if tt.rule == "" {
+				if err != nil {
+					t.Fatalf("Authorize() error = %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &denial) {
+				t.Fatalf("Authorize() error = %v, want a denial", err)
+			}
+			if denial.Rule != tt.rule {
+				t.Errorf("Authorize() denied by %q, want %q", denial.Rule, tt.rule)
+			}
+		})
+	}
+}
+
+// TestKeylessCommands tests that an allow rule with keys does not cover
+// commands without keys
+func TestKeylessCommands(t *testing.T) {
+	p, err := Parse([]byte(`
+[[rules]]
+name = "worker jobs"
+effect = "allow"
+users = ["worker"]
+mem_keys = ["jobs:*"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Enforcer{}
+	e.policy.Store(p)
+	tests := []struct {
+		args    []string
+		allowed bool
+	}{
+		{args: []string{"GET", "jobs:1"}, allowed: true},
+		{args: []string{"FLUSHALL"}},
+		{args: []string{"FLUSHDB", "ASYNC"}},
+		{args: []string{"CONFIG", "SET", "save", ""}},
+		{args: []string{"KEYS", "*"}},
+		{args: []string{"SCAN", "0"}},
+		{args: []string{"SHUTDOWN"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args[0], func(t *testing.T) {
+			err := e.AuthorizeMem("worker", tt.args)
+			if tt.allowed {
+				if err != nil {
+					t.Errorf("AuthorizeMem() error = %v", err)
+				}
+				return
+			}
+			var denial *Denial
+			if !errors.As(err, &denial) || denial.Rule != "default" {
+				t.Errorf("AuthorizeMem() error = %v, want a denial by the default", err)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{name: "Invalid default", doc: `default = "maybe"`},
+		{name: "Invalid effect", doc: "[[rules]]\neffect = \"permit\"\nusers = [\"a\"]\nmem_commands = [\"GET\"]"},
+		{name: "No users", doc: "[[rules]]\neffect = \"allow\"\nmem_commands = [\"GET\"]"},
+		{name: "No resource", doc: "[[rules]]\neffect = \"allow\"\nusers = [\"a\"]"},
+		{name: "Unknown group", doc: "[[rules]]\neffect = \"allow\"\ngroups = [\"ops\"]\nmem_commands = [\"GET\"]"},
+		{name: "Invalid TOML", doc: "default = "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.doc)); err == nil {
+				t.Error("Parse() succeeded")
+			}
+		})
+	}
+	p, err := Parse(nil)
+	if err != nil || p.Default != Deny {
+		t.Errorf("Parse(nil) = %v, %v, want the deny default", p, err)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*", "", true},
+		{"cache:*", "cache:a:b", true},
+		{"cache:*", "session:a", false},
+		{"user:?", "user:1", true},
+		{"user:?", "user:12", false},
+		{"*:id", "user:1:id", true},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{"app_*_db", "app_x_db", true},
+	}
+	for _, tt := range tests {
+		if got := Glob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("Glob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMemRequest(t *testing.T) {
+	tests := []struct {
+		args []string
+		want request
+	}{
+		{[]string{"get", "k"}, request{ResourceMem, "GET", []string{"k"}}},
+		{[]string{"PING"}, request{ResourceMem, "PING", nil}},
+		{[]string{"config", "get", "maxmemory"}, request{ResourceMem, "CONFIG GET", nil}},
+		{[]string{"MSET", "a", "1", "b", "2"}, request{ResourceMem, "MSET", []string{"a", "b"}}},
+		{[]string{"BLPOP", "a", "b", "0"}, request{ResourceMem, "BLPOP", []string{"a", "b"}}},
+		{[]string{"EVAL", "return 1", "2", "a", "b", "x"}, request{ResourceMem, "EVAL", []string{"a", "b"}}},
+		{[]string{"EVAL", "return 1", "9", "a"}, request{ResourceMem, "EVAL", []string{"a"}}},
+		{[]string{"ZUNIONSTORE", "d", "2", "a", "b"}, request{ResourceMem, "ZUNIONSTORE", []string{"d", "a", "b"}}},
+		{[]string{"BLMPOP", "0", "2", "a", "b", "LEFT"}, request{ResourceMem, "BLMPOP", []string{"a", "b"}}},
+		{[]string{"XREADGROUP", "GROUP", "g", "c", "STREAMS", "s1", "s2", ">", ">"}, request{ResourceMem, "XREADGROUP", []string{"s1", "s2"}}},
+		{[]string{"RENAME", "a", "b"}, request{ResourceMem, "RENAME", []string{"a", "b"}}},
+		{[]string{"XGROUP", "CREATE", "s", "g", "$", "MKSTREAM"}, request{ResourceMem, "XGROUP CREATE", []string{"s"}}},
+		{[]string{"xinfo", "stream", "s"}, request{ResourceMem, "XINFO STREAM", []string{"s"}}},
+		{[]string{"XGROUP", "HELP"}, request{ResourceMem, "XGROUP HELP", nil}},
+		{[]string{"XACK", "s", "g", "1-0"}, request{ResourceMem, "XACK", []string{"s"}}},
+	}
+	for _, tt := range tests {
+		got := memRequest(tt.args)
+		if diff := cmp.Diff(tt.want, *got, cmp.AllowUnexported(request{})); diff != "" {
+			t.Errorf("memRequest(%q) mismatch (-want +got):\n%s", tt.args, diff)
+		}
+	}
+}
+
+func TestSQLRequests(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    []request
+		wantErr bool
+	}{
+		{query: "select * from t where a = 'x;y'", want: []request{{ResourceSQL, "SELECT", []string{"app"}}}},
+		{query: "SELECT * FROM `shop`.`orders` o JOIN users u ON o.u = u.id", want: []request{{ResourceSQL, "SELECT", []string{"shop", "app"}}}},
+		{query: "INSERT INTO logs.events (a) SELECT a FROM shop.orders", want: []request{{ResourceSQL, "INSERT", []string{"logs", "shop"}}}},
+		{query: "UPDATE shop.a, shop.b SET a.x = b.x", want: []request{{ResourceSQL, "UPDATE", []string{"shop"}}}},
+		{query: "WITH x AS (SELECT * FROM shop.a) DELETE FROM shop.b", want: []request{{ResourceSQL, "DELETE", []string{"shop"}}}},
+		{query: "(SELECT 1) UNION (SELECT 2)", want: []request{{ResourceSQL, "SELECT", []string{"app"}}}},
+		{query: "drop schema if exists shop", want: []request{{ResourceSQL, "DROP DATABASE", []string{"shop"}}}},
+		{query: "CREATE TABLE IF NOT EXISTS shop.t (id INT)", want: []request{{ResourceSQL, "CREATE TABLE", []string{"shop"}}}},
+		{query: "CREATE UNIQUE INDEX i ON t (a)", want: []request{{ResourceSQL, "CREATE INDEX", []string{"app"}}}},
+		{query: "RENAME TABLE a.t TO b.t", want: []request{{ResourceSQL, "RENAME", []string{"a", "b"}}}},
+		{query: "SHOW TABLES FROM shop LIKE 'o%'", want: []request{{ResourceSQL, "SHOW", []string{"shop"}}}},
+		{query: "use shop; -- switch\nTRUNCATE TABLE t", want: []request{
+			{ResourceSQL, "USE", []string{"shop"}},
+			{ResourceSQL, "TRUNCATE", []string{"app"}},
+		}},
+		{query: "SELECT 1 /* DROP TABLE x */; /*!40101 DROP TABLE shop.x */", want: []request{
+			{ResourceSQL, "SELECT", []string{"app"}},
+			{ResourceSQL, "DROP TABLE", []string{"shop"}},
+		}},
+		{query: "SELECT 1--1; DROP DATABASE shop", want: []request{
+			{ResourceSQL, "SELECT", []string{"app"}},
+			{ResourceSQL, "DROP DATABASE", []string{"shop"}},
+		}},
+		{query: "GRANT ALL ON other.* TO 'eve'@'%'", want: []request{{ResourceSQL, "GRANT", []string{"other"}}}},
+		{query: "GRANT SELECT ON *.* TO eve", want: []request{{ResourceSQL, "GRANT", []string{"*"}}}},
+		{query: "REVOKE EXECUTE ON PROCEDURE other.p FROM eve", want: []request{{ResourceSQL, "REVOKE", []string{"other"}}}},
+		{query: "GRANT SELECT ON t TO eve", want: []request{{ResourceSQL, "GRANT", []string{"app"}}}},
+		{query: "CALL other.proc(1)", want: []request{{ResourceSQL, "CALL", []string{"other"}}}},
+		{query: "ALTER DATABASE other CHARACTER SET utf8mb4", want: []request{{ResourceSQL, "ALTER DATABASE", []string{"other"}}}},
+		{query: "ALTER DATABASE CHARACTER SET utf8mb4", want: []request{{ResourceSQL, "ALTER DATABASE", []string{"app"}}}},
+		{query: "CREATE DATABASE other", want: []request{{ResourceSQL, "CREATE DATABASE", []string{"other"}}}},
+		{query: "DROP SCHEMA other", want: []request{{ResourceSQL, "DROP DATABASE", []string{"other"}}}},
+		{query: "CREATE VIEW other.v AS SELECT * FROM t", want: []request{{ResourceSQL, "CREATE VIEW", []string{"other", "app"}}}},
+		{query: "DROP TRIGGER IF EXISTS other.trg", want: []request{{ResourceSQL, "DROP TRIGGER", []string{"other"}}}},
+		{query: "CREATE INDEX i ON other.t (a)", want: []request{{ResourceSQL, "CREATE INDEX", []string{"other"}}}},
+		{query: "TRUNCATE other.t", want: []request{{ResourceSQL, "TRUNCATE", []string{"other"}}}},
+		{query: "LOCK TABLES other.t READ", want: []request{{ResourceSQL, "LOCK", []string{"other"}}}},
+		{query: "SELECT * FROM a JOIN b ON other.x = b.x ORDER BY x DESC", want: []request{{ResourceSQL, "SELECT", []string{"app"}}}},
+		{query: " ; -- nothing", wantErr: true},
+		{query: "'text'", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := sqlRequests(tt.query, "app")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sqlRequests() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var requests []request
+			for _, r := range got {
+				requests = append(requests, *r)
+			}
+			if diff := cmp.Diff(tt.want, requests, cmp.AllowUnexported(request{})); diff != "" {
+				t.Errorf("sqlRequests() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEnforcerReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.toml")
+	e, err := NewEnforcer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AuthorizeMem("eve", []string{"FLUSHALL"}); err != nil {
+		t.Fatalf("AuthorizeMem() without a policy file error = %v", err)
+	}
+	if err := os.WriteFile(path, []byte(testPolicy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AuthorizeMem("eve", []string{"FLUSHALL"}); err == nil {
+		t.Error("AuthorizeMem() allowed FLUSHALL after the policy was loaded")
+	}
+	if err := os.WriteFile(path, []byte(`default = "maybe"`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Reload(); err == nil {
+		t.Error("Reload() accepted an invalid policy")
+	}
+	if got := len(e.Policy().Rules); got != 5 {
+		t.Errorf("Policy() has %d rules after a failed reload, want 5", got)
+	}
+	var nilEnforcer *Enforcer
+	if err := nilEnforcer.AuthorizeSQL("eve", "mysql", "DROP DATABASE mysql"); err != nil {
+		t.Errorf("nil Enforcer error = %v", err)
+	}
+}
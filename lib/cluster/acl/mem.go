@@ -0,0 +1,150 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package acl
+
+import (
+	"strconv"
+	"strings"
+)
+
+// containerCommands take a subcommand, which is part of the action
+var containerCommands = map[string]bool{
+	"ACL": true, "CLIENT": true, "CLUSTER": true, "COMMAND": true, "CONFIG": true, "DEBUG": true,
+	"FUNCTION": true, "LATENCY": true, "MEMORY": true, "MODULE": true, "OBJECT": true, "PUBSUB": true,
+	"SCRIPT": true, "SLOWLOG": true, "XGROUP": true, "XINFO": true,
+}
+
+// keyedSubcommands take a key as the first argument after the subcommand
+var keyedSubcommands = map[string]bool{
+	"XGROUP CREATE": true, "XGROUP CREATECONSUMER": true, "XGROUP DELCONSUMER": true, "XGROUP DESTROY": true,
+	"XGROUP SETID": true, "XINFO CONSUMERS": true, "XINFO GROUPS": true, "XINFO STREAM": true,
+	"OBJECT ENCODING": true, "OBJECT FREQ": true, "OBJECT IDLETIME": true, "OBJECT REFCOUNT": true,
+	"MEMORY USAGE": true,
+}
+
+// keyLayout tells the arguments of a command that are keys
+type keyLayout int
+
+const (
+	keysFirst keyLayout = iota
+	keysNone
+	keysAll
+	keysAllButLast
+	keysFirstTwo
+	keysPairs
+	keysNumKeys
+	keysDestNumKeys
+	keysStreams
+	keysSecond
+)
+
+var keyLayouts = map[string]keyLayout{
+	"PING": keysNone, "ECHO": keysNone, "INFO": keysNone, "DBSIZE": keysNone, "TIME": keysNone,
+	"FLUSHALL": keysNone, "FLUSHDB": keysNone, "SAVE": keysNone, "BGSAVE": keysNone, "BGREWRITEAOF": keysNone,
+	"LASTSAVE": keysNone, "SHUTDOWN": keysNone, "RANDOMKEY": keysNone, "SCAN": keysNone, "KEYS": keysNone,
+	"MULTI": keysNone, "EXEC": keysNone, "DISCARD": keysNone, "UNWATCH": keysNone,
+	"SELECT": keysNone, "SWAPDB": keysNone, "WAIT": keysNone, "FAILOVER": keysNone, "REPLICAOF": keysNone,
+	"SLAVEOF": keysNone, "ROLE": keysNone, "READONLY": keysNone, "READWRITE": keysNone, "AUTH": keysNone,
+	"HELLO": keysNone, "RESET": keysNone, "QUIT": keysNone, "LOLWUT": keysNone, "MONITOR": keysNone,
+
+	"DEL": keysAll, "UNLINK": keysAll, "EXISTS": keysAll, "TOUCH": keysAll, "WATCH": keysAll, "MGET": keysAll,
+	"SUBSCRIBE": keysAll, "PSUBSCRIBE": keysAll, "SSUBSCRIBE": keysAll, "PFCOUNT": keysAll, "PFMERGE": keysAll,
+	"SINTER": keysAll, "SUNION": keysAll, "SDIFF": keysAll, "SINTERSTORE": keysAll, "SUNIONSTORE": keysAll,
+	"SDIFFSTORE": keysAll,
+
+	"BLPOP": keysAllButLast, "BRPOP": keysAllButLast, "BZPOPMIN": keysAllButLast, "BZPOPMAX": keysAllButLast,
+
+	"RENAME": keysFirstTwo, "RENAMENX": keysFirstTwo, "RPOPLPUSH": keysFirstTwo, "LMOVE": keysFirstTwo,
+	"BLMOVE": keysFirstTwo, "BRPOPLPUSH": keysFirstTwo, "SMOVE": keysFirstTwo, "COPY": keysFirstTwo,
+
+	"MSET": keysPairs, "MSETNX": keysPairs,
+
+	"SINTERCARD": keysNumKeys, "ZINTERCARD": keysNumKeys,
+	"LMPOP": keysNumKeys, "ZMPOP": keysNumKeys, "ZUNION": keysNumKeys, "ZINTER": keysNumKeys, "ZDIFF": keysNumKeys,
+
+	"ZUNIONSTORE": keysDestNumKeys, "ZINTERSTORE": keysDestNumKeys, "ZDIFFSTORE": keysDestNumKeys,
+
+	"XREAD": keysStreams, "XREADGROUP": keysStreams,
+
+	"EVAL": keysSecond, "EVALSHA": keysSecond, "EVAL_RO": keysSecond, "EVALSHA_RO": keysSecond,
+	"FCALL": keysSecond, "FCALL_RO": keysSecond, "BLMPOP": keysSecond, "BZMPOP": keysSecond,
+}
+
+// memRequest describes a Redis command. Keys are found by the layout of the
+// command, the first argument is taken as the key of commands not listed.
+func memRequest(args []string) *request {
+	name := strings.ToUpper(args[0])
+	rest := args[1:]
+	if containerCommands[name] {
+		if len(rest) == 0 {
+			return &request{resource: ResourceMem, action: name}
+		}
+		name += " " + strings.ToUpper(rest[0])
+		req := &request{resource: ResourceMem, action: name}
+		if keyedSubcommands[name] {
+			req.targets = rest[1:min(len(rest), 2)]
+		}
+		return req
+	}
+	return &request{resource: ResourceMem, action: name, targets: commandKeys(keyLayouts[name], rest)}
+}
+
+func commandKeys(layout keyLayout, args []string) []string {
+	switch layout {
+	case keysNone:
+		return nil
+	case keysAll:
+		return args
+	case keysAllButLast:
+		if len(args) == 0 {
+			return nil
+		}
+		return args[:len(args)-1]
+	case keysFirstTwo:
+		return args[:min(len(args), 2)] //nolint:mnd
+	case keysPairs:
+		var keys []string
+		for i := 0; i < len(args); i += 2 {
+			keys = append(keys, args[i])
+		}
+		return keys
+	case keysNumKeys:
+		return numKeys(args)
+	case keysDestNumKeys:
+		if len(args) == 0 {
+			return nil
+		}
+		return append([]string{args[0]}, numKeys(args[1:])...)
+	case keysSecond:
+		// script or timeout, numkeys, key...
+		if len(args) == 0 {
+			return nil
+		}
+		return numKeys(args[1:])
+	case keysStreams:
+		for i, a := range args {
+			if strings.EqualFold(a, "STREAMS") {
+				streams := args[i+1:]
+				return streams[:len(streams)/2]
+			}
+		}
+		return nil
+	default:
+		return args[:min(len(args), 1)]
+	}
+}
+
+// numKeys returns the keys after a count of keys. An invalid count takes all
+// the arguments as keys, so that deny rules still see them.
+func numKeys(args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 || n > len(args)-1 {
+		return args[1:]
+	}
+	return args[1 : n+1]
+}
@@ -0,0 +1,313 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package acl
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+)
+
+type tokenKind int
+
+const (
+	wordToken tokenKind = iota
+	identToken
+	stringToken
+	punctToken
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func (t token) is(words ...string) bool {
+	return t.kind == wordToken && slices.ContainsFunc(words, func(w string) bool { return strings.EqualFold(t.text, w) })
+}
+
+func (t token) isPunct(s string) bool {
+	return t.kind == punctToken && t.text == s
+}
+
+// objectKinds are the objects of CREATE, DROP and ALTER that make part of the
+// statement type
+var objectKinds = []string{"DATABASE", "SCHEMA", "TABLE", "INDEX", "VIEW", "USER", "ROLE", "PROCEDURE",
+	"FUNCTION", "TRIGGER", "EVENT", "TABLESPACE", "SERVER", "INSTANCE"}
+
+// tableKeywords precede a list of tables
+var tableKeywords = []string{"FROM", "JOIN", "INTO", "UPDATE", "TABLE", "TABLES", "TO", "REFERENCES"}
+
+// leadingKeywords start statements that are followed by a table or routine
+var leadingKeywords = []string{"CALL", "TRUNCATE", "DESCRIBE", "DESC", "EXPLAIN", "HANDLER"}
+
+// objectKeywords precede the object of CREATE, DROP and ALTER. ON names the
+// table of an index or a trigger there.
+var objectKeywords = []string{"VIEW", "PROCEDURE", "FUNCTION", "TRIGGER", "EVENT", "ON"}
+
+// databaseOptions follow ALTER DATABASE when it changes the current database
+var databaseOptions = []string{"CHARACTER", "CHARSET", "DEFAULT", "COLLATE", "ENCRYPTION", "READ"}
+
+// clauseKeywords end a list of tables
+var clauseKeywords = []string{"SELECT", "WITH", "WHERE", "SET", "VALUES", "VALUE", "ON", "USING", "FROM", "IN",
+	"LIKE", "TO", "PARTITION", "GROUP", "ORDER", "LIMIT", "HAVING", "UNION", "FOR", "LOCK"}
+
+// tokenize splits SQL into words, quoted identifiers, strings and
+// punctuation. Comments are dropped, except the executable comments of
+// MySQL, /*! ... */, which the server runs.
+func tokenize(doc string) []token {
+	var tokens []token
+	rs := []rune(doc)
+	executable := false
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case utils.IsSQLLineComment(rs, i):
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+2 < len(rs) && rs[i+1] == '*' && rs[i+2] == '!':
+			i += 3
+			for i < len(rs) && unicode.IsDigit(rs[i]) {
+				i++
+			}
+			executable = true
+		case r == '*' && executable && i+1 < len(rs) && rs[i+1] == '/':
+			i += 2
+			executable = false
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			i += 2
+			for i+1 < len(rs) && (rs[i] != '*' || rs[i+1] != '/') {
+				i++
+			}
+			i += 2
+		case r == '`' || r == '\'' || r == '"':
+			j := i + 1
+			var b strings.Builder
+			for j < len(rs) {
+				if rs[j] == r && j+1 < len(rs) && rs[j+1] == r {
+					b.WriteRune(r)
+					j += 2
+					continue
+				}
+				if rs[j] == '\\' && r != '`' && j+1 < len(rs) {
+					b.WriteRune(rs[j+1])
+					j += 2
+					continue
+				}
+				if rs[j] == r {
+					break
+				}
+				b.WriteRune(rs[j])
+				j++
+			}
+			kind := stringToken
+			if r == '`' {
+				kind = identToken
+			}
+			tokens = append(tokens, token{kind: kind, text: b.String()})
+			i = j + 1
+		case isWord(r):
+			j := i
+			for j < len(rs) && isWord(rs[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: wordToken, text: string(rs[i:j])})
+			i = j
+		default:
+			tokens = append(tokens, token{kind: punctToken, text: string(r)})
+			i++
+		}
+	}
+	return tokens
+}
+
+func isWord(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func splitStatements(tokens []token) [][]token {
+	var stmts [][]token
+	var current []token
+	for _, t := range tokens {
+		if t.isPunct(";") {
+			if len(current) > 0 {
+				stmts = append(stmts, current)
+			}
+			current = nil
+			continue
+		}
+		current = append(current, t)
+	}
+	if len(current) > 0 {
+		stmts = append(stmts, current)
+	}
+	return stmts
+}
+
+// sqlRequests describes each statement of a query run on a database
+func sqlRequests(query, database string) ([]*request, error) {
+	stmts := splitStatements(tokenize(query))
+	if len(stmts) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+	requests := make([]*request, len(stmts))
+	for i, stmt := range stmts {
+		action, err := statementType(stmt)
+		if err != nil {
+			return nil, err
+		}
+		requests[i] = &request{resource: ResourceSQL, action: action, targets: statementDatabases(stmt, database)}
+	}
+	return requests, nil
+}
+
+// statementType returns the leading keyword of a statement, with the object
+// kind for CREATE, DROP and ALTER, and the main statement of a WITH query
+func statementType(stmt []token) (string, error) {
+	for len(stmt) > 0 && stmt[0].isPunct("(") {
+		stmt = stmt[1:]
+	}
+	if len(stmt) == 0 || stmt[0].kind != wordToken {
+		return "", fmt.Errorf("invalid statement")
+	}
+	verb := strings.ToUpper(stmt[0].text)
+	switch verb {
+	case "WITH":
+		depth := 0
+		for _, t := range stmt[1:] {
+			switch {
+			case t.isPunct("("):
+				depth++
+			case t.isPunct(")"):
+				depth--
+			case depth == 0 && t.is("SELECT", "INSERT", "REPLACE", "UPDATE", "DELETE", "TABLE", "VALUES"):
+				return strings.ToUpper(t.text), nil
+			}
+		}
+		return "", fmt.Errorf("invalid WITH statement")
+	case "CREATE", "DROP", "ALTER":
+		for _, t := range stmt[1:] {
+			if t.is(objectKinds...) {
+				kind := strings.ToUpper(t.text)
+				if kind == "SCHEMA" {
+					kind = "DATABASE"
+				}
+				return verb + " " + kind, nil
+			}
+		}
+	}
+	return verb, nil
+}
+
+// statementDatabases returns the databases a statement uses. Tables without
+// a database, and statements without tables, belong to the database the
+// statement runs on. The level of GRANT and REVOKE, such as *.*, is a target
+// of its own.
+func statementDatabases(stmt []token, database string) []string {
+	var databases []string
+	add := func(db string) {
+		if db != "" && !slices.Contains(databases, db) {
+			databases = append(databases, db)
+		}
+	}
+	for len(stmt) > 0 && stmt[0].isPunct("(") {
+		stmt = stmt[1:]
+	}
+	isShow := len(stmt) > 0 && stmt[0].is("SHOW")
+	isDefinition := len(stmt) > 0 && stmt[0].is("CREATE", "DROP", "ALTER")
+	isGrant := len(stmt) > 0 && stmt[0].is("GRANT", "REVOKE")
+	for i := 0; i < len(stmt); i++ {
+		t := stmt[i]
+		switch {
+		case t.is("DATABASE", "SCHEMA", "USE") || (isShow && t.is("FROM", "IN")):
+			j := skipIfExists(stmt, i+1)
+			if j < len(stmt) && isName(stmt[j]) && !(isShow && stmt[j].is("LIKE", "WHERE")) &&
+				!stmt[j].is(databaseOptions...) {
+				add(stmt[j].text)
+				i = j
+			}
+		case isGrant && t.is("ON"):
+			i = grantLevel(stmt, i+1, database, add) - 1
+		case isGrant && t.is("TO", "FROM"):
+			// the rest of the statement names users and roles
+			i = len(stmt)
+		case t.is(tableKeywords...), i == 0 && t.is(leadingKeywords...), isDefinition && t.is(objectKeywords...):
+			i = tableList(stmt, skipIfExists(stmt, i+1), database, add) - 1
+		}
+	}
+	if len(databases) == 0 && database != "" {
+		databases = append(databases, database)
+	}
+	return databases
+}
+
+// grantLevel reads the level of GRANT and REVOKE, *.*, db.*, db.name or a
+// name of the current database, and returns the position after it
+func grantLevel(stmt []token, i int, database string, add func(string)) int {
+	if i < len(stmt) && stmt[i].is("TABLE", "FUNCTION", "PROCEDURE") {
+		i++
+	}
+	isLevel := func(j int) bool {
+		return j < len(stmt) && (isName(stmt[j]) || stmt[j].isPunct("*"))
+	}
+	switch {
+	case isLevel(i) && i+2 < len(stmt) && stmt[i+1].isPunct(".") && isLevel(i+2):
+		add(stmt[i].text)
+		return i + 3
+	case isLevel(i):
+		add(database)
+		return i + 1
+	}
+	return i
+}
+
+// tableList reads a comma separated list of tables with optional aliases
+// and returns the position after it
+func tableList(stmt []token, i int, database string, add func(string)) int {
+	for i < len(stmt) && isName(stmt[i]) && !stmt[i].is(clauseKeywords...) {
+		db := database
+		if i+2 < len(stmt) && stmt[i+1].isPunct(".") && isName(stmt[i+2]) {
+			db = stmt[i].text
+			i += 2
+		}
+		add(db)
+		i++
+		if i < len(stmt) && stmt[i].is("AS") {
+			i++
+		}
+		if i < len(stmt) && isName(stmt[i]) && !stmt[i].is(clauseKeywords...) && !stmt[i].is("JOIN", "INNER", "LEFT",
+			"RIGHT", "CROSS", "NATURAL", "STRAIGHT_JOIN") {
+			i++
+		}
+		if i >= len(stmt) || !stmt[i].isPunct(",") {
+			break
+		}
+		i++
+	}
+	return i
+}
+
+func skipIfExists(stmt []token, i int) int {
+	if i < len(stmt) && stmt[i].is("IF") {
+		i++
+		if i < len(stmt) && stmt[i].is("NOT") {
+			i++
+		}
+		if i < len(stmt) && stmt[i].is("EXISTS") {
+			i++
+		}
+	}
+	return i
+}
+
+func isName(t token) bool {
+	return t.kind == wordToken || t.kind == identToken
+}
@@ -0,0 +1,105 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// manifest is implemented by the manifests of the backups of each kind of
+// node
+type manifest interface {
+	stamp() (id string, createdAt time.Time)
+}
+
+// putStream stores what write produces, failing if either side fails
+func putStream(ctx context.Context, store Store, key string, write func(w io.Writer) error) error {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := write(pw)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	err := store.Put(ctx, key, pr)
+	pr.CloseWithError(err)
+	if writeErr := <-done; writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+// putManifest stores a manifest, which marks its backup complete
+func putManifest(ctx context.Context, store Store, key string, m manifest) error {
+	doc, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, key, strings.NewReader(string(doc)))
+}
+
+// listManifests reads the manifests under a prefix, oldest first
+func listManifests[M manifest](ctx context.Context, store Store, prefix string, newManifest func() M) ([]M, error) {
+	keys, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var manifests []M
+	for _, key := range keys {
+		if path.Base(key) != manifestName {
+			continue
+		}
+		r, err := store.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		m := newManifest()
+		err = json.NewDecoder(r).Decode(m)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %w", key, err)
+		}
+		manifests = append(manifests, m)
+	}
+	sort.Slice(manifests, func(i, j int) bool {
+		a, _ := manifests[i].stamp()
+		b, _ := manifests[j].stamp()
+		return a < b
+	})
+	return manifests, nil
+}
+
+// pickBackup returns the backup with an ID, or the latest one taken before a
+// time
+func pickBackup[M manifest](manifests []M, opts RestoreOptions) (M, error) {
+	var picked M
+	found := false
+	for _, m := range manifests {
+		id, createdAt := m.stamp()
+		switch {
+		case opts.ID != "" && id == opts.ID:
+			picked, found = m, true
+		case opts.ID == "" && (opts.Until.IsZero() || !createdAt.After(opts.Until)):
+			picked, found = m, true
+		}
+	}
+	if !found {
+		if opts.ID != "" {
+			return picked, fmt.Errorf("backup %s not found", opts.ID)
+		}
+		return picked, fmt.Errorf("no backup taken before %s", opts.Until.Format(time.RFC3339))
+	}
+	if id, createdAt := picked.stamp(); opts.ID != "" && !opts.Until.IsZero() && createdAt.After(opts.Until) {
+		return picked, fmt.Errorf("backup %s was taken after %s", id, opts.Until.Format(time.RFC3339))
+	}
+	return picked, nil
+}
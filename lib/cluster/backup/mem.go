@@ -0,0 +1,298 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/mem"
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+)
+
+// MemManifest describes a backup of a Redis cluster, a snapshot and the slots
+// of every master
+type MemManifest struct {
+	ID        string      `json:"id"`
+	Tenant    string      `json:"tenant"`
+	CreatedAt time.Time   `json:"created_at"`
+	Masters   []MemMaster `json:"masters"`
+}
+
+func (m *MemManifest) stamp() (string, time.Time) {
+	return m.ID, m.CreatedAt
+}
+
+// MemMaster is a master of a backed up cluster
+type MemMaster struct {
+	NodeID  string          `json:"node_id"`
+	Address string          `json:"address"`
+	Slots   []mem.SlotRange `json:"slots"`
+	// Object is the key of the snapshot of the master in the store
+	Object string `json:"object"`
+}
+
+// MemRestoreReport describes a restored cluster
+type MemRestoreReport struct {
+	Backup  string `json:"backup"`
+	Masters int    `json:"masters"`
+	Slots   int    `json:"slots"`
+}
+
+// MemBackup backs up the Redis cluster of a tenant to a store and restores it
+type MemBackup struct {
+	Store  Store
+	Tenant string
+	// Backoff spaces the checks while a restored cluster converges
+	Backoff utils.BackoffConfig
+
+	now func() time.Time
+}
+
+// NewMemBackup creates a backup of the Redis cluster of a tenant in a store
+func NewMemBackup(store Store, tenant string) *MemBackup {
+	return &MemBackup{
+		Store:  store,
+		Tenant: tenant,
+		Backoff: utils.BackoffConfig{
+			MaxAttempts:  10,
+			InitialDelay: time.Second,
+			MaxDelay:     10 * time.Second,
+		},
+		now: time.Now,
+	}
+}
+
+func (b *MemBackup) prefix() string {
+	return path.Join(b.Tenant, "mem") + "/"
+}
+
+// Backup snapshots every master of the cluster with BGSAVE and records the
+// slots each one serves. The nodes are the replicas of every shard.
+func (b *MemBackup) Backup(ctx context.Context, shards [][]*mem.MemNode) (*MemManifest, error) {
+	byID := map[string]*mem.MemNode{}
+	var view []mem.ClusterNode
+	for _, nodes := range shards {
+		for _, n := range nodes {
+			id, err := n.ID(ctx)
+			if err != nil {
+				logger.Warning("Node is unreachable", utils.M{"node": n.MemContainerName(), "error": err.Error()})
+				continue
+			}
+			byID[id] = n
+			if view == nil {
+				if view, err = n.ClusterNodes(ctx); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if view == nil {
+		return nil, fmt.Errorf("no reachable node")
+	}
+
+	m := &MemManifest{ID: b.now().UTC().Format(backupIDFormat), Tenant: b.Tenant, CreatedAt: b.now().UTC()}
+	for _, cn := range view {
+		if !cn.IsMaster() || len(cn.Slots) == 0 {
+			continue
+		}
+		if cn.HasFlag("fail") || byID[cn.ID] == nil {
+			return nil, fmt.Errorf("master %s at %s is not reachable, its slots cannot be backed up", cn.ID, cn.Address)
+		}
+		m.Masters = append(m.Masters, MemMaster{
+			NodeID:  cn.ID,
+			Address: cn.Address,
+			Slots:   mem.NormalizeSlots(cn.Slots),
+			Object:  path.Join(b.prefix()+m.ID, cn.ID+".rdb"),
+		})
+	}
+	if len(m.Masters) == 0 {
+		return nil, fmt.Errorf("the cluster has no master serving slots")
+	}
+	sort.Slice(m.Masters, func(i, j int) bool { return m.Masters[i].Slots[0].Start < m.Masters[j].Slots[0].Start })
+
+	errs := make([]error, len(m.Masters))
+	var wg sync.WaitGroup
+	for i, master := range m.Masters {
+		wg.Add(1)
+		go func(i int, master MemMaster, n *mem.MemNode) {
+			defer wg.Done()
+			logger.Info("Backing up master", utils.M{"node": n.MemContainerName(), "slots": fmt.Sprint(master.Slots)})
+			if err := n.Save(ctx); err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = putStream(ctx, b.Store, master.Object, func(w io.Writer) error {
+				return n.CopySnapshot(ctx, w)
+			})
+		}(i, master, byID[master.NodeID])
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	if err := putManifest(ctx, b.Store, path.Join(b.prefix()+m.ID, manifestName), m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Backups returns the complete backups of the cluster, oldest first
+func (b *MemBackup) Backups(ctx context.Context) ([]*MemManifest, error) {
+	return listManifests(ctx, b.Store, b.prefix(), func() *MemManifest { return &MemManifest{} })
+}
+
+// Restore rebuilds the cluster from a backup with the same slot
+// distribution. The first replica of each shard becomes the master of the
+// slots of one backed up master and loads its snapshot, the other replicas
+// are emptied and replicate it. Every node forgets its current cluster.
+func (b *MemBackup) Restore(ctx context.Context, shards [][]*mem.MemNode, opts RestoreOptions) (*MemRestoreReport, error) {
+	manifests, err := b.Backups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m, err := pickBackup(manifests, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Masters) != len(shards) {
+		return nil, fmt.Errorf("backup %s has %d masters, the cluster has %d shards", m.ID, len(m.Masters), len(shards))
+	}
+	report := &MemRestoreReport{Backup: m.ID, Masters: len(m.Masters)}
+
+	for i, master := range m.Masters {
+		if err := b.loadShard(ctx, shards[i], master); err != nil {
+			return nil, err
+		}
+		for _, r := range master.Slots {
+			report.Slots += r.Size()
+		}
+	}
+	if err := b.assignSlots(ctx, shards, m.Masters); err != nil {
+		return nil, err
+	}
+	if err := b.join(ctx, shards); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// loadShard loads the snapshot of a master into the first replica of a shard
+// and empties the others
+func (b *MemBackup) loadShard(ctx context.Context, nodes []*mem.MemNode, master MemMaster) error {
+	if len(nodes) == 0 {
+		return fmt.Errorf("shard without nodes")
+	}
+	logger.Info("Restoring master", utils.M{"node": nodes[0].MemContainerName(), "slots": fmt.Sprint(master.Slots)})
+	r, err := b.Store.Get(ctx, master.Object)
+	if err != nil {
+		return err
+	}
+	err = nodes[0].ReplaceData(ctx, r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes[1:] {
+		if err := n.ReplaceData(ctx, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assignSlots gives each master the slots of its backed up master. A master
+// already owns the slots it loaded keys of, it claims them on startup.
+func (b *MemBackup) assignSlots(ctx context.Context, shards [][]*mem.MemNode, masters []MemMaster) error {
+	for i, master := range masters {
+		n := shards[i][0]
+		view, err := n.ClusterNodes(ctx)
+		if err != nil {
+			return err
+		}
+		var owned []mem.SlotRange
+		for _, cn := range view {
+			if cn.HasFlag("myself") {
+				owned = cn.Slots
+			}
+		}
+		if extra := mem.SubtractSlots(owned, master.Slots); len(extra) > 0 {
+			return fmt.Errorf("%s loaded keys of slots %v outside of its slots", n.MemContainerName(), extra)
+		}
+		if err := n.AddSlots(ctx, mem.SubtractSlots(master.Slots, owned)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// join forms the cluster, attaches the replicas to their masters and waits
+// until the cluster serves all its slots
+func (b *MemBackup) join(ctx context.Context, shards [][]*mem.MemNode) error {
+	first := shards[0][0]
+	total := 0
+	for _, nodes := range shards {
+		for _, n := range nodes {
+			total++
+			if n == first {
+				continue
+			}
+			if err := first.Meet(ctx, n); err != nil {
+				return err
+			}
+		}
+	}
+	err := b.Backoff.Retry(ctx, func() error {
+		view, err := first.ClusterNodes(ctx)
+		if err != nil {
+			return err
+		}
+		known := 0
+		for _, cn := range view {
+			if !cn.HasFlag("handshake") && !cn.HasFlag("noaddr") {
+				known++
+			}
+		}
+		if known < total {
+			return fmt.Errorf("%d of %d nodes joined the cluster", known, total)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, nodes := range shards {
+		masterID, err := nodes[0].ID(ctx)
+		if err != nil {
+			return err
+		}
+		for _, n := range nodes[1:] {
+			err := b.Backoff.Retry(ctx, func() error {
+				_, err := n.CLI(ctx, "CLUSTER", "REPLICATE", masterID)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return b.Backoff.Retry(ctx, func() error {
+		out, err := first.CLI(ctx, "CLUSTER", "INFO")
+		if err != nil {
+			return err
+		}
+		if state := mem.ParseInfo(out)["cluster_state"]; state != "ok" {
+			return fmt.Errorf("cluster state is %s", state)
+		}
+		return nil
+	})
+}
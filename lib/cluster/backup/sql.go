@@ -0,0 +1,559 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/container"
+	"github.com/evgnomon/zygote/lib/cluster/db"
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+)
+
+// Kinds of SQL backups. A logical backup is a dump of the application
+// databases, a physical backup is a clone of the whole data directory.
+const (
+	KindLogical  = "logical"
+	KindPhysical = "physical"
+)
+
+const backupIDFormat = "20060102T150405Z"
+const binlogTimeFormat = "2006-01-02 15:04:05"
+const manifestName = "manifest.json"
+const maxDumpHeader = 1 << 20
+const restoreStartTimeout = 5 * time.Minute
+const restoreDir = "/tmp/zygote-restore"
+
+// systemSchemas are not part of logical backups, the target cluster keeps its
+// own users and metadata
+var systemSchemas = []string{"mysql", "sys", "performance_schema", "information_schema", "mysql_innodb_cluster_metadata"}
+
+var (
+	gtidPurgedRe   = regexp.MustCompile(`SET @@GLOBAL\.GTID_PURGED=(?:/\*!80000 '\+'\*/ )?'([^']*)'`)
+	sourceLogPosRe = regexp.MustCompile(`SOURCE_LOG_FILE='([^']+)', SOURCE_LOG_POS=(\d+)`)
+)
+
+// Manifest describes a backup of a shard, it is written last so a backup
+// without one is incomplete
+type Manifest struct {
+	ID     string `json:"id"`
+	Tenant string `json:"tenant"`
+	Shard  int    `json:"shard"`
+	Kind   string `json:"kind"`
+	// Source is the member the backup was taken from
+	Source     string    `json:"source"`
+	ServerUUID string    `json:"server_uuid"`
+	CreatedAt  time.Time `json:"created_at"`
+	// GTIDExecuted is the set of transactions the snapshot contains
+	GTIDExecuted   string   `json:"gtid_executed"`
+	BinlogFile     string   `json:"binlog_file,omitempty"`
+	BinlogPosition uint64   `json:"binlog_position,omitempty"`
+	Databases      []string `json:"databases,omitempty"`
+	// Object is the key of the snapshot in the store
+	Object string `json:"object"`
+}
+
+func (m *Manifest) stamp() (string, time.Time) {
+	return m.ID, m.CreatedAt
+}
+
+// RestoreOptions selects the backup and the point in time to restore
+type RestoreOptions struct {
+	// ID is the backup to restore, empty picks the latest one taken before
+	// Until
+	ID string
+	// Until stops replaying the archived binary logs at a time, zero replays
+	// all of them
+	Until time.Time
+}
+
+// RestoreReport describes a restored shard
+type RestoreReport struct {
+	Shard        int    `json:"shard"`
+	Backup       string `json:"backup"`
+	Binlogs      int    `json:"binlogs"`
+	GTIDExecuted string `json:"gtid_executed"`
+}
+
+// SQLBackup backs up the SQL shards of a tenant to a store and restores them
+type SQLBackup struct {
+	Store  Store
+	Tenant string
+
+	probe func(ctx context.Context, n *db.SQLNode) db.MemberStatus
+	now   func() time.Time
+}
+
+// NewSQLBackup creates a backup of the shards of a tenant in a store
+func NewSQLBackup(store Store, tenant string) *SQLBackup {
+	return &SQLBackup{Store: store, Tenant: tenant, probe: db.ProbeMember, now: time.Now}
+}
+
+func (b *SQLBackup) shardPrefix(shard int) string {
+	return fmt.Sprintf("%s/sql/shard-%d", b.Tenant, shard)
+}
+
+func (b *SQLBackup) baseKey(shard int, id, name string) string {
+	return path.Join(b.shardPrefix(shard), "base", id, name)
+}
+
+func (b *SQLBackup) binlogPrefix(shard int) string {
+	return path.Join(b.shardPrefix(shard), "binlogs") + "/"
+}
+
+// chooseSource picks an ONLINE secondary of a shard so backups do not load
+// the primary, a shard without secondaries is backed up from its primary
+func (b *SQLBackup) chooseSource(ctx context.Context, nodes []*db.SQLNode) (*db.SQLNode, db.MemberStatus, error) {
+	var primary *db.SQLNode
+	var primaryStatus db.MemberStatus
+	for _, n := range nodes {
+		st := b.probe(ctx, n)
+		if st.State != db.MemberOnline {
+			continue
+		}
+		if st.Role != db.RolePrimary {
+			return n, st, nil
+		}
+		primary, primaryStatus = n, st
+	}
+	if primary == nil {
+		return nil, db.MemberStatus{}, fmt.Errorf("no ONLINE member to back up")
+	}
+	logger.Warning("No ONLINE secondary, backing up the primary", utils.M{"host": primaryStatus.Host})
+	return primary, primaryStatus, nil
+}
+
+// Backup takes a consistent snapshot of a shard from a secondary
+func (b *SQLBackup) Backup(ctx context.Context, nodes []*db.SQLNode, kind string) (*Manifest, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no nodes to back up")
+	}
+	n, st, err := b.chooseSource(ctx, nodes)
+	if err != nil {
+		return nil, fmt.Errorf("shard %d: %w", nodes[0].ShardIndex, err)
+	}
+	if err := n.ResolveSecrets(); err != nil {
+		return nil, err
+	}
+	m := &Manifest{
+		ID:        b.now().UTC().Format(backupIDFormat),
+		Tenant:    b.Tenant,
+		Shard:     n.ShardIndex,
+		Kind:      kind,
+		Source:    st.Host,
+		CreatedAt: b.now().UTC(),
+	}
+	conn, err := n.GetAdminDB()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.QueryRowContext(ctx, "SELECT @@server_uuid").Scan(&m.ServerUUID); err != nil {
+		return nil, err
+	}
+	logger.Info("Backing up shard", utils.M{"shard": m.Shard, "kind": kind, "source": m.Source, "id": m.ID})
+	switch kind {
+	case KindLogical:
+		err = b.dump(ctx, n, conn, m)
+	case KindPhysical:
+		err = b.clone(ctx, n, conn, m)
+	default:
+		err = fmt.Errorf("unknown backup kind %q", kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("shard %d: %w", m.Shard, err)
+	}
+	if err := putManifest(ctx, b.Store, b.baseKey(m.Shard, m.ID, manifestName), m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// dump streams a compressed mysqldump of the application databases
+func (b *SQLBackup) dump(ctx context.Context, n *db.SQLNode, conn *sql.DB, m *Manifest) error {
+	databases, err := listDatabases(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if len(databases) == 0 {
+		return fmt.Errorf("no application database to back up")
+	}
+	m.Databases = databases
+	m.Object = b.baseKey(m.Shard, m.ID, "dump.sql.gz")
+	cmd := append([]string{"mysqldump", "-uroot", "--single-transaction", "--source-data=2", "--set-gtid-purged=ON",
+		"--routines", "--events", "--triggers", "--hex-blob", "--databases"}, databases...)
+	header := &headerBuffer{max: maxDumpHeader}
+	err = putStream(ctx, b.Store, m.Object, func(w io.Writer) error {
+		gz := gzip.NewWriter(w)
+		err := container.Exec(ctx, n.SQLContainerName(), cmd, container.ExecOptions{
+			Env:    []string{"MYSQL_PWD=" + n.RootPassword},
+			Stdout: io.MultiWriter(gz, header),
+		})
+		if err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+	if err != nil {
+		return err
+	}
+	m.GTIDExecuted, m.BinlogFile, m.BinlogPosition = parseDumpHeader(header.Bytes())
+	return nil
+}
+
+// clone copies the data directory with the clone plugin and streams it as a
+// compressed tarball
+func (b *SQLBackup) clone(ctx context.Context, n *db.SQLNode, conn *sql.DB, m *Manifest) error {
+	var active int
+	err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM information_schema.PLUGINS "+
+		"WHERE PLUGIN_NAME = 'clone' AND PLUGIN_STATUS = 'ACTIVE'").Scan(&active)
+	if err != nil {
+		return err
+	}
+	if active == 0 {
+		return fmt.Errorf("the clone plugin is not loaded on %s, add plugin-load-add=mysql_clone.so to its configuration", m.Source)
+	}
+	dir := fmt.Sprintf("/tmp/zygote-clone-%s", m.ID)
+	// the ID is a timestamp, safe to inline
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("CLONE LOCAL DATA DIRECTORY = '%s'", dir)); err != nil {
+		return fmt.Errorf("failed to clone %s: %w", m.Source, err)
+	}
+	defer func() {
+		err := container.Exec(context.Background(), n.SQLContainerName(), []string{"rm", "-rf", dir}, container.ExecOptions{})
+		if err != nil {
+			logger.Error("Remove clone", err, utils.M{"dir": dir})
+		}
+	}()
+	var gtids sql.NullString
+	var file sql.NullString
+	var pos sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GTID_EXECUTED, BINLOG_FILE, BINLOG_POSITION FROM performance_schema.clone_status").
+		Scan(&gtids, &file, &pos)
+	if err != nil {
+		return err
+	}
+	m.GTIDExecuted, m.BinlogFile, m.BinlogPosition = gtids.String, file.String, uint64(max(pos.Int64, 0))
+	m.Object = b.baseKey(m.Shard, m.ID, "data.tar.gz")
+	return putStream(ctx, b.Store, m.Object, func(w io.Writer) error {
+		return container.Exec(ctx, n.SQLContainerName(), []string{"tar", "-C", dir, "-czf", "-", "."},
+			container.ExecOptions{Stdout: w})
+	})
+}
+
+func listDatabases(ctx context.Context, conn *sql.DB) ([]string, error) {
+	query := fmt.Sprintf("SELECT SCHEMA_NAME FROM information_schema.SCHEMATA WHERE SCHEMA_NAME NOT IN ('%s') ORDER BY SCHEMA_NAME",
+		strings.Join(systemSchemas, "', '"))
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var databases []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		databases = append(databases, name)
+	}
+	return databases, rows.Err()
+}
+
+// headerBuffer keeps the first bytes written to it
+type headerBuffer struct {
+	buf []byte
+	max int
+}
+
+func (h *headerBuffer) Write(p []byte) (int, error) {
+	if room := h.max - len(h.buf); room > 0 {
+		h.buf = append(h.buf, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+func (h *headerBuffer) Bytes() []byte {
+	return h.buf
+}
+
+// parseDumpHeader reads the GTID set and the binary log position a dump was
+// taken at from its header
+func parseDumpHeader(header []byte) (gtids, file string, pos uint64) {
+	if match := gtidPurgedRe.FindSubmatch(header); match != nil {
+		gtids = strings.Join(strings.Fields(string(match[1])), "")
+	}
+	if match := sourceLogPosRe.FindSubmatch(header); match != nil {
+		file = string(match[1])
+		pos, _ = strconv.ParseUint(string(match[2]), 10, 64)
+	}
+	return gtids, file, pos
+}
+
+// ArchiveBinlogs copies the closed binary logs of a member of a shard that are
+// not archived yet and returns their keys
+func (b *SQLBackup) ArchiveBinlogs(ctx context.Context, nodes []*db.SQLNode) ([]string, error) {
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+	n, _, err := b.chooseSource(ctx, nodes)
+	if err != nil {
+		return nil, fmt.Errorf("shard %d: %w", nodes[0].ShardIndex, err)
+	}
+	conn, err := n.GetAdminDB()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var serverUUID, basename string
+	if err := conn.QueryRowContext(ctx, "SELECT @@server_uuid, @@log_bin_basename").Scan(&serverUUID, &basename); err != nil {
+		return nil, err
+	}
+	// rotate so the latest transactions are in a closed file, the rotation
+	// must not be logged, a logged statement on a secondary is an errant
+	// transaction
+	if _, err := conn.ExecContext(ctx, "FLUSH NO_WRITE_TO_BINLOG BINARY LOGS"); err != nil {
+		return nil, err
+	}
+	files, err := binaryLogs(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	prefix := b.binlogPrefix(n.ShardIndex) + serverUUID + "/"
+	archived, err := b.Store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	have := map[string]bool{}
+	for _, key := range archived {
+		have[key] = true
+	}
+	var keys []string
+	dir := path.Dir(basename)
+	// the last file is still being written
+	for _, file := range files[:max(len(files)-1, 0)] {
+		key := prefix + file
+		if have[key] {
+			continue
+		}
+		err := putStream(ctx, b.Store, key, func(w io.Writer) error {
+			return container.Exec(ctx, n.SQLContainerName(), []string{"cat", path.Join(dir, file)}, container.ExecOptions{Stdout: w})
+		})
+		if err != nil {
+			return keys, fmt.Errorf("failed to archive %s: %w", file, err)
+		}
+		keys = append(keys, key)
+	}
+	logger.Info("Archived binary logs", utils.M{"shard": n.ShardIndex, "server": serverUUID, "files": len(keys)})
+	return keys, nil
+}
+
+func binaryLogs(ctx context.Context, conn *sql.DB) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, "SHOW BINARY LOGS")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for rows.Next() {
+		values := make([]any, len(columns))
+		var name string
+		values[0] = &name
+		for i := 1; i < len(values); i++ {
+			values[i] = new(sql.RawBytes)
+		}
+		if err := rows.Scan(values...); err != nil {
+			return nil, err
+		}
+		files = append(files, name)
+	}
+	return files, rows.Err()
+}
+
+// Backups returns the complete backups of a shard, oldest first
+func (b *SQLBackup) Backups(ctx context.Context, shard int) ([]*Manifest, error) {
+	return listManifests(ctx, b.Store, path.Join(b.shardPrefix(shard), "base")+"/", func() *Manifest { return &Manifest{} })
+}
+
+// orderBinlogs orders the archived binary logs by member, the member the
+// backup was taken from first, and by sequence number within a member. The
+// members log the same transactions, replaying a transaction twice is
+// skipped by its GTID.
+func orderBinlogs(keys []string, sourceUUID string) []string {
+	ordered := append([]string{}, keys...)
+	server := func(key string) string { return path.Base(path.Dir(key)) }
+	sort.SliceStable(ordered, func(i, j int) bool {
+		si, sj := server(ordered[i]), server(ordered[j])
+		if (si == sourceUUID) != (sj == sourceUUID) {
+			return si == sourceUUID
+		}
+		if si != sj {
+			return si < sj
+		}
+		return path.Base(ordered[i]) < path.Base(ordered[j])
+	})
+	return ordered
+}
+
+// Restore loads a backup into every member of a shard of a fresh cluster,
+// replays the archived binary logs up to a point in time and reboots the
+// group. A physical backup brings the users of the source cluster, the
+// target must use the same credentials.
+func (b *SQLBackup) Restore(ctx context.Context, nodes []*db.SQLNode, opts RestoreOptions) (*RestoreReport, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no nodes to restore")
+	}
+	shard := nodes[0].ShardIndex
+	manifests, err := b.Backups(ctx, shard)
+	if err != nil {
+		return nil, err
+	}
+	m, err := pickBackup(manifests, opts)
+	if err != nil {
+		return nil, fmt.Errorf("shard %d: %w", shard, err)
+	}
+	binlogs, err := b.Store.List(ctx, b.binlogPrefix(shard))
+	if err != nil {
+		return nil, err
+	}
+	binlogs = orderBinlogs(binlogs, m.ServerUUID)
+	report := &RestoreReport{Shard: shard, Backup: m.ID, Binlogs: len(binlogs)}
+
+	for _, n := range nodes {
+		if err := n.ResolveSecrets(); err != nil {
+			return nil, err
+		}
+		logger.Info("Restoring member", utils.M{"shard": shard, "host": n.SQLContainerName(), "backup": m.ID})
+		if err := b.restoreBase(ctx, n, m); err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", n.SQLContainerName(), err)
+		}
+		if err := b.replay(ctx, n, binlogs, opts.Until); err != nil {
+			return nil, fmt.Errorf("failed to replay binary logs on %s: %w", n.SQLContainerName(), err)
+		}
+	}
+
+	plan, err := db.PlanReboot(ctx, nodes, db.RebootOptions{From: -1})
+	if err != nil {
+		return nil, err
+	}
+	report.GTIDExecuted = plan.Members[plan.Bootstrap].GTIDSet
+	if err := plan.Execute(ctx); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// restoreBase replaces the data of a member with a backup, group replication
+// stays stopped and the member writable
+func (b *SQLBackup) restoreBase(ctx context.Context, n *db.SQLNode, m *Manifest) error {
+	r, err := b.Store.Get(ctx, m.Object)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	switch m.Kind {
+	case KindLogical:
+		queries := []string{"STOP GROUP_REPLICATION", "SET GLOBAL super_read_only = OFF"}
+		for _, name := range m.Databases {
+			queries = append(queries, fmt.Sprintf("DROP DATABASE IF EXISTS `%s`", strings.ReplaceAll(name, "`", "``")))
+		}
+		// the dump sets the purged GTIDs, which requires an empty history
+		queries = append(queries, "RESET BINARY LOGS AND GTIDS")
+		if err := execSQL(ctx, n, queries...); err != nil {
+			return err
+		}
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		return container.Exec(ctx, n.SQLContainerName(), []string{"mysql", "-uroot"}, container.ExecOptions{
+			Env:   []string{"MYSQL_PWD=" + n.RootPassword},
+			Stdin: gz,
+		})
+	case KindPhysical:
+		name := n.SQLContainerName()
+		if err := container.Stop(ctx, name); err != nil {
+			return err
+		}
+		// the clone keeps the identity of the source, the server generates a
+		// new one without auto.cnf
+		script := fmt.Sprintf("find %[1]s -mindepth 1 -delete && tar -xzf - -C %[1]s && rm -f %[1]s/auto.cnf && chown -R mysql:mysql %[1]s",
+			db.DataDir)
+		err := container.Run(ctx, n.Image, []string{"sh", "-c", script},
+			[]string{fmt.Sprintf("%s:%s", n.DataVolumeName(), db.DataDir)}, container.ExecOptions{Stdin: r})
+		if err != nil {
+			return err
+		}
+		if err := container.Start(ctx, name, restoreStartTimeout); err != nil {
+			return err
+		}
+		return execSQL(ctx, n, "STOP GROUP_REPLICATION", "SET GLOBAL super_read_only = OFF")
+	default:
+		return fmt.Errorf("unknown backup kind %q", m.Kind)
+	}
+}
+
+// replay applies archived binary logs to a member, stopping at a time
+func (b *SQLBackup) replay(ctx context.Context, n *db.SQLNode, keys []string, until time.Time) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	name := n.SQLContainerName()
+	if err := container.Exec(ctx, name, []string{"mkdir", "-p", restoreDir}, container.ExecOptions{}); err != nil {
+		return err
+	}
+	defer func() {
+		err := container.Exec(context.Background(), name, []string{"rm", "-rf", restoreDir}, container.ExecOptions{})
+		if err != nil {
+			logger.Error("Remove restored binary logs", err, utils.M{"dir": restoreDir})
+		}
+	}()
+	args := []string{"sh", "-c", `mysqlbinlog "$@" | mysql -uroot`, "sh"}
+	if !until.IsZero() {
+		args = append(args, "--stop-datetime="+until.UTC().Format(binlogTimeFormat))
+	}
+	for _, key := range keys {
+		// files of different members share names
+		file := path.Join(restoreDir, path.Base(path.Dir(key))+"-"+path.Base(key))
+		r, err := b.Store.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		err = container.Exec(ctx, name, []string{"sh", "-c", `cat > "$1"`, "sh", file}, container.ExecOptions{Stdin: r})
+		r.Close()
+		if err != nil {
+			return err
+		}
+		args = append(args, file)
+	}
+	return container.Exec(ctx, name, args, container.ExecOptions{Env: []string{"MYSQL_PWD=" + n.RootPassword, "TZ=UTC"}})
+}
+
+func execSQL(ctx context.Context, n *db.SQLNode, queries ...string) error {
+	conn, err := n.GetAdminDB()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, query := range queries {
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("%s: %w", query, err)
+		}
+	}
+	return nil
+}
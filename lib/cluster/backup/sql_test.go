@@ -0,0 +1,126 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package backup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/db"
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestParseDumpHeader tests reading the snapshot position of a dump
+func TestParseDumpHeader(t *testing.T) {
+	header := `-- MySQL dump 10.13  Distrib 8.4.4, for Linux (x86_64)
+SET @@SESSION.SQL_LOG_BIN= 0;
+
+--
+-- GTID state at the beginning of the backup
+--
+
+SET @@GLOBAL.GTID_PURGED=/*!80000 '+'*/ '3e11fa47-71ca-11e1-9e33-c80aa9429562:1-57,
+aaaaaaaa-71ca-11e1-9e33-c80aa9429562:1-3';
+
+--
+-- Position to start replication or point-in-time recovery from
+--
+
+-- CHANGE REPLICATION SOURCE TO SOURCE_LOG_FILE='mysql-bin.000004', SOURCE_LOG_POS=1723;
+`
+	gtids, file, pos := parseDumpHeader([]byte(header))
+	if want := "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-57,aaaaaaaa-71ca-11e1-9e33-c80aa9429562:1-3"; gtids != want {
+		t.Errorf("gtids = %q, want %q", gtids, want)
+	}
+	if file != "mysql-bin.000004" || pos != 1723 {
+		t.Errorf("position = %s:%d, want mysql-bin.000004:1723", file, pos)
+	}
+}
+
+// TestPickBackup tests choosing the backup to restore
+func TestPickBackup(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC) }
+	manifests := []*Manifest{{ID: "a", CreatedAt: day(1)}, {ID: "b", CreatedAt: day(3)}, {ID: "c", CreatedAt: day(5)}}
+	tests := []struct {
+		name    string
+		opts    RestoreOptions
+		want    string
+		wantErr bool
+	}{
+		{name: "latest", want: "c"},
+		{name: "before time", opts: RestoreOptions{Until: day(4)}, want: "b"},
+		{name: "at time", opts: RestoreOptions{Until: day(3)}, want: "b"},
+		{name: "too early", opts: RestoreOptions{Until: day(0)}, wantErr: true},
+		{name: "by id", opts: RestoreOptions{ID: "a", Until: day(4)}, want: "a"},
+		{name: "id after time", opts: RestoreOptions{ID: "c", Until: day(4)}, wantErr: true},
+		{name: "missing id", opts: RestoreOptions{ID: "d"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pickBackup(manifests, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pickBackup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.ID != tt.want {
+				t.Errorf("pickBackup() = %s, want %s", got.ID, tt.want)
+			}
+		})
+	}
+}
+
+// TestOrderBinlogs tests that the binary logs of the backup source replay first
+func TestOrderBinlogs(t *testing.T) {
+	keys := []string{
+		"t/sql/shard-0/binlogs/a/mysql-bin.000002",
+		"t/sql/shard-0/binlogs/a/mysql-bin.000001",
+		"t/sql/shard-0/binlogs/b/mysql-bin.000007",
+		"t/sql/shard-0/binlogs/c/mysql-bin.000001",
+	}
+	want := []string{
+		"t/sql/shard-0/binlogs/b/mysql-bin.000007",
+		"t/sql/shard-0/binlogs/a/mysql-bin.000001",
+		"t/sql/shard-0/binlogs/a/mysql-bin.000002",
+		"t/sql/shard-0/binlogs/c/mysql-bin.000001",
+	}
+	if diff := cmp.Diff(want, orderBinlogs(keys, "b")); diff != "" {
+		t.Errorf("orderBinlogs() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestChooseSource tests that backups prefer an ONLINE secondary
+func TestChooseSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []db.MemberStatus
+		want    int
+		wantErr bool
+	}{
+		{name: "secondary", members: []db.MemberStatus{
+			{State: db.MemberOnline, Role: db.RolePrimary},
+			{State: db.MemberRecovering, Role: db.RoleSecondary},
+			{State: db.MemberOnline, Role: db.RoleSecondary},
+		}, want: 2},
+		{name: "primary only", members: []db.MemberStatus{{State: db.MemberOnline, Role: db.RolePrimary}, {State: db.MemberUnreachable}}, want: 0},
+		{name: "offline", members: []db.MemberStatus{{State: db.MemberOffline}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var nodes []*db.SQLNode
+			for i := range tt.members {
+				nodes = append(nodes, &db.SQLNode{RepIndex: i})
+			}
+			b := NewSQLBackup(NewLocalStore(t.TempDir()), "zygote")
+			b.probe = func(_ context.Context, n *db.SQLNode) db.MemberStatus { return tt.members[n.RepIndex] }
+			got, _, err := b.chooseSource(context.Background(), nodes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("chooseSource() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.RepIndex != tt.want {
+				t.Errorf("chooseSource() = replica %d, want %d", got.RepIndex, tt.want)
+			}
+		})
+	}
+}
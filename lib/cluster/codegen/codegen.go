@@ -0,0 +1,296 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+// Package codegen generates typed Go code for the document tables of a schema.
+package codegen
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/evgnomon/zygote/lib/cluster/schema"
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+)
+
+//go:embed templates/*.tmpl
+var templates embed.FS
+
+var logger = utils.NewLogger()
+
+const idColumn = "id"
+const dataColumn = "data"
+
+// initialisms are kept upper case in Go names
+var initialisms = map[string]bool{
+	"id": true, "url": true, "uri": true, "uuid": true, "json": true, "sql": true,
+	"api": true, "http": true, "ip": true, "html": true, "xml": true, "utc": true,
+}
+
+type field struct {
+	Name   string
+	Column string
+	GoType string
+}
+
+type property struct {
+	Name   string
+	Path   string
+	GoType string
+}
+
+type finder struct {
+	Name   string
+	Column string
+	GoType string
+}
+
+type docType struct {
+	Name         string
+	Var          string
+	Repo         string
+	Object       string
+	Table        string
+	IDType       string
+	Columns      string
+	Placeholders string
+	Assignments  string
+	Fields       []field
+	Properties   []property
+	Finders      []finder
+}
+
+type file struct {
+	Package string
+	Imports []string
+	Types   []*docType
+}
+
+// Generate returns the formatted Go source of structs, JSON path accessors and
+// repositories for the document tables of the databases. Document tables have
+// an id primary key and a JSON data column, other tables are skipped.
+func Generate(s *schema.Schema, pkg string, databases []string) ([]byte, error) {
+	if len(databases) == 0 {
+		databases = s.DatabaseNames()
+	}
+	f := &file{Package: pkg}
+	imports := map[string]bool{"context": true, "database/sql": true, "encoding/json": true, "errors": true, "fmt": true}
+	names := map[string]string{}
+	for _, dbName := range databases {
+		d, ok := s.Databases[strings.ToLower(dbName)]
+		if !ok {
+			return nil, fmt.Errorf("database %s not found", dbName)
+		}
+		for _, tableName := range sortedTables(d) {
+			t := d.Tables[tableName]
+			doc, err := newDocType(d, t)
+			if err != nil {
+				return nil, err
+			}
+			if doc == nil {
+				logger.Debug("skip table without id and JSON data columns", utils.M{"table": d.Name + "." + t.Name})
+				continue
+			}
+			if other, ok := names[doc.Name]; ok {
+				return nil, fmt.Errorf("tables %s and %s both generate %s, generate them one database at a time", other, doc.Object, doc.Name)
+			}
+			names[doc.Name] = doc.Object
+			if doc.usesTime() {
+				imports["time"] = true
+			}
+			f.Types = append(f.Types, doc)
+		}
+	}
+	for imp := range imports {
+		f.Imports = append(f.Imports, imp)
+	}
+	sort.Strings(f.Imports)
+
+	src, err := templates.ReadFile("templates/documents.go.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New("documents.go.tmpl").Parse(string(src))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, f); err != nil {
+		return nil, err
+	}
+	code, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w", err)
+	}
+	return code, nil
+}
+
+func sortedTables(d *schema.Database) []string {
+	names := make([]string, 0, len(d.Tables))
+	for name := range d.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newDocType describes a document table, it returns nil for other tables
+func newDocType(d *schema.Database, t *schema.Table) (*docType, error) {
+	id, hasID := t.Columns[idColumn]
+	data, hasData := t.Columns[dataColumn]
+	if !hasID || !hasData || data.Type != "json" {
+		return nil, nil
+	}
+	idType, err := goType(id.Type)
+	if err != nil {
+		return nil, fmt.Errorf("%s.%s.id: %w", d.Name, t.Name, err)
+	}
+	name := GoName(singular(t.Name))
+	doc := &docType{
+		Name:   name,
+		Var:    strings.ToLower(name[:1]) + name[1:],
+		Repo:   name + "Repo",
+		Object: d.Name + "." + t.Name,
+		Table:  fmt.Sprintf("`%s`.`%s`", d.Name, t.Name),
+		IDType: idType,
+	}
+	columns := []string{"`id`", "`data`"}
+	assignments := []string{"`data` = ?"}
+	for _, columnName := range sortedColumns(t) {
+		c := t.Columns[columnName]
+		if columnName == idColumn || columnName == dataColumn {
+			continue
+		}
+		typ, err := goType(c.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s.%s: %w", d.Name, t.Name, c.Name, err)
+		}
+		switch {
+		case c.Generated && c.JSONPath != "":
+			doc.Properties = append(doc.Properties, property{Name: GoName(c.Name), Path: c.JSONPath, GoType: typ})
+		case c.Generated:
+			// computed from other columns, only filterable
+		default:
+			if c.Nullable && typ != "[]byte" && typ != "json.RawMessage" {
+				typ = "*" + typ
+			}
+			doc.Fields = append(doc.Fields, field{Name: GoName(c.Name), Column: c.Name, GoType: typ})
+			columns = append(columns, fmt.Sprintf("`%s`", c.Name))
+			assignments = append(assignments, fmt.Sprintf("`%s` = ?", c.Name))
+		}
+		if leadsIndex(t, c.Name) {
+			doc.Finders = append(doc.Finders, finder{Name: "FindBy" + GoName(c.Name), Column: c.Name, GoType: strings.TrimPrefix(typ, "*")})
+		}
+	}
+	doc.Columns = strings.Join(columns, ", ")
+	doc.Placeholders = strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	doc.Assignments = strings.Join(assignments, ", ")
+	return doc, nil
+}
+
+func (doc *docType) usesTime() bool {
+	for _, f := range doc.Fields {
+		if strings.Contains(f.GoType, "time.") {
+			return true
+		}
+	}
+	for _, p := range doc.Properties {
+		if strings.Contains(p.GoType, "time.") {
+			return true
+		}
+	}
+	for _, f := range doc.Finders {
+		if strings.Contains(f.GoType, "time.") {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedColumns(t *schema.Table) []string {
+	names := make([]string, 0, len(t.Columns))
+	for name := range t.Columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// leadsIndex reports whether the column is the first column of an index other
+// than the primary key
+func leadsIndex(t *schema.Table, column string) bool {
+	for _, idx := range t.Indexes {
+		if idx.Name != "PRIMARY" && !idx.FullText && len(idx.Columns) > 0 && strings.EqualFold(idx.Columns[0], column) {
+			return true
+		}
+	}
+	return false
+}
+
+// goType maps a normalized column type to a Go type
+func goType(columnType string) (string, error) {
+	base := columnType
+	if i := strings.IndexAny(base, "( "); i >= 0 {
+		base = base[:i]
+	}
+	switch base {
+	case "tinyint", "smallint", "mediumint", "int", "bigint":
+		if columnType == "tinyint(1)" {
+			return "bool", nil
+		}
+		if strings.Contains(columnType, " unsigned") {
+			return "uint64", nil
+		}
+		return "int64", nil
+	case "float", "double":
+		return "float64", nil
+	case "decimal", "char", "varchar", "tinytext", "text", "mediumtext", "longtext", "enum", "set", "time":
+		return "string", nil
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		return "[]byte", nil
+	case "date", "datetime", "timestamp":
+		return "time.Time", nil
+	case "json":
+		return "json.RawMessage", nil
+	}
+	return "", fmt.Errorf("unsupported column type %s", columnType)
+}
+
+// GoName converts a snake case name to an exported Go name
+func GoName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool {
+		return r == '_' || r == '-' || r == ' ' || r == '.'
+	}) {
+		if initialisms[strings.ToLower(part)] {
+			b.WriteString(strings.ToUpper(part))
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	s := b.String()
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		s = "X" + s
+	}
+	return s
+}
+
+// singular guesses the singular of an English table name
+func singular(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, "ies") && len(name) > 3:
+		return name[:len(name)-3] + "y"
+	case strings.HasSuffix(lower, "sses"), strings.HasSuffix(lower, "xes"), strings.HasSuffix(lower, "ches"):
+		return name[:len(name)-2]
+	case strings.HasSuffix(lower, "s") && !strings.HasSuffix(lower, "ss") &&
+		!strings.HasSuffix(lower, "us") && !strings.HasSuffix(lower, "is"):
+		return name[:len(name)-1]
+	}
+	return name
+}
@@ -0,0 +1,83 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/evgnomon/zygote/lib/cluster/schema"
+)
+
+var testMigrations = []string{
+	"CREATE DATABASE shop;",
+	"CREATE TABLE IF NOT EXISTS `shop`.`categories` (\n" +
+		"    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,\n" +
+		"    data JSON NOT NULL,\n" +
+		"    PRIMARY KEY (id)\n" +
+		");",
+	"ALTER TABLE `shop`.`categories` ADD COLUMN `parent_id` BIGINT UNSIGNED;",
+	"ALTER TABLE `shop`.`categories` ADD COLUMN `created_at` DATETIME(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3);",
+	"ALTER TABLE `shop`.`categories`\n" +
+		"ADD COLUMN `url` VARCHAR(255) GENERATED ALWAYS AS (data->>'$.links.url') STORED;",
+	"ALTER TABLE `shop`.`categories`\nADD UNIQUE INDEX `idx_url` (\n  `url`\n);",
+	"CREATE TABLE `shop`.`audit` (at DATETIME, message TEXT);",
+}
+
+// TestGenerate tests generating code for a document table
+func TestGenerate(t *testing.T) {
+	s := schema.New()
+	for _, doc := range testMigrations {
+		if err := s.Apply(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	code, err := Generate(s, "models", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := string(code)
+	for _, want := range []string{
+		"package models",
+		"\t\"time\"\n",
+		"type Category struct {",
+		"\tID        uint64\n",
+		"\tCreatedAt time.Time\n",
+		"\tParentID  *uint64\n",
+		"func (d *Category) URL() (string, bool) {",
+		"tables.DocValue[string](d.Data, \"$.links.url\")",
+		"func (d *Category) SetURL(v string) error {",
+		"func NewCategoryRepo(connector *tables.MultiDBConnector) *CategoryRepo {",
+		"const categoryColumns = \"`id`, `data`, `created_at`, `parent_id`\"",
+		"func (r *CategoryRepo) Get(ctx context.Context, key string, id uint64) (*Category, error) {",
+		"UPDATE `shop`.`categories` SET `data` = ?, `created_at` = ?, `parent_id` = ? WHERE `id` = ?",
+		"func (r *CategoryRepo) FindByURL(ctx context.Context, key string, v string) ([]*Category, error) {",
+	} {
+		if !strings.Contains(src, want) {
+			t.Errorf("generated code misses %q:\n%s", want, src)
+		}
+	}
+	if strings.Contains(src, "Audit") {
+		t.Error("tables without id and data must be skipped")
+	}
+}
+
+// TestNames tests Go names of tables and columns
+func TestNames(t *testing.T) {
+	tests := map[string]string{
+		"users":      "User",
+		"categories": "Category",
+		"addresses":  "Address",
+		"status":     "Status",
+		"user_id":    "UserID",
+		"api_url":    "APIURL",
+		"2fa_codes":  "X2faCode",
+	}
+	for input, want := range tests {
+		if got := GoName(singular(input)); got != want {
+			t.Errorf("GoName(singular(%q)) = %q, want %q", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+// Package commands contains all available commands.
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/backup"
+	"github.com/evgnomon/zygote/lib/cluster/db"
+	"github.com/evgnomon/zygote/lib/cluster/topology"
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// untilLayouts are the accepted formats of a point in time, in UTC unless
+// the format carries a zone
+var untilLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05"}
+
+func storeFlag(name, usage string) cli.Flag {
+	return &cli.StringFlag{
+		Name:     name,
+		Usage:    usage + ", a directory or s3://bucket/prefix",
+		EnvVars:  []string{"ZYGOTE_BACKUP_STORE"},
+		Required: true,
+	}
+}
+
+func shardsFlag() cli.Flag {
+	return &cli.IntSliceFlag{
+		Name:  "shard",
+		Usage: "Shards to process, all shards by default",
+	}
+}
+
+// selectedShards returns the replicas of the shards chosen by the shard flag.
+func selectedShards(c *cli.Context, t *topology.Topology) ([][]*db.SQLNode, error) {
+	all := sqlNodes(t)
+	if len(c.IntSlice("shard")) == 0 {
+		return all, nil
+	}
+	var nodes [][]*db.SQLNode
+	for _, shard := range c.IntSlice("shard") {
+		if shard < 0 || shard >= len(all) {
+			return nil, fmt.Errorf("shard %d does not exist", shard)
+		}
+		nodes = append(nodes, all[shard])
+	}
+	return nodes, nil
+}
+
+func parseUntil(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range untilLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use RFC 3339 or YYYY-MM-DD HH:MM:SS in UTC", s)
+}
+
+// BackupCommand backs up the SQL shards and archives their binary logs.
+func BackupCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "backup",
+		Usage: "Back up the SQL shards from a secondary and archive their binary logs",
+		Flags: []cli.Flag{
+			topologyFlag(),
+			storeFlag("to", "Where to store the backups"),
+			shardsFlag(),
+			&cli.BoolFlag{
+				Name:  "physical",
+				Usage: "Clone the data directory instead of dumping the application databases",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			ctx := context.Background()
+			t, _, err := loadTopology(c)
+			if err != nil {
+				return err
+			}
+			store, err := backup.OpenStore(c.String("to"))
+			if err != nil {
+				return err
+			}
+			shards, err := selectedShards(c, t)
+			if err != nil {
+				return err
+			}
+			kind := backup.KindLogical
+			if c.Bool("physical") {
+				kind = backup.KindPhysical
+			}
+			b := backup.NewSQLBackup(store, t.Tenant)
+			for _, nodes := range shards {
+				m, err := b.Backup(ctx, nodes, kind)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("shard %d: backup %s from %s at %s\n", m.Shard, m.ID, m.Source, m.GTIDExecuted)
+				if _, err := b.ArchiveBinlogs(ctx, nodes); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Subcommands: []*cli.Command{
+			archiveBinlogsCommand(),
+			listBackupsCommand(),
+		},
+	}
+}
+
+// archiveBinlogsCommand archives the binary logs closed since the last run.
+func archiveBinlogsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "binlogs",
+		Usage: "Archive the binary logs of the SQL shards, run it periodically for point-in-time restores",
+		Flags: []cli.Flag{
+			topologyFlag(),
+			storeFlag("to", "Where to store the binary logs"),
+			shardsFlag(),
+		},
+		Action: func(c *cli.Context) error {
+			ctx := context.Background()
+			t, _, err := loadTopology(c)
+			if err != nil {
+				return err
+			}
+			store, err := backup.OpenStore(c.String("to"))
+			if err != nil {
+				return err
+			}
+			shards, err := selectedShards(c, t)
+			if err != nil {
+				return err
+			}
+			b := backup.NewSQLBackup(store, t.Tenant)
+			for _, nodes := range shards {
+				keys, err := b.ArchiveBinlogs(ctx, nodes)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("shard %d: archived %d binary logs\n", nodes[0].ShardIndex, len(keys))
+			}
+			return nil
+		},
+	}
+}
+
+// listBackupsCommand lists the complete backups of each shard.
+func listBackupsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "list",
+		Usage: "List the backups of the SQL shards",
+		Flags: []cli.Flag{
+			topologyFlag(),
+			storeFlag("from", "Where the backups are stored"),
+			&cli.StringFlag{
+				Name:  "tenant",
+				Usage: "Tenant that took the backups, defaults to the tenant of the topology",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			ctx := context.Background()
+			t, _, err := loadTopology(c)
+			if err != nil {
+				return err
+			}
+			store, err := backup.OpenStore(c.String("from"))
+			if err != nil {
+				return err
+			}
+			b := backup.NewSQLBackup(store, backupTenant(c, t))
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "SHARD\tID\tKIND\tSOURCE\tGTID_EXECUTED")
+			for shard := 0; shard < t.Shards; shard++ {
+				manifests, err := b.Backups(ctx, shard)
+				if err != nil {
+					return err
+				}
+				for _, m := range manifests {
+					fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", m.Shard, m.ID, m.Kind, m.Source, m.GTIDExecuted)
+				}
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func backupTenant(c *cli.Context, t *topology.Topology) string {
+	if c.String("tenant") != "" {
+		return c.String("tenant")
+	}
+	return t.Tenant
+}
+
+// RestoreCommand restores the SQL shards of a fresh cluster to a point in time.
+func RestoreCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "restore",
+		Usage: "Restore the SQL shards of a fresh cluster from a backup to a point in time",
+		Flags: []cli.Flag{
+			topologyFlag(),
+			storeFlag("from", "Where the backups are stored"),
+			shardsFlag(),
+			&cli.StringFlag{
+				Name:  "tenant",
+				Usage: "Tenant that took the backups, defaults to the tenant of the topology",
+			},
+			&cli.StringFlag{
+				Name:  "id",
+				Usage: "Backup to restore, defaults to the latest one taken before --until",
+			},
+			&cli.StringFlag{
+				Name:  "until",
+				Usage: "Point in time to restore to, in RFC 3339 or YYYY-MM-DD HH:MM:SS UTC, defaults to the last archived transaction",
+			},
+			&cli.BoolFlag{
+				Name:    "yes",
+				Aliases: []string{"y"},
+				Usage:   "Do not ask for confirmation",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			ctx := context.Background()
+			t, _, err := loadTopology(c)
+			if err != nil {
+				return err
+			}
+			until, err := parseUntil(c.String("until"))
+			if err != nil {
+				return err
+			}
+			store, err := backup.OpenStore(c.String("from"))
+			if err != nil {
+				return err
+			}
+			shards, err := selectedShards(c, t)
+			if err != nil {
+				return err
+			}
+			if !c.Bool("yes") && !utils.GetYesNoInput(fmt.Sprintf("This replaces the data of tenant %s.", t.Tenant)) {
+				return nil
+			}
+			b := backup.NewSQLBackup(store, backupTenant(c, t))
+			opts := backup.RestoreOptions{ID: c.String("id"), Until: until}
+			for _, nodes := range shards {
+				report, err := b.Restore(ctx, nodes, opts)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("shard %d: restored backup %s with %d binary logs to %s\n",
+					report.Shard, report.Backup, report.Binlogs, report.GTIDExecuted)
+			}
+			return nil
+		},
+	}
+}
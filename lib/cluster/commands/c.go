@@ -6,9 +6,12 @@ License: HGL General License <https://evgnomon.org/docs/hgl>
 package commands
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"io"
+	nethttp "net/http"
+	"net/url"
 	"os"
 	"strings"
 
@@ -19,6 +22,9 @@ import (
 	"github.com/urfave/cli/v2"
 )
 
+// maxEventSize is the largest event printEvents reads
+const maxEventSize = 16 << 20
+
 // CCommand executes Mem query on a shard.
 func CCommand() *cli.Command {
 	return &cli.Command{
@@ -33,14 +39,7 @@ func CCommand() *cli.Command {
 			},
 		},
 		Action: func(c *cli.Context) error {
-			server := c.Args().Get(0)
-			if server == "" {
-				server = "zygote:8443"
-			}
-			if !strings.Contains(server, ":") {
-				server = fmt.Sprintf("%s:443", server)
-			}
-			url := fmt.Sprintf("https://%s/mem/query", server)
+			url := fmt.Sprintf("https://%s/mem/query", memServer(c.Args().Get(0)))
 			query, err := io.ReadAll(os.Stdin)
 			if err != nil {
 				return fmt.Errorf("failed to read from stdin: %v", err)
@@ -59,7 +58,120 @@ func CCommand() *cli.Command {
 			}
 			return sendAndPrint(url, c.String("user"), p)
 		},
+		Subcommands: []*cli.Command{
+			subscribeCommand(),
+			streamCommand(),
+		},
+	}
+}
+
+// memServer returns the address of the API server given on the command line.
+func memServer(server string) string {
+	if server == "" {
+		server = "zygote:8443"
+	}
+	if !strings.Contains(server, ":") {
+		server = fmt.Sprintf("%s:443", server)
+	}
+	return server
+}
+
+func eventFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "user",
+			Aliases: []string{"u"},
+			Usage:   "User name with sign certificate",
+			Value:   utils.User(),
+		},
+		&cli.StringFlag{
+			Name:  "server",
+			Usage: "API server address",
+			Value: "zygote:8443",
+		},
+	}
+}
+
+// subscribeCommand prints the messages published to channels.
+func subscribeCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "subscribe",
+		Usage:     "Print the messages published to channels as JSON lines until interrupted",
+		ArgsUsage: "CHANNEL...",
+		Flags: append(eventFlags(),
+			&cli.StringSliceFlag{
+				Name:  "pattern",
+				Usage: "Also print the messages of the channels matching a pattern",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			query := url.Values{"channel": c.Args().Slice(), "pattern": c.StringSlice("pattern")}
+			if len(query["channel"]) == 0 && len(query["pattern"]) == 0 {
+				return fmt.Errorf("expected a channel or a pattern")
+			}
+			return printEvents(fmt.Sprintf("https://%s/mem/subscribe?%s", memServer(c.String("server")), query.Encode()), c.String("user"))
+		},
+	}
+}
+
+// streamCommand prints the entries of a stream read by a consumer group.
+func streamCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "stream",
+		Usage:     "Print the entries of a stream read by a consumer group as JSON lines until interrupted",
+		ArgsUsage: "STREAM",
+		Flags: append(eventFlags(),
+			&cli.StringFlag{
+				Name:     "group",
+				Usage:    "Consumer group, created at the end of the stream if missing",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "consumer",
+				Usage: "Consumer name, defaults to the user",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("expected a stream")
+			}
+			query := url.Values{"group": {c.String("group")}}
+			if c.String("consumer") != "" {
+				query.Set("consumer", c.String("consumer"))
+			}
+			return printEvents(fmt.Sprintf("https://%s/mem/streams/%s?%s",
+				memServer(c.String("server")), url.PathEscape(c.Args().First()), query.Encode()), c.String("user"))
+		},
+	}
+}
+
+// printEvents prints the data of the server-sent events of a URL, one line
+// per event.
+func printEvents(target, user string) error {
+	client, err := http.NewHTTPTransportConfigForUser(user).Client()
+	if err != nil {
+		return err
+	}
+	// The stream lasts until it is interrupted
+	client.SetTimeout(0)
+	r, err := client.R().SetDoNotParseResponse(true).SetHeader("Accept", "text/event-stream").Get(target)
+	if err != nil {
+		return err
+	}
+	body := r.RawBody()
+	defer body.Close()
+	if r.StatusCode() != nethttp.StatusOK {
+		msg, _ := io.ReadAll(body)
+		return fmt.Errorf("%s: %s", r.Status(), strings.TrimSpace(string(msg)))
+	}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, maxEventSize)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			fmt.Println(data)
+		}
 	}
+	return scanner.Err()
 }
 
 func sendAndPrint(url, user string, p any) error {
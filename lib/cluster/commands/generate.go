@@ -6,39 +6,24 @@ License: HGL General License <https://evgnomon.org/docs/hgl>
 package commands
 
 import (
+	"context"
 	"fmt"
+	"go/token"
+	"os"
+	"path/filepath"
 
+	"github.com/evgnomon/zygote/lib/cluster/codegen"
 	"github.com/evgnomon/zygote/lib/cluster/db"
+	"github.com/evgnomon/zygote/lib/cluster/schema"
 	"github.com/evgnomon/zygote/lib/cluster/utils"
 	"github.com/urfave/cli/v2"
 )
 
-const varChar255 = "VARCHAR(255)"
-
-func sqlCol(colType string) string {
-	var sqlCol string
-	switch colType {
-	case "string":
-		sqlCol = varChar255
-	case "integer":
-		sqlCol = "INT"
-	case "double":
-		sqlCol = "DOUBLE"
-	case "bool":
-		sqlCol = "BOOLEAN"
-	case "binary":
-		sqlCol = "MEDIUMBLOB"
-	case "json":
-		sqlCol = "JSON"
-	case "uuid":
-		sqlCol = "CHAR(36)"
-	case "text":
-		sqlCol = "MEDIUMTEXT"
-	default:
-		sqlCol = varChar255
-	}
-	return sqlCol
-}
+const sourceFileMode = 0644
+
+const columnTypeUsage = "Column type: string, integer, double, bool, binary, json, text, uuid, " +
+	"or a SQL type such as DECIMAL(10,2), DATETIME(3), TIMESTAMP(6), ENUM('a','b'), BIGINT UNSIGNED, " +
+	"VARBINARY(16) or VARCHAR(64)"
 
 func generateDBCommand() *cli.Command {
 	return &cli.Command{
@@ -135,9 +120,33 @@ func generateColCommand() *cli.Command {
 			},
 			&cli.StringFlag{
 				Name:  "type",
-				Usage: "Column type: string, integer, double, bool, binary, json, text, uuid",
+				Usage: columnTypeUsage,
 				Value: "string",
 			},
+			&cli.BoolFlag{
+				Name:  "not-null",
+				Usage: "Reject NULL values, existing rows get the default or the zero value of the type",
+			},
+			&cli.StringFlag{
+				Name:  "default",
+				Usage: "Default value. Numbers, NULL, CURRENT_TIMESTAMP and (expressions) are kept as they are, other values are quoted",
+			},
+			&cli.StringFlag{
+				Name:  "after",
+				Usage: "Place the column after this column",
+			},
+			&cli.StringFlag{
+				Name:  "comment",
+				Usage: "Column comment",
+			},
+			&cli.StringFlag{
+				Name:  "generated-as",
+				Usage: "Expression of a generated column, e.g. \"price * quantity\"",
+			},
+			&cli.BoolFlag{
+				Name:  "stored",
+				Usage: "Store the generated column instead of computing it on read",
+			},
 		},
 		Action: func(c *cli.Context) error {
 			colName := c.String("name")
@@ -161,7 +170,22 @@ func generateColCommand() *cli.Command {
 				colType = "string"
 			}
 
-			m, err := db.CreateColumn(dbName, tableName, colName, sqlCol(colType))
+			opts := db.ColumnOptions{
+				NotNull:     c.Bool("not-null"),
+				After:       c.String("after"),
+				Comment:     c.String("comment"),
+				GeneratedAs: c.String("generated-as"),
+				Stored:      c.Bool("stored"),
+			}
+			if c.IsSet("default") {
+				defaultValue := c.String("default")
+				opts.Default = &defaultValue
+			}
+			params, err := db.NewCreateColumnParams(dbName, tableName, colName, colType, opts)
+			if err != nil {
+				return err
+			}
+			m, err := db.GenCreateSQL(params)
 			if err != nil {
 				return fmt.Errorf("failed to create column: %w", err)
 			}
@@ -204,7 +228,7 @@ func generatePropCommand() *cli.Command {
 			},
 			&cli.StringFlag{
 				Name:  "type",
-				Usage: "Column type: string, integer, double, bool, binary, json, text, uuid",
+				Usage: columnTypeUsage,
 				Value: "string",
 			},
 			&cli.BoolFlag{
@@ -234,8 +258,12 @@ func generatePropCommand() *cli.Command {
 				dbName = name
 			}
 
+			colType, err := db.ParseColumnType(c.String("type"))
+			if err != nil {
+				return err
+			}
 			m, err := db.CreateProperty(dbName, tableName, colName, fieldPath,
-				sqlCol(c.String("type")), c.Bool("virtual"))
+				colType.SQL(), c.Bool("virtual"))
 			if err != nil {
 				return fmt.Errorf("failed to create column: %w", err)
 			}
@@ -330,6 +358,366 @@ func generateIndexCommand() *cli.Command {
 	}
 }
 
+// databaseName returns the database flag or the name of the repository.
+func databaseName(c *cli.Context) string {
+	if dbName := c.String("db"); dbName != "" {
+		return dbName
+	}
+	return utils.RepoFullName()
+}
+
+// saveMigration renders the migration templates of spec into the migration
+// directory.
+func saveMigration(spec db.MigrationSpec) error {
+	m, err := db.GenCreateSQL(spec)
+	if err != nil {
+		return fmt.Errorf("failed to create %s %s: %w", spec.GetAction(), spec.GetType(), err)
+	}
+	err = m.Save()
+	if err != nil {
+		return fmt.Errorf("failed to save model: %w", err)
+	}
+	return nil
+}
+
+// tableFlags are the flags naming the table a migration alters.
+func tableFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     "table",
+			Aliases:  []string{"t"},
+			Usage:    "Name of the table",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "db",
+			Usage: "Name of the database",
+		},
+	}
+}
+
+// columnDefinitionFlags are the flags of a column definition.
+func columnDefinitionFlags(typeFlag string) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  typeFlag,
+			Usage: columnTypeUsage,
+		},
+		&cli.BoolFlag{
+			Name:  "not-null",
+			Usage: "Reject NULL values, existing rows get the default or the zero value of the type",
+		},
+		&cli.StringFlag{
+			Name:  "default",
+			Usage: "Default value. Numbers, NULL, CURRENT_TIMESTAMP and (expressions) are kept as they are, other values are quoted",
+		},
+		&cli.StringFlag{
+			Name:  "comment",
+			Usage: "Column comment",
+		},
+	}
+}
+
+// columnOptions reads the column definition flags.
+func columnOptions(c *cli.Context) db.ColumnOptions {
+	opts := db.ColumnOptions{
+		NotNull: c.Bool("not-null"),
+		Comment: c.String("comment"),
+	}
+	if c.IsSet("default") {
+		defaultValue := c.String("default")
+		opts.Default = &defaultValue
+	}
+	return opts
+}
+
+// existingColumn returns the definition given by the type flag, or the one
+// implied by the migrations when the flag is not set.
+func existingColumn(c *cli.Context, typeFlag string, opts db.ColumnOptions) (*db.ColumnDefinition, error) {
+	if colType := c.String(typeFlag); colType != "" {
+		return db.NewColumnDefinition(colType, opts)
+	}
+	d, err := db.ExistingColumn(databaseName(c), c.String("table"), c.String("name"))
+	if err != nil {
+		return nil, fmt.Errorf("%w, use --%s to give its type", err, typeFlag)
+	}
+	return d, nil
+}
+
+func generateForeignKeyCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "foreign-key",
+		Aliases: []string{"fk"},
+		Usage:   "Create a foreign key. Foreign keys are enforced within a shard only",
+		Flags: append(tableFlags(),
+			&cli.StringFlag{
+				Name:     "name",
+				Aliases:  []string{"n"},
+				Usage:    "Name of the foreign key",
+				Required: true,
+			},
+			&cli.StringSliceFlag{
+				Name:     "column",
+				Aliases:  []string{"col"},
+				Usage:    "Referencing column. Use more than once for multiple columns",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "ref-table",
+				Usage:    "Referenced table",
+				Required: true,
+			},
+			&cli.StringSliceFlag{
+				Name:  "ref-column",
+				Usage: "Referenced column, id by default. Use more than once for multiple columns",
+			},
+			&cli.StringFlag{
+				Name:  "ref-db",
+				Usage: "Database of the referenced table, the database of the table by default",
+			},
+			&cli.StringFlag{
+				Name:  "on-delete",
+				Usage: "RESTRICT, CASCADE, SET NULL or NO ACTION",
+				Value: "RESTRICT",
+			},
+			&cli.StringFlag{
+				Name:  "on-update",
+				Usage: "RESTRICT, CASCADE, SET NULL or NO ACTION",
+				Value: "RESTRICT",
+			},
+		),
+		Action: func(c *cli.Context) error {
+			params, err := db.NewCreateForeignKeyParams(databaseName(c), c.String("table"), c.String("name"),
+				c.StringSlice("column"), c.String("ref-db"), c.String("ref-table"), c.StringSlice("ref-column"),
+				c.String("on-delete"), c.String("on-update"))
+			if err != nil {
+				return err
+			}
+			logger.Warning(params.ShardLocalityWarning())
+			return saveMigration(params)
+		},
+	}
+}
+
+func generateRenameTableCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "rename-table",
+		Usage: "Rename a table",
+		Flags: append(tableFlags(),
+			&cli.StringFlag{
+				Name:     "to",
+				Usage:    "New name of the table",
+				Required: true,
+			},
+		),
+		Action: func(c *cli.Context) error {
+			return saveMigration(db.NewRenameTableParams(databaseName(c), c.String("table"), c.String("to")))
+		},
+	}
+}
+
+func generateRenameColumnCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "rename-column",
+		Usage: "Rename a column",
+		Flags: append(tableFlags(),
+			&cli.StringFlag{
+				Name:     "name",
+				Aliases:  []string{"n"},
+				Usage:    "Name of the column",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "to",
+				Usage:    "New name of the column",
+				Required: true,
+			},
+		),
+		Action: func(c *cli.Context) error {
+			return saveMigration(db.NewRenameColumnParams(databaseName(c), c.String("table"), c.String("name"), c.String("to")))
+		},
+	}
+}
+
+func generateDropColumnCommand() *cli.Command {
+	flags := append(tableFlags(), &cli.StringFlag{
+		Name:     "name",
+		Aliases:  []string{"n"},
+		Usage:    "Name of the column",
+		Required: true,
+	})
+	return &cli.Command{
+		Name:  "drop-column",
+		Usage: "Drop a column. The down migration restores its definition from the migrations or the flags, not its data",
+		Flags: append(flags, columnDefinitionFlags("type")...),
+		Action: func(c *cli.Context) error {
+			definition, err := existingColumn(c, "type", columnOptions(c))
+			if err != nil {
+				return err
+			}
+			return saveMigration(db.NewDropColumnParams(databaseName(c), c.String("table"), c.String("name"), definition))
+		},
+	}
+}
+
+func generateChangeColumnCommand() *cli.Command {
+	flags := append(tableFlags(),
+		&cli.StringFlag{
+			Name:     "name",
+			Aliases:  []string{"n"},
+			Usage:    "Name of the column",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "from-type",
+			Usage: "Current type of the column restored by the down migration, read from the migrations by default",
+		},
+		&cli.BoolFlag{
+			Name:  "from-not-null",
+			Usage: "The current column rejects NULL values, used with --from-type",
+		},
+	)
+	return &cli.Command{
+		Name:  "change-column",
+		Usage: "Change the type and attributes of a column",
+		Flags: append(flags, columnDefinitionFlags("type")...),
+		Action: func(c *cli.Context) error {
+			if c.String("type") == "" {
+				return fmt.Errorf("type is required")
+			}
+			definition, err := db.NewColumnDefinition(c.String("type"), columnOptions(c))
+			if err != nil {
+				return err
+			}
+			previous, err := existingColumn(c, "from-type", db.ColumnOptions{NotNull: c.Bool("from-not-null")})
+			if err != nil {
+				return err
+			}
+			return saveMigration(db.NewChangeColumnParams(databaseName(c), c.String("table"), c.String("name"),
+				definition, previous))
+		},
+	}
+}
+
+func generateCheckCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "check",
+		Usage: "Create a check constraint",
+		Flags: append(tableFlags(),
+			&cli.StringFlag{
+				Name:     "name",
+				Aliases:  []string{"n"},
+				Usage:    "Name of the check constraint",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "expression",
+				Aliases:  []string{"e"},
+				Usage:    "Condition every row must satisfy, e.g. \"price >= 0\"",
+				Required: true,
+			},
+		),
+		Action: func(c *cli.Context) error {
+			return saveMigration(&db.CreateCheckParams{
+				CreateSQLParams: db.CreateSQLParams{
+					Type:         "check",
+					DatabaseName: databaseName(c),
+					TableName:    c.String("table"),
+					Name:         c.String("name"),
+				},
+				Expression: c.String("expression"),
+			})
+		},
+	}
+}
+
+// codeSchema reads the schema from the migrations, or from the first shard of
+// the topology when live is set.
+func codeSchema(c *cli.Context, databases []string) (*schema.Schema, error) {
+	if !c.Bool("live") {
+		files, err := schema.MigrationFiles(c.String("directory"))
+		if err != nil {
+			return nil, err
+		}
+		return schema.Expected(files, schema.LatestVersion(files))
+	}
+	ctx := context.Background()
+	t, _, err := loadTopology(c)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := connectTopology(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	defer connector.CloseAll()
+	conn, err := connector.GetReadConnection(0)
+	if err != nil {
+		return nil, err
+	}
+	if len(databases) == 0 {
+		databases = []string{utils.RepoFullName()}
+	}
+	return schema.Introspect(ctx, conn, databases)
+}
+
+func generateCodeCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "code",
+		Usage: "Generate Go structs, JSON path accessors and repositories for the document tables",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "out",
+				Aliases: []string{"o"},
+				Usage:   "Go file to write",
+				Value:   filepath.Join("models", "models.go"),
+			},
+			&cli.StringFlag{
+				Name:  "package",
+				Usage: "Package name of the generated file, the name of its directory by default",
+			},
+			&cli.StringSliceFlag{
+				Name:  "db",
+				Usage: "Database to generate. Use more than once for multiple databases, all databases by default",
+			},
+			&cli.StringFlag{
+				Name:    "directory",
+				Aliases: []string{"C"},
+				Usage:   "Directory containing the SQL migration files",
+				Value:   "sqls",
+			},
+			&cli.BoolFlag{
+				Name:  "live",
+				Usage: "Read the schema of the first shard instead of the migrations",
+			},
+			topologyFlag(),
+		},
+		Action: func(c *cli.Context) error {
+			out := c.String("out")
+			pkg := c.String("package")
+			if pkg == "" {
+				pkg = filepath.Base(filepath.Dir(out))
+			}
+			if !token.IsIdentifier(pkg) {
+				return fmt.Errorf("invalid package name %q, use --package", pkg)
+			}
+			databases := c.StringSlice("db")
+			s, err := codeSchema(c, databases)
+			if err != nil {
+				return err
+			}
+			code, err := codegen.Generate(s, pkg, databases)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(out), dirPerm); err != nil {
+				return err
+			}
+			return os.WriteFile(out, code, sourceFileMode) // #nosec
+		},
+	}
+}
+
 // GenerateCommand generates source files.
 func GenerateCommand() *cli.Command {
 	return &cli.Command{
@@ -342,6 +730,13 @@ func GenerateCommand() *cli.Command {
 			generateColCommand(),
 			generatePropCommand(),
 			generateIndexCommand(),
+			generateForeignKeyCommand(),
+			generateRenameTableCommand(),
+			generateRenameColumnCommand(),
+			generateDropColumnCommand(),
+			generateChangeColumnCommand(),
+			generateCheckCommand(),
+			generateCodeCommand(),
 		},
 	}
 }
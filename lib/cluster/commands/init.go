@@ -7,40 +7,72 @@ package commands
 
 import (
 	"context"
+	"fmt"
 	"sync"
 
 	"github.com/evgnomon/zygote/lib/cluster/container"
 	"github.com/evgnomon/zygote/lib/cluster/db"
 	"github.com/evgnomon/zygote/lib/cluster/mem"
+	"github.com/evgnomon/zygote/lib/cluster/secrets"
+	"github.com/evgnomon/zygote/lib/cluster/tables"
+	"github.com/evgnomon/zygote/lib/cluster/topology"
 	"github.com/evgnomon/zygote/lib/cluster/utils"
 	"github.com/urfave/cli/v2"
 )
 
-const defaultShardSize = 3
-const defaultNumShards = 3
-
 var logger = utils.NewLogger()
 
-func createNode(ctx context.Context, sn *db.SQLNode) {
+func createNode(ctx context.Context, sn *db.SQLNode, mc *mem.MemNode) {
 	n := container.NewNetworkConfig(sn.NetworkName)
 	n.Ensure(ctx)
 	err := sn.StartSQLContainers(ctx)
 	logger.FatalIfErr("Make SQL node", err)
-	mc := mem.NewMemNode()
-	mc.Domain = sn.Domain
-	mc.Tenant = sn.Tenant
-	mc.ShardIndex = sn.ShardIndex
-	mc.RepIndex = sn.RepIndex
-	mc.NetworkName = sn.NetworkName
-	if mc.NetworkName != container.HostNetworkName {
-		mc.NetworkName = container.AppNetworkName()
-	}
-	mc.ShardSize = sn.ShardSize
-	mc.NumShards = sn.NumShards
 	mc.CreateReplica(ctx)
 	mc.Init(ctx)
 }
 
+func topologyFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "file",
+		Aliases: []string{"f"},
+		Value:   topology.DefaultFileName,
+		Usage:   "Topology file declaring the cluster shape",
+	}
+}
+
+// loadTopology reads the topology file named by the file flag. A missing file
+// falls back to the default topology unless the flag was set explicitly.
+func loadTopology(c *cli.Context) (*topology.Topology, bool, error) {
+	path := c.String("file")
+	if c.IsSet("file") {
+		t, err := topology.Load(path)
+		return t, err == nil, err
+	}
+	return topology.LoadOrDefault(path)
+}
+
+// connectTopology connects to the read and write routers of every shard of
+// the topology and routes keys by the shared shard map.
+func connectTopology(ctx context.Context, t *topology.Topology) (*tables.MultiDBConnector, error) {
+	connector := tables.NewMultiDBConnector(t.Network, t.Tenant, t.Domain, "mysql",
+		t.SQL.RouterReadOnlyPort, t.SQL.RouterReadWritePort, t.Shards)
+	_, err := connector.ConnectAllShardsRead(ctx)
+	if err != nil {
+		connector.CloseAll()
+		return nil, err
+	}
+	_, err = connector.ConnectAllShardsWrite(ctx)
+	if err != nil {
+		connector.CloseAll()
+		return nil, err
+	}
+	if err := connector.ReloadShardMap(ctx); err != nil {
+		connector.CloseAll()
+		return nil, err
+	}
+	return connector, nil
+}
+
 // InitCommand initializes resources for the current repo.
 func InitCommand() *cli.Command {
 	return &cli.Command{
@@ -53,28 +85,30 @@ func InitCommand() *cli.Command {
 				Value:   "sqls",
 				Usage:   "Directory containing the SQL migration files.",
 			},
+			topologyFlag(),
 		},
 		Action: func(c *cli.Context) error {
 			ctx := context.Background()
+			t, _, err := loadTopology(c)
+			if err != nil {
+				return err
+			}
+			if c.IsSet("directory") {
+				t.SQL.MigrationDir = c.String("directory")
+			}
+			// generate the credentials once, before the nodes race to load them
+			if _, err := secrets.Ensure(t.Tenant); err != nil {
+				return fmt.Errorf("failed to prepare SQL credentials: %w", err)
+			}
 			var wg sync.WaitGroup
 
-			for shardIndex := 0; shardIndex < defaultNumShards; shardIndex++ {
-				for repIndex := 0; repIndex < defaultShardSize; repIndex++ {
-					sn := db.NewSQLNode()
-					sn.Domain = utils.DomainName()
-					sn.DatabaseName = utils.TenantName()
-					sn.Tenant = utils.TenantName()
-					sn.ShardIndex = shardIndex
-					sn.RepIndex = repIndex
-					sn.NumShards = defaultNumShards
-					sn.ShardSize = defaultShardSize
-					sn.NetworkName = container.AppNetworkName()
-					sn.MigrationDir = c.String("directory")
+			for shardIndex := 0; shardIndex < t.Shards; shardIndex++ {
+				for repIndex := 0; repIndex < t.Replicas; repIndex++ {
 					wg.Add(1)
-					go func(sn *db.SQLNode) {
+					go func(sn *db.SQLNode, mc *mem.MemNode) {
 						defer wg.Done()
-						createNode(ctx, sn)
-					}(sn)
+						createNode(ctx, sn, mc)
+					}(t.SQLNode(shardIndex, repIndex), t.MemNode(shardIndex, repIndex))
 				}
 			}
 			wg.Wait()
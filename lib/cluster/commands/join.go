@@ -7,9 +7,9 @@ package commands
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/evgnomon/zygote/lib/cluster/container"
-	"github.com/evgnomon/zygote/lib/cluster/db"
 	"github.com/evgnomon/zygote/lib/cluster/utils"
 	"github.com/urfave/cli/v2"
 )
@@ -26,21 +26,21 @@ func JoinCommand() *cli.Command {
 				Value:   "sqls",
 				Usage:   "Directory containing the SQL migration files.",
 			},
+			topologyFlag(),
 			&cli.IntFlag{
 				Name:  "num-shards",
 				Value: 1,
-				Usage: "number of shards",
+				Usage: "number of shards, overrides the topology file",
 			},
 			&cli.IntFlag{
 				Name:  "shard-size",
 				Value: 3,
-				Usage: "number of nodes in a shard",
+				Usage: "number of nodes in a shard, overrides the topology file",
 			},
 			&cli.StringFlag{
-				Name:     "domain",
-				Aliases:  []string{"d"},
-				Usage:    "The domain name, e.g. foo.com or foo.bar.com",
-				Required: true,
+				Name:    "domain",
+				Aliases: []string{"d"},
+				Usage:   "The domain name, e.g. foo.com or foo.bar.com, overrides the topology file",
 			},
 			&cli.StringFlag{
 				Name:     "host",
@@ -54,22 +54,40 @@ func JoinCommand() *cli.Command {
 		},
 		Action: func(c *cli.Context) error {
 			ctx := context.Background()
-			var sn = db.NewSQLNode()
-			sn.Domain = c.String("domain")
-			sn.NetworkName = container.HostNetworkName
-			sn.DatabaseName = c.String("db")
+			t, found, err := loadTopology(c)
+			if err != nil {
+				return err
+			}
+			// Without a topology file the flags describe the cluster shape.
+			if c.IsSet("num-shards") || !found {
+				t.Shards = c.Int("num-shards")
+			}
+			if c.IsSet("shard-size") || !found {
+				t.Replicas = c.Int("shard-size")
+			}
+			if c.IsSet("domain") || !found {
+				t.Domain = c.String("domain")
+			}
+			if c.IsSet("directory") {
+				t.SQL.MigrationDir = c.String("directory")
+			}
+			t.Network = container.HostNetworkName
+			if t.Domain == "" {
+				return fmt.Errorf("domain is required, set it with --domain or in %s", c.String("file"))
+			}
+			if err := t.Validate(); err != nil {
+				return err
+			}
 			index, err := utils.GetClusterIndex(c.String("host"))
 			if err != nil {
 				return err
 			}
-			sn.ShardIndex = index.ShardIndex
-			sn.RepIndex = index.RepIndex
-			sn.Tenant = utils.TenantName()
-			sn.RootPassword = "root1234"
-			sn.NumShards = c.Int("num-shards")
-			sn.ShardSize = c.Int("shard-size")
+			sn := t.SQLNode(index.ShardIndex, index.RepIndex)
+			if c.IsSet("db") {
+				sn.DatabaseName = c.String("db")
+			}
 
-			createNode(ctx, sn)
+			createNode(ctx, sn, t.MemNode(index.ShardIndex, index.RepIndex))
 			return nil
 		},
 	}
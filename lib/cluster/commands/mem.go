@@ -6,7 +6,21 @@ License: HGL General License <https://evgnomon.org/docs/hgl>
 package commands
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/evgnomon/zygote/lib/cluster/backup"
 	"github.com/evgnomon/zygote/lib/cluster/mem"
+	"github.com/evgnomon/zygote/lib/cluster/memconn"
+	"github.com/evgnomon/zygote/lib/cluster/topology"
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+	"github.com/redis/go-redis/v9"
 	"github.com/urfave/cli/v2"
 )
 
@@ -16,27 +30,494 @@ func MemCommand() *cli.Command {
 		Name:  "mem",
 		Usage: "Get/Set memory values",
 		Flags: []cli.Flag{
-			&cli.Int64Flag{
-				Name:    "replica-index",
-				Aliases: []string{"n"},
-				Value:   0,
-				Usage:   "Replica ID, starting 0",
-			},
-			&cli.Int64Flag{
-				Name:    "shard-index",
-				Aliases: []string{"s"},
-				Value:   0,
-				Usage:   "Shared index, starting 0",
-			},
 			&cli.StringFlag{
 				Name:    "domain",
 				Aliases: []string{"d"},
 				Usage:   "The domain name, e.g. foo.com or foo.bar.com",
-				Value:   "zygote.run",
+				Value:   utils.DomainName(),
+			},
+			topologyFlag(),
+		},
+		Subcommands: []*cli.Command{
+			memGetCommand(),
+			memSetCommand(),
+			memDelCommand(),
+			memScanCommand(),
+			memTTLCommand(),
+			memKeysPerSlotCommand(),
+			memAddNodeCommand(),
+			memRemoveNodeCommand(),
+			memRebalanceCommand(),
+			memFailoverCommand(),
+			memBackupCommand(),
+			memRestoreCommand(),
+		},
+	}
+}
+
+// withMemClient runs fn with a client of the memory cluster of the domain flag,
+// shaped by the topology of the file flag.
+func withMemClient(c *cli.Context, fn func(ctx context.Context, client *redis.ClusterClient) error) error {
+	t, _, err := loadTopology(c)
+	if err != nil {
+		return err
+	}
+	client, err := memconn.NewClient(t.Network, c.String("domain"), t.Mem.Port, t.Shards)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return fn(c.Context, client)
+}
+
+// memGetCommand prints the value of a key.
+func memGetCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "get",
+		Usage:     "Print the value of a key, decompressing gzip-compressed JSON",
+		ArgsUsage: "KEY",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "raw",
+				Usage: "Print the stored bytes without decompressing them",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("expected a key")
+			}
+			return withMemClient(c, func(ctx context.Context, client *redis.ClusterClient) error {
+				value, err := client.Get(ctx, c.Args().First()).Bytes()
+				if errors.Is(err, redis.Nil) {
+					return fmt.Errorf("key %s not found", c.Args().First())
+				}
+				if err != nil {
+					return err
+				}
+				if c.Bool("raw") || !mem.IsCompressed(value) {
+					_, err = os.Stdout.Write(append(value, '\n'))
+					return err
+				}
+				var doc any
+				if err := mem.DecompressJSON(value, &doc); err != nil {
+					return err
+				}
+				out, err := json.MarshalIndent(doc, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(out))
+				return nil
+			})
+		},
+	}
+}
+
+// memSetCommand sets the value of a key.
+func memSetCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "set",
+		Usage:     "Set the value of a key, - reads the value from the standard input",
+		ArgsUsage: "KEY VALUE",
+		Flags: []cli.Flag{
+			&cli.DurationFlag{
+				Name:  "ttl",
+				Usage: "Time until the key expires, it never expires by default",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Require the value to be JSON and store it compacted",
+			},
+			&cli.BoolFlag{
+				Name:  "gzip",
+				Usage: "Store the value as gzip-compressed JSON, implies --json",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 2 { //nolint:mnd
+				return fmt.Errorf("expected a key and a value")
+			}
+			if c.Duration("ttl") < 0 {
+				return fmt.Errorf("invalid ttl %s", c.Duration("ttl"))
+			}
+			value := []byte(c.Args().Get(1))
+			if c.Args().Get(1) == "-" {
+				var err error
+				if value, err = io.ReadAll(os.Stdin); err != nil {
+					return err
+				}
+			}
+			value, err := encodeMemValue(value, c.Bool("json"), c.Bool("gzip"))
+			if err != nil {
+				return err
+			}
+			return withMemClient(c, func(ctx context.Context, client *redis.ClusterClient) error {
+				return client.Set(ctx, c.Args().First(), value, c.Duration("ttl")).Err()
+			})
+		},
+	}
+}
+
+// encodeMemValue encodes a value given on the command line for storage
+func encodeMemValue(value []byte, asJSON, compress bool) ([]byte, error) {
+	if !asJSON && !compress {
+		return value, nil
+	}
+	var doc any
+	if err := json.Unmarshal(value, &doc); err != nil {
+		return nil, fmt.Errorf("value is not JSON: %w", err)
+	}
+	if compress {
+		return mem.CompressJSON(doc)
+	}
+	return json.Marshal(doc)
+}
+
+// memDelCommand deletes keys.
+func memDelCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "del",
+		Usage:     "Delete keys and print how many existed",
+		ArgsUsage: "KEY...",
+		Action: func(c *cli.Context) error {
+			if c.NArg() == 0 {
+				return fmt.Errorf("expected at least one key")
+			}
+			return withMemClient(c, func(ctx context.Context, client *redis.ClusterClient) error {
+				// Keys of a DEL must share a slot, each one is deleted on its own
+				var deleted int64
+				for _, key := range c.Args().Slice() {
+					n, err := client.Del(ctx, key).Result()
+					if err != nil {
+						return err
+					}
+					deleted += n
+				}
+				fmt.Println(deleted)
+				return nil
+			})
+		},
+	}
+}
+
+// memScanCommand lists the keys matching a pattern.
+func memScanCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "scan",
+		Usage:     "List the keys matching a pattern on every master without blocking them",
+		ArgsUsage: "[PATTERN]",
+		Flags: []cli.Flag{
+			&cli.Int64Flag{
+				Name:  "count",
+				Usage: "Number of keys each SCAN call looks at",
+				Value: 100, //nolint:mnd
+			},
+		},
+		Action: func(c *cli.Context) error {
+			pattern := "*"
+			if c.NArg() > 0 {
+				pattern = c.Args().First()
+			}
+			if c.Int64("count") <= 0 {
+				return fmt.Errorf("count must be positive")
+			}
+			return withMemClient(c, func(ctx context.Context, client *redis.ClusterClient) error {
+				return mem.ScanKeys(ctx, client, pattern, c.Int64("count"), func(key string) error {
+					_, err := fmt.Println(key)
+					return err
+				})
+			})
+		},
+	}
+}
+
+// memTTLCommand prints the time until a key expires.
+func memTTLCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "ttl",
+		Usage:     "Print the time until a key expires",
+		ArgsUsage: "KEY",
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("expected a key")
+			}
+			return withMemClient(c, func(ctx context.Context, client *redis.ClusterClient) error {
+				ttl, err := client.PTTL(ctx, c.Args().First()).Result()
+				if err != nil {
+					return err
+				}
+				// The client keeps the -2 reply for a missing key and the -1
+				// reply for a key without expiry as they are
+				switch ttl {
+				case -2: //nolint:mnd
+					return fmt.Errorf("key %s not found", c.Args().First())
+				case -1:
+					fmt.Println("no expiry")
+				default:
+					fmt.Println(ttl)
+				}
+				return nil
+			})
+		},
+	}
+}
+
+// memKeysPerSlotCommand counts the keys in hash slots.
+func memKeysPerSlotCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "keys-per-slot",
+		Usage:     "Count the keys in hash slots, given by number or by a key hashing to them",
+		ArgsUsage: "SLOT|KEY...",
+		Action: func(c *cli.Context) error {
+			if c.NArg() == 0 {
+				return fmt.Errorf("expected at least one slot or key")
+			}
+			return withMemClient(c, func(ctx context.Context, client *redis.ClusterClient) error {
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "SLOT\tKEYS")
+				for _, arg := range c.Args().Slice() {
+					slot, err := strconv.Atoi(arg)
+					if err != nil {
+						keySlot, err := client.ClusterKeySlot(ctx, arg).Result()
+						if err != nil {
+							return err
+						}
+						slot = int(keySlot)
+					}
+					n, err := mem.CountKeysInSlot(ctx, client, slot)
+					if err != nil {
+						return err
+					}
+					fmt.Fprintf(w, "%d\t%d\n", slot, n)
+				}
+				return w.Flush()
+			})
+		},
+	}
+}
+
+// withClusterAdmin runs fn with an admin of the memory cluster and prints
+// the resulting topology as the API reports it.
+func withClusterAdmin(c *cli.Context, fn func(ctx context.Context, admin *mem.ClusterAdmin) error) error {
+	return withMemClient(c, func(ctx context.Context, client *redis.ClusterClient) error {
+		admin := mem.NewClusterAdmin(client)
+		defer admin.Close()
+		if err := fn(ctx, admin); err != nil {
+			return err
+		}
+		topology, err := admin.Topology(ctx)
+		if err != nil {
+			return err
+		}
+		return printJSON(topology)
+	})
+}
+
+func printJSON(v any) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// memAddNodeCommand adds an empty node to the memory cluster.
+func memAddNodeCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "add-node",
+		Usage:     "Add an empty node to the memory cluster as a master without slots or as a replica",
+		ArgsUsage: "HOST:PORT",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "replica-of",
+				Usage: "ID or address of the master to replicate",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("expected the address of the node")
+			}
+			return withClusterAdmin(c, func(ctx context.Context, admin *mem.ClusterAdmin) error {
+				_, err := admin.AddNode(ctx, c.Args().First(), c.String("replica-of"))
+				return err
+			})
+		},
+	}
+}
+
+// memRemoveNodeCommand removes a node from the memory cluster.
+func memRemoveNodeCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "remove-node",
+		Usage:     "Move the slots and replicas of a node to the other masters and remove it from the memory cluster",
+		ArgsUsage: "ID|HOST:PORT",
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("expected the ID or the address of the node")
+			}
+			return withClusterAdmin(c, func(ctx context.Context, admin *mem.ClusterAdmin) error {
+				return admin.RemoveNode(ctx, c.Args().First())
+			})
+		},
+	}
+}
+
+// memRebalanceCommand spreads the slots evenly over the masters.
+func memRebalanceCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "rebalance",
+		Usage: "Move slots and their keys to spread them evenly over the masters of the memory cluster",
+		Flags: []cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  "exclude",
+				Usage: "ID of a master to move all slots away from",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Print the slot moves without making them",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.Bool("dry-run") {
+				return withMemClient(c, func(ctx context.Context, client *redis.ClusterClient) error {
+					admin := mem.NewClusterAdmin(client)
+					defer admin.Close()
+					nodes, err := admin.Nodes(ctx)
+					if err != nil {
+						return err
+					}
+					moves, err := mem.PlanRebalance(nodes, c.StringSlice("exclude")...)
+					if err != nil {
+						return err
+					}
+					return printJSON(moves)
+				})
+			}
+			return withClusterAdmin(c, func(ctx context.Context, admin *mem.ClusterAdmin) error {
+				_, err := admin.Rebalance(ctx, c.StringSlice("exclude")...)
+				return err
+			})
+		},
+	}
+}
+
+// memFailoverCommand promotes a replica to master.
+func memFailoverCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "failover",
+		Usage:     "Promote a replica to master of its shard",
+		ArgsUsage: "ID|HOST:PORT",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "Do not wait for the master to agree, for an unreachable master",
+			},
+			&cli.BoolFlag{
+				Name:  "takeover",
+				Usage: "Do not wait for the other masters to agree, for a cluster without a majority",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.NArg() != 1 {
+				return fmt.Errorf("expected the ID or the address of the replica")
+			}
+			mode := ""
+			switch {
+			case c.Bool("force") && c.Bool("takeover"):
+				return fmt.Errorf("--force and --takeover are exclusive")
+			case c.Bool("force"):
+				mode = mem.FailoverForce
+			case c.Bool("takeover"):
+				mode = mem.FailoverTakeover
+			}
+			return withClusterAdmin(c, func(ctx context.Context, admin *mem.ClusterAdmin) error {
+				return admin.Failover(ctx, c.Args().First(), mode)
+			})
+		},
+	}
+}
+
+func memNodes(t *topology.Topology) [][]*mem.MemNode {
+	nodes := make([][]*mem.MemNode, t.Shards)
+	for shardIndex := range nodes {
+		for repIndex := 0; repIndex < t.Replicas; repIndex++ {
+			nodes[shardIndex] = append(nodes[shardIndex], t.MemNode(shardIndex, repIndex))
+		}
+	}
+	return nodes
+}
+
+// memBackupCommand snapshots every master of the memory cluster.
+func memBackupCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "backup",
+		Usage: "Snapshot every master of the memory cluster and record the slot map",
+		Flags: []cli.Flag{
+			topologyFlag(),
+			storeFlag("to", "Where to store the backups"),
+		},
+		Action: func(c *cli.Context) error {
+			t, _, err := loadTopology(c)
+			if err != nil {
+				return err
+			}
+			store, err := backup.OpenStore(c.String("to"))
+			if err != nil {
+				return err
+			}
+			m, err := backup.NewMemBackup(store, t.Tenant).Backup(context.Background(), memNodes(t))
+			if err != nil {
+				return err
+			}
+			for _, master := range m.Masters {
+				fmt.Printf("backup %s: master %s at %s, slots %v\n", m.ID, master.NodeID, master.Address, master.Slots)
+			}
+			return nil
+		},
+	}
+}
+
+// memRestoreCommand rebuilds the memory cluster from a backup.
+func memRestoreCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "restore",
+		Usage: "Rebuild the memory cluster with the slot distribution and data of a backup",
+		Flags: []cli.Flag{
+			topologyFlag(),
+			storeFlag("from", "Where the backups are stored"),
+			&cli.StringFlag{
+				Name:  "tenant",
+				Usage: "Tenant that took the backups, defaults to the tenant of the topology",
+			},
+			&cli.StringFlag{
+				Name:  "id",
+				Usage: "Backup to restore, defaults to the latest one",
+			},
+			&cli.BoolFlag{
+				Name:    "yes",
+				Aliases: []string{"y"},
+				Usage:   "Do not ask for confirmation",
 			},
 		},
-		Action: func(_ *cli.Context) error {
-			mem.RunExample()
+		Action: func(c *cli.Context) error {
+			t, _, err := loadTopology(c)
+			if err != nil {
+				return err
+			}
+			store, err := backup.OpenStore(c.String("from"))
+			if err != nil {
+				return err
+			}
+			if !c.Bool("yes") && !utils.GetYesNoInput(fmt.Sprintf("This replaces the memory data of tenant %s.", t.Tenant)) {
+				return nil
+			}
+			b := backup.NewMemBackup(store, backupTenant(c, t))
+			report, err := b.Restore(context.Background(), memNodes(t), backup.RestoreOptions{ID: c.String("id")})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("restored backup %s to %d masters serving %d slots\n", report.Backup, report.Masters, report.Slots)
 			return nil
 		},
 	}
@@ -7,9 +7,15 @@ package commands
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/evgnomon/zygote/lib/cluster/migration"
 	"github.com/evgnomon/zygote/lib/cluster/utils"
+	migrate "github.com/golang-migrate/migrate/v4"
 	"github.com/urfave/cli/v2"
 )
 
@@ -25,6 +31,20 @@ func MigrateCommand() *cli.Command {
 				Value:   "sqls",
 				Usage:   "Directory containing the SQL migration files.",
 			},
+			topologyFlag(),
+			&cli.StringFlag{
+				Name:  "mode",
+				Value: string(migration.Parallel),
+				Usage: "Rollout over the shards, parallel or rolling",
+			},
+			&cli.BoolFlag{
+				Name:  "continue-on-error",
+				Usage: "Keep migrating the other shards when a shard fails",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Print the SQL of the migrations that would run without running them",
+			},
 		},
 		Action: func(c *cli.Context) error {
 			dir := c.String("directory")
@@ -42,20 +62,199 @@ func MigrateCommand() *cli.Command {
 				Name:  "up",
 				Usage: "Apply all pending migrations to update the database schema to the latest version.",
 				Action: func(c *cli.Context) error {
-					ctx := context.Background()
-					m := migration.NewMigration(c.String("directory"))
-					return m.Up(ctx)
+					return runMigration(c, (*migrate.Migrate).Up, migration.PlanUp())
 				},
 			},
 			{
 				Name:  "down",
-				Usage: "Revert the last applied migration to undo changes to the database schema.",
+				Usage: "Revert all applied migrations to undo changes to the database schema.",
+				Action: func(c *cli.Context) error {
+					return runMigration(c, (*migrate.Migrate).Down, migration.PlanDown())
+				},
+			},
+			{
+				Name:      "goto",
+				Usage:     "Migrate up or down to the given version.",
+				ArgsUsage: "<version>",
+				Action: func(c *cli.Context) error {
+					v, err := versionArg(c)
+					if err != nil {
+						return err
+					}
+					return runMigration(c, func(mm *migrate.Migrate) error {
+						return mm.Migrate(v)
+					}, migration.PlanGoto(v))
+				},
+			},
+			{
+				Name:      "steps",
+				Usage:     "Apply the next N migrations, or revert the last N when negative, e.g. steps -- -2.",
+				ArgsUsage: "<N>",
+				Action: func(c *cli.Context) error {
+					n, err := strconv.Atoi(c.Args().First())
+					if err != nil || n == 0 || c.NArg() != 1 {
+						return fmt.Errorf("expected a non-zero number of steps, got %q", c.Args().First())
+					}
+					return runMigration(c, func(mm *migrate.Migrate) error {
+						return mm.Steps(n)
+					}, migration.PlanSteps(n))
+				},
+			},
+			{
+				Name:      "force",
+				Usage:     "Set the version without running migrations to recover a dirty shard, -1 clears the version.",
+				ArgsUsage: "<version>",
+				Action: func(c *cli.Context) error {
+					v, err := strconv.Atoi(c.Args().First())
+					if err != nil || v < -1 || c.NArg() != 1 {
+						return fmt.Errorf("expected a version or -1, got %q", c.Args().First())
+					}
+					if c.Bool("dry-run") {
+						fmt.Printf("would force every shard to version %d\n", v)
+						return nil
+					}
+					return runMigration(c, func(mm *migrate.Migrate) error {
+						return mm.Force(v)
+					}, nil)
+				},
+			},
+			{
+				Name:  "version",
+				Usage: "Print the migration version of every shard.",
+				Action: func(c *cli.Context) error {
+					m, closeAll, err := newMigration(c)
+					if err != nil {
+						return err
+					}
+					defer closeAll()
+					results := m.Versions(context.Background())
+					w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+					fmt.Fprintln(w, "SHARD\tVERSION\tDIRTY")
+					for _, r := range results {
+						if r.Err != nil {
+							fmt.Fprintf(w, "%d\terror: %v\t\n", r.Shard, r.Err)
+							continue
+						}
+						fmt.Fprintf(w, "%d\t%d\t%t\n", r.Shard, r.FromVersion, r.Dirty)
+					}
+					w.Flush()
+					return migration.Failed(results)
+				},
+			},
+			{
+				Name:  "status",
+				Usage: "Show the migration version of every shard and the version skew between them.",
 				Action: func(c *cli.Context) error {
-					ctx := context.Background()
-					m := migration.NewMigration(c.String("directory"))
-					return m.Down(ctx)
+					reports, latest, err := checkSchema(c, c.String("directory"))
+					if err != nil {
+						return err
+					}
+					if !printMigrationStatus(reports, latest) {
+						return fmt.Errorf("shards are not all migrated to version %d", latest)
+					}
+					return nil
 				},
 			},
 		},
 	}
 }
+
+// newMigration connects to the shards of the topology. The returned function
+// closes the connections.
+func newMigration(c *cli.Context) (*migration.Migration, func(), error) {
+	ctx := context.Background()
+	mode, err := migration.ParseMode(c.String("mode"))
+	if err != nil {
+		return nil, nil, err
+	}
+	t, _, err := loadTopology(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	dir := c.String("directory")
+	if !utils.PathExists(dir) {
+		return nil, nil, fmt.Errorf("migration directory %s not found", dir)
+	}
+	connector, err := connectTopology(ctx, t)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := &migration.Migration{
+		Directory:       dir,
+		Connector:       connector,
+		Mode:            mode,
+		ContinueOnError: c.Bool("continue-on-error"),
+	}
+	return m, func() { connector.CloseAll() }, nil
+}
+
+// runMigration applies a migration operation to every shard of the topology
+// and prints the result of each shard. With --dry-run it prints the SQL the
+// planner finds pending instead.
+func runMigration(c *cli.Context, op func(*migrate.Migrate) error, planner migration.Planner) error {
+	ctx := context.Background()
+	m, closeAll, err := newMigration(c)
+	if err != nil {
+		return err
+	}
+	defer closeAll()
+	if c.Bool("dry-run") && planner != nil {
+		plans, err := m.Plan(ctx, planner)
+		if err != nil {
+			return err
+		}
+		return printMigrationPlans(plans)
+	}
+	results, err := m.Apply(ctx, op)
+	if err != nil {
+		return err
+	}
+	printMigrationResults(results)
+	return migration.Failed(results)
+}
+
+// versionArg parses the version argument of a command.
+func versionArg(c *cli.Context) (uint, error) {
+	v, err := strconv.ParseUint(c.Args().First(), 10, 0)
+	if err != nil || c.NArg() != 1 {
+		return 0, fmt.Errorf("expected a migration version, got %q", c.Args().First())
+	}
+	return uint(v), nil
+}
+
+// printMigrationPlans prints the SQL every shard would run.
+func printMigrationPlans(plans []migration.ShardPlan) error {
+	var failed error
+	for _, p := range plans {
+		if p.Err != nil {
+			fmt.Printf("-- shard %d: %v\n\n", p.Shard, p.Err)
+			if failed == nil {
+				failed = fmt.Errorf("cannot plan shard %d: %w", p.Shard, p.Err)
+			}
+			continue
+		}
+		if len(p.Steps) == 0 {
+			fmt.Printf("-- shard %d: version %d, nothing to run\n\n", p.Shard, p.Version)
+			continue
+		}
+		fmt.Printf("-- shard %d: version %d, %d migrations\n", p.Shard, p.Version, len(p.Steps))
+		for _, step := range p.Steps {
+			if step.File() == "" {
+				fmt.Printf("-- %d: no migration file, only the version changes\n\n", step.Version)
+				continue
+			}
+			fmt.Printf("-- %s\n%s\n\n", step.File(), strings.TrimSpace(step.SQL))
+		}
+	}
+	return failed
+}
+
+// printMigrationResults prints the version change of every shard.
+func printMigrationResults(results []migration.ShardResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SHARD\tFROM\tTO\tDIRTY\tSTATUS")
+	for _, r := range results {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%t\t%s\n", r.Shard, r.FromVersion, r.ToVersion, r.Dirty, r.Status())
+	}
+	w.Flush()
+}
@@ -6,6 +6,8 @@ License: HGL General License <https://evgnomon.org/docs/hgl>
 package commands
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -13,6 +15,7 @@ import (
 
 	"github.com/evgnomon/zygote/lib/cluster/controller"
 	"github.com/evgnomon/zygote/lib/cluster/cert"
+	"github.com/evgnomon/zygote/lib/cluster/tables"
 	"github.com/evgnomon/zygote/lib/cluster/utils"
 	"github.com/urfave/cli/v2"
 )
@@ -33,6 +36,41 @@ func QCommand() *cli.Command {
 				Name:  "curl",
 				Usage: "Print curl command instead of executing the query",
 			},
+			&cli.GenericFlag{
+				Name:  "arg",
+				Usage: "Value of the next ? placeholder, as JSON or else as a string (repeatable)",
+				Value: &repeatedFlag{},
+			},
+			&cli.GenericFlag{
+				Name:  "param",
+				Usage: "Value of a :name placeholder as name=value, the value as JSON or else as a string (repeatable)",
+				Value: &repeatedFlag{},
+			},
+			&cli.BoolFlag{
+				Name:  "batch",
+				Usage: `Read a JSON array of statements such as [{"query": "...", "args": [...]}] and run them in one transaction`,
+			},
+			&cli.IntFlag{
+				Name:  "shard",
+				Usage: "Index of the shard to run on, shard 0 by default",
+			},
+			&cli.StringFlag{
+				Name:  "shard-key",
+				Usage: "Run on the shard that owns this key",
+			},
+			&cli.BoolFlag{
+				Name:  "all-shards",
+				Usage: "Run a SELECT on every shard and merge the results",
+			},
+			&cli.StringFlag{
+				Name:  "intent",
+				Usage: "read or write, by default statements that return rows are read and the others written",
+			},
+			&cli.StringFlag{
+				Name:    "database",
+				Aliases: []string{"d"},
+				Usage:   "Database to run on instead of the one of the connection",
+			},
 		},
 		Action: func(c *cli.Context) error {
 			server := c.Args().Get(0)
@@ -47,6 +85,29 @@ func QCommand() *cli.Command {
 			if err != nil {
 				return fmt.Errorf("failed to read from stdin: %v", err)
 			}
+			p := controller.SQLQueryRequest{
+				ShardKey: c.String("shard-key"),
+				Intent:   c.String("intent"),
+				Database: c.String("database"),
+			}
+			if c.IsSet("shard") {
+				shard := c.Int("shard")
+				p.Shard = &shard
+			}
+			if c.Bool("all-shards") {
+				p.Shards = "all"
+			}
+			if c.Bool("batch") {
+				if err := json.Unmarshal(query, &p.Batch); err != nil {
+					return fmt.Errorf("failed to parse batch: %w", err)
+				}
+			} else {
+				p.Query = string(query)
+				p.Args, err = queryArgs(*c.Generic("arg").(*repeatedFlag), *c.Generic("param").(*repeatedFlag))
+				if err != nil {
+					return err
+				}
+			}
 
 			certService, err := cert.Cert()
 			if err != nil {
@@ -61,23 +122,70 @@ func QCommand() *cli.Command {
 
 			// If curl flag is set, print the curl command and return
 			if c.Bool("curl") {
+				payload, err := json.Marshal(p)
+				if err != nil {
+					return fmt.Errorf("failed to marshal payload: %v", err)
+				}
 				curlCmd := fmt.Sprintf(`curl -s -X POST \
   --cert %s \
   --key %s \
   --cacert %s \
   -H "Content-Type: application/json" \
-  -d '{"query": "%s"}' \
-  %s`, certPath, keyPath, caCertPath, strings.ReplaceAll(string(query), `"`, `\"`), url)
+  -d '%s' \
+  %s`, certPath, keyPath, caCertPath, strings.ReplaceAll(string(payload), "'", `'\''`), url)
 
 				fmt.Println(curlCmd)
 				return nil
 			}
 
-			// Original execution path
-			p := controller.SQLQueryRequest{
-				Query: string(query),
-			}
 			return sendAndPrint(url, user, p)
 		},
 	}
 }
+
+// repeatedFlag collects every value of a repeated flag, unlike a string
+// slice flag it does not split values on commas
+type repeatedFlag []string
+
+func (f *repeatedFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func (f *repeatedFlag) String() string {
+	return strings.Join(*f, " ")
+}
+
+// queryArgs builds the positional or named args of a query from the command
+// line
+func queryArgs(positional, named []string) (tables.Args, error) {
+	var args tables.Args
+	if len(positional) > 0 && len(named) > 0 {
+		return args, fmt.Errorf("--arg and --param cannot be combined")
+	}
+	for _, v := range positional {
+		args.Positional = append(args.Positional, argValue(v))
+	}
+	for _, kv := range named {
+		name, v, ok := strings.Cut(kv, "=")
+		if !ok || name == "" {
+			return args, fmt.Errorf("invalid param %q, expected name=value", kv)
+		}
+		if args.Named == nil {
+			args.Named = map[string]any{}
+		}
+		args.Named[name] = argValue(v)
+	}
+	return args, nil
+}
+
+// argValue decodes a value given as JSON, anything else is a string
+func argValue(s string) any {
+	dec := json.NewDecoder(bytes.NewReader([]byte(s)))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return s
+	}
+	return v
+}
@@ -11,13 +11,10 @@ import (
 	"os"
 	"strings"
 
-	"github.com/evgnomon/zygote/lib/cluster/container"
 	"github.com/evgnomon/zygote/lib/cluster/tables"
-	"github.com/evgnomon/zygote/lib/cluster/utils"
 	"github.com/urfave/cli/v2"
 )
 
-const defaultTenant = "zygote"
 const defaultDatabaseName = "mysql"
 const defaultShard = 0
 
@@ -31,14 +28,19 @@ func Query() *cli.Command {
 				Aliases: []string{"d"},
 				Usage:   "Database name",
 			},
+			topologyFlag(),
 		},
 		Action: func(c *cli.Context) error {
 			database := c.String("database")
 			if database == "" {
 				database = defaultDatabaseName
 			}
-			connector := tables.NewMultiDBConnector(container.AppNetworkName(), defaultTenant,
-				utils.DomainName(), database, routerReadOnlyPort, routerReadWritePort, defaultNumShards)
+			t, _, err := loadTopology(c)
+			if err != nil {
+				return err
+			}
+			connector := tables.NewMultiDBConnector(t.Network, t.Tenant, t.Domain, database,
+				t.SQL.RouterReadOnlyPort, t.SQL.RouterReadWritePort, t.Shards)
 
 			db, err := connector.GetWriteConnection(defaultShard)
 			if err != nil {
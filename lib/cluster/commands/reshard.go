@@ -0,0 +1,202 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+// Package commands contains all available commands.
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/evgnomon/zygote/lib/cluster/container"
+	"github.com/evgnomon/zygote/lib/cluster/db"
+	"github.com/evgnomon/zygote/lib/cluster/migration"
+	"github.com/evgnomon/zygote/lib/cluster/reshard"
+	"github.com/evgnomon/zygote/lib/cluster/tables"
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// ReshardCommand adds shards and moves rows to the new shard layout.
+func ReshardCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "reshard",
+		Usage: "Add shards and move rows to the new shard layout without downtime",
+		Flags: []cli.Flag{
+			topologyFlag(),
+			&cli.IntFlag{
+				Name:     "shards",
+				Usage:    "Number of shards after resharding",
+				Required: true,
+			},
+			&cli.StringSliceFlag{
+				Name:     "table",
+				Aliases:  []string{"t"},
+				Usage:    "Table to move given as name:shard_key_column, with :unique when its primary key is unique across shards, can be repeated",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "database",
+				Usage: "Database holding the tables, defaults to the topology database",
+			},
+			&cli.IntFlag{
+				Name:  "batch-size",
+				Value: 500,
+				Usage: "Number of rows read and written per batch",
+			},
+			&cli.BoolFlag{
+				Name:  "skip-provision",
+				Usage: "Do not create SQL nodes for the added shards",
+			},
+			&cli.BoolFlag{
+				Name:  "cleanup",
+				Usage: "Delete moved rows from their old shards after the cutover",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			ctx := context.Background()
+			t, _, err := loadTopology(c)
+			if err != nil {
+				return err
+			}
+			oldShards, newShards := t.Shards, c.Int("shards")
+			if newShards <= oldShards {
+				return fmt.Errorf("new shard count %d must be greater than the current %d", newShards, oldShards)
+			}
+			var reshardTables []reshard.Table
+			for _, s := range c.StringSlice("table") {
+				table, err := reshard.ParseTable(s)
+				if err != nil {
+					return err
+				}
+				reshardTables = append(reshardTables, table)
+			}
+			database := t.SQL.Database
+			if c.IsSet("database") {
+				database = c.String("database")
+			}
+
+			t.Shards = newShards
+			if !c.Bool("skip-provision") {
+				provisionShards(ctx, t.Network, oldShards, newShards, t.Replicas, t.SQLNode)
+			}
+
+			connector, err := connectTopology(ctx, t)
+			if err != nil {
+				return err
+			}
+			defer connector.CloseAll()
+
+			m := &migration.Migration{Directory: t.SQL.MigrationDir, Connector: connector}
+			for shardIndex := oldShards; shardIndex < newShards; shardIndex++ {
+				if err := m.UpShard(ctx, shardIndex); err != nil {
+					return fmt.Errorf("failed to migrate shard %d: %w", shardIndex, err)
+				}
+			}
+
+			from, err := tables.NewHashRouter(oldShards)
+			if err != nil {
+				return err
+			}
+			to, err := tables.NewHashRouter(newShards)
+			if err != nil {
+				return err
+			}
+			// every connector double writes once it loads the cutover from
+			// the shared shard map, rows are copied after that
+			version, err := connector.PublishShardMap(ctx, &tables.ShardMap{Shards: oldShards, NextShards: newShards})
+			if err != nil {
+				return err
+			}
+			abort := func() {
+				if _, err := connector.PublishShardMap(ctx, &tables.ShardMap{Shards: oldShards}); err != nil {
+					logger.Error("Failed to abort the cutover", err)
+				}
+			}
+			if err := connector.WaitForShardMap(ctx, version); err != nil {
+				abort()
+				return err
+			}
+
+			r := &reshard.Resharder{
+				Connector: connector,
+				From:      from,
+				To:        to,
+				Database:  database,
+				Tables:    reshardTables,
+				BatchSize: c.Int("batch-size"),
+			}
+			if err := r.Copy(ctx); err != nil {
+				abort()
+				return err
+			}
+			reports, err := r.Verify(ctx)
+			if err != nil {
+				abort()
+				return err
+			}
+			if !printReshardReports(reports) {
+				abort()
+				return fmt.Errorf("verification failed, shard map left at %d shards", oldShards)
+			}
+			version, err = connector.PublishShardMap(ctx, &tables.ShardMap{Shards: newShards})
+			if err != nil {
+				return err
+			}
+			if err := t.Save(c.String("file")); err != nil {
+				return err
+			}
+			logger.Info("Shard map flipped", utils.M{"shards": newShards})
+
+			if c.Bool("cleanup") {
+				// connectors read the old shards until they load the flip
+				if err := connector.WaitForShardMap(ctx, version); err != nil {
+					return err
+				}
+				return r.Cleanup(ctx)
+			}
+			return nil
+		},
+	}
+}
+
+// provisionShards starts the SQL nodes of the added shards
+func provisionShards(ctx context.Context, network string, oldShards, newShards, replicas int,
+	node func(shardIndex, repIndex int) *db.SQLNode) {
+	n := container.NewNetworkConfig(network)
+	n.Ensure(ctx)
+	var wg sync.WaitGroup
+	for shardIndex := oldShards; shardIndex < newShards; shardIndex++ {
+		for repIndex := 0; repIndex < replicas; repIndex++ {
+			wg.Add(1)
+			go func(sn *db.SQLNode) {
+				defer wg.Done()
+				err := sn.StartSQLContainers(ctx)
+				logger.FatalIfErr("Make SQL node", err)
+			}(node(shardIndex, repIndex))
+		}
+	}
+	wg.Wait()
+}
+
+// printReshardReports prints the verification table and reports whether
+// every shard matched
+func printReshardReports(reports []reshard.TableReport) bool {
+	ok := true
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TABLE\tSHARD\tEXPECTED\tACTUAL\tCHECKSUM")
+	for _, r := range reports {
+		status := "ok"
+		if !r.OK() {
+			status = "mismatch"
+			ok = false
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", r.Table, r.Shard, r.ExpectedRows, r.ActualRows, status)
+	}
+	w.Flush()
+	return ok
+}
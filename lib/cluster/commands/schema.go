@@ -0,0 +1,116 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+// Package commands contains all available commands.
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/evgnomon/zygote/lib/cluster/schema"
+	"github.com/urfave/cli/v2"
+)
+
+// checkSchema compares every shard of the topology with the migrations.
+func checkSchema(c *cli.Context, dir string) ([]schema.ShardReport, uint, error) {
+	ctx := context.Background()
+	t, _, err := loadTopology(c)
+	if err != nil {
+		return nil, 0, err
+	}
+	if dir == "" {
+		dir = t.SQL.MigrationDir
+	}
+	files, err := schema.MigrationFiles(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	connector, err := connectTopology(ctx, t)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer connector.CloseAll()
+	reports, err := schema.Check(ctx, connector, dir)
+	return reports, schema.LatestVersion(files), err
+}
+
+// printMigrationStatus prints the migration version of every shard and
+// reports whether all shards are migrated to the latest version.
+func printMigrationStatus(reports []schema.ShardReport, latest uint) bool {
+	ok := true
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SHARD\tVERSION\tDIRTY\tSTATUS")
+	for _, r := range reports {
+		status := "up to date"
+		switch {
+		case r.Err != nil:
+			status = fmt.Sprintf("error: %v", r.Err)
+		case r.Dirty:
+			status = "dirty"
+		case r.Version < latest:
+			status = fmt.Sprintf("pending, latest is %d", latest)
+		case r.Version > latest:
+			status = fmt.Sprintf("ahead of latest %d", latest)
+		}
+		if status != "up to date" {
+			ok = false
+		}
+		fmt.Fprintf(w, "%d\t%d\t%t\t%s\n", r.Shard, r.Version, r.Dirty, status)
+	}
+	w.Flush()
+	if schema.Skewed(reports) {
+		fmt.Println("version skew: shards are at different migration versions")
+		ok = false
+	}
+	return ok
+}
+
+// SchemaCommand inspects the schema of the shards.
+func SchemaCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "schema",
+		Usage: "Inspect the database schema of the shards",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "diff",
+				Usage: "Compare the schema of every shard with the schema implied by the applied migrations",
+				Flags: []cli.Flag{
+					topologyFlag(),
+					&cli.StringFlag{
+						Name:    "directory",
+						Aliases: []string{"C"},
+						Usage:   "Directory containing the SQL migration files, defaults to the topology migrations",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					reports, latest, err := checkSchema(c, c.String("directory"))
+					if err != nil {
+						return err
+					}
+					ok := printMigrationStatus(reports, latest)
+					for _, r := range reports {
+						if r.Err != nil {
+							continue
+						}
+						if len(r.Drift) == 0 {
+							fmt.Printf("shard %d: no drift\n", r.Shard)
+							continue
+						}
+						ok = false
+						for _, d := range r.Drift {
+							fmt.Printf("shard %d: %s\n", r.Shard, d)
+						}
+					}
+					if !ok {
+						return fmt.Errorf("schema drift detected")
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+// Package commands contains all available commands.
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/evgnomon/zygote/lib/cluster/db"
+	"github.com/evgnomon/zygote/lib/cluster/secrets"
+	"github.com/evgnomon/zygote/lib/cluster/topology"
+	"github.com/urfave/cli/v2"
+)
+
+// SecretsCommand manages the generated credentials of the cluster.
+func SecretsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "secrets",
+		Usage: "Manage the generated credentials of the cluster",
+		Subcommands: []*cli.Command{
+			rotateSecretsCommand(),
+			discardSecretsCommand(),
+		},
+	}
+}
+
+// rotateSecretsCommand changes the passwords of the SQL users on a running cluster.
+// The old passwords keep working until they are discarded.
+func rotateSecretsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "rotate",
+		Usage: "Generate new SQL passwords and apply them to the running cluster, keeping the old ones",
+		Flags: []cli.Flag{
+			topologyFlag(),
+		},
+		Action: func(c *cli.Context) error {
+			ctx := context.Background()
+			t, current, err := loadCredentials(c)
+			if err != nil {
+				return err
+			}
+			if current.Previous != nil {
+				return fmt.Errorf("the previous SQL passwords are still accepted, run zygote secrets discard first")
+			}
+			// the new passwords are kept aside until every shard accepts them,
+			// an interrupted rotation resumes with the same passwords
+			from, to := current.Current(), current.Next
+			if to == nil {
+				if to, err = secrets.Generate(); err != nil {
+					return err
+				}
+				pending := *from
+				pending.Next = to
+				if err := secrets.Save(t.Tenant, &pending); err != nil {
+					return err
+				}
+			} else {
+				fmt.Println("Resuming an interrupted rotation")
+			}
+			if err := db.RotateCredentials(ctx, sqlNodes(t), from, to); err != nil {
+				return fmt.Errorf("rotation is incomplete, run it again to resume: %w", err)
+			}
+			published := *to
+			published.Previous = from
+			if err := secrets.Save(t.Tenant, &published); err != nil {
+				return err
+			}
+			fmt.Printf("Rotated SQL credentials of tenant %s, run zygote secrets discard once every service "+
+				"has reconnected with them\n", t.Tenant)
+			return nil
+		},
+	}
+}
+
+// discardSecretsCommand removes the passwords a rotation replaced.
+func discardSecretsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "discard",
+		Usage: "Stop accepting the SQL passwords replaced by the last rotation",
+		Flags: []cli.Flag{
+			topologyFlag(),
+		},
+		Action: func(c *cli.Context) error {
+			ctx := context.Background()
+			t, current, err := loadCredentials(c)
+			if err != nil {
+				return err
+			}
+			if current.Next != nil {
+				return fmt.Errorf("a rotation is incomplete, run zygote secrets rotate to finish it")
+			}
+			if current.Previous == nil {
+				fmt.Printf("No previous SQL passwords for tenant %s\n", t.Tenant)
+				return nil
+			}
+			if err := db.DiscardOldPasswords(ctx, sqlNodes(t), current.Current()); err != nil {
+				return err
+			}
+			if err := secrets.Save(t.Tenant, current.Current()); err != nil {
+				return err
+			}
+			fmt.Printf("Discarded the previous SQL passwords of tenant %s\n", t.Tenant)
+			return nil
+		},
+	}
+}
+
+// loadCredentials reads the topology and the credentials of its tenant from the vault
+func loadCredentials(c *cli.Context) (*topology.Topology, *secrets.Credentials, error) {
+	t, _, err := loadTopology(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if os.Getenv(secrets.RootPasswordEnv) != "" {
+		return nil, nil, fmt.Errorf("credentials are set by %s, rotate them where they are managed", secrets.RootPasswordEnv)
+	}
+	current, err := secrets.Load(t.Tenant)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, current, nil
+}
@@ -6,18 +6,24 @@ License: HGL General License <https://evgnomon.org/docs/hgl>
 package commands
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/evgnomon/zygote/lib/cluster/db"
+	"github.com/evgnomon/zygote/lib/cluster/secrets"
+	"github.com/evgnomon/zygote/lib/cluster/tables"
+	"github.com/evgnomon/zygote/lib/cluster/topology"
 	"github.com/evgnomon/zygote/lib/cluster/utils"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/term"
 )
 
-const routerReadWritePort = 6446
-const routerReadOnlyPort = 7447
-
 // SQLCommand provides a SQL shell to interact with the database.
 func SQLCommand() *cli.Command {
 	return &cli.Command{
@@ -48,11 +54,22 @@ func SQLCommand() *cli.Command {
 				Usage: "Host name",
 				Value: "127.0.0.1",
 			},
+			topologyFlag(),
 		},
 		Action: func(c *cli.Context) error {
+			t, _, err := loadTopology(c)
+			if err != nil {
+				return err
+			}
 			user := "root"
-			password := "root1234"
-			if c.String("user") != "" {
+			var password string
+			if c.String("user") == "" {
+				creds, err := secrets.Load(t.Tenant)
+				if err != nil {
+					return err
+				}
+				password = creds.Root
+			} else {
 				fmt.Print("Enter password: ")
 				bytePassword, err := term.ReadPassword(int(syscall.Stdin)) //nolint:unconvert
 				if err != nil {
@@ -66,20 +83,232 @@ func SQLCommand() *cli.Command {
 			// Construct the MySQL DSN (Data Source Name)
 			n := c.Int("s")
 			os.Setenv("MYSQL_PWD", password)
-			portNum := fmt.Sprintf("%d", routerReadWritePort+100*n)
+			portNum := fmt.Sprintf("%d", t.SQL.RouterReadWritePort+100*n)
 			if c.Bool("read-only") {
-				portNum = fmt.Sprintf("%d", routerReadOnlyPort+100*n)
+				portNum = fmt.Sprintf("%d", t.SQL.RouterReadOnlyPort+100*n)
 			}
 
 			command := []string{"mysql", "-u", user, "-h", c.String("host"), "-P", portNum, "-s", "--auto-rehash"}
 			if c.String("i") != "" {
 				command = append(command, "-e", c.String("i"))
 			}
-			err := utils.Run(command...)
+			err = utils.Run(command...)
 			if err != nil {
 				return err
 			}
 			return nil
 		},
+		Subcommands: []*cli.Command{
+			xaRecoverCommand(),
+			doctorCommand(),
+			rebootClusterCommand(),
+		},
+	}
+}
+
+// xaRecoverCommand resolves distributed transactions left in doubt by a crash.
+func xaRecoverCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "xa-recover",
+		Usage: "Commit or roll back distributed transactions left prepared on the shards",
+		Flags: []cli.Flag{
+			topologyFlag(),
+			&cli.StringFlag{
+				Name:  "log",
+				Usage: "Coordinator log file, defaults to the tenant log under ~/.config/zygote/xa",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			ctx := context.Background()
+			t, _, err := loadTopology(c)
+			if err != nil {
+				return err
+			}
+			logPath := c.String("log")
+			if logPath == "" {
+				logPath = tables.DefaultXALogPath(t.Tenant)
+			}
+			connector, err := connectTopology(ctx, t)
+			if err != nil {
+				return err
+			}
+			defer connector.CloseAll()
+			report, err := connector.RecoverXA(ctx, tables.NewFileLog(logPath))
+			if report != nil {
+				for _, gtrid := range report.Committed {
+					fmt.Printf("committed %s\n", gtrid)
+				}
+				for _, gtrid := range report.RolledBack {
+					fmt.Printf("rolled back %s\n", gtrid)
+				}
+				for _, gtrid := range report.Skipped {
+					fmt.Printf("skipped %s of another coordinator\n", gtrid)
+				}
+			}
+			return err
+		},
+	}
+}
+
+// sqlNodes returns the SQL nodes of the topology grouped by shard.
+func sqlNodes(t *topology.Topology) [][]*db.SQLNode {
+	nodes := make([][]*db.SQLNode, t.Shards)
+	for shardIndex := range nodes {
+		for repIndex := 0; repIndex < t.Replicas; repIndex++ {
+			nodes[shardIndex] = append(nodes[shardIndex], t.SQLNode(shardIndex, repIndex))
+		}
+	}
+	return nodes
+}
+
+// doctorCommand checks the group replication members and recovers the failed ones.
+func doctorCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "doctor",
+		Usage: "Check group replication members and restart replication on failed ones",
+		Flags: []cli.Flag{
+			topologyFlag(),
+			&cli.BoolFlag{
+				Name:  "watch",
+				Usage: "Keep monitoring and print every state transition as a JSON event",
+			},
+			&cli.DurationFlag{
+				Name:  "interval",
+				Usage: "Time between checks when watching",
+				Value: 10 * time.Second,
+			},
+			&cli.BoolFlag{
+				Name:  "recover",
+				Usage: "Restart group replication on ERROR and OFFLINE members, use --recover=false to only report",
+				Value: true,
+			},
+		},
+		Action: func(c *cli.Context) error {
+			t, _, err := loadTopology(c)
+			if err != nil {
+				return err
+			}
+			var onEvent func(db.GroupEvent)
+			if c.Bool("watch") {
+				enc := json.NewEncoder(os.Stdout)
+				onEvent = func(e db.GroupEvent) {
+					if err := enc.Encode(e); err != nil {
+						logger.Error("Failed to print event", err)
+					}
+				}
+			}
+			monitor := db.NewGroupMonitor(sqlNodes(t), onEvent)
+			monitor.Interval = c.Duration("interval")
+			monitor.AutoRecover = c.Bool("recover")
+			if c.Bool("watch") {
+				ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+				defer stop()
+				err := monitor.Run(ctx)
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+
+			statuses := monitor.Check(context.Background())
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "SHARD\tREPLICA\tHOST\tSTATE\tROLE\tERROR")
+			unhealthy := 0
+			for _, st := range statuses {
+				fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\n", st.Shard, st.Replica, st.Host, st.State, st.Role, st.Error)
+				if st.State != db.MemberOnline {
+					unhealthy++
+				}
+			}
+			w.Flush()
+			if unhealthy > 0 {
+				return fmt.Errorf("%d of %d members are not ONLINE", unhealthy, len(statuses))
+			}
+			return nil
+		},
+	}
+}
+
+// rebootClusterCommand bootstraps the groups of the shards after a complete outage.
+func rebootClusterCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "reboot-cluster",
+		Usage: "Bootstrap the group of every shard from its most advanced member after all members stopped",
+		Flags: []cli.Flag{
+			topologyFlag(),
+			&cli.IntSliceFlag{
+				Name:  "shard",
+				Usage: "Shards to reboot, defaults to all",
+			},
+			&cli.IntFlag{
+				Name:  "from",
+				Usage: "Replica to bootstrap from instead of the most advanced one, requires a single shard",
+				Value: -1,
+			},
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "Reboot with ONLINE or unreachable members, or from a member missing transactions of another",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Print the members and the chosen bootstrap member without changing anything",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			ctx := context.Background()
+			t, _, err := loadTopology(c)
+			if err != nil {
+				return err
+			}
+			nodes := sqlNodes(t)
+			shards := c.IntSlice("shard")
+			if len(shards) == 0 {
+				for shardIndex := range nodes {
+					shards = append(shards, shardIndex)
+				}
+			}
+			if c.Int("from") >= 0 && len(shards) != 1 {
+				return fmt.Errorf("--from requires exactly one --shard")
+			}
+			opts := db.RebootOptions{From: c.Int("from"), Force: c.Bool("force")}
+
+			var plans []*db.RebootPlan
+			for _, shardIndex := range shards {
+				if shardIndex < 0 || shardIndex >= len(nodes) {
+					return fmt.Errorf("shard %d out of range [0, %d)", shardIndex, len(nodes))
+				}
+				plan, err := db.PlanReboot(ctx, nodes[shardIndex], opts)
+				if plan != nil {
+					printRebootPlan(plan, err == nil)
+				}
+				if err != nil {
+					return err
+				}
+				plans = append(plans, plan)
+			}
+			if c.Bool("dry-run") {
+				return nil
+			}
+			for _, plan := range plans {
+				if err := plan.Execute(ctx); err != nil {
+					return fmt.Errorf("shard %d: %w", plan.Shard, err)
+				}
+				fmt.Printf("shard %d bootstrapped from %s\n", plan.Shard, plan.Members[plan.Bootstrap].Host)
+			}
+			return nil
+		},
+	}
+}
+
+func printRebootPlan(plan *db.RebootPlan, chosen bool) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SHARD\tREPLICA\tHOST\tSTATE\tTRANSACTIONS\tBOOTSTRAP\tERROR")
+	for i, m := range plan.Members {
+		bootstrap := ""
+		if chosen && i == plan.Bootstrap {
+			bootstrap = "yes"
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%d\t%s\t%s\n", m.Shard, m.Replica, m.Host, m.State, m.Transactions, bootstrap, m.Error)
 	}
+	w.Flush()
 }
@@ -0,0 +1,196 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package container
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+)
+
+const maxStderrSize = 64 * 1024
+
+// ExecOptions configures a command run inside a container
+type ExecOptions struct {
+	Env []string
+	// Stdin is streamed to the command and closed when exhausted
+	Stdin io.Reader
+	// Stdout receives the output of the command, it is discarded when nil
+	Stdout io.Writer
+}
+
+// Exec runs a command inside a running container and fails if the command
+// exits with a non-zero status
+func Exec(ctx context.Context, containerName string, cmd []string, opts ExecOptions) error {
+	cli, err := CreateClinet()
+	if err != nil {
+		return fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	defer cli.Close()
+	created, err := cli.ContainerExecCreate(ctx, containerName, containertypes.ExecOptions{
+		Env:          opts.Env,
+		Cmd:          cmd,
+		AttachStdin:  opts.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create exec in %s: %w", containerName, err)
+	}
+	resp, err := cli.ContainerExecAttach(ctx, created.ID, containertypes.ExecAttachOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to attach exec in %s: %w", containerName, err)
+	}
+	defer resp.Close()
+
+	stderr, err := streamHijacked(resp, opts.Stdin, opts.Stdout)
+	if err != nil {
+		return fmt.Errorf("%s in %s: %w", cmd[0], containerName, err)
+	}
+	inspect, err := cli.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect exec in %s: %w", containerName, err)
+	}
+	if inspect.ExitCode != 0 {
+		return fmt.Errorf("%s in %s exited with %d: %s", cmd[0], containerName, inspect.ExitCode, strings.TrimSpace(stderr))
+	}
+	return nil
+}
+
+// Run runs a command in a new container of an image with the given volume
+// bindings, streams its standard input and output and removes it when done
+func Run(ctx context.Context, image string, cmd, bindings []string, opts ExecOptions) error {
+	cli, err := CreateClinet()
+	if err != nil {
+		return fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	defer cli.Close()
+	resp, err := cli.ContainerCreate(ctx, &containertypes.Config{
+		Image:        image,
+		Cmd:          cmd,
+		Env:          opts.Env,
+		AttachStdin:  opts.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		OpenStdin:    opts.Stdin != nil,
+		StdinOnce:    opts.Stdin != nil,
+	}, &containertypes.HostConfig{Binds: bindings}, nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+	defer func() {
+		err := cli.ContainerRemove(context.Background(), resp.ID, containertypes.RemoveOptions{Force: true})
+		if err != nil {
+			logger.Error("Remove container", err, utils.M{"containerID": resp.ID})
+		}
+	}()
+	attached, err := cli.ContainerAttach(ctx, resp.ID, containertypes.AttachOptions{
+		Stream: true,
+		Stdin:  opts.Stdin != nil,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach to container: %w", err)
+	}
+	defer attached.Close()
+	if err := cli.ContainerStart(ctx, resp.ID, containertypes.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start container: %w", err)
+	}
+	stderr, err := streamHijacked(attached, opts.Stdin, opts.Stdout)
+	if err != nil {
+		return fmt.Errorf("%s in %s: %w", cmd[0], image, err)
+	}
+	statusCh, errCh := cli.ContainerWait(ctx, resp.ID, containertypes.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("failed to wait for container: %w", err)
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			return fmt.Errorf("%s in %s exited with %d: %s", cmd[0], image, status.StatusCode, strings.TrimSpace(stderr))
+		}
+	}
+	return nil
+}
+
+// streamHijacked copies stdin into the connection and demultiplexes its
+// output, it returns the tail of the standard error
+func streamHijacked(resp types.HijackedResponse, stdin io.Reader, stdout io.Writer) (string, error) {
+	inErr := make(chan error, 1)
+	if stdin != nil {
+		go func() {
+			_, err := io.Copy(resp.Conn, stdin)
+			if closeErr := resp.CloseWrite(); err == nil {
+				err = closeErr
+			}
+			inErr <- err
+		}()
+	} else {
+		inErr <- nil
+	}
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	stderr := &tailBuffer{max: maxStderrSize}
+	if _, err := stdcopy.StdCopy(stdout, stderr, resp.Reader); err != nil {
+		return stderr.String(), err
+	}
+	if err := <-inErr; err != nil {
+		return stderr.String(), fmt.Errorf("failed to write input: %w", err)
+	}
+	return stderr.String(), nil
+}
+
+// tailBuffer keeps the last bytes written to it
+type tailBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	n, err := b.Buffer.Write(p)
+	if over := b.Len() - b.max; over > 0 {
+		b.Next(over)
+	}
+	return n, err
+}
+
+// Stop stops a container, the restart policy does not start it again until
+// it is started explicitly
+func Stop(ctx context.Context, containerName string) error {
+	cli, err := CreateClinet()
+	if err != nil {
+		return fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	defer cli.Close()
+	if err := cli.ContainerStop(ctx, containerName, containertypes.StopOptions{Signal: "SIGTERM"}); err != nil {
+		return fmt.Errorf("failed to stop container %s: %w", containerName, err)
+	}
+	return nil
+}
+
+// Start starts a stopped container and waits until it is healthy
+func Start(ctx context.Context, containerName string, timeout time.Duration) error {
+	cli, err := CreateClinet()
+	if err != nil {
+		return fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	defer cli.Close()
+	if err := cli.ContainerStart(ctx, containerName, containertypes.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start container %s: %w", containerName, err)
+	}
+	if !WaitHealthy(containerName, timeout) {
+		return fmt.Errorf("container %s is not healthy", containerName)
+	}
+	return nil
+}
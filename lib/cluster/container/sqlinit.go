@@ -19,7 +19,9 @@ type SQLInitParams struct {
 }
 
 type RouterConfParams struct {
-	Destinations string
+	Destinations  string
+	ReadWritePort int
+	ReadOnlyPort  int
 }
 
 type InnoDBClusterParams struct {
@@ -0,0 +1,24 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package controller
+
+import (
+	"errors"
+
+	"github.com/evgnomon/zygote/lib/cluster/acl"
+	"github.com/evgnomon/zygote/lib/cluster/http"
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+)
+
+// sendDenial answers a request the ACL policy rejected, with a 403 for a
+// denial and a 400 for a query the policy could not read
+func sendDenial(c http.Context, err error) error {
+	var denial *acl.Denial
+	if errors.As(err, &denial) {
+		logger.Info("Denied query", utils.M{"user": denial.User, "action": denial.Action, "rule": denial.Rule})
+		return c.SendForbiddenError(denial)
+	}
+	return c.SendError(err.Error())
+}
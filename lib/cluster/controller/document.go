@@ -0,0 +1,308 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/acl"
+	"github.com/evgnomon/zygote/lib/cluster/http"
+	"github.com/evgnomon/zygote/lib/cluster/tables"
+)
+
+const maxDocumentSize = 1 << 20
+const filterParts = 3
+
+// maxCollections bounds the collections a controller keeps open
+const maxCollections = 1024
+
+// collectionTTL is how long an open collection is used before the table is
+// checked again
+const collectionTTL = 5 * time.Minute
+
+// DocumentController serves the document tables as collections
+type DocumentController struct {
+	connector   *tables.MultiDBConnector
+	stopWatch   context.CancelFunc
+	mu          sync.Mutex
+	collections map[string]*openCollection
+	acl         *acl.Enforcer
+}
+
+// openCollection is a collection whose table was found at checkedAt
+type openCollection struct {
+	col       *tables.Collection
+	checkedAt time.Time
+}
+
+// DocumentResponse is the response of the insert, get and patch endpoints
+type DocumentResponse struct {
+	Key string `json:"key"`
+	tables.Document
+}
+
+func NewDocumentController(enforcer *acl.Enforcer) (*DocumentController, error) {
+	connector, stopWatch, err := newSQLConnector()
+	if err != nil {
+		return nil, err
+	}
+	return &DocumentController{
+		connector:   connector,
+		stopWatch:   stopWatch,
+		collections: map[string]*openCollection{},
+		acl:         enforcer,
+	}, nil
+}
+
+// Close cleans up database resources
+func (dc *DocumentController) Close() error {
+	logger.Debug("Closing document database connections")
+	dc.stopWatch()
+	return dc.connector.CloseAll()
+}
+
+// collection returns the collection of the db and table path parameters. A
+// table is checked before its collection is kept, so names of missing tables
+// do not fill the cache.
+func (dc *DocumentController) collection(c http.Context, key string) (*tables.Collection, error) {
+	name := c.Param("db") + "." + c.Param("table")
+	dc.mu.Lock()
+	open, ok := dc.collections[name]
+	dc.mu.Unlock()
+	if ok && time.Since(open.checkedAt) < collectionTTL {
+		return open.col, nil
+	}
+	col, err := tables.NewCollection(dc.connector, c.Param("db"), c.Param("table"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", tables.ErrInvalidQuery, err)
+	}
+	if err := col.Check(c.GetRequestContext(), key); err != nil {
+		return nil, err
+	}
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if _, ok := dc.collections[name]; !ok && len(dc.collections) >= maxCollections {
+		dc.evictCollection()
+	}
+	dc.collections[name] = &openCollection{col: col, checkedAt: time.Now()}
+	return col, nil
+}
+
+// evictCollection drops the collection checked longest ago
+func (dc *DocumentController) evictCollection() {
+	var oldest string
+	var oldestAt time.Time
+	for name, open := range dc.collections {
+		if oldest == "" || open.checkedAt.Before(oldestAt) {
+			oldest, oldestAt = name, open.checkedAt
+		}
+	}
+	delete(dc.collections, oldest)
+}
+
+// authorize checks the ACL policy for a statement on the db and table path
+// parameters, given by the words that precede the table, such as DELETE FROM
+func (dc *DocumentController) authorize(c http.Context, user, statement string) error {
+	table := quoteIdentifier(c.Param("db")) + "." + quoteIdentifier(c.Param("table"))
+	return dc.acl.AuthorizeSQL(user, c.Param("db"), statement+" "+table)
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// target reads the collection, the shard key and, when present, the document id
+func (dc *DocumentController) target(c http.Context) (col *tables.Collection, key string, id uint64, err error) {
+	key = c.QueryParam("key")
+	if key == "" {
+		return nil, "", 0, fmt.Errorf("%w: the key query parameter is required to route the document to its shard",
+			tables.ErrInvalidQuery)
+	}
+	if s := c.Param("id"); s != "" {
+		id, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, "", 0, fmt.Errorf("%w: invalid document id %q", tables.ErrInvalidQuery, s)
+		}
+	}
+	col, err = dc.collection(c, key)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return col, key, id, nil
+}
+
+func readDocument(c http.Context) (json.RawMessage, error) {
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxDocumentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxDocumentSize {
+		return nil, fmt.Errorf("document exceeds %d bytes", maxDocumentSize)
+	}
+	return body, nil
+}
+
+// sendDocumentError maps collection errors to responses
+func sendDocumentError(c http.Context, msg string, err error) error {
+	switch {
+	case errors.Is(err, tables.ErrDocumentNotFound), errors.Is(err, tables.ErrCollectionNotFound):
+		return c.SendNotFoundError(err.Error())
+	case errors.Is(err, tables.ErrInvalidDocument), errors.Is(err, tables.ErrInvalidQuery), errors.Is(err, tables.ErrKeyMoving):
+		return c.SendError(err.Error())
+	}
+	return c.SendInternalError(msg, err)
+}
+
+// InsertHandler stores the request body as a new document
+func (dc *DocumentController) InsertHandler(c http.Context) error {
+	user, err := c.GetUser()
+	if err != nil {
+		return c.SendUnauthorizedError()
+	}
+	if err := dc.authorize(c, user, "INSERT INTO"); err != nil {
+		return sendDenial(c, err)
+	}
+	col, key, _, err := dc.target(c)
+	if err != nil {
+		return sendDocumentError(c, "Failed to open collection: ", err)
+	}
+	data, err := readDocument(c)
+	if err != nil {
+		return c.SendError(err.Error())
+	}
+	id, err := col.Insert(c.GetRequestContext(), key, data)
+	if err != nil {
+		return sendDocumentError(c, "Failed to insert document: ", err)
+	}
+	return c.Send(DocumentResponse{Key: key, Document: tables.Document{ID: id, Data: data}})
+}
+
+// GetHandler returns a document by id
+func (dc *DocumentController) GetHandler(c http.Context) error {
+	user, err := c.GetUser()
+	if err != nil {
+		return c.SendUnauthorizedError()
+	}
+	if err := dc.authorize(c, user, "SELECT * FROM"); err != nil {
+		return sendDenial(c, err)
+	}
+	col, key, id, err := dc.target(c)
+	if err != nil {
+		return sendDocumentError(c, "Failed to open collection: ", err)
+	}
+	doc, err := col.Get(c.GetRequestContext(), key, id)
+	if err != nil {
+		return sendDocumentError(c, "Failed to get document: ", err)
+	}
+	return c.Send(DocumentResponse{Key: key, Document: *doc})
+}
+
+// PatchHandler applies the request body as a JSON merge patch to a document
+func (dc *DocumentController) PatchHandler(c http.Context) error {
+	user, err := c.GetUser()
+	if err != nil {
+		return c.SendUnauthorizedError()
+	}
+	if err := dc.authorize(c, user, "UPDATE"); err != nil {
+		return sendDenial(c, err)
+	}
+	col, key, id, err := dc.target(c)
+	if err != nil {
+		return sendDocumentError(c, "Failed to open collection: ", err)
+	}
+	patch, err := readDocument(c)
+	if err != nil {
+		return c.SendError(err.Error())
+	}
+	doc, err := col.Patch(c.GetRequestContext(), key, id, patch)
+	if err != nil {
+		return sendDocumentError(c, "Failed to patch document: ", err)
+	}
+	return c.Send(DocumentResponse{Key: key, Document: *doc})
+}
+
+// DeleteHandler removes a document by id
+func (dc *DocumentController) DeleteHandler(c http.Context) error {
+	user, err := c.GetUser()
+	if err != nil {
+		return c.SendUnauthorizedError()
+	}
+	if err := dc.authorize(c, user, "DELETE FROM"); err != nil {
+		return sendDenial(c, err)
+	}
+	col, key, id, err := dc.target(c)
+	if err != nil {
+		return sendDocumentError(c, "Failed to open collection: ", err)
+	}
+	if err := col.Delete(c.GetRequestContext(), key, id); err != nil {
+		return sendDocumentError(c, "Failed to delete document: ", err)
+	}
+	return c.Send(map[string]any{"deleted": id})
+}
+
+// FindHandler returns a page of documents. Filters are given as repeated
+// f=<path>:<op>:<value> query parameters, for example f=$.age:gte:18.
+func (dc *DocumentController) FindHandler(c http.Context) error {
+	user, err := c.GetUser()
+	if err != nil {
+		return c.SendUnauthorizedError()
+	}
+	if err := dc.authorize(c, user, "SELECT * FROM"); err != nil {
+		return sendDenial(c, err)
+	}
+	col, key, _, err := dc.target(c)
+	if err != nil {
+		return sendDocumentError(c, "Failed to open collection: ", err)
+	}
+	q := tables.Query{Cursor: c.QueryParam("cursor")}
+	if s := c.QueryParam("limit"); s != "" {
+		q.Limit, err = strconv.Atoi(s)
+		if err != nil {
+			return c.SendError(fmt.Sprintf("invalid limit %q", s))
+		}
+	}
+	for _, f := range c.Request().URL.Query()["f"] {
+		parts := strings.SplitN(f, ":", filterParts)
+		if len(parts) != filterParts {
+			return c.SendError(fmt.Sprintf("invalid filter %q, expected <path>:<op>:<value>", f))
+		}
+		q.Filters = append(q.Filters, tables.Filter{Path: parts[0], Op: parts[1], Value: tables.ParseFilterValue(parts[2])})
+	}
+	page, err := col.Find(c.GetRequestContext(), key, q)
+	if err != nil {
+		return sendDocumentError(c, "Failed to find documents: ", err)
+	}
+	return c.Send(page)
+}
+
+// AddEndpoint configures the controller routes
+func (dc *DocumentController) AddEndpoint(prefix string, e http.Router) error {
+	collection := fmt.Sprintf("%s/sql/collections/:db/:table", prefix)
+	routes := []struct {
+		method  http.Method
+		path    string
+		handler func(http.Context) error
+	}{
+		{http.POST, collection, dc.InsertHandler},
+		{http.GET, collection, dc.FindHandler},
+		{http.GET, collection + "/:id", dc.GetHandler},
+		{http.PATCH, collection + "/:id", dc.PatchHandler},
+		{http.DELETE, collection + "/:id", dc.DeleteHandler},
+	}
+	for _, r := range routes {
+		if err := e.Add(r.method, r.path, r.handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -7,26 +7,29 @@ package controller
 import (
 	"context"
 	"fmt"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/evgnomon/zygote/lib/cluster/acl"
 	"github.com/evgnomon/zygote/lib/cluster/http"
+	"github.com/evgnomon/zygote/lib/cluster/mem"
 	"github.com/evgnomon/zygote/lib/cluster/memconn"
+	"github.com/evgnomon/zygote/lib/cluster/topology"
 	"github.com/evgnomon/zygote/lib/cluster/utils"
 	"github.com/redis/go-redis/v9"
 )
 
 const redisTimeout = 5 * time.Second
-const targetReadPort = 6373
 
 type RedisQueryRequest struct {
 	Query []string `json:"query" form:"query"`
 }
 
 type RedisQueryController struct {
+	network   string
 	config    *RedisConfig
+	acl       *acl.Enforcer
 	client    *redis.ClusterClient
 	mu        sync.Mutex
 	lastCheck time.Time
@@ -37,11 +40,17 @@ type RedisConfig struct {
 	Password string
 }
 
-func NewRedisQueryController(config *RedisConfig) (*RedisQueryController, error) {
-	// It is enough to connect to two endpoints, the rest will be discovered
-	ep, err := memconn.MemEndpoints(utils.NetworkName(), utils.DomainName(), 2, targetReadPort)
-	logger.FatalIfErr("Get endpoints", err)
+func NewRedisQueryController(config *RedisConfig, enforcer *acl.Enforcer) (*RedisQueryController, error) {
+	t, _, err := topology.LoadOrDefault(topology.DefaultPath())
+	if err != nil {
+		return nil, err
+	}
 	if config == nil {
+		// The first replica of each shard seeds the discovery of the others
+		ep, err := memconn.MemEndpoints(t.Network, t.Domain, t.Shards, t.Mem.Port)
+		if err != nil {
+			return nil, err
+		}
 		config = &RedisConfig{
 			// The rest of nodes are discovered by the client
 			Addrs: []string{},
@@ -53,7 +62,9 @@ func NewRedisQueryController(config *RedisConfig) (*RedisQueryController, error)
 	}
 
 	rc := &RedisQueryController{
-		config: config,
+		network: t.Network,
+		config:  config,
+		acl:     enforcer,
 	}
 
 	if err := rc.ensureConnection(); err != nil {
@@ -87,10 +98,7 @@ func (rc *RedisQueryController) ensureConnection() error {
 		rc.client = nil
 	}
 
-	client, err := memconn.Client()
-	if err != nil {
-		return err
-	}
+	client := memconn.NewClusterClient(rc.network, rc.config.Addrs)
 
 	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
 	defer cancel()
@@ -106,14 +114,23 @@ func (rc *RedisQueryController) ensureConnection() error {
 }
 
 func (rc *RedisQueryController) QueryHandler(c http.Context) error {
-	if err := rc.ensureConnection(); err != nil {
-		return c.SendInternalError("Redis connection failed: ", err)
+	user, err := c.GetUser()
+	if err != nil {
+		return c.SendUnauthorizedError()
 	}
-
 	var req RedisQueryRequest
 	if err := c.BindBody(&req); err != nil {
 		return err
 	}
+	if len(req.Query) == 0 {
+		return c.SendError("Query cannot be empty")
+	}
+	if err := rc.acl.AuthorizeMem(user, req.Query); err != nil {
+		return sendDenial(c, err)
+	}
+	if err := rc.ensureConnection(); err != nil {
+		return c.SendInternalError("Redis connection failed: ", err)
+	}
 
 	// Prepare command arguments for Redis
 	args := make([]any, len(req.Query))
@@ -126,7 +143,6 @@ func (rc *RedisQueryController) QueryHandler(c http.Context) error {
 	defer cancel()
 
 	var result any
-	var err error
 	for attempt := 0; attempt < 3; attempt++ {
 		result, err = rc.client.Do(ctx, args...).Result()
 		if err != nil {
@@ -184,72 +200,11 @@ func (rc *RedisQueryController) ClusterNodesHandler(c http.Context) error {
 		return c.SendInternalError("Failed to get cluster nodes", err)
 	}
 
-	// Parse the nodes string into a more structured JSON response
-	type NodeInfo struct {
-		ID          string   `json:"id"`
-		Address     string   `json:"address"`
-		Flags       []string `json:"flags"`
-		Role        string   `json:"role"`
-		MasterID    string   `json:"masterId,omitempty"`
-		PingSent    int64    `json:"pingSent"`
-		PongRecv    int64    `json:"pongRecv"`
-		ConfigEpoch int64    `json:"configEpoch"`
-		LinkState   string   `json:"linkState"`
-		Slots       []string `json:"slots,omitempty"`
-	}
-
-	var clusterNodes []NodeInfo
-	lines := strings.Split(nodes, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		parts := strings.Fields(line)
-		if len(parts) < 8 { //nolint:mnd
-			continue
-		}
-
-		flags := strings.Split(parts[2], ",")
-		role := "master"
-		if strings.Contains(parts[2], "slave") {
-			role = "slave"
-		}
-
-		node := NodeInfo{
-			ID:          parts[0],
-			Address:     parts[1],
-			Flags:       flags,
-			Role:        role,
-			MasterID:    parts[3],
-			PingSent:    parseInt(parts[4]),
-			PongRecv:    parseInt(parts[5]),
-			ConfigEpoch: parseInt(parts[6]),
-			LinkState:   parts[7],
-		}
-
-		// Add slots if present (for master nodes)
-		if len(parts) > 8 { //nolint:mnd
-			node.Slots = parts[8:]
-		}
-
-		clusterNodes = append(clusterNodes, node)
-	}
-
-	return c.Send(map[string]any{
-		"nodes": clusterNodes,
-		"count": len(clusterNodes),
-	})
-}
-
-// Helper function to parse integers safely
-func parseInt(s string) int64 {
-	val, err := strconv.ParseInt(s, 10, 64)
+	clusterNodes, err := mem.ParseClusterNodes(nodes)
 	if err != nil {
-		return 0
+		return c.SendInternalError("Failed to parse cluster nodes", err)
 	}
-	return val
+	return c.Send(mem.NewClusterTopology(clusterNodes))
 }
 
 // Modify the AddEndpoint method to include the new endpoint
@@ -262,5 +217,13 @@ func (rc *RedisQueryController) AddEndpoint(prefix string, e http.Router) error
 	if err != nil {
 		return err
 	}
+	err = e.Add(http.GET, fmt.Sprintf("%s/mem/subscribe", prefix), rc.SubscribeHandler)
+	if err != nil {
+		return err
+	}
+	err = e.Add(http.GET, fmt.Sprintf("%s/mem/streams/:stream", prefix), rc.StreamHandler)
+	if err != nil {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,204 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package controller
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/http"
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultStreamCount = 10
+const maxStreamCount = 1000
+const streamBlock = 5 * time.Second
+
+// PubSubEvent is a message published to a channel, sent as a message event
+type PubSubEvent struct {
+	Channel string `json:"channel"`
+	Pattern string `json:"pattern,omitempty"`
+	Payload string `json:"payload"`
+}
+
+// StreamEvent is an entry of a stream, sent as an entry event with the entry
+// ID as the event ID
+type StreamEvent struct {
+	Stream string         `json:"stream"`
+	ID     string         `json:"id"`
+	Values map[string]any `json:"values"`
+}
+
+// SubscribeHandler streams the messages published to channels, or to
+// channels matching patterns, as server-sent events until the client
+// disconnects
+func (rc *RedisQueryController) SubscribeHandler(c http.Context) error {
+	user, err := c.GetUser()
+	if err != nil {
+		return c.SendUnauthorizedError()
+	}
+	query := c.Request().URL.Query()
+	channels, patterns := query["channel"], query["pattern"]
+	if len(channels) == 0 && len(patterns) == 0 {
+		return c.SendError("channel or pattern is required")
+	}
+	if len(channels) > 0 {
+		if err := rc.acl.AuthorizeMem(user, append([]string{"SUBSCRIBE"}, channels...)); err != nil {
+			return sendDenial(c, err)
+		}
+	}
+	if len(patterns) > 0 {
+		if err := rc.acl.AuthorizeMem(user, append([]string{"PSUBSCRIBE"}, patterns...)); err != nil {
+			return sendDenial(c, err)
+		}
+	}
+	if err := rc.ensureConnection(); err != nil {
+		return c.SendInternalError("Redis connection failed: ", err)
+	}
+
+	ctx := c.GetRequestContext()
+	pubsub := rc.client.Subscribe(ctx)
+	defer pubsub.Close()
+	if len(channels) > 0 {
+		if err := pubsub.Subscribe(ctx, channels...); err != nil {
+			return c.SendInternalError("Subscribe failed", err)
+		}
+	}
+	if len(patterns) > 0 {
+		if err := pubsub.PSubscribe(ctx, patterns...); err != nil {
+			return c.SendInternalError("Subscribe failed", err)
+		}
+	}
+	logger.Debug("Subscribed", utils.M{"user": user, "channels": channels, "patterns": patterns})
+
+	stream, err := newEventStream(c)
+	if err != nil {
+		return err
+	}
+	messages := pubsub.Channel()
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err = stream.keepAlive()
+		case msg, ok := <-messages:
+			if !ok {
+				// The connection to Redis is gone, the client reconnects
+				return nil
+			}
+			err = stream.send("", "message", PubSubEvent{Channel: msg.Channel, Pattern: msg.Pattern, Payload: msg.Payload})
+		}
+		if err != nil {
+			// The client is gone
+			return nil
+		}
+	}
+}
+
+// StreamHandler reads a stream as a consumer of a group and sends its entries
+// as server-sent events until the client disconnects. The consumer is named
+// after the user, so a user cannot read the entries pending for another. The
+// entries the consumer read without acknowledging them are sent first, each
+// entry is acknowledged once it is sent. The group is created at the end of
+// the stream if it does not exist. The user needs XGROUP CREATE, XREADGROUP
+// and XACK on the stream.
+func (rc *RedisQueryController) StreamHandler(c http.Context) error {
+	user, err := c.GetUser()
+	if err != nil {
+		return c.SendUnauthorizedError()
+	}
+	key := c.Param("stream")
+	group := c.QueryParam("group")
+	if group == "" {
+		return c.SendError("group is required")
+	}
+	consumer := user
+	count := int64(defaultStreamCount)
+	if s := c.QueryParam("count"); s != "" {
+		if count, err = strconv.ParseInt(s, 10, 64); err != nil || count <= 0 || count > maxStreamCount {
+			return c.SendError("count must be between 1 and 1000")
+		}
+	}
+	for _, args := range [][]string{
+		{"XGROUP", "CREATE", key, group, "$", "MKSTREAM"},
+		{"XREADGROUP", "GROUP", group, consumer, "STREAMS", key, ">"},
+		{"XACK", key, group},
+	} {
+		if err := rc.acl.AuthorizeMem(user, args); err != nil {
+			return sendDenial(c, err)
+		}
+	}
+	if err := rc.ensureConnection(); err != nil {
+		return c.SendInternalError("Redis connection failed: ", err)
+	}
+
+	ctx := c.GetRequestContext()
+	err = rc.client.XGroupCreateMkStream(ctx, key, group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return c.SendInternalError("Failed to create consumer group", err)
+	}
+	logger.Debug("Reading stream", utils.M{"user": user, "stream": key, "group": group, "consumer": consumer})
+
+	stream, err := newEventStream(c)
+	if err != nil {
+		return err
+	}
+	lastKeepAlive := time.Now()
+	// Start with the pending entries of the consumer, then read new ones
+	id := "0"
+	for ctx.Err() == nil {
+		args := &redis.XReadGroupArgs{Group: group, Consumer: consumer, Streams: []string{key, id}, Count: count}
+		if id == ">" {
+			args.Block = streamBlock
+		}
+		res, err := rc.client.XReadGroup(ctx, args).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			if ctx.Err() == nil {
+				logger.Error("Failed to read stream", err, utils.M{"stream": key, "group": group})
+				_ = stream.send("", "error", map[string]string{"error": "failed to read stream"})
+			}
+			return nil
+		}
+		var entries []redis.XMessage
+		for _, s := range res {
+			entries = append(entries, s.Messages...)
+		}
+		if len(entries) == 0 {
+			id = ">"
+			if time.Since(lastKeepAlive) >= sseKeepAlive {
+				if stream.keepAlive() != nil {
+					return nil
+				}
+				lastKeepAlive = time.Now()
+			}
+			continue
+		}
+		for _, entry := range entries {
+			if err := stream.send(entry.ID, "entry", StreamEvent{Stream: key, ID: entry.ID, Values: entry.Values}); err != nil {
+				return nil
+			}
+			if err := rc.client.XAck(context.WithoutCancel(ctx), key, group, entry.ID).Err(); err != nil {
+				// The entry stays pending and is sent again when the
+				// consumer reconnects
+				logger.Error("Failed to acknowledge stream entry", err, utils.M{"stream": key, "id": entry.ID})
+				_ = stream.send("", "error", map[string]string{"error": "failed to acknowledge stream entry"})
+				return nil
+			}
+		}
+		if id != ">" {
+			// Read the pending entries after the ones just sent
+			id = entries[len(entries)-1].ID
+		}
+		lastKeepAlive = time.Now()
+	}
+	return nil
+}
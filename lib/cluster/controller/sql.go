@@ -6,45 +6,92 @@ package controller
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"strings"
 
-	"github.com/evgnomon/zygote/lib/cluster/container"
+	"github.com/evgnomon/zygote/lib/cluster/acl"
 	"github.com/evgnomon/zygote/lib/cluster/http"
 	"github.com/evgnomon/zygote/lib/cluster/tables"
+	"github.com/evgnomon/zygote/lib/cluster/topology"
 	"github.com/evgnomon/zygote/lib/cluster/utils"
 )
 
-const routerReadPort = 6446
-const routerWritePort = 6447
-const defaultNumShards = 3
+const defaultDatabase = "mysql"
 
 var logger = utils.NewLogger()
 
+const allShards = "all"
+
+// Intents of a query, without one statements that return rows are read and
+// the others written
+const (
+	IntentRead  = "read"
+	IntentWrite = "write"
+)
+
+// SQLQueryRequest runs a query with its args, or a batch of statements in
+// one transaction. The query runs on shard 0 unless a shard, the key of a
+// shard or all shards are given, and on the database of the connection
+// unless a database is given.
 type SQLQueryRequest struct {
-	Query string `json:"query" form:"query"`
+	Query    string             `json:"query" form:"query"`
+	Args     tables.Args        `json:"args,omitzero"`
+	Batch    []tables.Statement `json:"batch,omitempty"`
+	Shards   string             `json:"shards" form:"shards"`
+	Shard    *int               `json:"shard,omitempty"`
+	ShardKey string             `json:"shardKey,omitempty"`
+	Intent   string             `json:"intent,omitempty"`
+	Database string             `json:"database,omitempty"`
+}
+
+// SQLBatchResponse holds the result of each statement of a batch
+type SQLBatchResponse struct {
+	Results []tables.StatementResult `json:"results"`
 }
 
 type SQLQueryController struct {
 	connector *tables.MultiDBConnector
+	stopWatch context.CancelFunc
+	acl       *acl.Enforcer
 }
 
-func NewSQLQueryController() (*SQLQueryController, error) {
-	// Initialize database configuration
+// newSQLConnector connects to the read and write routers of every shard of
+// the topology and follows the shared shard map until the returned function
+// is called
+func newSQLConnector() (*tables.MultiDBConnector, context.CancelFunc, error) {
+	t, _, err := topology.LoadOrDefault(topology.DefaultPath())
+	if err != nil {
+		return nil, nil, err
+	}
 	ctx := context.Background()
-	connector := tables.NewMultiDBConnector(container.AppNetworkName(), "zygote", utils.DomainName(), "mysql",
-		routerReadPort, routerWritePort, defaultNumShards)
-	_, err := connector.ConnectAllShardsRead(ctx)
+	connector := tables.NewMultiDBConnector(t.Network, t.Tenant, t.Domain, defaultDatabase,
+		t.SQL.RouterReadOnlyPort, t.SQL.RouterReadWritePort, t.Shards)
+	_, err = connector.ConnectAllShardsRead(ctx)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	_, err = connector.ConnectAllShardsWrite(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := connector.ReloadShardMap(ctx); err != nil {
+		return nil, nil, err
+	}
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	go connector.WatchShardMap(watchCtx, tables.DefaultShardMapInterval)
+	return connector, stopWatch, nil
+}
+
+func NewSQLQueryController(enforcer *acl.Enforcer) (*SQLQueryController, error) {
+	// Initialize database configuration
+	connector, stopWatch, err := newSQLConnector()
 	if err != nil {
 		return nil, err
 	}
 	dc := &SQLQueryController{
 		connector: connector,
+		stopWatch: stopWatch,
+		acl:       enforcer,
 	}
 	return dc, nil
 }
@@ -52,73 +99,134 @@ func NewSQLQueryController() (*SQLQueryController, error) {
 // Close cleans up database resources
 func (dc *SQLQueryController) Close() error {
 	logger.Debug("Closing database connections")
+	dc.stopWatch()
 	return dc.connector.CloseAll()
 }
 
 // QueryHandler handles SQL query requests
 func (dc *SQLQueryController) QueryHandler(c http.Context) error {
+	user, err := c.GetUser()
+	if err != nil {
+		return c.SendUnauthorizedError()
+	}
 	var req SQLQueryRequest
-	err := c.BindBody(&req)
+	err = c.BindBody(&req)
 	if err != nil {
 		return err
 	}
 
+	if req.Intent != "" && req.Intent != IntentRead && req.Intent != IntentWrite {
+		return c.SendError(fmt.Sprintf("Unsupported intent %q", req.Intent))
+	}
+	database := req.Database
+	if database == "" {
+		database = defaultDatabase
+	}
+	var opts []tables.OperationOption
+	if req.Database != "" {
+		opts = append(opts, tables.WithDatabase(req.Database))
+	}
+
+	if len(req.Batch) > 0 {
+		return dc.batch(c, user, database, &req, opts)
+	}
+
 	query := strings.TrimSpace(req.Query)
 	if query == "" {
 		// return c.SendJSONError(nethttp.StatusBadRequest, map[string]string{
 		return c.SendError("Query cannot be empty")
 	}
+	if err := dc.acl.AuthorizeSQL(user, database, query); err != nil {
+		return sendDenial(c, err)
+	}
+	stmt := tables.Statement{Query: query, Args: req.Args}
+	boundQuery, args, err := stmt.Bind()
+	if err != nil {
+		return c.SendError(err.Error())
+	}
 
-	// Execute query with retry on connection loss
-	return dc.connector.RetryReadOperation(c.GetRequestContext(), 0, func(db *sql.DB) error {
-		rows, err := db.QueryContext(c.GetRequestContext(), query)
-		if err != nil {
-			return err
+	switch req.Shards {
+	case "":
+	case allShards:
+		if req.Shard != nil || req.ShardKey != "" {
+			return c.SendError("shards cannot be combined with shard or shardKey")
 		}
-		if rows == nil {
-			return c.SendInternalError("Query returned no rows after multiple attempts", err)
+		if req.Intent == IntentWrite || req.Database != "" {
+			return c.SendError("queries on all shards are reads on the database of the connection")
 		}
-		defer rows.Close()
-
-		// Get column names
-		columns, err := rows.Columns()
+		result, err := dc.connector.ScatterQuery(c.GetRequestContext(), boundQuery, args...)
 		if err != nil {
-			return c.SendInternalError("Failed to get columns: ", err)
+			return c.SendInternalError("Failed to execute query on all shards: ", err)
+		}
+		return c.Send(result)
+	default:
+		return c.SendError(fmt.Sprintf("Unsupported shards value %q", req.Shards))
+	}
+
+	shardIndex, err := dc.shard(&req)
+	if err != nil {
+		return c.SendError(err.Error())
+	}
+	write := req.Intent == IntentWrite || (req.Intent == "" && !tables.ReturnsRows(query))
+
+	// Execute query with retry on connection loss
+	result, err := dc.connector.Execute(c.GetRequestContext(), shardIndex, stmt, write, opts...)
+	if err != nil {
+		return c.SendInternalError("Failed to execute query: ", err)
+	}
+	return c.Send(result)
+}
+
+// shard returns the shard a request runs on
+func (dc *SQLQueryController) shard(req *SQLQueryRequest) (int, error) {
+	switch {
+	case req.Shard != nil && req.ShardKey != "":
+		return 0, fmt.Errorf("shard and shardKey cannot be combined")
+	case req.ShardKey != "":
+		return dc.connector.ShardForKey(req.ShardKey)
+	case req.Shard != nil:
+		if *req.Shard < 0 || *req.Shard >= dc.connector.NumShards() {
+			return 0, fmt.Errorf("shard must be between 0 and %d", dc.connector.NumShards()-1)
 		}
+		return *req.Shard, nil
+	default:
+		return 0, nil
+	}
+}
 
-		// Process results
-		var results []map[string]any
-		for rows.Next() {
-			values := make([]any, len(columns))
-			valuePtrs := make([]any, len(columns))
-			for i := range values {
-				valuePtrs[i] = &values[i]
-			}
-
-			if err := rows.Scan(valuePtrs...); err != nil {
-				return c.SendInternalError("Failed to scan row: ", err)
-			}
-
-			row := make(map[string]any)
-			for i, col := range columns {
-				var v any
-				if values[i] != nil {
-					switch va := values[i].(type) {
-					case []byte:
-						v = string(va)
-					default:
-						v = values[i]
-					}
-				}
-				row[col] = v
-			}
-			results = append(results, row)
+// batch runs the statements of a request in one transaction on the write
+// pool
+func (dc *SQLQueryController) batch(c http.Context, user, database string, req *SQLQueryRequest,
+	opts []tables.OperationOption) error {
+	if strings.TrimSpace(req.Query) != "" || !req.Args.IsZero() {
+		return c.SendError("query and args cannot be combined with batch")
+	}
+	if req.Shards != "" {
+		return c.SendError("batch runs on a single shard")
+	}
+	if req.Intent == IntentRead {
+		return c.SendError("batch runs on the write pool")
+	}
+	shardIndex, err := dc.shard(req)
+	if err != nil {
+		return c.SendError(err.Error())
+	}
+	for i, stmt := range req.Batch {
+		if strings.TrimSpace(stmt.Query) == "" {
+			return c.SendError(fmt.Sprintf("statement %d: query cannot be empty", i+1))
+		}
+		if err := dc.acl.AuthorizeSQL(user, database, stmt.Query); err != nil {
+			return sendDenial(c, err)
 		}
-		if err = rows.Err(); err != nil {
-			return c.SendInternalError("Row iteration error", err)
+		if _, _, err := stmt.Bind(); err != nil {
+			return c.SendError(fmt.Sprintf("statement %d: %v", i+1, err))
 		}
-		return c.Send(results)
-	})
+	}
+	results, err := dc.connector.ExecuteBatch(c.GetRequestContext(), shardIndex, req.Batch, opts...)
+	if err != nil {
+		return c.SendInternalError("Failed to execute batch: ", err)
+	}
+	return c.Send(SQLBatchResponse{Results: results})
 }
 
 // ClusterMember defines the structure for cluster member info
@@ -0,0 +1,70 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	nethttp "net/http"
+	"strings"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/http"
+)
+
+// sseKeepAlive is how often an idle event stream sends a comment, so proxies
+// and clients do not drop it
+const sseKeepAlive = 15 * time.Second
+
+// eventStream writes server-sent events to a client
+type eventStream struct {
+	w  nethttp.ResponseWriter
+	rc *nethttp.ResponseController
+}
+
+// newEventStream starts an event stream as the response of a request. The
+// stream lives as long as the client stays connected, so the read and write
+// timeouts of the server are lifted for it.
+func newEventStream(c http.Context) (*eventStream, error) {
+	w := c.ResponseWriter()
+	rc := nethttp.NewResponseController(w)
+	for _, setDeadline := range []func(time.Time) error{rc.SetReadDeadline, rc.SetWriteDeadline} {
+		if err := setDeadline(time.Time{}); err != nil && !errors.Is(err, nethttp.ErrNotSupported) {
+			return nil, err
+		}
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(nethttp.StatusOK)
+	return &eventStream{w: w, rc: rc}, rc.Flush()
+}
+
+// send writes an event with a JSON payload. The ID is omitted when empty.
+func (s *eventStream) send(id, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	if id != "" {
+		fmt.Fprintf(&b, "id: %s\n", id)
+	}
+	fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", event, payload)
+	if _, err := s.w.Write([]byte(b.String())); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// keepAlive writes a comment, which clients ignore
+func (s *eventStream) keepAlive() error {
+	if _, err := s.w.Write([]byte(": keep-alive\n\n")); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
@@ -0,0 +1,198 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/evgnomon/zygote/lib/cluster/schema"
+)
+
+// CreateForeignKeyParams adds a foreign key with an index on its columns
+type CreateForeignKeyParams struct {
+	CreateSQLParams
+	Columns         []string
+	RefDatabaseName string
+	RefTableName    string
+	RefColumns      []string
+	OnDelete        string
+	OnUpdate        string
+}
+
+// NewCreateForeignKeyParams validates a foreign key. The referenced columns
+// default to id and the referential actions to RESTRICT.
+func NewCreateForeignKeyParams(dbName, tableName, name string, columns []string,
+	refDBName, refTableName string, refColumns []string, onDelete, onUpdate string) (*CreateForeignKeyParams, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("foreign key %s needs at least one column", name)
+	}
+	if len(refColumns) == 0 {
+		refColumns = []string{"id"}
+	}
+	if len(refColumns) != len(columns) {
+		return nil, fmt.Errorf("foreign key %s has %d columns but references %d", name, len(columns), len(refColumns))
+	}
+	if refDBName == "" {
+		refDBName = dbName
+	}
+	p := &CreateForeignKeyParams{
+		CreateSQLParams: CreateSQLParams{
+			Type:         "fk",
+			DatabaseName: dbName,
+			TableName:    tableName,
+			Name:         name,
+		},
+		Columns:         columns,
+		RefDatabaseName: refDBName,
+		RefTableName:    refTableName,
+		RefColumns:      refColumns,
+	}
+	var err error
+	if p.OnDelete, err = referentialAction(onDelete); err != nil {
+		return nil, err
+	}
+	if p.OnUpdate, err = referentialAction(onUpdate); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func referentialAction(action string) (string, error) {
+	action = strings.Join(strings.Fields(strings.ToUpper(action)), " ")
+	switch action {
+	case "":
+		return "RESTRICT", nil
+	case "RESTRICT", "CASCADE", "SET NULL", "NO ACTION":
+		return action, nil
+	}
+	return "", fmt.Errorf("unsupported referential action %q, expected RESTRICT, CASCADE, SET NULL or NO ACTION", action)
+}
+
+// ShardLocalityWarning explains that the foreign key is only enforced within
+// a shard
+func (p *CreateForeignKeyParams) ShardLocalityWarning() string {
+	return fmt.Sprintf("foreign keys are enforced within a shard only, "+
+		"rows of %s.%s must be routed to the shard of the %s.%s rows they reference",
+		p.DatabaseName, p.TableName, p.RefDatabaseName, p.RefTableName)
+}
+
+// RenameParams renames a table or a column, Name is the new name of a table
+// and the old name of a column
+type RenameParams struct {
+	CreateSQLParams
+	NewName string
+}
+
+// NewRenameTableParams renames a table within its database
+func NewRenameTableParams(dbName, tableName, newName string) *RenameParams {
+	return &RenameParams{
+		CreateSQLParams: CreateSQLParams{
+			Action:       "rename",
+			Type:         "table",
+			DatabaseName: dbName,
+			TableName:    tableName,
+			Name:         newName,
+		},
+		NewName: newName,
+	}
+}
+
+// NewRenameColumnParams renames a column of a table
+func NewRenameColumnParams(dbName, tableName, name, newName string) *RenameParams {
+	return &RenameParams{
+		CreateSQLParams: CreateSQLParams{
+			Action:       "rename",
+			Type:         "column",
+			DatabaseName: dbName,
+			TableName:    tableName,
+			Name:         name,
+		},
+		NewName: newName,
+	}
+}
+
+// DropColumnParams drops a column, the down migration adds it back with its
+// definition but not its data
+type DropColumnParams struct {
+	CreateSQLParams
+	Definition ColumnDefinition
+}
+
+// NewDropColumnParams drops a column that is restored with the given
+// definition by the down migration
+func NewDropColumnParams(dbName, tableName, name string, definition *ColumnDefinition) *DropColumnParams {
+	return &DropColumnParams{
+		CreateSQLParams: CreateSQLParams{
+			Action:       "drop",
+			Type:         "column",
+			DatabaseName: dbName,
+			TableName:    tableName,
+			Name:         name,
+		},
+		Definition: *definition,
+	}
+}
+
+// ChangeColumnParams changes the definition of a column, the down migration
+// restores the previous definition
+type ChangeColumnParams struct {
+	CreateSQLParams
+	Definition ColumnDefinition
+	Previous   ColumnDefinition
+}
+
+// NewChangeColumnParams changes a column from the previous to the new
+// definition
+func NewChangeColumnParams(dbName, tableName, name string, definition, previous *ColumnDefinition) *ChangeColumnParams {
+	return &ChangeColumnParams{
+		CreateSQLParams: CreateSQLParams{
+			Action:       "change",
+			Type:         "column",
+			DatabaseName: dbName,
+			TableName:    tableName,
+			Name:         name,
+		},
+		Definition: *definition,
+		Previous:   *previous,
+	}
+}
+
+// CreateCheckParams adds a check constraint
+type CreateCheckParams struct {
+	CreateSQLParams
+	Expression string
+}
+
+// ExistingColumn returns the definition of a column as implied by the
+// migrations in the migration directory. Defaults are not tracked, a NOT NULL
+// column gets the zero value of its type.
+func ExistingColumn(dbName, tableName, name string) (*ColumnDefinition, error) {
+	files, err := schema.MigrationFiles(sqlsDir)
+	if err != nil {
+		return nil, err
+	}
+	s, err := schema.Expected(files, schema.LatestVersion(files))
+	if err != nil {
+		return nil, err
+	}
+	object := fmt.Sprintf("%s.%s.%s", dbName, tableName, name)
+	d, ok := s.Databases[strings.ToLower(dbName)]
+	if !ok {
+		return nil, fmt.Errorf("column %s not found in %s", object, sqlsDir)
+	}
+	t, ok := d.Tables[strings.ToLower(tableName)]
+	if !ok {
+		return nil, fmt.Errorf("column %s not found in %s", object, sqlsDir)
+	}
+	c, ok := t.Columns[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("column %s not found in %s", object, sqlsDir)
+	}
+	if c.Generated {
+		return nil, fmt.Errorf("column %s is generated, its expression is not tracked", object)
+	}
+	return NewColumnDefinition(c.Type, ColumnOptions{NotNull: !c.Nullable})
+}
@@ -0,0 +1,167 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/evgnomon/zygote/lib/cluster/schema"
+	"github.com/google/go-cmp/cmp"
+)
+
+var baseMigrations = []string{
+	"CREATE DATABASE shop;",
+	"CREATE TABLE `shop`.`users` (id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT, PRIMARY KEY (id));",
+	"CREATE TABLE `shop`.`orders` (id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT, " +
+		"user_id BIGINT UNSIGNED NOT NULL, price DECIMAL(10,2), PRIMARY KEY (id));",
+}
+
+func baseSchema(t *testing.T) *schema.Schema {
+	s := schema.New()
+	for _, doc := range baseMigrations {
+		if err := s.Apply(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// TestAlterMigrations tests that the down migration of every generator undoes
+// its up migration
+func TestAlterMigrations(t *testing.T) {
+	fk, err := NewCreateForeignKeyParams("shop", "orders", "user", []string{"user_id"}, "", "users", nil, "cascade", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decimal, err := NewColumnDefinition("decimal(10,2)", ColumnOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bigger, err := NewColumnDefinition("decimal(12,4)", ColumnOptions{NotNull: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		spec   MigrationSpec
+		desc   string
+		wantUp string
+	}{
+		{
+			name: "foreign key",
+			spec: fk,
+			desc: "fk_shop_orders_user",
+			wantUp: "-- Foreign keys are enforced within a shard only\n" +
+				"ALTER TABLE `shop`.`orders`\n" +
+				"ADD INDEX `idx_fk_user` (`user_id`),\n" +
+				"ADD CONSTRAINT `fk_user` FOREIGN KEY (`user_id`)\n" +
+				"  REFERENCES `shop`.`users` (`id`)\n" +
+				"  ON DELETE CASCADE ON UPDATE RESTRICT;",
+		},
+		{
+			name:   "rename table",
+			spec:   NewRenameTableParams("shop", "orders", "purchases"),
+			desc:   "rename_table_shop_orders_purchases",
+			wantUp: "RENAME TABLE `shop`.`orders` TO `shop`.`purchases`;",
+		},
+		{
+			name:   "rename column",
+			spec:   NewRenameColumnParams("shop", "orders", "price", "amount"),
+			desc:   "rename_column_shop_orders_price",
+			wantUp: "ALTER TABLE `shop`.`orders` RENAME COLUMN `price` TO `amount`;",
+		},
+		{
+			name:   "drop column",
+			spec:   NewDropColumnParams("shop", "orders", "price", decimal),
+			desc:   "drop_column_shop_orders_price",
+			wantUp: "ALTER TABLE `shop`.`orders` DROP COLUMN `price`;",
+		},
+		{
+			name:   "change column",
+			spec:   NewChangeColumnParams("shop", "orders", "price", bigger, decimal),
+			desc:   "change_column_shop_orders_price",
+			wantUp: "ALTER TABLE `shop`.`orders` MODIFY COLUMN `price` DECIMAL(12,4) NOT NULL DEFAULT 0.0;",
+		},
+		{
+			name: "check",
+			spec: &CreateCheckParams{
+				CreateSQLParams: CreateSQLParams{Type: "check", DatabaseName: "shop", TableName: "orders", Name: "price"},
+				Expression:      "price >= 0",
+			},
+			desc:   "check_shop_orders_price",
+			wantUp: "ALTER TABLE `shop`.`orders` ADD CONSTRAINT `chk_price` CHECK (price >= 0);",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := GenCreateSQL(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Desc != tt.desc {
+				t.Errorf("Desc = %q, want %q", m.Desc, tt.desc)
+			}
+			if m.Up != tt.wantUp {
+				t.Errorf("Up = %q, want %q", m.Up, tt.wantUp)
+			}
+			s := baseSchema(t)
+			if err := s.Apply(m.Up); err != nil {
+				t.Fatalf("apply up: %v", err)
+			}
+			if err := s.Apply(m.Down); err != nil {
+				t.Fatalf("apply down: %v", err)
+			}
+			if diff := cmp.Diff(baseSchema(t), s); diff != "" {
+				t.Errorf("down does not undo up (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestForeignKeyParams tests validating foreign keys
+func TestForeignKeyParams(t *testing.T) {
+	if _, err := NewCreateForeignKeyParams("shop", "orders", "user", []string{"a", "b"}, "", "users", nil, "", ""); err == nil {
+		t.Error("column count mismatch must fail")
+	}
+	if _, err := NewCreateForeignKeyParams("shop", "orders", "user", []string{"user_id"}, "", "users", nil, "SET DEFAULT", ""); err == nil {
+		t.Error("SET DEFAULT must fail")
+	}
+	p, err := NewCreateForeignKeyParams("shop", "orders", "user", []string{"user_id"}, "auth", "users", nil, "set  null", "no action")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.OnDelete != "SET NULL" || p.OnUpdate != "NO ACTION" || p.RefDatabaseName != "auth" {
+		t.Errorf("NewCreateForeignKeyParams() = %+v", p)
+	}
+}
+
+// TestExistingColumn tests reading a column definition from the migrations
+func TestExistingColumn(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir(sqlsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i, doc := range baseMigrations {
+		name := filepath.Join(sqlsDir, string(rune('1'+i))+"_base.up.sql")
+		if err := os.WriteFile(name, []byte(doc), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d, err := ExistingColumn("shop", "orders", "user_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ColumnDefinition{SQLColType: "BIGINT UNSIGNED", NotNull: true, DefaultValue: "0"}
+	if diff := cmp.Diff(want, d); diff != "" {
+		t.Errorf("ExistingColumn() mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := ExistingColumn("shop", "orders", "missing"); err == nil {
+		t.Error("missing column must fail")
+	}
+}
@@ -18,6 +18,7 @@ import (
 
 	"github.com/evgnomon/zygote/lib/cluster/container"
 	"github.com/evgnomon/zygote/lib/cluster/cert"
+	"github.com/evgnomon/zygote/lib/cluster/secrets"
 	"github.com/evgnomon/zygote/lib/cluster/tables"
 	"github.com/evgnomon/zygote/lib/cluster/utils"
 )
@@ -25,18 +26,31 @@ import (
 //go:embed templates/*.sql
 var templates embed.FS
 
-const mysqlImage = "evgnomon/mysql:8.4.4"
+// DefaultImage is the MySQL image of the SQL nodes
+const DefaultImage = "evgnomon/mysql:8.4.4"
+
+// DefaultPort is the MySQL port of the SQL nodes
+const DefaultPort = 3306
+
+// DefaultGroupReplicationPort is the group replication port of the SQL nodes
+const DefaultGroupReplicationPort = 33061
+
+// DefaultRouterReadWritePort is the read-write port of the SQL routers
+const DefaultRouterReadWritePort = 6446
+
+// DefaultRouterReadOnlyPort is the read-only port of the SQL routers
+const DefaultRouterReadOnlyPort = 6447
+
 const plainFilePermission = 0644
-const routerPortNumRead = 6446
-const routerPortNumWrite = 6447
 const sqlsDir = "sqls"
-const mysqlPublicDefaultPort = 3306
-const groupRepDefaultPort = 33061
 const defaultShardSize = 3
 const hostNetworkName = "host"
 const defaultConnDatabaseName = "mysql"
 const localhostIP = "127.0.0.1"
 const dbShortName = "sql"
+
+// DataDir is the data directory of the MySQL server in its container
+const DataDir = "/var/lib/mysql"
 const dbRouterShortName = "sql-router"
 const replicationRetrySleepTime = 5 * time.Second
 const numRetriesJoinGroupReplication = 5
@@ -44,19 +58,25 @@ const numRetriesJoinGroupReplication = 5
 var logger = utils.NewLogger()
 
 type SQLNode struct {
-	Tenant       string
-	Domain       string
-	DatabaseName string
-	User         string
-	Password     string
-	RootPassword string
-	MigrationDir string
-	NetworkName  string
-	GroupName    string
-	NumShards    int
-	ShardSize    int
-	ShardIndex   int
-	RepIndex     int
+	Tenant               string
+	Domain               string
+	DatabaseName         string
+	User                 string
+	Password             string
+	RootPassword         string
+	ReplicationPassword  string
+	MigrationDir         string
+	NetworkName          string
+	GroupName            string
+	Image                string
+	Port                 int
+	GroupReplicationPort int
+	RouterReadWritePort  int
+	RouterReadOnlyPort   int
+	NumShards            int
+	ShardSize            int
+	ShardIndex           int
+	RepIndex             int
 }
 
 func NewSQLNode() *SQLNode {
@@ -74,12 +94,6 @@ func NewSQLNode() *SQLNode {
 	if c.User == "" {
 		c.User = "admin"
 	}
-	if c.Password == "" {
-		c.Password = "password"
-	}
-	if c.RootPassword == "" {
-		c.RootPassword = "root1234"
-	}
 	if c.Tenant == "" {
 		c.Tenant = "zygote"
 	}
@@ -92,9 +106,46 @@ func NewSQLNode() *SQLNode {
 	if c.Domain == "" {
 		c.Domain = "zygote.run"
 	}
+	if c.Image == "" {
+		c.Image = DefaultImage
+	}
+	if c.Port == 0 {
+		c.Port = DefaultPort
+	}
+	if c.GroupReplicationPort == 0 {
+		c.GroupReplicationPort = DefaultGroupReplicationPort
+	}
+	if c.RouterReadWritePort == 0 {
+		c.RouterReadWritePort = DefaultRouterReadWritePort
+	}
+	if c.RouterReadOnlyPort == 0 {
+		c.RouterReadOnlyPort = DefaultRouterReadOnlyPort
+	}
 	return c
 }
 
+// ResolveSecrets fills the passwords that are not set with the credentials of
+// the tenant
+func (s *SQLNode) ResolveSecrets() error {
+	if s.Password != "" && s.RootPassword != "" && s.ReplicationPassword != "" {
+		return nil
+	}
+	c, err := secrets.Load(s.Tenant)
+	if err != nil {
+		return err
+	}
+	if s.Password == "" {
+		s.Password = c.App
+	}
+	if s.RootPassword == "" {
+		s.RootPassword = c.Root
+	}
+	if s.ReplicationPassword == "" {
+		s.ReplicationPassword = c.Replication
+	}
+	return nil
+}
+
 func (s *SQLNode) mapPort(target int) int {
 	return utils.NodePort(s.NetworkName, target, s.RepIndex, s.ShardIndex)
 }
@@ -108,21 +159,20 @@ func (s *SQLNode) MakeDB(ctx context.Context) error {
 	containerConfig := &container.ContainerConfig{
 		Name:        containerName,
 		NetworkName: s.NetworkName,
-		Image:       mysqlImage,
+		Image:       s.Image,
+		// The server listens on TCP once the init scripts ran. The ping
+		// needs no password, so rotating the root password keeps the
+		// container healthy.
 		HealthCommand: []string{
 			"CMD",
-			"mysql",
+			"mysqladmin",
+			"ping",
 			"-h",
-			"localhost",
-			"-u",
-			"root",
-			fmt.Sprintf("-p%s", s.RootPassword),
-			"-e",
-			"SHOW tables;",
-			dbName,
+			localhostIP,
+			"--protocol=TCP",
 		},
 		Bindings: []string{
-			fmt.Sprintf("%s-data:/var/lib/mysql", containerName),
+			fmt.Sprintf("%s:%s", s.DataVolumeName(), DataDir),
 			fmt.Sprintf("%s-conf-gr:/etc/mysql/conf.d", containerName),
 			fmt.Sprintf("%s-conf:/docker-entrypoint-initdb.d", containerName),
 			fmt.Sprintf("%s:/etc/certs", s.certVolName()),
@@ -132,8 +182,8 @@ func (s *SQLNode) MakeDB(ctx context.Context) error {
 			fmt.Sprintf("MYSQL_ROOT_PASSWORD=%s", s.RootPassword),
 		},
 		Ports: map[int]int{
-			s.mapPort(mysqlPublicDefaultPort): mysqlPublicDefaultPort,
-			s.mapPort(groupRepDefaultPort):    groupRepDefaultPort,
+			s.mapPort(s.Port):                 s.Port,
+			s.mapPort(s.GroupReplicationPort): s.GroupReplicationPort,
 		},
 	}
 	return containerConfig.StartContainer(ctx)
@@ -168,7 +218,7 @@ func (s *SQLNode) generateAddresses(port int) []string {
 }
 
 func (s *SQLNode) Endpoints() []string {
-	return s.generateAddresses(mysqlPublicDefaultPort)
+	return s.generateAddresses(s.Port)
 }
 
 func (s *SQLNode) GroupReplicationHosts(shardIndex int) []string {
@@ -193,7 +243,11 @@ func (s *SQLNode) GroupReplicationHosts(shardIndex int) []string {
 
 func (s *SQLNode) StartSQLContainers(ctx context.Context) error {
 	logger.Debug("Start SQL node containers", utils.M{"sqlNode": s})
-	err := s.MakeSQLReplica(ctx)
+	err := s.ResolveSecrets()
+	if err != nil {
+		return fmt.Errorf("failed to resolve SQL credentials: %w", err)
+	}
+	err = s.MakeSQLReplica(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to create SQL replica: %w", err)
 	}
@@ -227,7 +281,9 @@ func (s *SQLNode) ContainerName(name string) string {
 func (s *SQLNode) MakeSQLRouter(ctx context.Context) error {
 	const mysqlRouterConfTmplName = "router.conf"
 	routerConfParams := container.RouterConfParams{
-		Destinations: strings.Join(s.Endpoints(), ","),
+		Destinations:  strings.Join(s.Endpoints(), ","),
+		ReadWritePort: s.RouterReadWritePort,
+		ReadOnlyPort:  s.RouterReadOnlyPort,
 	}
 	routerConf, err := container.ApplyTemplate(mysqlRouterConfTmplName, routerConfParams)
 	if err != nil {
@@ -239,7 +295,7 @@ func (s *SQLNode) MakeSQLRouter(ctx context.Context) error {
 	config := &container.ContainerConfig{
 		Name:          s.ContainerName(dbRouterShortName),
 		NetworkName:   s.NetworkName,
-		Image:         mysqlImage,
+		Image:         s.Image,
 		HealthCommand: []string{"CMD", "true"},
 		Bindings: []string{
 			fmt.Sprintf("%s:/etc/mysqlrouter/", fmt.Sprintf("%s-conf", containerName)),
@@ -253,8 +309,8 @@ func (s *SQLNode) MakeSQLRouter(ctx context.Context) error {
 			"--config=/etc/mysqlrouter/router.conf",
 		},
 		Ports: map[int]int{
-			s.mapPort(routerPortNumRead):  routerPortNumRead,
-			s.mapPort(routerPortNumWrite): routerPortNumWrite,
+			s.mapPort(s.RouterReadWritePort): s.RouterReadWritePort,
+			s.mapPort(s.RouterReadOnlyPort):  s.RouterReadOnlyPort,
 		},
 	}
 	err = config.StartContainer(ctx)
@@ -278,11 +334,11 @@ func (s *SQLNode) MakeGroupReplicationConfigVolume() error {
 	const clusterTmplName = "innodb_cluster_template.cnf"
 	sqlParams := container.InnoDBClusterParams{
 		ServerID:             s.RepIndex + 1,
-		GroupReplicationPort: groupRepDefaultPort,
+		GroupReplicationPort: s.GroupReplicationPort,
 		ServerCount:          s.ShardSize,
 		ServersList:          s.groupSeedsValue(),
 		ReportAddress:        s.reportSQLInstanceAddress(),
-		ReportPort:           mysqlPublicDefaultPort,
+		ReportPort:           s.Port,
 	}
 	innodbGroupReplication, err := container.ApplyTemplate(clusterTmplName, sqlParams)
 	if err != nil {
@@ -306,12 +362,12 @@ func (s *SQLNode) groupIPAllowListValue() string {
 }
 
 func (s *SQLNode) groupSeedsValue() string {
-	return strings.Join(s.generateAddresses(groupRepDefaultPort), ",")
+	return strings.Join(s.generateAddresses(s.GroupReplicationPort), ",")
 }
 
 func (s *SQLNode) localAddressValue() string {
 	return fmt.Sprintf("%s:%d",
-		s.GroupReplicationHosts(s.ShardIndex)[s.RepIndex], groupRepDefaultPort,
+		s.GroupReplicationHosts(s.ShardIndex)[s.RepIndex], s.GroupReplicationPort,
 	)
 }
 
@@ -482,75 +538,121 @@ func CreateTable(dbName, tableName string) (*SQLMigration, error) {
 }
 
 type CreateColumnParams struct {
-	TableName    string
-	DatabaseName string
-	SQLColType   string
-	ColumnName   string
+	CreateSQLParams
+	Definition ColumnDefinition
+	After      string
+}
+
+// ColumnOptions are the optional parts of a column definition
+type ColumnOptions struct {
+	NotNull bool
+	// Default is kept as is for numbers, NULL, CURRENT_TIMESTAMP and
+	// parenthesized expressions, other values are quoted
+	Default     *string
+	After       string
+	Comment     string
+	GeneratedAs string
+	Stored      bool
+}
+
+// ColumnDefinition is the type and attributes of a column as written after
+// the column name
+type ColumnDefinition struct {
+	SQLColType string
+	// DefaultValue is the rendered DEFAULT literal, empty for no default
 	DefaultValue string
+	NotNull      bool
+	Comment      string
+	// GeneratedAs is the expression of a generated column
+	GeneratedAs string
+	Stored      bool
 }
 
-func resolveDefaultValue(sqlColType string) (string, error) {
-	var defaultValue string
-	switch sqlColType {
-	case "INT", "BIGINT":
-		defaultValue = "0"
-	case "FLOAT", "DOUBLE":
-		defaultValue = "0.0"
-	case "BOOLEAN":
-		defaultValue = "false"
-	case "MEDIUMBLOB":
-		defaultValue = "''"
-	case "JSON":
-		defaultValue = "NULL"
-	case "VARCHAR(255)", "MEDIUMTEXT", "CHAR(36)":
-		defaultValue = "''"
-	default:
-		return "", fmt.Errorf("unsupported column type: %s", sqlColType)
+// NewColumnDefinition validates the column type and options. A NOT NULL
+// column without a default gets the zero value of its type so it can be added
+// to tables with rows.
+func NewColumnDefinition(colType string, opts ColumnOptions) (*ColumnDefinition, error) {
+	t, err := ParseColumnType(colType)
+	if err != nil {
+		return nil, err
+	}
+	d := &ColumnDefinition{
+		SQLColType:  t.SQL(),
+		NotNull:     opts.NotNull,
+		Comment:     opts.Comment,
+		GeneratedAs: opts.GeneratedAs,
+		Stored:      opts.Stored,
+	}
+	if opts.Stored && opts.GeneratedAs == "" {
+		return nil, fmt.Errorf("stored requires a generated column expression")
+	}
+	switch {
+	case opts.Default != nil && opts.GeneratedAs != "":
+		return nil, fmt.Errorf("generated column cannot have a default")
+	case opts.Default != nil:
+		d.DefaultValue, err = t.Literal(*opts.Default)
+		if err != nil {
+			return nil, err
+		}
+		if d.NotNull && d.DefaultValue == "NULL" {
+			return nil, fmt.Errorf("not null column cannot default to NULL")
+		}
+	case opts.NotNull && opts.GeneratedAs == "":
+		d.DefaultValue = t.ZeroValue()
 	}
-	return defaultValue, nil
+	return d, nil
 }
 
-func CreateColumn(dbName, tableName, name, sqlColType string) (*SQLMigration, error) {
-	upTemplate, err := templates.ReadFile("templates/create_column_up.sql")
-	if err != nil {
-		return nil, err
+func (d ColumnDefinition) String() string {
+	s := d.SQLColType
+	if d.GeneratedAs != "" {
+		s += fmt.Sprintf(" GENERATED ALWAYS AS (%s)", d.GeneratedAs)
+		if d.Stored {
+			s += " STORED"
+		} else {
+			s += " VIRTUAL"
+		}
 	}
-	downTemplate, err := templates.ReadFile("templates/create_column_down.sql")
-	if err != nil {
-		return nil, err
+	if d.NotNull {
+		s += " NOT NULL"
 	}
-	tmplUp := string(upTemplate)
-	tmplDown := string(downTemplate)
-	tUp, err := template.New("create_column_up.sql").Parse(tmplUp)
-	if err != nil {
-		return nil, err
+	if d.DefaultValue != "" {
+		s += " DEFAULT " + d.DefaultValue
 	}
-	tDown, err := template.New("create_column_down.sql").Parse(tmplDown)
-	if err != nil {
-		return nil, err
+	if d.Comment != "" {
+		s += " COMMENT " + quoteString(d.Comment)
 	}
+	return s
+}
 
-	defaultValue, err := resolveDefaultValue(sqlColType)
+// NewCreateColumnParams validates the column type and options of a new column
+func NewCreateColumnParams(dbName, tableName, name, colType string, opts ColumnOptions) (*CreateColumnParams, error) {
+	d, err := NewColumnDefinition(colType, opts)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("column %s: %w", name, err)
 	}
+	return &CreateColumnParams{
+		CreateSQLParams: CreateSQLParams{
+			Type:         "column",
+			DatabaseName: dbName,
+			TableName:    tableName,
+			Name:         name,
+		},
+		Definition: *d,
+		After:      opts.After,
+	}, nil
+}
 
-	var tplUp bytes.Buffer
-	params := &CreateColumnParams{TableName: tableName, DatabaseName: dbName,
-		ColumnName: name, SQLColType: sqlColType, DefaultValue: defaultValue}
-	if err := tUp.Execute(&tplUp, params); err != nil {
+func CreateColumn(dbName, tableName, name, sqlColType string) (*SQLMigration, error) {
+	t, err := ParseColumnType(sqlColType)
+	if err != nil {
 		return nil, err
 	}
-	var tplDown bytes.Buffer
-	if err := tDown.Execute(&tplDown, params); err != nil {
+	params, err := NewCreateColumnParams(dbName, tableName, name, sqlColType, ColumnOptions{NotNull: t.HasDefault()})
+	if err != nil {
 		return nil, err
 	}
-	result := &SQLMigration{
-		Desc: fmt.Sprintf("column_%s_%s_%s", dbName, tableName, name),
-		Up:   strings.Trim(tplUp.String(), "\n"),
-		Down: strings.Trim(tplDown.String(), "\n"),
-	}
-	return result, nil
+	return GenCreateSQL(params)
 }
 
 type CreatePropertyParams struct {
@@ -603,8 +705,10 @@ func CreateProperty(dbName, tableName, name, fieldPath, dataType string, virtual
 type CreateSQLParams struct {
 	TableName    string
 	DatabaseName string
-	Type         string
-	Name         string
+	// Action defaults to create
+	Action string
+	Type   string
+	Name   string
 }
 
 func (p CreateSQLParams) GetTableName() string {
@@ -615,6 +719,13 @@ func (p CreateSQLParams) GetDatabaseName() string {
 	return p.DatabaseName
 }
 
+func (p CreateSQLParams) GetAction() string {
+	if p.Action == "" {
+		return "create"
+	}
+	return p.Action
+}
+
 func (p CreateSQLParams) GetType() string {
 	return p.Type
 }
@@ -626,26 +737,30 @@ func (p CreateSQLParams) GetName() string {
 type MigrationSpec interface {
 	GetTableName() string
 	GetDatabaseName() string
+	GetAction() string
 	GetType() string
 	GetName() string
 }
 
+// GenCreateSQL renders the <action>_<type>_up.sql and down templates of a
+// migration
 func GenCreateSQL(params MigrationSpec) (*SQLMigration, error) {
-	upTemplate, err := templates.ReadFile(fmt.Sprintf("templates/create_%s_up.sql", params.GetType()))
+	name := fmt.Sprintf("%s_%s", params.GetAction(), params.GetType())
+	upTemplate, err := templates.ReadFile(fmt.Sprintf("templates/%s_up.sql", name))
 	if err != nil {
 		return nil, err
 	}
-	downTemplate, err := templates.ReadFile(fmt.Sprintf("templates/create_%s_down.sql", params.GetType()))
+	downTemplate, err := templates.ReadFile(fmt.Sprintf("templates/%s_down.sql", name))
 	if err != nil {
 		return nil, err
 	}
 	tmplUp := string(upTemplate)
 	tmplDown := string(downTemplate)
-	tUp, err := template.New(fmt.Sprintf("create_%s_up.sql", params.GetType())).Parse(tmplUp)
+	tUp, err := template.New(fmt.Sprintf("%s_up.sql", name)).Parse(tmplUp)
 	if err != nil {
 		return nil, err
 	}
-	tDown, err := template.New(fmt.Sprintf("create_%s_down.sql", params.GetType())).Parse(tmplDown)
+	tDown, err := template.New(fmt.Sprintf("%s_down.sql", name)).Parse(tmplDown)
 	if err != nil {
 		return nil, err
 	}
@@ -658,8 +773,12 @@ func GenCreateSQL(params MigrationSpec) (*SQLMigration, error) {
 	if err := tDown.Execute(&tplDown, params); err != nil {
 		return nil, err
 	}
+	desc := params.GetType()
+	if params.GetAction() != "create" {
+		desc = name
+	}
 	result := &SQLMigration{
-		Desc: fmt.Sprintf("%s_%s_%s_%s", params.GetType(), params.GetDatabaseName(),
+		Desc: fmt.Sprintf("%s_%s_%s_%s", desc, params.GetDatabaseName(),
 			params.GetTableName(), params.GetName()),
 		Up:   strings.Trim(tplUp.String(), "\n"),
 		Down: strings.Trim(tplDown.String(), "\n"),
@@ -678,16 +797,39 @@ func (s *SQLNode) SQLRouterContainerName() string {
 	return s.ContainerName(dbRouterShortName)
 }
 
+// SQLContainerName returns the name of the MySQL server container of the node
+func (s *SQLNode) SQLContainerName() string {
+	return s.sqlContainerName()
+}
+
+// DataVolumeName returns the name of the volume holding the data directory
+func (s *SQLNode) DataVolumeName() string {
+	return fmt.Sprintf("%s-data", s.sqlContainerName())
+}
+
 func (s *SQLNode) connectionString(dbName string) string {
 	if s.NetworkName != hostNetworkName {
-		return fmt.Sprintf("root:%s@tcp(%s:%d)/%s?tls=sqlTLS", s.RootPassword, localhostIP, s.mapPort(mysqlPublicDefaultPort), dbName)
+		return fmt.Sprintf("root:%s@tcp(%s:%d)/%s?tls=sqlTLS", s.RootPassword, localhostIP, s.mapPort(s.Port), dbName)
 	}
-	return fmt.Sprintf("root:%s@tcp(%s:%d)/%s?tls=sqlTLS", s.RootPassword, localhostIP, mysqlPublicDefaultPort, dbName)
+	return fmt.Sprintf("root:%s@tcp(%s:%d)/%s?tls=sqlTLS", s.RootPassword, localhostIP, s.Port, dbName)
 }
 
 func (s *SQLNode) GetDB() (*sql.DB, error) {
+	return s.openDB(s.DatabaseName)
+}
+
+// GetAdminDB connects to the node as root without requiring the application
+// database to exist
+func (s *SQLNode) GetAdminDB() (*sql.DB, error) {
+	return s.openDB(defaultConnDatabaseName)
+}
+
+func (s *SQLNode) openDB(dbName string) (*sql.DB, error) {
+	if err := s.ResolveSecrets(); err != nil {
+		return nil, err
+	}
 	tables.RegisterTLSConfig(utils.ContainerCertName(utils.ContainerName("provisioner")))
-	dsn := s.connectionString(s.DatabaseName)
+	dsn := s.connectionString(dbName)
 	db, err := sql.Open(defaultConnDatabaseName, dsn)
 	if err != nil {
 		logger.Debug("Error connecting to database", utils.M{
@@ -700,6 +842,13 @@ func (s *SQLNode) GetDB() (*sql.DB, error) {
 	return db, nil
 }
 
+// RecoveryChannelQuery sets the credentials distributed recovery uses to
+// clone the data of a donor
+func (s *SQLNode) RecoveryChannelQuery() string {
+	return fmt.Sprintf("CHANGE REPLICATION SOURCE TO SOURCE_USER = 'repl', SOURCE_PASSWORD = '%s' "+
+		"FOR CHANNEL 'group_replication_recovery'", s.ReplicationPassword)
+}
+
 func (s *SQLNode) JoinGroupReplication() error {
 	logger.Debug("Joining group replication", utils.M{"replicaIndex": s.RepIndex,
 		"shardIndex": s.ShardIndex, "domain": s.Domain, "tenant": s.Tenant})
@@ -730,7 +879,7 @@ func (s *SQLNode) JoinGroupReplication() error {
 		"SET GLOBAL group_replication_recovery_ssl_cert = '/etc/certs/my-server-cert.pem'",
 		"SET GLOBAL group_replication_recovery_ssl_key = '/etc/certs/my-server-key.pem'",
 		"SET SQL_LOG_BIN = 0",
-		"CREATE USER 'repl'@'%' IDENTIFIED with mysql_native_password BY 'strong_password'",
+		fmt.Sprintf("CREATE USER 'repl'@'%%' IDENTIFIED with mysql_native_password BY '%s'", s.ReplicationPassword),
 		"GRANT REPLICATION SLAVE, REPLICATION CLIENT ON *.* TO 'repl'@'%'",
 		"FLUSH PRIVILEGES",
 		"SET SQL_LOG_BIN = 1",
@@ -763,7 +912,7 @@ func (s *SQLNode) JoinGroupReplication() error {
 			"STOP GROUP_REPLICATION",
 			"RESET BINARY LOGS AND GTIDS",
 			"RESET REPLICA ALL",
-			"CHANGE REPLICATION SOURCE TO SOURCE_USER = 'repl', SOURCE_PASSWORD = 'strong_password' FOR CHANNEL 'group_replication_recovery'",
+			s.RecoveryChannelQuery(),
 		}
 
 		for _, query := range secondaryQueries {
@@ -0,0 +1,129 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var gtidTagRe = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,31}$`)
+
+// gtidInterval is an inclusive range of transaction numbers
+type gtidInterval struct {
+	start, end uint64
+}
+
+// GTIDSet is a set of global transaction identifiers keyed by source UUID, or
+// UUID and tag
+type GTIDSet map[string][]gtidInterval
+
+// ParseGTIDSet parses a GTID set such as
+// 3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5:11,24da167b-71ca-11e1-9e33-c80aa9429562:tag:1
+func ParseGTIDSet(s string) (GTIDSet, error) {
+	set := GTIDSet{}
+	s = strings.Join(strings.Fields(s), "")
+	if s == "" {
+		return set, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Split(part, ":")
+		if len(fields) < 2 || fields[0] == "" {
+			return nil, fmt.Errorf("invalid GTID set %q", part)
+		}
+		uuid := strings.ToLower(fields[0])
+		source := uuid
+		for _, f := range fields[1:] {
+			if f == "" {
+				return nil, fmt.Errorf("invalid GTID set %q", part)
+			}
+			if f[0] < '0' || f[0] > '9' {
+				// a tag applies to the intervals after it
+				tag := strings.ToLower(f)
+				if !gtidTagRe.MatchString(tag) {
+					return nil, fmt.Errorf("invalid tag %q in GTID set %q", f, part)
+				}
+				source = uuid + ":" + tag
+				continue
+			}
+			interval, err := parseGTIDInterval(f)
+			if err != nil {
+				return nil, fmt.Errorf("invalid GTID set %q: %w", part, err)
+			}
+			set[source] = append(set[source], interval)
+		}
+	}
+	for source := range set {
+		set[source] = mergeIntervals(set[source])
+	}
+	return set, nil
+}
+
+func parseGTIDInterval(s string) (gtidInterval, error) {
+	start, end, isRange := strings.Cut(s, "-")
+	first, err := strconv.ParseUint(start, 10, 64)
+	if err != nil {
+		return gtidInterval{}, err
+	}
+	last := first
+	if isRange {
+		if last, err = strconv.ParseUint(end, 10, 64); err != nil {
+			return gtidInterval{}, err
+		}
+	}
+	if first == 0 || last < first {
+		return gtidInterval{}, fmt.Errorf("invalid interval %s", s)
+	}
+	return gtidInterval{first, last}, nil
+}
+
+func mergeIntervals(intervals []gtidInterval) []gtidInterval {
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].start < intervals[j].start })
+	merged := intervals[:1]
+	for _, in := range intervals[1:] {
+		last := &merged[len(merged)-1]
+		if in.start <= last.end+1 {
+			last.end = max(last.end, in.end)
+			continue
+		}
+		merged = append(merged, in)
+	}
+	return merged
+}
+
+// Contains reports whether every transaction of other is in the set
+func (s GTIDSet) Contains(other GTIDSet) bool {
+	for source, intervals := range other {
+		for _, in := range intervals {
+			if !s.containsInterval(source, in) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s GTIDSet) containsInterval(source string, in gtidInterval) bool {
+	for _, have := range s[source] {
+		if have.start <= in.start && in.end <= have.end {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns the number of transactions in the set
+func (s GTIDSet) Count() uint64 {
+	var n uint64
+	for _, intervals := range s {
+		for _, in := range intervals {
+			n += in.end - in.start + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,73 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package db
+
+import (
+	"testing"
+)
+
+const uuidA = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+const uuidB = "24da167b-71ca-11e1-9e33-c80aa9429562"
+
+// TestParseGTIDSet tests parsing and counting GTID sets
+func TestParseGTIDSet(t *testing.T) {
+	tests := []struct {
+		input   string
+		count   uint64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{uuidA + ":1-5", 5, false},
+		{uuidA + ":1-5:7,\n" + uuidB + ":1-3", 9, false},
+		{uuidA + ":1-5:3-8", 8, false},
+		{uuidA + ":1-2:backup:1-4", 6, false},
+		{uuidA, 0, true},
+		{uuidA + ":5-1", 0, true},
+		{uuidA + ":0", 0, true},
+		{uuidA + ":x-y", 0, true},
+		{uuidA + "::1", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			set, err := ParseGTIDSet(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseGTIDSet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && set.Count() != tt.count {
+				t.Errorf("Count() = %d, want %d", set.Count(), tt.count)
+			}
+		})
+	}
+}
+
+// TestGTIDSetContains tests comparing GTID sets
+func TestGTIDSetContains(t *testing.T) {
+	parse := func(s string) GTIDSet {
+		set, err := ParseGTIDSet(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return set
+	}
+	tests := []struct {
+		set, other string
+		want       bool
+	}{
+		{uuidA + ":1-10", uuidA + ":1-5:7", true},
+		{uuidA + ":1-5:7", uuidA + ":1-10", false},
+		{uuidA + ":1-10", "", true},
+		{"", uuidA + ":1", false},
+		{uuidA + ":1-4:5-9", uuidA + ":3-8", true},
+		{uuidA + ":1-10", uuidB + ":1", false},
+		{uuidA + ":1-10", uuidA + ":tag:1", false},
+	}
+
+	for _, tt := range tests {
+		if got := parse(tt.set).Contains(parse(tt.other)); got != tt.want {
+			t.Errorf("%q.Contains(%q) = %v, want %v", tt.set, tt.other, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+)
+
+// Member states of performance_schema.replication_group_members. A member the
+// monitor cannot connect to is UNREACHABLE.
+const (
+	MemberOnline      = "ONLINE"
+	MemberRecovering  = "RECOVERING"
+	MemberOffline     = "OFFLINE"
+	MemberError       = "ERROR"
+	MemberUnreachable = "UNREACHABLE"
+)
+
+// Member roles of performance_schema.replication_group_members
+const (
+	RolePrimary   = "PRIMARY"
+	RoleSecondary = "SECONDARY"
+)
+
+// Types of the events emitted by the group monitor
+const (
+	EventStateChanged      = "state_changed"
+	EventRecoveryStarted   = "recovery_started"
+	EventRecoveryFailed    = "recovery_failed"
+	EventRecoveryExhausted = "recovery_exhausted"
+	EventGroupOffline      = "group_offline"
+	EventGroupOnline       = "group_online"
+)
+
+const defaultMonitorInterval = 10 * time.Second
+const probeTimeout = 5 * time.Second
+const defaultRecoveryAttempts = 5
+const defaultRecoveryDelay = 5 * time.Second
+const defaultMaxRecoveryDelay = 5 * time.Minute
+
+// MemberStatus is the state of a group replication member as seen by itself
+type MemberStatus struct {
+	Shard   int    `json:"shard"`
+	Replica int    `json:"replica"`
+	Host    string `json:"host"`
+	State   string `json:"state"`
+	Role    string `json:"role,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// GroupEvent reports a state transition of a member or a group, or a recovery
+// attempt. Replica is -1 for events of a whole group.
+type GroupEvent struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Shard   int       `json:"shard"`
+	Replica int       `json:"replica"`
+	Host    string    `json:"host,omitempty"`
+	From    string    `json:"from,omitempty"`
+	To      string    `json:"to,omitempty"`
+	Attempt int       `json:"attempt,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+type memberKey struct {
+	shard, replica int
+}
+
+type memberTrack struct {
+	state    string
+	attempts int
+	next     time.Time
+}
+
+// GroupMonitor watches the group replication members of every shard and
+// restarts group replication on members in ERROR or OFFLINE state as long as
+// their group has an ONLINE member. Unreachable members are only reported,
+// their containers restart on their own and come back OFFLINE. A group
+// without ONLINE members must be rebooted.
+type GroupMonitor struct {
+	// Nodes holds the replicas of each shard
+	Nodes       [][]*SQLNode
+	Interval    time.Duration
+	AutoRecover bool
+	// Backoff spaces the recovery attempts of a member, a member that is
+	// not back ONLINE after MaxAttempts is left alone
+	Backoff utils.BackoffConfig
+	OnEvent func(GroupEvent)
+
+	probe   func(ctx context.Context, n *SQLNode) MemberStatus
+	recover func(ctx context.Context, n *SQLNode, state string) error
+	now     func() time.Time
+
+	members map[memberKey]*memberTrack
+	offline map[int]bool
+}
+
+// NewGroupMonitor creates a monitor that recovers members and reports events
+// to onEvent
+func NewGroupMonitor(nodes [][]*SQLNode, onEvent func(GroupEvent)) *GroupMonitor {
+	return &GroupMonitor{
+		Nodes:       nodes,
+		Interval:    defaultMonitorInterval,
+		AutoRecover: true,
+		Backoff: utils.BackoffConfig{
+			MaxAttempts:  defaultRecoveryAttempts,
+			InitialDelay: defaultRecoveryDelay,
+			MaxDelay:     defaultMaxRecoveryDelay,
+		},
+		OnEvent: onEvent,
+		probe:   ProbeMember,
+		recover: RecoverMember,
+		now:     time.Now,
+		members: map[memberKey]*memberTrack{},
+		offline: map[int]bool{},
+	}
+}
+
+// Run checks the groups every interval until the context is done
+func (m *GroupMonitor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		m.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check probes every member once, emits the state transitions and attempts
+// the recoveries that are due
+func (m *GroupMonitor) Check(ctx context.Context) []MemberStatus {
+	var all []MemberStatus
+	for shard, replicas := range m.Nodes {
+		statuses := make([]MemberStatus, len(replicas))
+		var wg sync.WaitGroup
+		for i, n := range replicas {
+			wg.Add(1)
+			go func(i int, n *SQLNode) {
+				defer wg.Done()
+				statuses[i] = m.probe(ctx, n)
+			}(i, n)
+		}
+		wg.Wait()
+
+		online := false
+		for _, st := range statuses {
+			m.observe(st)
+			online = online || st.State == MemberOnline
+		}
+		m.observeGroup(shard, online)
+		if m.AutoRecover && online {
+			for i, st := range statuses {
+				m.maybeRecover(ctx, replicas[i], st)
+			}
+		}
+		all = append(all, statuses...)
+	}
+	return all
+}
+
+func (m *GroupMonitor) emit(e GroupEvent) {
+	e.Time = m.now()
+	logger.Info("Group replication event", utils.M{"type": e.Type, "shard": e.Shard, "replica": e.Replica,
+		"from": e.From, "to": e.To, "attempt": e.Attempt, "message": e.Message})
+	if m.OnEvent != nil {
+		m.OnEvent(e)
+	}
+}
+
+func (m *GroupMonitor) observe(st MemberStatus) {
+	key := memberKey{st.Shard, st.Replica}
+	track, ok := m.members[key]
+	if !ok {
+		track = &memberTrack{}
+		m.members[key] = track
+	}
+	if track.state == st.State {
+		return
+	}
+	m.emit(GroupEvent{Type: EventStateChanged, Shard: st.Shard, Replica: st.Replica, Host: st.Host,
+		From: track.state, To: st.State, Message: st.Error})
+	track.state = st.State
+	// a member that flaps between RECOVERING and ERROR keeps backing off,
+	// only reaching ONLINE starts a fresh series of attempts
+	if st.State == MemberOnline {
+		track.attempts = 0
+		track.next = time.Time{}
+	}
+}
+
+func (m *GroupMonitor) observeGroup(shard int, online bool) {
+	wasOffline, known := m.offline[shard]
+	m.offline[shard] = !online
+	switch {
+	case !online && (!known || !wasOffline):
+		m.emit(GroupEvent{Type: EventGroupOffline, Shard: shard, Replica: -1,
+			Message: "no ONLINE member, reboot the group with zygote sql reboot-cluster"})
+	case online && known && wasOffline:
+		m.emit(GroupEvent{Type: EventGroupOnline, Shard: shard, Replica: -1})
+	}
+}
+
+func (m *GroupMonitor) maybeRecover(ctx context.Context, n *SQLNode, st MemberStatus) {
+	if st.State != MemberError && st.State != MemberOffline {
+		return
+	}
+	track := m.members[memberKey{st.Shard, st.Replica}]
+	if track.attempts >= m.Backoff.MaxAttempts || m.now().Before(track.next) {
+		return
+	}
+	track.attempts++
+	track.next = m.now().Add(m.recoveryDelay(track.attempts))
+	e := GroupEvent{Shard: st.Shard, Replica: st.Replica, Host: st.Host, From: st.State, Attempt: track.attempts}
+	err := m.recover(ctx, n, st.State)
+	if err == nil {
+		e.Type = EventRecoveryStarted
+		m.emit(e)
+		return
+	}
+	e.Type = EventRecoveryFailed
+	e.Message = err.Error()
+	m.emit(e)
+	if track.attempts == m.Backoff.MaxAttempts {
+		e.Type = EventRecoveryExhausted
+		e.Message = fmt.Sprintf("giving up after %d attempts, recover the member manually", track.attempts)
+		m.emit(e)
+	}
+}
+
+// recoveryDelay doubles the delay after every attempt up to the maximum
+func (m *GroupMonitor) recoveryDelay(attempts int) time.Duration {
+	delay := m.Backoff.InitialDelay
+	for i := 1; i < attempts && delay < m.Backoff.MaxDelay; i++ {
+		delay *= 2
+	}
+	if m.Backoff.MaxDelay > 0 && delay > m.Backoff.MaxDelay {
+		delay = m.Backoff.MaxDelay
+	}
+	return delay
+}
+
+// ProbeMember reads the state of a node from its own view of the group
+func ProbeMember(ctx context.Context, n *SQLNode) MemberStatus {
+	st := MemberStatus{Shard: n.ShardIndex, Replica: n.RepIndex, Host: n.ContainerName(dbShortName)}
+	db, err := n.GetAdminDB()
+	if err != nil {
+		st.State, st.Error = MemberUnreachable, err.Error()
+		return st
+	}
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	var state, role sql.NullString
+	err = db.QueryRowContext(ctx, `SELECT MEMBER_STATE, MEMBER_ROLE FROM performance_schema.replication_group_members
+		WHERE MEMBER_ID = @@server_uuid`).Scan(&state, &role)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// group replication was never started on the node
+		st.State = MemberOffline
+	case err != nil:
+		st.State, st.Error = MemberUnreachable, err.Error()
+	default:
+		st.State, st.Role = state.String, role.String
+	}
+	return st
+}
+
+// RecoverMember restarts group replication on a node, a member in ERROR state
+// leaves the group first
+func RecoverMember(ctx context.Context, n *SQLNode, state string) error {
+	db, err := n.GetAdminDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if state == MemberError {
+		if _, err := db.ExecContext(ctx, "STOP GROUP_REPLICATION"); err != nil {
+			return fmt.Errorf("failed to stop group replication on %s: %w", n.ContainerName(dbShortName), err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, "START GROUP_REPLICATION"); err != nil {
+		return fmt.Errorf("failed to start group replication on %s: %w", n.ContainerName(dbShortName), err)
+	}
+	return nil
+}
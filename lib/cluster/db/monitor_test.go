@@ -0,0 +1,106 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeGroup drives a monitor with scripted member states
+type fakeGroup struct {
+	states     map[memberKey]string
+	recoverErr error
+	recovered  []string
+	events     []string
+	now        time.Time
+}
+
+func newFakeMonitor(g *fakeGroup, replicas int) *GroupMonitor {
+	var nodes []*SQLNode
+	for i := 0; i < replicas; i++ {
+		nodes = append(nodes, &SQLNode{Tenant: "zygote", RepIndex: i})
+	}
+	m := NewGroupMonitor([][]*SQLNode{nodes}, func(e GroupEvent) {
+		g.events = append(g.events, fmt.Sprintf("%s %d/%d %s>%s #%d", e.Type, e.Shard, e.Replica, e.From, e.To, e.Attempt))
+	})
+	m.Backoff = utils.BackoffConfig{MaxAttempts: 2, InitialDelay: time.Minute, MaxDelay: time.Hour}
+	m.probe = func(_ context.Context, n *SQLNode) MemberStatus {
+		return MemberStatus{Shard: n.ShardIndex, Replica: n.RepIndex, State: g.states[memberKey{n.ShardIndex, n.RepIndex}]}
+	}
+	m.recover = func(_ context.Context, n *SQLNode, state string) error {
+		g.recovered = append(g.recovered, fmt.Sprintf("%d %s", n.RepIndex, state))
+		return g.recoverErr
+	}
+	m.now = func() time.Time { return g.now }
+	return m
+}
+
+// TestGroupMonitor tests state transition events and recovery backoff
+func TestGroupMonitor(t *testing.T) {
+	g := &fakeGroup{
+		states: map[memberKey]string{{0, 0}: MemberOnline, {0, 1}: MemberError},
+		now:    time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	m := newFakeMonitor(g, 2)
+	ctx := context.Background()
+
+	g.recoverErr = fmt.Errorf("refused")
+	m.Check(ctx)
+	// not due yet
+	g.now = g.now.Add(30 * time.Second)
+	m.Check(ctx)
+	g.now = g.now.Add(time.Minute)
+	m.Check(ctx)
+	// exhausted
+	g.now = g.now.Add(time.Hour)
+	m.Check(ctx)
+
+	wantEvents := []string{
+		"state_changed 0/0 >ONLINE #0",
+		"state_changed 0/1 >ERROR #0",
+		"recovery_failed 0/1 ERROR> #1",
+		"recovery_failed 0/1 ERROR> #2",
+		"recovery_exhausted 0/1 ERROR> #2",
+	}
+	if diff := cmp.Diff(wantEvents, g.events); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"1 ERROR", "1 ERROR"}, g.recovered); diff != "" {
+		t.Errorf("recoveries mismatch (-want +got):\n%s", diff)
+	}
+
+	// back online resets the attempts, the group going offline is reported
+	// and not recovered member by member
+	g.events, g.recovered, g.recoverErr = nil, nil, nil
+	g.states[memberKey{0, 1}] = MemberOnline
+	m.Check(ctx)
+	g.states[memberKey{0, 0}] = MemberOffline
+	g.states[memberKey{0, 1}] = MemberUnreachable
+	m.Check(ctx)
+	g.states[memberKey{0, 1}] = MemberOnline
+	m.Check(ctx)
+
+	wantEvents = []string{
+		"state_changed 0/1 ERROR>ONLINE #0",
+		"state_changed 0/0 ONLINE>OFFLINE #0",
+		"state_changed 0/1 ONLINE>UNREACHABLE #0",
+		"group_offline 0/-1 > #0",
+		"state_changed 0/1 UNREACHABLE>ONLINE #0",
+		"group_online 0/-1 > #0",
+		"recovery_started 0/0 OFFLINE> #1",
+	}
+	if diff := cmp.Diff(wantEvents, g.events); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"0 OFFLINE"}, g.recovered); diff != "" {
+		t.Errorf("recoveries mismatch (-want +got):\n%s", diff)
+	}
+}
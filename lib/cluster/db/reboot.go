@@ -0,0 +1,206 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+)
+
+// RebootMember is a member of a group being rebooted with the transactions it
+// has executed
+type RebootMember struct {
+	MemberStatus
+	GTIDSet string `json:"gtid_set"`
+	// Transactions is the number of transactions in the GTID set
+	Transactions uint64 `json:"transactions"`
+
+	gtids GTIDSet
+}
+
+func (m *RebootMember) reachable() bool {
+	return m.gtids != nil
+}
+
+// RebootOptions configures a group reboot
+type RebootOptions struct {
+	// From is the replica to bootstrap from, -1 picks the most advanced one
+	From int
+	// Force reboots even if members are ONLINE or unreachable, or if the
+	// bootstrap member lacks transactions of another member. Transactions
+	// missing from the bootstrap member are lost.
+	Force bool
+}
+
+// RebootPlan describes how the group of a shard is rebooted after a complete
+// outage
+type RebootPlan struct {
+	Shard   int            `json:"shard"`
+	Members []RebootMember `json:"members"`
+	// Bootstrap is the replica the group is bootstrapped from
+	Bootstrap int `json:"bootstrap"`
+
+	nodes []*SQLNode
+}
+
+// PlanReboot reads the executed GTID set of every replica of a shard and
+// picks the member that has executed all transactions of the others
+func PlanReboot(ctx context.Context, nodes []*SQLNode, opts RebootOptions) (*RebootPlan, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no nodes to reboot")
+	}
+	p := &RebootPlan{Shard: nodes[0].ShardIndex, Members: make([]RebootMember, len(nodes)), nodes: nodes}
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n *SQLNode) {
+			defer wg.Done()
+			p.Members[i] = inspectMember(ctx, n)
+		}(i, n)
+	}
+	wg.Wait()
+	bootstrap, err := chooseBootstrap(p.Members, opts)
+	if err != nil {
+		return p, fmt.Errorf("shard %d: %w", p.Shard, err)
+	}
+	p.Bootstrap = bootstrap
+	return p, nil
+}
+
+// inspectMember reads the state and the executed transactions of a node
+func inspectMember(ctx context.Context, n *SQLNode) RebootMember {
+	m := RebootMember{MemberStatus: ProbeMember(ctx, n)}
+	if m.State == MemberUnreachable {
+		return m
+	}
+	db, err := n.GetAdminDB()
+	if err != nil {
+		m.State, m.Error = MemberUnreachable, err.Error()
+		return m
+	}
+	defer db.Close()
+	err = db.QueryRowContext(ctx, "SELECT @@GLOBAL.gtid_executed").Scan(&m.GTIDSet)
+	if err == nil {
+		m.gtids, err = ParseGTIDSet(m.GTIDSet)
+	}
+	if err != nil {
+		m.State, m.Error = MemberUnreachable, err.Error()
+		return m
+	}
+	m.Transactions = m.gtids.Count()
+	return m
+}
+
+// chooseBootstrap returns the index of the member to bootstrap the group from
+func chooseBootstrap(members []RebootMember, opts RebootOptions) (int, error) {
+	var unreachable []string
+	for _, m := range members {
+		if m.State == MemberOnline && !opts.Force {
+			return 0, fmt.Errorf("member %s is ONLINE, the group is running", m.Host)
+		}
+		if !m.reachable() {
+			unreachable = append(unreachable, m.Host)
+		}
+	}
+	if len(unreachable) == len(members) {
+		return 0, fmt.Errorf("no member is reachable")
+	}
+	if len(unreachable) > 0 && !opts.Force {
+		return 0, fmt.Errorf("members %s are unreachable and may have executed more transactions, "+
+			"start them or force the reboot", strings.Join(unreachable, ", "))
+	}
+
+	if opts.From >= 0 {
+		for i, m := range members {
+			if m.Replica != opts.From {
+				continue
+			}
+			if !m.reachable() {
+				return 0, fmt.Errorf("member %s is unreachable", m.Host)
+			}
+			for _, other := range members {
+				if other.reachable() && !m.gtids.Contains(other.gtids) && !opts.Force {
+					return 0, fmt.Errorf("member %s lacks transactions of %s, force the reboot to lose them", m.Host, other.Host)
+				}
+			}
+			return i, nil
+		}
+		return 0, fmt.Errorf("replica %d not found", opts.From)
+	}
+
+	for i, m := range members {
+		if !m.reachable() {
+			continue
+		}
+		complete := true
+		for _, other := range members {
+			if other.reachable() && !m.gtids.Contains(other.gtids) {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("the GTID sets of the members diverged, choose the member to bootstrap from")
+}
+
+// Execute stops group replication on every reachable member, bootstraps the
+// group on the chosen member and starts group replication on the others
+func (p *RebootPlan) Execute(ctx context.Context) error {
+	var errs []error
+	for i, m := range p.Members {
+		if !m.reachable() || m.State == MemberOffline {
+			continue
+		}
+		if err := execOnNode(ctx, p.nodes[i], "STOP GROUP_REPLICATION"); err != nil {
+			return err
+		}
+	}
+
+	n := p.nodes[p.Bootstrap]
+	logger.Info("Bootstrapping group", utils.M{"shard": p.Shard, "host": p.Members[p.Bootstrap].Host})
+	err := execOnNode(ctx, n,
+		"SET GLOBAL group_replication_bootstrap_group = ON",
+		"START GROUP_REPLICATION")
+	// the flag must not stay on, a restarted member would start a new group
+	if offErr := execOnNode(ctx, n, "SET GLOBAL group_replication_bootstrap_group = OFF"); offErr != nil {
+		errs = append(errs, offErr)
+	}
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	for i, m := range p.Members {
+		if i == p.Bootstrap || !m.reachable() {
+			continue
+		}
+		logger.Info("Rejoining group", utils.M{"shard": p.Shard, "host": m.Host})
+		if err := execOnNode(ctx, p.nodes[i], "START GROUP_REPLICATION"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func execOnNode(ctx context.Context, n *SQLNode, queries ...string) error {
+	db, err := n.GetAdminDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("%s on %s: %w", query, n.ContainerName(dbShortName), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package db
+
+import (
+	"fmt"
+	"testing"
+)
+
+func rebootMember(t *testing.T, replica int, state, gtidSet string) RebootMember {
+	m := RebootMember{MemberStatus: MemberStatus{Replica: replica, Host: fmt.Sprintf("sql-%d", replica), State: state}}
+	if state == MemberUnreachable {
+		return m
+	}
+	var err error
+	if m.gtids, err = ParseGTIDSet(gtidSet); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// TestChooseBootstrap tests picking the member to bootstrap a group from
+func TestChooseBootstrap(t *testing.T) {
+	behind := rebootMember(t, 0, MemberOffline, uuidA+":1-5")
+	ahead := rebootMember(t, 1, MemberOffline, uuidA+":1-9")
+	equal := rebootMember(t, 2, MemberError, uuidA+":1-9")
+	diverged := rebootMember(t, 2, MemberOffline, uuidA+":1-5,"+uuidB+":1")
+	down := rebootMember(t, 2, MemberUnreachable, "")
+	online := rebootMember(t, 2, MemberOnline, uuidA+":1-9")
+
+	tests := []struct {
+		name    string
+		members []RebootMember
+		opts    RebootOptions
+		want    int
+		wantErr bool
+	}{
+		{name: "most advanced", members: []RebootMember{behind, ahead, equal}, opts: RebootOptions{From: -1}, want: 1},
+		{name: "diverged", members: []RebootMember{behind, ahead, diverged}, opts: RebootOptions{From: -1}, wantErr: true},
+		{name: "unreachable", members: []RebootMember{behind, ahead, down}, opts: RebootOptions{From: -1}, wantErr: true},
+		{name: "forced unreachable", members: []RebootMember{behind, ahead, down}, opts: RebootOptions{From: -1, Force: true}, want: 1},
+		{name: "running", members: []RebootMember{behind, ahead, online}, opts: RebootOptions{From: -1}, wantErr: true},
+		{name: "from", members: []RebootMember{behind, ahead, equal}, opts: RebootOptions{From: 2}, want: 2},
+		{name: "from behind", members: []RebootMember{behind, ahead, equal}, opts: RebootOptions{From: 0}, wantErr: true},
+		{name: "forced from behind", members: []RebootMember{behind, ahead, equal}, opts: RebootOptions{From: 0, Force: true}, want: 0},
+		{name: "from unknown", members: []RebootMember{behind, ahead, equal}, opts: RebootOptions{From: 5}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := chooseBootstrap(tt.members, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("chooseBootstrap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("chooseBootstrap() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/evgnomon/zygote/lib/cluster/secrets"
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+)
+
+// RotateCredentials changes the passwords of the SQL users of every shard
+// from one set of credentials to another. The users are altered on the
+// primary and replicated to the group, the recovery channel is updated on
+// every member. The old passwords are retained, so clients keep connecting
+// with them until DiscardOldPasswords. It can be run again after a partial
+// failure.
+func RotateCredentials(ctx context.Context, shards [][]*SQLNode, from, to *secrets.Credentials) error {
+	for _, nodes := range shards {
+		if err := rotateShard(ctx, nodes, from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rotateShard(ctx context.Context, nodes []*SQLNode, from, to *secrets.Credentials) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	var primary *SQLNode
+	rotated := false
+	for _, n := range nodes {
+		// a previous attempt may have rotated the shard already, the root
+		// password is changed last so it accepting the new one means every
+		// user does
+		useCredentials(n, to)
+		st := ProbeMember(ctx, n)
+		usesNew := st.State != MemberUnreachable
+		if !usesNew {
+			useCredentials(n, from)
+			st = ProbeMember(ctx, n)
+		}
+		if st.State == MemberOnline && st.Role == RolePrimary {
+			primary, rotated = n, usesNew
+			break
+		}
+	}
+	if primary == nil {
+		return fmt.Errorf("shard %d has no reachable primary member", nodes[0].ShardIndex)
+	}
+	if !rotated {
+		// retaining the current password again would drop the old one
+		logger.Info("Rotating SQL credentials", utils.M{"shard": primary.ShardIndex, "primary": primary.ContainerName(dbShortName)})
+		if err := execOnNode(ctx, primary, rotationQueries(primary.User, to)...); err != nil {
+			return err
+		}
+	}
+
+	var errs []error
+	for _, n := range nodes {
+		useCredentials(n, to)
+		if err := execOnNode(ctx, n, n.RecoveryChannelQuery()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DiscardOldPasswords removes the passwords retained by RotateCredentials
+// on every shard. Clients still using them can no longer connect.
+func DiscardOldPasswords(ctx context.Context, shards [][]*SQLNode, c *secrets.Credentials) error {
+	for _, nodes := range shards {
+		if len(nodes) == 0 {
+			continue
+		}
+		var primary *SQLNode
+		for _, n := range nodes {
+			useCredentials(n, c)
+			st := ProbeMember(ctx, n)
+			if st.State == MemberOnline && st.Role == RolePrimary {
+				primary = n
+				break
+			}
+		}
+		if primary == nil {
+			return fmt.Errorf("shard %d has no reachable primary member", nodes[0].ShardIndex)
+		}
+		logger.Info("Discarding old SQL passwords", utils.M{"shard": primary.ShardIndex, "primary": primary.ContainerName(dbShortName)})
+		var queries []string
+		for _, user := range rotatedUsers(primary.User) {
+			queries = append(queries, fmt.Sprintf("ALTER USER IF EXISTS %s DISCARD OLD PASSWORD", user.account))
+		}
+		if err := execOnNode(ctx, primary, queries...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotatedUser is an account whose password is rotated
+type rotatedUser struct {
+	account  string
+	password func(*secrets.Credentials) string
+}
+
+// rotatedUsers returns the accounts whose passwords are rotated, root last
+func rotatedUsers(appUser string) []rotatedUser {
+	app := func(c *secrets.Credentials) string { return c.App }
+	root := func(c *secrets.Credentials) string { return c.Root }
+	return []rotatedUser{
+		{fmt.Sprintf("'%s'@'%%'", appUser), app},
+		{fmt.Sprintf("'%s'@'localhost'", appUser), app},
+		{"'repl'@'%'", func(c *secrets.Credentials) string { return c.Replication }},
+		{"'root'@'localhost'", root},
+		{"'root'@'%'", root},
+	}
+}
+
+// rotationQueries returns the statements that set the new passwords and
+// retain the current ones. The users keep the plugin they were created with.
+// The passwords are alphanumeric so they are safe to inline.
+func rotationQueries(appUser string, c *secrets.Credentials) []string {
+	users := rotatedUsers(appUser)
+	queries := make([]string, len(users))
+	for i, user := range users {
+		queries[i] = fmt.Sprintf("ALTER USER IF EXISTS %s IDENTIFIED BY '%s' RETAIN CURRENT PASSWORD", user.account, user.password(c))
+	}
+	return queries
+}
+
+func useCredentials(n *SQLNode, c *secrets.Credentials) {
+	n.RootPassword = c.Root
+	n.Password = c.App
+	n.ReplicationPassword = c.Replication
+}
@@ -0,0 +1,28 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package db
+
+import (
+	"testing"
+
+	"github.com/evgnomon/zygote/lib/cluster/secrets"
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestRotationQueries tests that the current passwords are retained and root
+// is altered last
+func TestRotationQueries(t *testing.T) {
+	c := &secrets.Credentials{Root: "r", App: "a", Replication: "p"}
+	want := []string{
+		"ALTER USER IF EXISTS 'app'@'%' IDENTIFIED BY 'a' RETAIN CURRENT PASSWORD",
+		"ALTER USER IF EXISTS 'app'@'localhost' IDENTIFIED BY 'a' RETAIN CURRENT PASSWORD",
+		"ALTER USER IF EXISTS 'repl'@'%' IDENTIFIED BY 'p' RETAIN CURRENT PASSWORD",
+		"ALTER USER IF EXISTS 'root'@'localhost' IDENTIFIED BY 'r' RETAIN CURRENT PASSWORD",
+		"ALTER USER IF EXISTS 'root'@'%' IDENTIFIED BY 'r' RETAIN CURRENT PASSWORD",
+	}
+	if diff := cmp.Diff(want, rotationQueries("app", c)); diff != "" {
+		t.Errorf("rotationQueries() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1 @@
+ALTER TABLE `{{ .DatabaseName }}`.`{{ .TableName }}` MODIFY COLUMN `{{ .Name }}` {{ .Previous }};
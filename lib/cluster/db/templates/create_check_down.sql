@@ -0,0 +1 @@
+ALTER TABLE `{{ .DatabaseName }}`.`{{ .TableName }}` DROP CHECK `chk_{{ .Name }}`;
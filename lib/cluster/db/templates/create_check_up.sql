@@ -0,0 +1 @@
+ALTER TABLE `{{ .DatabaseName }}`.`{{ .TableName }}` ADD CONSTRAINT `chk_{{ .Name }}` CHECK ({{ .Expression }});
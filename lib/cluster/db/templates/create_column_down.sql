@@ -1 +1 @@
-ALTER TABLE `{{ .DatabaseName }}`.`{{ .TableName }}` DROP COLUMN `{{ .ColumnName }}`;
+ALTER TABLE `{{ .DatabaseName }}`.`{{ .TableName }}` DROP COLUMN `{{ .Name }}`;
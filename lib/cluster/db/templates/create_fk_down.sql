@@ -0,0 +1,3 @@
+ALTER TABLE `{{ .DatabaseName }}`.`{{ .TableName }}`
+DROP FOREIGN KEY `fk_{{ .Name }}`,
+DROP INDEX `idx_fk_{{ .Name }}`;
@@ -0,0 +1,6 @@
+-- Foreign keys are enforced within a shard only
+ALTER TABLE `{{ .DatabaseName }}`.`{{ .TableName }}`
+ADD INDEX `idx_fk_{{ .Name }}` ({{ range $i, $col := .Columns }}{{ if $i }}, {{ end }}`{{ $col }}`{{ end }}),
+ADD CONSTRAINT `fk_{{ .Name }}` FOREIGN KEY ({{ range $i, $col := .Columns }}{{ if $i }}, {{ end }}`{{ $col }}`{{ end }})
+  REFERENCES `{{ .RefDatabaseName }}`.`{{ .RefTableName }}` ({{ range $i, $col := .RefColumns }}{{ if $i }}, {{ end }}`{{ $col }}`{{ end }})
+  ON DELETE {{ .OnDelete }} ON UPDATE {{ .OnUpdate }};
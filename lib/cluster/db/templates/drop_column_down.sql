@@ -0,0 +1,2 @@
+-- Restores the column definition, the dropped values are not restored
+ALTER TABLE `{{ .DatabaseName }}`.`{{ .TableName }}` ADD COLUMN `{{ .Name }}` {{ .Definition }};
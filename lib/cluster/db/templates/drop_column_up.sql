@@ -0,0 +1 @@
+ALTER TABLE `{{ .DatabaseName }}`.`{{ .TableName }}` DROP COLUMN `{{ .Name }}`;
@@ -0,0 +1 @@
+ALTER TABLE `{{ .DatabaseName }}`.`{{ .TableName }}` RENAME COLUMN `{{ .NewName }}` TO `{{ .Name }}`;
@@ -0,0 +1 @@
+RENAME TABLE `{{ .DatabaseName }}`.`{{ .NewName }}` TO `{{ .DatabaseName }}`.`{{ .TableName }}`;
@@ -0,0 +1 @@
+RENAME TABLE `{{ .DatabaseName }}`.`{{ .TableName }}` TO `{{ .DatabaseName }}`.`{{ .NewName }}`;
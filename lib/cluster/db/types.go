@@ -0,0 +1,282 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const maxDecimalPrecision = 65
+const maxDecimalScale = 30
+const maxTimePrecision = 6
+const maxVarLength = 65535
+
+// typeAliases maps the short type names of the generators to SQL types
+var typeAliases = map[string]string{
+	"string":  varChar255,
+	"integer": "INT",
+	"double":  "DOUBLE",
+	"bool":    "BOOLEAN",
+	"binary":  "MEDIUMBLOB",
+	"json":    "JSON",
+	"uuid":    "CHAR(36)",
+	"text":    "MEDIUMTEXT",
+}
+
+const varChar255 = "VARCHAR(255)"
+
+var columnTypeRe = regexp.MustCompile(`^([a-z]+)\s*(?:\((.*)\))?\s*(unsigned)?$`)
+
+// ColumnType is a parsed SQL column type
+type ColumnType struct {
+	// Name is the upper case base type, e.g. DECIMAL
+	Name      string
+	Length    int
+	Precision int
+	Scale     int
+	Unsigned  bool
+	Values    []string
+}
+
+// ParseColumnType parses a generator type alias such as string or integer, or
+// one of the supported SQL types: INT, BIGINT [UNSIGNED], DOUBLE, FLOAT,
+// BOOLEAN, DECIMAL(p,s), DATETIME(p), TIMESTAMP(p), DATE, VARCHAR(n), CHAR(n),
+// VARBINARY(n), MEDIUMBLOB, MEDIUMTEXT, JSON and ENUM('a','b')
+func ParseColumnType(s string) (*ColumnType, error) {
+	raw := strings.TrimSpace(s)
+	if alias, ok := typeAliases[strings.ToLower(raw)]; ok {
+		raw = alias
+	}
+	// INFORMATION_SCHEMA reports BOOLEAN as tinyint(1)
+	if strings.EqualFold(raw, "tinyint(1)") {
+		raw = "BOOLEAN"
+	}
+	m := columnTypeRe.FindStringSubmatch(strings.ToLower(raw))
+	if m == nil {
+		return nil, fmt.Errorf("invalid column type %q", s)
+	}
+	t := &ColumnType{Name: strings.ToUpper(m[1]), Unsigned: m[3] != ""}
+	// keep the case of enum values
+	args := ""
+	if open := strings.Index(raw, "("); open >= 0 {
+		args = raw[open+1 : strings.LastIndex(raw, ")")]
+	}
+	hasArgs := m[2] != "" || strings.Contains(raw, "(")
+	switch t.Name {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "INTEGER", "BIGINT":
+		if t.Name == "INTEGER" {
+			t.Name = "INT"
+		}
+		if hasArgs {
+			return nil, fmt.Errorf("integer display width is deprecated in %q", s)
+		}
+	case "DOUBLE", "FLOAT", "BOOLEAN", "BOOL", "DATE", "JSON",
+		"MEDIUMBLOB", "BLOB", "LONGBLOB", "MEDIUMTEXT", "TEXT", "LONGTEXT":
+		if t.Name == "BOOL" {
+			t.Name = "BOOLEAN"
+		}
+		if hasArgs {
+			return nil, fmt.Errorf("invalid column type %q", s)
+		}
+	case "DECIMAL", "NUMERIC":
+		t.Name = "DECIMAL"
+		t.Precision, t.Scale = 10, 0
+		if hasArgs {
+			parts := strings.Split(args, ",")
+			if len(parts) > 2 {
+				return nil, fmt.Errorf("invalid decimal type %q", s)
+			}
+			var err error
+			if t.Precision, err = typeArg(parts[0], 1, maxDecimalPrecision); err != nil {
+				return nil, fmt.Errorf("decimal precision of %q: %w", s, err)
+			}
+			if len(parts) == 2 {
+				if t.Scale, err = typeArg(parts[1], 0, maxDecimalScale); err != nil {
+					return nil, fmt.Errorf("decimal scale of %q: %w", s, err)
+				}
+			}
+			if t.Scale > t.Precision {
+				return nil, fmt.Errorf("decimal scale is larger than precision in %q", s)
+			}
+		}
+	case "DATETIME", "TIMESTAMP", "TIME":
+		if hasArgs {
+			var err error
+			if t.Precision, err = typeArg(args, 0, maxTimePrecision); err != nil {
+				return nil, fmt.Errorf("fractional seconds of %q: %w", s, err)
+			}
+		}
+	case "VARCHAR", "CHAR", "VARBINARY", "BINARY":
+		if !hasArgs {
+			if strings.HasPrefix(t.Name, "VAR") {
+				return nil, fmt.Errorf("%s requires a length", t.Name)
+			}
+			t.Length = 1
+			break
+		}
+		var err error
+		if t.Length, err = typeArg(args, 1, maxVarLength); err != nil {
+			return nil, fmt.Errorf("length of %q: %w", s, err)
+		}
+	case "ENUM":
+		values, err := enumValues(args)
+		if err != nil {
+			return nil, fmt.Errorf("values of %q: %w", s, err)
+		}
+		t.Values = values
+	default:
+		return nil, fmt.Errorf("unsupported column type %q", s)
+	}
+	if t.Unsigned && !t.numeric() {
+		return nil, fmt.Errorf("%s cannot be unsigned", t.Name)
+	}
+	return t, nil
+}
+
+func typeArg(s string, minValue, maxValue int) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	if n < minValue || n > maxValue {
+		return 0, fmt.Errorf("%d is out of range %d..%d", n, minValue, maxValue)
+	}
+	return n, nil
+}
+
+// enumValues splits a comma separated list of optionally quoted values
+func enumValues(s string) ([]string, error) {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if len(v) >= 2 && (v[0] == '\'' && v[len(v)-1] == '\'' || v[0] == '"' && v[len(v)-1] == '"') {
+			v = v[1 : len(v)-1]
+		}
+		if v == "" {
+			return nil, fmt.Errorf("empty enum value")
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (t *ColumnType) numeric() bool {
+	switch t.Name {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "DOUBLE", "FLOAT", "DECIMAL":
+		return true
+	}
+	return false
+}
+
+func (t *ColumnType) temporal() bool {
+	return t.Name == "DATETIME" || t.Name == "TIMESTAMP"
+}
+
+// SQL returns the type as written in a column definition
+func (t *ColumnType) SQL() string {
+	s := t.Name
+	switch t.Name {
+	case "DECIMAL":
+		s = fmt.Sprintf("DECIMAL(%d,%d)", t.Precision, t.Scale)
+	case "DATETIME", "TIMESTAMP", "TIME":
+		if t.Precision > 0 {
+			s = fmt.Sprintf("%s(%d)", t.Name, t.Precision)
+		}
+	case "VARCHAR", "CHAR", "VARBINARY", "BINARY":
+		s = fmt.Sprintf("%s(%d)", t.Name, t.Length)
+	case "ENUM":
+		quoted := make([]string, len(t.Values))
+		for i, v := range t.Values {
+			quoted[i] = quoteString(v)
+		}
+		s = fmt.Sprintf("ENUM(%s)", strings.Join(quoted, ","))
+	}
+	if t.Unsigned {
+		s += " UNSIGNED"
+	}
+	return s
+}
+
+// HasDefault reports whether the type takes a literal default, BLOB, TEXT and
+// JSON columns only take expression defaults
+func (t *ColumnType) HasDefault() bool {
+	switch t.Name {
+	case "MEDIUMBLOB", "BLOB", "LONGBLOB", "MEDIUMTEXT", "TEXT", "LONGTEXT", "JSON":
+		return false
+	}
+	return true
+}
+
+// ZeroValue returns the default filled into existing rows when a NOT NULL
+// column is added without a default
+func (t *ColumnType) ZeroValue() string {
+	switch {
+	case !t.HasDefault():
+		return ""
+	case t.Name == "BOOLEAN":
+		return "false"
+	case t.Name == "DECIMAL" || t.Name == "DOUBLE" || t.Name == "FLOAT":
+		return "0.0"
+	case t.numeric():
+		return "0"
+	case t.temporal():
+		return t.currentTimestamp()
+	case t.Name == "DATE":
+		return "(CURRENT_DATE)"
+	case t.Name == "TIME":
+		return "'00:00:00'"
+	case t.Name == "ENUM":
+		return quoteString(t.Values[0])
+	}
+	return "''"
+}
+
+func (t *ColumnType) currentTimestamp() string {
+	if t.Precision > 0 {
+		return fmt.Sprintf("CURRENT_TIMESTAMP(%d)", t.Precision)
+	}
+	return "CURRENT_TIMESTAMP"
+}
+
+// Literal renders a default value for the type. Numbers, booleans, NULL,
+// CURRENT_TIMESTAMP and parenthesized expressions are kept as they are, other
+// values are quoted as strings.
+func (t *ColumnType) Literal(v string) (string, error) {
+	upper := strings.ToUpper(strings.TrimSpace(v))
+	switch {
+	case upper == "NULL":
+		return "NULL", nil
+	case strings.HasPrefix(v, "(") && strings.HasSuffix(v, ")"):
+		return v, nil
+	case t.temporal() && strings.HasPrefix(upper, "CURRENT_TIMESTAMP"):
+		return t.currentTimestamp(), nil
+	case t.Name == "BOOLEAN" && (upper == "TRUE" || upper == "FALSE"):
+		return strings.ToLower(upper), nil
+	case t.numeric() || t.Name == "BOOLEAN":
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return "", fmt.Errorf("default %q is not a number", v)
+		}
+		return v, nil
+	case !t.HasDefault():
+		return "", fmt.Errorf("%s columns take only expression defaults, e.g. (%s)", t.Name, quoteString(v))
+	case t.Name == "ENUM":
+		for _, value := range t.Values {
+			if value == v {
+				return quoteString(v), nil
+			}
+		}
+		return "", fmt.Errorf("default %q is not one of the enum values %v", v, t.Values)
+	}
+	return quoteString(v), nil
+}
+
+// quoteString quotes a string literal
+func quoteString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
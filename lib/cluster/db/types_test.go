@@ -0,0 +1,122 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package db
+
+import (
+	"testing"
+)
+
+// TestParseColumnType tests parsing aliases and SQL column types
+func TestParseColumnType(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"string", "VARCHAR(255)", false},
+		{"uuid", "CHAR(36)", false},
+		{"integer", "INT", false},
+		{"bigint unsigned", "BIGINT UNSIGNED", false},
+		{"DECIMAL(12, 2)", "DECIMAL(12,2)", false},
+		{"decimal", "DECIMAL(10,0)", false},
+		{"numeric(8)", "DECIMAL(8,0)", false},
+		{"datetime(3)", "DATETIME(3)", false},
+		{"TIMESTAMP", "TIMESTAMP", false},
+		{"varbinary(16)", "VARBINARY(16)", false},
+		{"varchar(64)", "VARCHAR(64)", false},
+		{"ENUM('Small', \"large\", x)", "ENUM('Small','large','x')", false},
+		{"varchar", "", true},
+		{"decimal(4,6)", "", true},
+		{"datetime(7)", "", true},
+		{"int(11)", "", true},
+		{"varchar(10) unsigned", "", true},
+		{"enum('a',)", "", true},
+		{"geometry", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseColumnType(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseColumnType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.SQL() != tt.want {
+				t.Errorf("ParseColumnType().SQL() = %q, want %q", got.SQL(), tt.want)
+			}
+		})
+	}
+}
+
+// TestCreateColumnSQL tests rendering column options through GenCreateSQL
+func TestCreateColumnSQL(t *testing.T) {
+	value := func(s string) *string { return &s }
+	tests := []struct {
+		name    string
+		colType string
+		opts    ColumnOptions
+		wantUp  string
+		wantErr bool
+	}{
+		{
+			name:    "nullable",
+			colType: "decimal(10,2)",
+			wantUp:  "ALTER TABLE `shop`.`orders` ADD COLUMN `c` DECIMAL(10,2);",
+		},
+		{
+			name:    "not null zero value",
+			colType: "datetime(3)",
+			opts:    ColumnOptions{NotNull: true},
+			wantUp:  "ALTER TABLE `shop`.`orders` ADD COLUMN `c` DATETIME(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3);",
+		},
+		{
+			name:    "all options",
+			colType: "enum('new','paid')",
+			opts:    ColumnOptions{NotNull: true, Default: value("paid"), After: "id", Comment: "it's the state"},
+			wantUp: "ALTER TABLE `shop`.`orders` ADD COLUMN `c` ENUM('new','paid') NOT NULL DEFAULT 'paid' " +
+				"COMMENT 'it''s the state' AFTER `id`;",
+		},
+		{
+			name:    "json not null",
+			colType: "json",
+			opts:    ColumnOptions{NotNull: true},
+			wantUp:  "ALTER TABLE `shop`.`orders` ADD COLUMN `c` JSON NOT NULL;",
+		},
+		{
+			name:    "generated",
+			colType: "bigint unsigned",
+			opts:    ColumnOptions{GeneratedAs: "price * quantity", Stored: true, NotNull: true},
+			wantUp:  "ALTER TABLE `shop`.`orders` ADD COLUMN `c` BIGINT UNSIGNED GENERATED ALWAYS AS (price * quantity) STORED NOT NULL;",
+		},
+		{name: "generated with default", colType: "int", opts: ColumnOptions{GeneratedAs: "1", Default: value("1")}, wantErr: true},
+		{name: "not a number", colType: "int", opts: ColumnOptions{Default: value("one")}, wantErr: true},
+		{name: "not an enum value", colType: "enum('a')", opts: ColumnOptions{Default: value("b")}, wantErr: true},
+		{name: "stored without expression", colType: "int", opts: ColumnOptions{Stored: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := NewCreateColumnParams("shop", "orders", "c", tt.colType, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewCreateColumnParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			m, err := GenCreateSQL(params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Up != tt.wantUp {
+				t.Errorf("Up = %q, want %q", m.Up, tt.wantUp)
+			}
+			if want := "ALTER TABLE `shop`.`orders` DROP COLUMN `c`;"; m.Down != want {
+				t.Errorf("Down = %q, want %q", m.Down, want)
+			}
+			if want := "column_shop_orders_c"; m.Desc != want {
+				t.Errorf("Desc = %q, want %q", m.Desc, want)
+			}
+		})
+	}
+}
@@ -32,15 +32,19 @@ type Router interface {
 type Context interface {
 	GetUser() (string, error)
 	SendUnauthorizedError() error
+	SendForbiddenError(response any) error
 	SendString(response string) error
 	BindBody(b any) error
 	SendError(msg string) error
+	SendNotFoundError(msg string) error
 	Send(response any) error
 	SendInternalError(msg string, err error) error
 	GetRequestContext() context.Context
 	Request() *http.Request
 	ResponseWriter() http.ResponseWriter
 	Path() string
+	Param(name string) string
+	QueryParam(name string) string
 }
 
 type Controller interface {
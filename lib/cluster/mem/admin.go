@@ -0,0 +1,230 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package mem
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/container"
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+)
+
+// DataDir is the data directory of the Redis server in its container
+const DataDir = "/data"
+
+const saveTimeout = 10 * time.Minute
+const savePollInterval = time.Second
+const restartTimeout = 5 * time.Minute
+
+// MemContainerName returns the name of the Redis container of the node
+func (m *MemNode) MemContainerName() string {
+	return m.memContainerName()
+}
+
+// DataVolumeName returns the name of the volume holding the data directory
+func (m *MemNode) DataVolumeName() string {
+	return fmt.Sprintf("%s-data", m.memContainerName())
+}
+
+// ClusterAddress returns the address the other nodes of the cluster reach
+// the node at
+func (m *MemNode) ClusterAddress() string {
+	if m.NetworkName != hostNetworkName {
+		return fmt.Sprintf("%s:%d", m.memContainerName(), m.port())
+	}
+	return fmt.Sprintf("%s:%d", utils.NodeHost(hostNetworkName, m.Domain, m.RepIndex, m.ShardIndex), m.port())
+}
+
+// CLI runs a command with redis-cli inside the container of the node and
+// returns its raw output
+func (m *MemNode) CLI(ctx context.Context, args ...string) (string, error) {
+	cmd := append([]string{"redis-cli", "--tls", "--cert", certPath, "--key", keyCertPath, "--cacert", caCertPath,
+		"-p", strconv.Itoa(m.port())}, args...)
+	var out bytes.Buffer
+	if err := container.Exec(ctx, m.memContainerName(), cmd, container.ExecOptions{Stdout: &out}); err != nil {
+		return "", err
+	}
+	result := strings.TrimSpace(out.String())
+	if isErrorReply(result) {
+		return "", fmt.Errorf("%s on %s: %s", strings.Join(args, " "), m.memContainerName(), result)
+	}
+	return result, nil
+}
+
+// isErrorReply reports whether redis-cli printed an error reply
+func isErrorReply(s string) bool {
+	if strings.HasPrefix(s, "(error)") {
+		return true
+	}
+	code, _, _ := strings.Cut(s, " ")
+	switch code {
+	case "ERR", "WRONGTYPE", "MOVED", "ASK", "CLUSTERDOWN", "NOAUTH", "NOPERM", "BUSY", "LOADING", "READONLY":
+		return true
+	}
+	return false
+}
+
+// ParseInfo parses the output of INFO into its fields
+func ParseInfo(s string) map[string]string {
+	info := map[string]string{}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			info[key] = value
+		}
+	}
+	return info
+}
+
+// Save writes a snapshot with BGSAVE and waits until it is complete
+func (m *MemNode) Save(ctx context.Context) error {
+	out, err := m.CLI(ctx, "INFO", "persistence")
+	if err != nil {
+		return err
+	}
+	saves := ParseInfo(out)["rdb_saves"]
+	if _, err := m.CLI(ctx, "BGSAVE", "SCHEDULE"); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, saveTimeout)
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("snapshot of %s did not complete: %w", m.memContainerName(), ctx.Err())
+		case <-time.After(savePollInterval):
+		}
+		out, err := m.CLI(ctx, "INFO", "persistence")
+		if err != nil {
+			return err
+		}
+		info := ParseInfo(out)
+		if info["rdb_saves"] == saves || info["rdb_bgsave_in_progress"] != "0" {
+			continue
+		}
+		if info["rdb_last_bgsave_status"] != "ok" {
+			return fmt.Errorf("snapshot of %s failed", m.memContainerName())
+		}
+		return nil
+	}
+}
+
+// configGet returns the value of a configuration parameter
+func (m *MemNode) configGet(ctx context.Context, name string) (string, error) {
+	out, err := m.CLI(ctx, "CONFIG", "GET", name)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(out, "\n")
+	if len(lines) != 2 { //nolint:mnd
+		return "", fmt.Errorf("unexpected CONFIG GET %s reply %q", name, out)
+	}
+	return strings.TrimSpace(lines[1]), nil
+}
+
+// CopySnapshot writes the last snapshot of the node
+func (m *MemNode) CopySnapshot(ctx context.Context, w io.Writer) error {
+	dir, err := m.configGet(ctx, "dir")
+	if err != nil {
+		return err
+	}
+	file, err := m.configGet(ctx, "dbfilename")
+	if err != nil {
+		return err
+	}
+	return container.Exec(ctx, m.memContainerName(), []string{"cat", path.Join(dir, file)}, container.ExecOptions{Stdout: w})
+}
+
+// ReplaceData stops the node, replaces its data directory with a snapshot,
+// or empties it when the snapshot is nil, and starts it again. The node
+// forgets the cluster it was part of. The snapshot is also installed as the
+// base of the append-only file, which Redis loads instead of the snapshot
+// when append-only is enabled.
+func (m *MemNode) ReplaceData(ctx context.Context, snapshot io.Reader) error {
+	dir, err := m.configGet(ctx, "dir")
+	if err != nil {
+		return err
+	}
+	if dir != DataDir {
+		return fmt.Errorf("%s keeps its data in %s outside of its volume, recreate it", m.memContainerName(), dir)
+	}
+	script := fmt.Sprintf("find %[1]s -mindepth 1 -delete", DataDir)
+	if snapshot != nil {
+		script += fmt.Sprintf(` && cat > %[1]s/dump.rdb && mkdir %[1]s/appendonlydir`+
+			` && cp %[1]s/dump.rdb %[1]s/appendonlydir/appendonly.aof.1.base.rdb`+
+			` && echo "file appendonly.aof.1.base.rdb seq 1 type b" > %[1]s/appendonlydir/appendonly.aof.manifest`, DataDir)
+	}
+	script += fmt.Sprintf(" && chown -R redis:redis %s", DataDir)
+
+	name := m.memContainerName()
+	if err := container.Stop(ctx, name); err != nil {
+		return err
+	}
+	err = container.Run(ctx, m.image(), []string{"sh", "-c", script},
+		[]string{fmt.Sprintf("%s:%s", m.DataVolumeName(), DataDir)}, container.ExecOptions{Stdin: snapshot})
+	if err != nil {
+		return err
+	}
+	return container.Start(ctx, name, restartTimeout)
+}
+
+// ID returns the cluster node ID of the node
+func (m *MemNode) ID(ctx context.Context) (string, error) {
+	return m.CLI(ctx, "CLUSTER", "MYID")
+}
+
+// ClusterNodes returns the nodes of the cluster as the node sees them
+func (m *MemNode) ClusterNodes(ctx context.Context) ([]ClusterNode, error) {
+	out, err := m.CLI(ctx, "CLUSTER", "NODES")
+	if err != nil {
+		return nil, err
+	}
+	return ParseClusterNodes(out)
+}
+
+// AddSlots assigns ranges of slots to the node
+func (m *MemNode) AddSlots(ctx context.Context, ranges []SlotRange) error {
+	if len(ranges) == 0 {
+		return nil
+	}
+	args := []string{"CLUSTER", "ADDSLOTSRANGE"}
+	for _, r := range ranges {
+		args = append(args, strconv.Itoa(r.Start), strconv.Itoa(r.End))
+	}
+	_, err := m.CLI(ctx, args...)
+	return err
+}
+
+// Meet introduces another node to the cluster of the node. CLUSTER MEET
+// takes an IP address, the address of the other node is resolved inside the
+// container of the node.
+func (m *MemNode) Meet(ctx context.Context, other *MemNode) error {
+	host, port, err := net.SplitHostPort(other.ClusterAddress())
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	err = container.Exec(ctx, m.memContainerName(), []string{"getent", "hosts", host}, container.ExecOptions{Stdout: &out})
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	fields := strings.Fields(out.String())
+	if len(fields) == 0 {
+		return fmt.Errorf("failed to resolve %s", host)
+	}
+	_, err = m.CLI(ctx, "CLUSTER", "MEET", fields[0], port)
+	return err
+}
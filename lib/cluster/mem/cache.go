@@ -0,0 +1,402 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package mem
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const entryVersion = 1
+const entryNegative = 1 << 0
+const defaultCacheTTL = 10 * time.Minute
+const defaultNegativeTTL = 30 * time.Second
+const defaultEarlyRefresh = 1.0
+const defaultLoadTimeout = 30 * time.Second
+
+// ErrCacheMiss is returned when a key is not cached
+var ErrCacheMiss = errors.New("cache miss")
+
+// ErrNotFound is returned by a loader when the value does not exist. The
+// cache remembers it for the negative TTL.
+var ErrNotFound = errors.New("not found")
+
+// CacheOptions configures a cache
+type CacheOptions struct {
+	// TTL is how long values are kept, 10 minutes by default
+	TTL time.Duration
+	// Jitter spreads the expiry of values loaded together by up to this
+	// fraction of the TTL in either direction, e.g. 0.1
+	Jitter float64
+	// NegativeTTL is how long a value is remembered as not found, 30 seconds
+	// by default. A negative value disables negative caching.
+	NegativeTTL time.Duration
+	// EarlyRefresh scales how early a value is reloaded before it expires,
+	// weighted by how long it took to load. 1 by default, 0 disables it.
+	EarlyRefresh float64
+	// LoadTimeout bounds a load shared by concurrent callers, which does not
+	// stop when the caller that started it goes away. 30 seconds by default.
+	LoadTimeout time.Duration
+}
+
+// Loader loads a value missing from a cache
+type Loader[T any] func(ctx context.Context) (T, error)
+
+// Cache stores typed values in Redis. Concurrent loads of a key in a
+// process are collapsed into one, and values are reloaded before they expire
+// with a probability growing as they approach their expiry, so a popular key
+// does not stampede its source.
+//
+// A value and its tags are written in one MULTI when they share a slot, on a
+// single node or when the prefix has a hash tag such as {users}. Otherwise
+// they are written one after the other, and an Invalidate running in between
+// can miss the value, which then lives until its TTL.
+type Cache[T any] struct {
+	client redis.UniversalClient
+	prefix string
+	codec  Codec[T]
+	opts   CacheOptions
+	flight flightGroup[T]
+	// atomic tells whether values and tags can be written in one MULTI
+	atomic bool
+
+	now  func() time.Time
+	rand func() float64
+}
+
+// NewCache creates a cache of the keys under a prefix
+func NewCache[T any](client redis.UniversalClient, prefix string, codec Codec[T], opts CacheOptions) *Cache[T] {
+	if opts.TTL <= 0 {
+		opts.TTL = defaultCacheTTL
+	}
+	if opts.NegativeTTL == 0 {
+		opts.NegativeTTL = defaultNegativeTTL
+	}
+	if opts.EarlyRefresh == 0 {
+		opts.EarlyRefresh = defaultEarlyRefresh
+	}
+	if opts.LoadTimeout <= 0 {
+		opts.LoadTimeout = defaultLoadTimeout
+	}
+	_, single := client.(*redis.Client)
+	return &Cache[T]{
+		client: client,
+		prefix: prefix,
+		codec:  codec,
+		opts:   opts,
+		atomic: single || hasHashTag(prefix),
+		now:    time.Now,
+		rand:   rand.Float64,
+	}
+}
+
+// hasHashTag tells whether a prefix holds the hash tag of the keys under it,
+// so they all map to one slot
+func hasHashTag(prefix string) bool {
+	open := strings.IndexByte(prefix, '{')
+	if open < 0 {
+		return false
+	}
+	closing := strings.IndexByte(prefix[open+1:], '}')
+	return closing > 0
+}
+
+func (c *Cache[T]) key(key string) string {
+	return fmt.Sprintf("%s:%s", c.prefix, key)
+}
+
+func (c *Cache[T]) tagKey(tag string) string {
+	return fmt.Sprintf("%s:tag:%s", c.prefix, tag)
+}
+
+// Get returns a cached value. It fails with ErrCacheMiss if the key is not
+// cached and with ErrNotFound if it is cached as not found.
+func (c *Cache[T]) Get(ctx context.Context, key string) (T, error) {
+	var zero T
+	e, err := c.get(ctx, key)
+	if err != nil {
+		return zero, err
+	}
+	if e.negative {
+		return zero, ErrNotFound
+	}
+	return c.codec.Decode(e.payload)
+}
+
+func (c *Cache[T]) get(ctx context.Context, key string) (*cacheEntry, error) {
+	data, err := c.client.Get(ctx, c.key(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeEntry(data)
+}
+
+// Set caches a value and attaches tags to it
+func (c *Cache[T]) Set(ctx context.Context, key string, v T, tags ...string) error {
+	return c.set(ctx, key, v, 0, false, tags)
+}
+
+func (c *Cache[T]) set(ctx context.Context, key string, v T, delta time.Duration, negative bool, tags []string) error {
+	e := &cacheEntry{negative: negative, delta: delta}
+	ttl := c.opts.NegativeTTL
+	if !negative {
+		payload, err := c.codec.Encode(v)
+		if err != nil {
+			return err
+		}
+		e.payload = payload
+		ttl = c.jitter(c.opts.TTL)
+	}
+	e.expiry = c.now().Add(ttl)
+	if c.atomic && len(tags) > 0 {
+		_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, c.key(key), e.encode(), ttl)
+			c.tag(ctx, pipe, key, tags)
+			return nil
+		})
+		return err
+	}
+	if err := c.client.Set(ctx, c.key(key), e.encode(), ttl).Err(); err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		c.tag(ctx, pipe, key, tags)
+		return nil
+	})
+	return err
+}
+
+// tag adds a key to the sets of its tags. A set lives as long as the
+// longest lived value it can hold.
+func (c *Cache[T]) tag(ctx context.Context, pipe redis.Pipeliner, key string, tags []string) {
+	maxTTL := c.opts.TTL + time.Duration(c.opts.Jitter*float64(c.opts.TTL))
+	for _, tag := range tags {
+		pipe.SAdd(ctx, c.tagKey(tag), key)
+		pipe.Expire(ctx, c.tagKey(tag), maxTTL)
+	}
+}
+
+// jitter spreads a TTL by the jitter fraction in either direction
+func (c *Cache[T]) jitter(ttl time.Duration) time.Duration {
+	if c.opts.Jitter <= 0 {
+		return ttl
+	}
+	spread := time.Duration((c.rand()*2 - 1) * c.opts.Jitter * float64(ttl))
+	return max(ttl+spread, time.Millisecond)
+}
+
+// Delete removes keys from the cache
+func (c *Cache[T]) Delete(ctx context.Context, keys ...string) error {
+	// Keys of a DEL must share a slot, each one is deleted on its own
+	for _, key := range keys {
+		if err := c.client.Del(ctx, c.key(key)).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Invalidate removes the keys tagged with any of the tags
+func (c *Cache[T]) Invalidate(ctx context.Context, tags ...string) error {
+	for _, tag := range tags {
+		keys, err := c.client.SMembers(ctx, c.tagKey(tag)).Result()
+		if err != nil {
+			return err
+		}
+		if err := c.Delete(ctx, keys...); err != nil {
+			return err
+		}
+		if err := c.client.Del(ctx, c.tagKey(tag)).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetOrLoad returns a cached value, or loads and caches it with tags. A
+// loader failing with ErrNotFound is cached as not found. When Redis is
+// unreachable the value is loaded without caching it.
+func (c *Cache[T]) GetOrLoad(ctx context.Context, key string, load Loader[T], tags ...string) (T, error) {
+	e, err := c.get(ctx, key)
+	switch {
+	case err == nil && !c.refreshDue(e):
+		if e.negative {
+			var zero T
+			return zero, ErrNotFound
+		}
+		v, err := c.codec.Decode(e.payload)
+		if err == nil {
+			return v, nil
+		}
+		logger.Warning("Reloading undecodable cache entry", utils.M{"key": c.key(key), "error": err.Error()})
+		e = nil
+	case err == nil:
+		logger.Debug("Refreshing cache entry early", utils.M{"key": c.key(key)})
+	case errors.Is(err, ErrCacheMiss):
+	default:
+		logger.Warning("Cache is unavailable", utils.M{"key": c.key(key), "error": err.Error()})
+		e = nil
+	}
+
+	v, err := c.flight.do(ctx, key, c.opts.LoadTimeout, func(ctx context.Context) (T, error) {
+		return c.load(ctx, key, load, tags)
+	})
+	if err != nil && !errors.Is(err, ErrNotFound) && e != nil && !e.negative {
+		// An early refresh failed, the cached value has not expired yet
+		logger.Warning("Early refresh failed", utils.M{"key": c.key(key), "error": err.Error()})
+		return c.codec.Decode(e.payload)
+	}
+	return v, err
+}
+
+func (c *Cache[T]) load(ctx context.Context, key string, load Loader[T], tags []string) (T, error) {
+	start := c.now()
+	v, err := load(ctx)
+	delta := c.now().Sub(start)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		if c.opts.NegativeTTL > 0 {
+			if err := c.set(ctx, key, v, delta, true, tags); err != nil {
+				logger.Warning("Failed to cache value as not found", utils.M{"key": c.key(key), "error": err.Error()})
+			}
+		}
+		return v, err
+	case err != nil:
+		return v, err
+	}
+	if err := c.set(ctx, key, v, delta, false, tags); err != nil {
+		logger.Warning("Failed to cache value", utils.M{"key": c.key(key), "error": err.Error()})
+	}
+	return v, nil
+}
+
+// refreshDue decides whether to reload a value before it expires. It is the
+// XFetch algorithm: the probability grows as the expiry approaches and with
+// the time the value took to load.
+func (c *Cache[T]) refreshDue(e *cacheEntry) bool {
+	if e.delta <= 0 || c.opts.EarlyRefresh <= 0 {
+		return false
+	}
+	r := c.rand()
+	if r <= 0 {
+		return false
+	}
+	gap := time.Duration(-float64(e.delta) * c.opts.EarlyRefresh * math.Log(r))
+	return !c.now().Add(gap).Before(e.expiry)
+}
+
+// cacheEntry is a cached value with what early refresh needs to know about it
+type cacheEntry struct {
+	negative bool
+	// delta is how long the value took to load
+	delta   time.Duration
+	expiry  time.Time
+	payload []byte
+}
+
+// encode writes the version, the flags, the load duration and the expiry in
+// milliseconds as varints, then the payload
+func (e *cacheEntry) encode() []byte {
+	var flags byte
+	if e.negative {
+		flags |= entryNegative
+	}
+	b := []byte{entryVersion, flags}
+	b = binary.AppendUvarint(b, uint64(e.delta.Milliseconds())) //nolint:gosec
+	b = binary.AppendVarint(b, e.expiry.UnixMilli())
+	return append(b, e.payload...)
+}
+
+func decodeEntry(data []byte) (*cacheEntry, error) {
+	if len(data) < 2 || data[0] != entryVersion { //nolint:mnd
+		return nil, fmt.Errorf("invalid cache entry")
+	}
+	e := &cacheEntry{negative: data[1]&entryNegative != 0}
+	rest := data[2:]
+	delta, n := binary.Uvarint(rest)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid cache entry")
+	}
+	rest = rest[n:]
+	expiry, n := binary.Varint(rest)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid cache entry")
+	}
+	e.delta = time.Duration(delta) * time.Millisecond //nolint:gosec
+	e.expiry = time.UnixMilli(expiry)
+	e.payload = rest[n:]
+	return e, nil
+}
+
+// flightGroup collapses concurrent calls with the same key into one
+type flightGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+type flightCall[T any] struct {
+	done chan struct{}
+	// waiters counts the calls sharing the result
+	waiters int
+	val     T
+	err     error
+}
+
+// do runs fn once for the concurrent calls with the same key. It runs
+// detached from the context of the caller that started it, bounded by
+// timeout, and each caller stops waiting when its own context is done.
+func (g *flightGroup[T]) do(ctx context.Context, key string, timeout time.Duration,
+	fn func(ctx context.Context) (T, error)) (T, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flightCall[T]{}
+	}
+	call, ok := g.calls[key]
+	if ok {
+		call.waiters++
+	} else {
+		call = &flightCall[T]{done: make(chan struct{})}
+		g.calls[key] = call
+		go g.run(context.WithoutCancel(ctx), key, timeout, call, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.val, call.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+func (g *flightGroup[T]) run(ctx context.Context, key string, timeout time.Duration, call *flightCall[T],
+	fn func(ctx context.Context) (T, error)) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.val, call.err = fn(ctx)
+}
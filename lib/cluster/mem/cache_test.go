@@ -0,0 +1,211 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package mem
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type cachedUser struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+func TestCodecs(t *testing.T) {
+	user := cachedUser{Name: "hamed", Roles: []string{"admin"}}
+	for name, codec := range map[string]Codec[cachedUser]{
+		"json":      JSONCodec[cachedUser]{},
+		"gzip-json": GzipJSONCodec[cachedUser]{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := codec.Encode(user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := codec.Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(user, got); diff != "" {
+				t.Errorf("Decode() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCacheEntry(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry cacheEntry
+	}{
+		{
+			name:  "Value",
+			entry: cacheEntry{delta: 250 * time.Millisecond, expiry: time.UnixMilli(1750000000123), payload: []byte(`{"a":1}`)},
+		},
+		{
+			name:  "Not found",
+			entry: cacheEntry{negative: true, expiry: time.UnixMilli(1750000000000), payload: []byte{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeEntry(tt.entry.encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(&tt.entry, got, cmp.AllowUnexported(cacheEntry{})); diff != "" {
+				t.Errorf("decodeEntry() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+	for _, data := range [][]byte{nil, {entryVersion}, {9, 0, 0, 0}, {entryVersion, 0, 0x80}} {
+		if _, err := decodeEntry(data); err == nil {
+			t.Errorf("decodeEntry(%v) succeeded", data)
+		}
+	}
+}
+
+func TestCacheJitter(t *testing.T) {
+	c := NewCache[cachedUser](nil, "users", JSONCodec[cachedUser]{}, CacheOptions{TTL: time.Minute, Jitter: 0.1})
+	for r, want := range map[float64]time.Duration{0: 54 * time.Second, 0.5: time.Minute, 1: 66 * time.Second} {
+		c.rand = func() float64 { return r }
+		if got := c.jitter(time.Minute); got != want {
+			t.Errorf("jitter() with rand %v = %s, want %s", r, got, want)
+		}
+	}
+}
+
+func TestCacheRefreshDue(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := NewCache[cachedUser](nil, "users", JSONCodec[cachedUser]{}, CacheOptions{})
+	c.now = func() time.Time { return now }
+	tests := []struct {
+		name  string
+		delta time.Duration
+		left  time.Duration
+		rand  float64
+		want  bool
+	}{
+		{name: "Far from expiry", delta: time.Second, left: time.Hour, rand: 0.01, want: false},
+		// -ln(0.01) is about 4.6 load durations ahead
+		{name: "Close to expiry and unlucky", delta: time.Second, left: 4 * time.Second, rand: 0.01, want: true},
+		{name: "Close to expiry and lucky", delta: time.Second, left: 4 * time.Second, rand: 0.9, want: false},
+		{name: "Unknown load duration", delta: 0, left: time.Millisecond, rand: 0.01, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.rand = func() float64 { return tt.rand }
+			e := &cacheEntry{delta: tt.delta, expiry: now.Add(tt.left)}
+			if got := c.refreshDue(e); got != tt.want {
+				t.Errorf("refreshDue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlightGroup(t *testing.T) {
+	var g flightGroup[int]
+	var calls atomic.Int32
+	release := make(chan struct{})
+	results := make([]int, 10)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = g.do(context.Background(), "key", time.Minute, func(context.Context) (int, error) {
+				calls.Add(1)
+				<-release
+				return 42, nil
+			})
+		}()
+	}
+	// Hold the load until every other caller waits for it
+	for {
+		g.mu.Lock()
+		call := g.calls["key"]
+		waiting := call != nil && call.waiters == len(results)-1
+		g.mu.Unlock()
+		if waiting {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Errorf("calls = %d, want 1", calls.Load())
+	}
+	if diff := cmp.Diff([]int{42, 42, 42, 42, 42, 42, 42, 42, 42, 42}, results); diff != "" {
+		t.Errorf("results mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestFlightGroupContext tests that a shared load outlives the caller that
+// started it and that each caller stops on its own context
+func TestFlightGroupContext(t *testing.T) {
+	var g flightGroup[int]
+	release := make(chan struct{})
+	loadErr := make(chan error, 1)
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderDone := make(chan error, 1)
+	go func() {
+		_, err := g.do(leaderCtx, "key", time.Minute, func(ctx context.Context) (int, error) {
+			<-release
+			loadErr <- ctx.Err()
+			return 42, nil
+		})
+		leaderDone <- err
+	}()
+	for {
+		g.mu.Lock()
+		started := g.calls["key"] != nil
+		g.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	waiterCtx, cancelWaiter := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelWaiter()
+	if _, err := g.do(waiterCtx, "key", time.Minute, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("do() of a waiter error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	cancelLeader()
+	if err := <-leaderDone; !errors.Is(err, context.Canceled) {
+		t.Errorf("do() of the leader error = %v, want %v", err, context.Canceled)
+	}
+
+	close(release)
+	if err := <-loadErr; err != nil {
+		t.Errorf("load context error = %v, want none", err)
+	}
+}
+
+func TestHasHashTag(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   bool
+	}{
+		{"users", false},
+		{"{users}", true},
+		{"app:{users}:v1", true},
+		{"{}users", false},
+		{"{users", false},
+	}
+	for _, tt := range tests {
+		if got := hasHashTag(tt.prefix); got != tt.want {
+			t.Errorf("hasHashTag(%q) = %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+}
@@ -9,13 +9,18 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 
 	"github.com/evgnomon/zygote/lib/cluster/memconn"
 	"github.com/evgnomon/zygote/lib/cluster/utils"
 	"github.com/redis/go-redis/v9"
 )
 
+var gzipMagic = []byte{0x1f, 0x8b}
+
 // CompressJSON compresses a Go value into gzip-compressed JSON bytes
 func CompressJSON(data any) ([]byte, error) {
 	// Marshal to JSON
@@ -56,6 +61,45 @@ func DecompressJSON(compressed []byte, output any) error {
 	return json.Unmarshal(decompressed.Bytes(), output)
 }
 
+// IsCompressed reports whether a value is gzip-compressed, as written by
+// CompressJSON
+func IsCompressed(value []byte) bool {
+	return len(value) >= len(gzipMagic) && bytes.Equal(value[:len(gzipMagic)], gzipMagic)
+}
+
+// ScanKeys calls fn with every key matching a pattern on every master of the
+// cluster. Count is the number of keys each SCAN call is asked to look at.
+func ScanKeys(ctx context.Context, client *redis.ClusterClient, match string, count int64, fn func(key string) error) error {
+	var mu sync.Mutex
+	return client.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		iter := master.Scan(ctx, 0, match, count).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			err := fn(iter.Val())
+			mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	})
+}
+
+// CountKeysInSlot returns the number of keys in a hash slot. Only the master
+// serving the slot holds keys of it, so the counts of all masters are summed.
+func CountKeysInSlot(ctx context.Context, client *redis.ClusterClient, slot int) (int64, error) {
+	if slot < 0 || slot >= SlotCount {
+		return 0, fmt.Errorf("slot %d is out of range", slot)
+	}
+	var total atomic.Int64
+	err := client.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		n, err := master.ClusterCountKeysInSlot(ctx, slot).Result()
+		total.Add(n)
+		return err
+	})
+	return total.Load(), err
+}
+
 // StoreInRedis stores data in Redis
 func StoreInRedis(client *redis.ClusterClient, ctx context.Context, key string, value []byte) error {
 	return client.Set(ctx, key, value, 0).Err()
@@ -69,7 +113,7 @@ func RetrieveFromRedis(client *redis.ClusterClient, ctx context.Context, key str
 func RunExample() {
 	// Initialize Redis client
 	ctx := context.Background()
-	client, err := memconn.Client()
+	client, err := memconn.NewClient(utils.NetworkName(), utils.DomainName(), DefaultPort, defaultShardSize)
 	logger.FatalIfErr("Create Redis client", err)
 
 	// Sample data
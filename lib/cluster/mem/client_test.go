@@ -0,0 +1,32 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package mem
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCompressJSON(t *testing.T) {
+	data := map[string]any{"key": "value", "numbers": []any{1.0, 2.0, 3.0}}
+	compressed, err := CompressJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsCompressed(compressed) {
+		t.Error("IsCompressed() = false for CompressJSON output")
+	}
+	if IsCompressed([]byte(`{"key":"value"}`)) || IsCompressed(nil) {
+		t.Error("IsCompressed() = true for plain JSON")
+	}
+	var got map[string]any
+	if err := DecompressJSON(compressed, &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(data, got); diff != "" {
+		t.Errorf("DecompressJSON() mismatch (-want +got):\n%s", diff)
+	}
+}
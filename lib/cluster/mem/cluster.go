@@ -0,0 +1,215 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package mem
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SlotCount is the number of hash slots of a Redis cluster
+const SlotCount = 16384
+
+const clusterNodeFields = 8
+const roleMaster = "master"
+const roleSlave = "slave"
+
+// SlotRange is an inclusive range of hash slots. It is written as in
+// CLUSTER NODES, e.g. 0-5460.
+type SlotRange struct {
+	Start int
+	End   int
+}
+
+func (r SlotRange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r *SlotRange) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseSlotRange(s)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+func (r SlotRange) String() string {
+	if r.Start == r.End {
+		return strconv.Itoa(r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// Size returns the number of slots in the range
+func (r SlotRange) Size() int {
+	return r.End - r.Start + 1
+}
+
+// ParseSlotRange parses a slot or a range of slots such as 0-5460
+func ParseSlotRange(s string) (SlotRange, error) {
+	start, end, isRange := strings.Cut(s, "-")
+	first, err := strconv.Atoi(start)
+	if err != nil {
+		return SlotRange{}, fmt.Errorf("invalid slot range %q", s)
+	}
+	last := first
+	if isRange {
+		if last, err = strconv.Atoi(end); err != nil {
+			return SlotRange{}, fmt.Errorf("invalid slot range %q", s)
+		}
+	}
+	if first < 0 || last >= SlotCount || last < first {
+		return SlotRange{}, fmt.Errorf("invalid slot range %q", s)
+	}
+	return SlotRange{first, last}, nil
+}
+
+// NormalizeSlots sorts ranges and merges the adjacent and overlapping ones
+func NormalizeSlots(ranges []SlotRange) []SlotRange {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sorted := append([]SlotRange{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	merged := sorted[:1]
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if r.Start <= last.End+1 {
+			last.End = max(last.End, r.End)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// SubtractSlots returns the slots of a that are not in b
+func SubtractSlots(a, b []SlotRange) []SlotRange {
+	var result []SlotRange
+	b = NormalizeSlots(b)
+	for _, r := range NormalizeSlots(a) {
+		start := r.Start
+		for _, o := range b {
+			if o.End < start || o.Start > r.End {
+				continue
+			}
+			if o.Start > start {
+				result = append(result, SlotRange{start, o.Start - 1})
+			}
+			start = o.End + 1
+		}
+		if start <= r.End {
+			result = append(result, SlotRange{start, r.End})
+		}
+	}
+	return result
+}
+
+// ClusterNode is a line of CLUSTER NODES
+type ClusterNode struct {
+	ID          string      `json:"id"`
+	Address     string      `json:"address"`
+	Hostname    string      `json:"hostname,omitempty"`
+	Flags       []string    `json:"flags"`
+	Role        string      `json:"role"`
+	MasterID    string      `json:"masterId,omitempty"`
+	PingSent    int64       `json:"pingSent"`
+	PongRecv    int64       `json:"pongRecv"`
+	ConfigEpoch int64       `json:"configEpoch"`
+	LinkState   string      `json:"linkState"`
+	Slots       []SlotRange `json:"slots,omitempty"`
+}
+
+// HasFlag reports whether the node has a flag such as master or myself
+func (n *ClusterNode) HasFlag(flag string) bool {
+	for _, f := range n.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMaster reports whether the node is a master
+func (n *ClusterNode) IsMaster() bool {
+	return n.HasFlag(roleMaster)
+}
+
+// SlotCount returns the number of slots the node serves
+func (n *ClusterNode) SlotCount() int {
+	count := 0
+	for _, r := range n.Slots {
+		count += r.Size()
+	}
+	return count
+}
+
+// ClusterTopology is the cluster as CLUSTER NODES describes it
+type ClusterTopology struct {
+	Nodes []ClusterNode `json:"nodes"`
+	Count int           `json:"count"`
+}
+
+// NewClusterTopology creates the topology of the nodes of a cluster
+func NewClusterTopology(nodes []ClusterNode) *ClusterTopology {
+	return &ClusterTopology{Nodes: nodes, Count: len(nodes)}
+}
+
+// ParseClusterNodes parses the output of CLUSTER NODES. Slots being imported
+// or migrated are not listed.
+func ParseClusterNodes(s string) ([]ClusterNode, error) {
+	var nodes []ClusterNode
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < clusterNodeFields {
+			return nil, fmt.Errorf("invalid cluster node %q", line)
+		}
+		n := ClusterNode{
+			ID:        fields[0],
+			Flags:     strings.Split(fields[2], ","),
+			Role:      roleMaster,
+			LinkState: fields[7],
+		}
+		if n.HasFlag(roleSlave) {
+			n.Role = roleSlave
+		}
+		// ip:port@cport[,hostname]
+		addr, hostname, _ := strings.Cut(fields[1], ",")
+		n.Address, _, _ = strings.Cut(addr, "@")
+		n.Hostname = hostname
+		if fields[3] != "-" {
+			n.MasterID = fields[3]
+		}
+		var err error
+		for i, v := range []*int64{&n.PingSent, &n.PongRecv, &n.ConfigEpoch} {
+			if *v, err = strconv.ParseInt(fields[4+i], 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid cluster node %q: %w", line, err)
+			}
+		}
+		for _, slot := range fields[clusterNodeFields:] {
+			if strings.HasPrefix(slot, "[") {
+				continue
+			}
+			r, err := ParseSlotRange(slot)
+			if err != nil {
+				return nil, err
+			}
+			n.Slots = append(n.Slots, r)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
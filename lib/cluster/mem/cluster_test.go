@@ -0,0 +1,230 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package mem
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestParseClusterNodes(t *testing.T) {
+	out := `07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:30004@31004,shard-b.zygote.run slave e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 0 1426238317239 4 connected
+67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 127.0.0.1:30002@31002 master - 0 1426238316232 2 connected 5461-10922
+e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30001@31001 myself,master - 0 0 1 connected 0-5460 [5461->-67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1]
+292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f 127.0.0.1:30003@31003 master,fail - 0 1426238318243 3 disconnected 10923 10924-16383
+`
+	got, err := ParseClusterNodes(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ClusterNode{
+		{
+			ID:          "07c37dfeb235213a872192d90877d0cd55635b91",
+			Address:     "127.0.0.1:30004",
+			Hostname:    "shard-b.zygote.run",
+			Flags:       []string{"slave"},
+			Role:        "slave",
+			MasterID:    "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca",
+			PongRecv:    1426238317239,
+			ConfigEpoch: 4,
+			LinkState:   "connected",
+		},
+		{
+			ID:          "67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1",
+			Address:     "127.0.0.1:30002",
+			Flags:       []string{"master"},
+			Role:        "master",
+			PongRecv:    1426238316232,
+			ConfigEpoch: 2,
+			LinkState:   "connected",
+			Slots:       []SlotRange{{5461, 10922}},
+		},
+		{
+			ID:          "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca",
+			Address:     "127.0.0.1:30001",
+			Flags:       []string{"myself", "master"},
+			Role:        "master",
+			ConfigEpoch: 1,
+			LinkState:   "connected",
+			Slots:       []SlotRange{{0, 5460}},
+		},
+		{
+			ID:          "292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f",
+			Address:     "127.0.0.1:30003",
+			Flags:       []string{"master", "fail"},
+			Role:        "master",
+			PongRecv:    1426238318243,
+			ConfigEpoch: 3,
+			LinkState:   "disconnected",
+			Slots:       []SlotRange{{10923, 10923}, {10924, 16383}},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ParseClusterNodes() mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := ParseClusterNodes("07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:30004@31004 slave"); err == nil {
+		t.Error("ParseClusterNodes() accepted a truncated line")
+	}
+}
+
+func TestSlots(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []SlotRange
+		want []SlotRange
+	}{
+		{
+			name: "Normalize merges adjacent and overlapping ranges",
+			got:  NormalizeSlots([]SlotRange{{10, 20}, {0, 5}, {6, 8}, {15, 30}, {40, 40}}),
+			want: []SlotRange{{0, 8}, {10, 30}, {40, 40}},
+		},
+		{
+			name: "Subtract splits a range around the removed slots",
+			got:  SubtractSlots([]SlotRange{{0, 100}}, []SlotRange{{10, 20}, {50, 50}}),
+			want: []SlotRange{{0, 9}, {21, 49}, {51, 100}},
+		},
+		{
+			name: "Subtract everything",
+			got:  SubtractSlots([]SlotRange{{0, 5460}}, []SlotRange{{0, SlotCount - 1}}),
+			want: nil,
+		},
+		{
+			name: "Subtract nothing",
+			got:  SubtractSlots([]SlotRange{{5461, 10922}}, nil),
+			want: []SlotRange{{5461, 10922}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, tt.got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseSlotRange(t *testing.T) {
+	for _, s := range []string{"", "a", "5-", "-1", "10-5", "16384", "0-16384"} {
+		if _, err := ParseSlotRange(s); err == nil {
+			t.Errorf("ParseSlotRange(%q) succeeded", s)
+		}
+	}
+	r, err := ParseSlotRange("0-16383")
+	if err != nil || r.Size() != SlotCount || r.String() != "0-16383" {
+		t.Errorf("ParseSlotRange(0-16383) = %v, %v", r, err)
+	}
+}
+
+func TestSlotRangeJSON(t *testing.T) {
+	data, err := json.Marshal([]SlotRange{{0, 5460}, {16383, 16383}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `["0-5460","16383"]`; string(data) != want {
+		t.Errorf("Marshal() = %s, want %s", data, want)
+	}
+	var got []SlotRange
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]SlotRange{{0, 5460}, {16383, 16383}}, got); diff != "" {
+		t.Errorf("Unmarshal() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPlanRebalance(t *testing.T) {
+	master := func(id string, slots ...SlotRange) ClusterNode {
+		return ClusterNode{ID: id, Flags: []string{"master"}, Slots: slots}
+	}
+	replica := ClusterNode{ID: "r", Flags: []string{"slave"}, MasterID: "a"}
+	tests := []struct {
+		name    string
+		nodes   []ClusterNode
+		exclude []string
+		want    []SlotMove
+		wantErr bool
+	}{
+		{
+			name:  "Balanced",
+			nodes: []ClusterNode{master("a", SlotRange{0, 8191}), master("b", SlotRange{8192, 16383}), replica},
+		},
+		{
+			name:  "New empty master",
+			nodes: []ClusterNode{master("a", SlotRange{0, 8191}), master("b", SlotRange{8192, 16383}), master("c"), replica},
+			want: []SlotMove{
+				{From: "a", To: "c", Slots: SlotRange{5462, 8191}},
+				{From: "b", To: "c", Slots: SlotRange{13653, 16383}},
+			},
+		},
+		{
+			name:    "Drain a master",
+			nodes:   []ClusterNode{master("a", SlotRange{0, 5461}), master("b", SlotRange{5462, 10922}), master("c", SlotRange{10923, 16383})},
+			exclude: []string{"a"},
+			want: []SlotMove{
+				{From: "a", To: "b", Slots: SlotRange{2731, 5461}},
+				{From: "a", To: "c", Slots: SlotRange{0, 2730}},
+			},
+		},
+		{
+			name:  "Fragmented donor",
+			nodes: []ClusterNode{master("a", SlotRange{0, 9}, SlotRange{20, 21}), master("b")},
+			want: []SlotMove{
+				{From: "a", To: "b", Slots: SlotRange{20, 21}},
+				{From: "a", To: "b", Slots: SlotRange{6, 9}},
+			},
+		},
+		{
+			name:    "Unknown master",
+			nodes:   []ClusterNode{master("a", SlotRange{0, 16383})},
+			exclude: []string{"x"},
+			wantErr: true,
+		},
+		{
+			name:    "Nothing left",
+			nodes:   []ClusterNode{master("a", SlotRange{0, 16383})},
+			exclude: []string{"a"},
+			wantErr: true,
+		},
+		{
+			name:    "Failing master with slots",
+			nodes:   []ClusterNode{master("a", SlotRange{0, 8191}), {ID: "b", Flags: []string{"master", "fail"}, Slots: []SlotRange{{8192, 16383}}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PlanRebalance(tt.nodes, tt.exclude...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PlanRebalance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("PlanRebalance() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMigrateOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts redis.ClusterOptions
+		want []any
+	}{
+		{name: "No password", want: []any{"REPLACE", "KEYS"}},
+		{name: "Password only", opts: redis.ClusterOptions{Password: "p"}, want: []any{"REPLACE", "AUTH", "p", "KEYS"}},
+		{name: "User and password", opts: redis.ClusterOptions{Username: "u", Password: "p"},
+			want: []any{"REPLACE", "AUTH2", "u", "p", "KEYS"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, migrateOptions(&tt.opts)); diff != "" {
+				t.Errorf("migrateOptions() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
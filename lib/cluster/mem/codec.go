@@ -0,0 +1,104 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package mem
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Codec encodes the values of a cache into bytes and back
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// JSONCodec stores values as JSON
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// GzipJSONCodec stores values as gzip-compressed JSON, as CompressJSON does
+type GzipJSONCodec[T any] struct{}
+
+func (GzipJSONCodec[T]) Encode(v T) ([]byte, error) {
+	return CompressJSON(v)
+}
+
+func (GzipJSONCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := DecompressJSON(data, &v)
+	return v, err
+}
+
+// ProtoMessage is a protobuf message with generated marshaling methods, as
+// generated by gogo/protobuf or vtprotobuf
+type ProtoMessage interface {
+	Marshal() ([]byte, error)
+	Unmarshal(data []byte) error
+}
+
+// ProtoCodec stores protobuf messages in their wire format
+type ProtoCodec[T ProtoMessage] struct {
+	newMessage func() T
+}
+
+// NewProtoCodec creates a codec of the messages newMessage allocates
+func NewProtoCodec[T ProtoMessage](newMessage func() T) *ProtoCodec[T] {
+	return &ProtoCodec[T]{newMessage: newMessage}
+}
+
+func (c *ProtoCodec[T]) Encode(v T) ([]byte, error) {
+	return v.Marshal()
+}
+
+func (c *ProtoCodec[T]) Decode(data []byte) (T, error) {
+	v := c.newMessage()
+	if err := v.Unmarshal(data); err != nil {
+		return v, fmt.Errorf("invalid protobuf message: %w", err)
+	}
+	return v, nil
+}
+
+// MsgpackMessage is a value with generated MessagePack methods, as generated
+// by tinylib/msgp
+type MsgpackMessage interface {
+	MarshalMsg(b []byte) ([]byte, error)
+	UnmarshalMsg(b []byte) ([]byte, error)
+}
+
+// MsgpackCodec stores values as MessagePack
+type MsgpackCodec[T MsgpackMessage] struct {
+	newMessage func() T
+}
+
+// NewMsgpackCodec creates a codec of the values newMessage allocates
+func NewMsgpackCodec[T MsgpackMessage](newMessage func() T) *MsgpackCodec[T] {
+	return &MsgpackCodec[T]{newMessage: newMessage}
+}
+
+func (c *MsgpackCodec[T]) Encode(v T) ([]byte, error) {
+	return v.MarshalMsg(nil)
+}
+
+func (c *MsgpackCodec[T]) Decode(data []byte) (T, error) {
+	v := c.newMessage()
+	rest, err := v.UnmarshalMsg(data)
+	if err != nil {
+		return v, fmt.Errorf("invalid msgpack value: %w", err)
+	}
+	if len(rest) != 0 {
+		return v, fmt.Errorf("invalid msgpack value: %d trailing bytes", len(rest))
+	}
+	return v, nil
+}
@@ -18,20 +18,30 @@ import (
 
 const defaultShardSize = 3
 const hostNetworkName = "host"
-const redisImage = "redis:7.4.2"
-const defaultRedisPort = 6373
+// DefaultImage is the Redis image of the memory nodes
+const DefaultImage = "redis:7.4.2"
+
+// DefaultPort is the Redis port of the memory nodes
+const DefaultPort = 6373
+
+// DefaultNodeTimeout is the cluster node timeout of the memory nodes in
+// milliseconds
+const DefaultNodeTimeout = 5000
 const memShortName = "mem"
 const caCertPath = "/etc/certs/mem-ca-cert.pem"
 const certPath = "/etc/certs/mem-server-cert.pem"
 const keyCertPath = "/etc/certs/mem-server-key.pem"
 
 var logger = utils.NewLogger()
-var defaultRedisPortStr = strconv.Itoa(defaultRedisPort)
 
 type MemNode struct {
 	Tenant      string
 	Domain      string
 	NetworkName string
+	Image       string
+	Port        int
+	NodeTimeout int
+	AppendOnly  bool
 	ShardSize   int
 	NumShards   int
 	ShardIndex  int
@@ -42,9 +52,27 @@ func NewMemNode() *MemNode {
 	m := MemNode{}
 	m.NetworkName = hostNetworkName
 	m.ShardSize = defaultShardSize
+	m.Image = DefaultImage
+	m.Port = DefaultPort
+	m.NodeTimeout = DefaultNodeTimeout
+	m.AppendOnly = true
 	return &m
 }
 
+func (m *MemNode) port() int {
+	if m.Port == 0 {
+		return DefaultPort
+	}
+	return m.Port
+}
+
+func (m *MemNode) image() string {
+	if m.Image == "" {
+		return DefaultImage
+	}
+	return m.Image
+}
+
 func (m *MemNode) containerName(name string) string {
 	return utils.NodeContainer(name, m.Tenant, m.RepIndex, m.ShardIndex)
 }
@@ -71,16 +99,25 @@ func (m *MemNode) makeCertsVolume() {
 
 func (m *MemNode) CreateReplica(ctx context.Context) {
 	m.makeCertsVolume()
+	portStr := strconv.Itoa(m.port())
+	nodeTimeout := m.NodeTimeout
+	if nodeTimeout == 0 {
+		nodeTimeout = DefaultNodeTimeout
+	}
+	appendOnly := "no"
+	if m.AppendOnly {
+		appendOnly = "yes"
+	}
 	config := container.ContainerConfig{
 		Name:        utils.NodeContainer("mem", m.Tenant, m.RepIndex, m.ShardIndex),
 		NetworkName: m.NetworkName,
-		Image:       redisImage,
+		Image:       m.image(),
 		HealthCommand: []string{
 			"CMD",
 			"redis-cli",
 			"--tls",
 			"-p",
-			defaultRedisPortStr,
+			portStr,
 			"--cert",
 			certPath,
 			"--key",
@@ -90,7 +127,8 @@ func (m *MemNode) CreateReplica(ctx context.Context) {
 			"ping",
 		},
 		Bindings: []string{
-			fmt.Sprintf("%s-data:/var/lib/redis", utils.NodeContainer("mem", m.Tenant, m.RepIndex, m.ShardIndex)),
+			// the image runs the server in its data directory
+			fmt.Sprintf("%s:%s", m.DataVolumeName(), DataDir),
 			fmt.Sprintf("%s:/etc/certs", m.certVolName()),
 		},
 		Caps:    []string{},
@@ -100,11 +138,11 @@ func (m *MemNode) CreateReplica(ctx context.Context) {
 			"--port",
 			"0",
 			"--tls-port",
-			defaultRedisPortStr,
+			portStr,
 			"--cluster-enabled",
 			"yes",
 			"--cluster-node-timeout",
-			"5000",
+			strconv.Itoa(nodeTimeout),
 			"--tls-cert-file",
 			certPath,
 			"--tls-key-file",
@@ -124,10 +162,10 @@ func (m *MemNode) CreateReplica(ctx context.Context) {
 			"--tls-ciphersuites",
 			"TLS_AES_256_GCM_SHA384:TLS_AES_128_GCM_SHA256",
 			"--appendonly",
-			"yes",
+			appendOnly,
 		},
 		Ports: map[int]int{
-			defaultRedisPort + m.RepIndex*10 + m.ShardIndex*100: defaultRedisPort,
+			m.port() + m.RepIndex*10 + m.ShardIndex*100: m.port(),
 		},
 	}
 	err := config.StartContainer(ctx)
@@ -160,17 +198,17 @@ func (m *MemNode) createRedisClusterCommand() []string {
 			if shardIndex%m.NumShards == 0 {
 				var host string
 				if m.NetworkName != hostNetworkName {
-					host = fmt.Sprintf("%s:%d", utils.NodeContainer("mem", m.Tenant, repIndex, shardIndex), defaultRedisPort)
+					host = fmt.Sprintf("%s:%d", utils.NodeContainer("mem", m.Tenant, repIndex, shardIndex), m.port())
 				} else {
-					host = fmt.Sprintf("shard-%s.%s:%d", shardLetter, m.Domain, defaultRedisPort)
+					host = fmt.Sprintf("shard-%s.%s:%d", shardLetter, m.Domain, m.port())
 				}
 				cmd = append(cmd, host)
 				continue
 			}
 			if m.NetworkName != hostNetworkName {
-				host = fmt.Sprintf("%s:%d", utils.NodeContainer("mem", m.Tenant, repIndex, shardIndex), defaultRedisPort)
+				host = fmt.Sprintf("%s:%d", utils.NodeContainer("mem", m.Tenant, repIndex, shardIndex), m.port())
 			} else {
-				host = fmt.Sprintf("shard-%s-%d.%s:%d", shardLetter, shardIndex%m.NumShards, m.Domain, defaultRedisPort)
+				host = fmt.Sprintf("shard-%s-%d.%s:%d", shardLetter, shardIndex%m.NumShards, m.Domain, m.port())
 			}
 			cmd = append(cmd, host)
 		}
@@ -186,8 +224,9 @@ func (m *MemNode) Init(ctx context.Context) {
 		return
 	}
 	logger.Debug("Creating Redis cluster", utils.M{"replicaIndex": m.RepIndex, "shardIndex": m.ShardIndex})
+	portStr := strconv.Itoa(m.port())
 	portMap := map[string]string{
-		defaultRedisPortStr: defaultRedisPortStr,
+		portStr: portStr,
 	}
 	client, err := container.CreateClinet()
 
@@ -205,7 +244,7 @@ func (m *MemNode) Init(ctx context.Context) {
 
 	// Use exponential backoff for container creation
 	err = backoffConfig.Retry(ctx, func() error {
-		return container.SpawnAndWait(ctx, client, redisImage, m.Tenant,
+		return container.SpawnAndWait(ctx, client, m.image(), m.Tenant,
 			m.createRedisClusterCommand(), portMap,
 			map[string]string{m.certVolName(): "/etc/certs"},
 			m.NetworkName,
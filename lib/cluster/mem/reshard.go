@@ -0,0 +1,531 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package mem
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultMigrateBatch = 100
+const defaultMigrateTimeout = 60 * time.Second
+
+// Failover modes of CLUSTER FAILOVER, the default waits for the master to
+// agree
+const (
+	FailoverForce    = "FORCE"
+	FailoverTakeover = "TAKEOVER"
+)
+
+// SlotMove moves a range of slots from a master to another
+type SlotMove struct {
+	From  string    `json:"from"`
+	To    string    `json:"to"`
+	Slots SlotRange `json:"slots"`
+}
+
+// PlanRebalance plans the moves that spread the slots evenly over the
+// healthy masters, leaving the excluded masters without slots. Masters give
+// away their highest slots first.
+func PlanRebalance(nodes []ClusterNode, exclude ...string) ([]SlotMove, error) {
+	var masters []ClusterNode
+	total := 0
+	for _, n := range nodes {
+		if !n.IsMaster() {
+			continue
+		}
+		if n.HasFlag("fail") || n.HasFlag("handshake") || n.HasFlag("noaddr") {
+			if len(n.Slots) > 0 {
+				return nil, fmt.Errorf("master %s at %s is failing and serves %d slots", n.ID, n.Address, n.SlotCount())
+			}
+			continue
+		}
+		total += n.SlotCount()
+		masters = append(masters, n)
+	}
+	for _, id := range exclude {
+		if !slices.ContainsFunc(masters, func(n ClusterNode) bool { return n.ID == id }) {
+			return nil, fmt.Errorf("master %s does not exist", id)
+		}
+	}
+	receivers := 0
+	for _, n := range masters {
+		if !slices.Contains(exclude, n.ID) {
+			receivers++
+		}
+	}
+	if receivers == 0 {
+		return nil, fmt.Errorf("no master left to serve the slots")
+	}
+
+	// Masters serving the most slots get the remainder, so that as few slots
+	// as possible move
+	sort.SliceStable(masters, func(i, j int) bool {
+		ei, ej := slices.Contains(exclude, masters[i].ID), slices.Contains(exclude, masters[j].ID)
+		if ei != ej {
+			return ej
+		}
+		if a, b := masters[i].SlotCount(), masters[j].SlotCount(); a != b {
+			return a > b
+		}
+		return masters[i].ID < masters[j].ID
+	})
+	type balance struct {
+		id    string
+		slots []SlotRange
+		delta int
+	}
+	var donors, takers []*balance
+	for i, n := range masters {
+		target := 0
+		if i < receivers {
+			target = total / receivers
+			if i < total%receivers {
+				target++
+			}
+		}
+		b := &balance{id: n.ID, slots: NormalizeSlots(n.Slots), delta: n.SlotCount() - target}
+		switch {
+		case b.delta > 0:
+			donors = append(donors, b)
+		case b.delta < 0:
+			takers = append(takers, b)
+		}
+	}
+
+	var moves []SlotMove
+	for _, taker := range takers {
+		for _, donor := range donors {
+			for taker.delta < 0 && donor.delta > 0 {
+				last := &donor.slots[len(donor.slots)-1]
+				n := min(-taker.delta, donor.delta, last.Size())
+				r := SlotRange{last.End - n + 1, last.End}
+				last.End -= n
+				if last.Size() == 0 {
+					donor.slots = donor.slots[:len(donor.slots)-1]
+				}
+				moves = append(moves, SlotMove{From: donor.id, To: taker.id, Slots: r})
+				taker.delta += n
+				donor.delta -= n
+			}
+		}
+	}
+	return moves, nil
+}
+
+// ClusterAdmin changes the members and the slot layout of a cluster
+type ClusterAdmin struct {
+	client *redis.ClusterClient
+	// Backoff spaces the checks while the cluster converges
+	Backoff utils.BackoffConfig
+	// MigrateBatch is the number of keys moved by each MIGRATE
+	MigrateBatch int
+	// MigrateTimeout bounds each MIGRATE
+	MigrateTimeout time.Duration
+
+	mu    sync.Mutex
+	nodes map[string]*redis.Client
+}
+
+// NewClusterAdmin creates an admin of the cluster of a client
+func NewClusterAdmin(client *redis.ClusterClient) *ClusterAdmin {
+	return &ClusterAdmin{
+		client: client,
+		Backoff: utils.BackoffConfig{
+			MaxAttempts:  10,
+			InitialDelay: time.Second,
+			MaxDelay:     10 * time.Second,
+		},
+		MigrateBatch:   defaultMigrateBatch,
+		MigrateTimeout: defaultMigrateTimeout,
+		nodes:          map[string]*redis.Client{},
+	}
+}
+
+// Close closes the connections to the nodes, the cluster client stays open
+func (a *ClusterAdmin) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var err error
+	for addr, c := range a.nodes {
+		if closeErr := c.Close(); closeErr != nil {
+			err = closeErr
+		}
+		delete(a.nodes, addr)
+	}
+	return err
+}
+
+// node returns a client of a single node, configured as the cluster client
+func (a *ClusterAdmin) node(addr string) *redis.Client {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if c, ok := a.nodes[addr]; ok {
+		return c
+	}
+	opts := a.client.Options()
+	c := redis.NewClient(&redis.Options{
+		Addr:      addr,
+		Dialer:    opts.Dialer,
+		Username:  opts.Username,
+		Password:  opts.Password,
+		TLSConfig: opts.TLSConfig,
+	})
+	a.nodes[addr] = c
+	return c
+}
+
+// Nodes returns the nodes of the cluster
+func (a *ClusterAdmin) Nodes(ctx context.Context) ([]ClusterNode, error) {
+	out, err := a.client.ClusterNodes(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	return ParseClusterNodes(out)
+}
+
+// Topology returns the topology of the cluster
+func (a *ClusterAdmin) Topology(ctx context.Context) (*ClusterTopology, error) {
+	nodes, err := a.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewClusterTopology(nodes), nil
+}
+
+func findNode(nodes []ClusterNode, id string) (*ClusterNode, error) {
+	for i := range nodes {
+		if nodes[i].ID == id || nodes[i].Address == id {
+			return &nodes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("node %s is not part of the cluster", id)
+}
+
+// AddNode adds an empty node at an address to the cluster. The node becomes
+// a replica of a master when masterID is set, otherwise a master without
+// slots until the cluster is rebalanced.
+func (a *ClusterAdmin) AddNode(ctx context.Context, addr, masterID string) (*ClusterNode, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	// CLUSTER MEET takes an IP address
+	ips, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	n := a.node(addr)
+	info, err := n.ClusterInfo(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	if known := ParseInfo(info)["cluster_known_nodes"]; known != "1" {
+		return nil, fmt.Errorf("%s already knows %s nodes, reset it first", addr, known)
+	}
+	size, err := n.DBSize(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	if size > 0 {
+		return nil, fmt.Errorf("%s is not empty, it holds %d keys", addr, size)
+	}
+	id, err := n.Do(ctx, "CLUSTER", "MYID").Text()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := a.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if masterID != "" {
+		master, err := findNode(nodes, masterID)
+		if err != nil {
+			return nil, err
+		}
+		if !master.IsMaster() {
+			return nil, fmt.Errorf("node %s is not a master", masterID)
+		}
+		masterID = master.ID
+	}
+	logger.Info("Adding node", utils.M{"address": addr, "id": id})
+	if err := a.client.ClusterMeet(ctx, ips[0], port).Err(); err != nil {
+		return nil, err
+	}
+	err = a.Backoff.Retry(ctx, func() error {
+		view, err := n.ClusterNodes(ctx).Result()
+		if err != nil {
+			return err
+		}
+		known, err := ParseClusterNodes(view)
+		if err != nil {
+			return err
+		}
+		for _, k := range known {
+			if k.HasFlag("handshake") {
+				return fmt.Errorf("%s is still meeting the cluster", addr)
+			}
+		}
+		if len(known) <= len(nodes) {
+			return fmt.Errorf("%s knows %d of %d nodes", addr, len(known), len(nodes)+1)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if masterID != "" {
+		err := a.Backoff.Retry(ctx, func() error {
+			return n.ClusterReplicate(ctx, masterID).Err()
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	nodes, err = a.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return findNode(nodes, id)
+}
+
+// RemoveNode removes a node from the cluster. The slots of a master are
+// moved to the other masters first and its replicas follow the master with
+// the fewest replicas. The removed node is reset so it forgets the cluster.
+func (a *ClusterAdmin) RemoveNode(ctx context.Context, id string) error {
+	nodes, err := a.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	target, err := findNode(nodes, id)
+	if err != nil {
+		return err
+	}
+	if target.IsMaster() {
+		if len(target.Slots) > 0 {
+			moves, err := PlanRebalance(nodes, target.ID)
+			if err != nil {
+				return err
+			}
+			if err := a.Move(ctx, moves); err != nil {
+				return err
+			}
+			if nodes, err = a.Nodes(ctx); err != nil {
+				return err
+			}
+		}
+		if err := a.adoptReplicas(ctx, nodes, target.ID); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("Removing node", utils.M{"address": target.Address, "id": target.ID})
+	for _, n := range nodes {
+		if n.ID == target.ID || n.HasFlag("fail") || n.HasFlag("noaddr") {
+			continue
+		}
+		err := a.Backoff.Retry(ctx, func() error {
+			return a.node(n.Address).ClusterForget(ctx, target.ID).Err()
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if target.HasFlag("fail") {
+		return nil
+	}
+	return a.node(target.Address).ClusterResetHard(ctx).Err()
+}
+
+// adoptReplicas attaches the replicas of a master to the master with the
+// fewest replicas
+func (a *ClusterAdmin) adoptReplicas(ctx context.Context, nodes []ClusterNode, masterID string) error {
+	replicas := map[string]int{}
+	for _, n := range nodes {
+		if n.IsMaster() && n.ID != masterID && len(n.Slots) > 0 && !n.HasFlag("fail") {
+			replicas[n.ID] = 0
+		}
+	}
+	for _, n := range nodes {
+		if _, ok := replicas[n.MasterID]; ok {
+			replicas[n.MasterID]++
+		}
+	}
+	for _, n := range nodes {
+		if n.MasterID != masterID || n.HasFlag("fail") {
+			continue
+		}
+		adopter := ""
+		for id, count := range replicas {
+			if adopter == "" || count < replicas[adopter] || (count == replicas[adopter] && id < adopter) {
+				adopter = id
+			}
+		}
+		if adopter == "" {
+			return fmt.Errorf("no master left to adopt replica %s", n.ID)
+		}
+		logger.Info("Moving replica", utils.M{"replica": n.ID, "master": adopter})
+		if err := a.node(n.Address).ClusterReplicate(ctx, adopter).Err(); err != nil {
+			return err
+		}
+		replicas[adopter]++
+	}
+	return nil
+}
+
+// Rebalance spreads the slots evenly over the masters, leaving the excluded
+// ones without slots, and returns the moves it made
+func (a *ClusterAdmin) Rebalance(ctx context.Context, exclude ...string) ([]SlotMove, error) {
+	nodes, err := a.Nodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	moves, err := PlanRebalance(nodes, exclude...)
+	if err != nil {
+		return nil, err
+	}
+	return moves, a.Move(ctx, moves)
+}
+
+// Move migrates ranges of slots with their keys between masters
+func (a *ClusterAdmin) Move(ctx context.Context, moves []SlotMove) error {
+	nodes, err := a.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range moves {
+		from, err := findNode(nodes, m.From)
+		if err != nil {
+			return err
+		}
+		to, err := findNode(nodes, m.To)
+		if err != nil {
+			return err
+		}
+		logger.Info("Moving slots", utils.M{"slots": m.Slots.String(), "from": from.Address, "to": to.Address})
+		for slot := m.Slots.Start; slot <= m.Slots.End; slot++ {
+			if err := a.migrateSlot(ctx, nodes, from, to, slot); err != nil {
+				return fmt.Errorf("failed to move slot %d from %s to %s: %w", slot, from.Address, to.Address, err)
+			}
+		}
+	}
+	return nil
+}
+
+// migrateSlot moves a slot and its keys as redis-cli --cluster reshard does:
+// the slot is marked importing on the target and migrating on the source,
+// the keys are migrated in batches and the new owner is announced
+func (a *ClusterAdmin) migrateSlot(ctx context.Context, nodes []ClusterNode, from, to *ClusterNode, slot int) error {
+	source, target := a.node(from.Address), a.node(to.Address)
+	if err := target.Do(ctx, "CLUSTER", "SETSLOT", slot, "IMPORTING", from.ID).Err(); err != nil {
+		return err
+	}
+	if err := source.Do(ctx, "CLUSTER", "SETSLOT", slot, "MIGRATING", to.ID).Err(); err != nil {
+		return err
+	}
+	host, port, err := net.SplitHostPort(to.Address)
+	if err != nil {
+		return err
+	}
+	for {
+		keys, err := source.ClusterGetKeysInSlot(ctx, slot, a.MigrateBatch).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			break
+		}
+		args := []any{"MIGRATE", host, port, "", 0, a.MigrateTimeout.Milliseconds()}
+		args = append(args, migrateOptions(a.client.Options())...)
+		for _, k := range keys {
+			args = append(args, k)
+		}
+		if err := source.Do(ctx, args...).Err(); err != nil {
+			return err
+		}
+	}
+	// The target learns it owns the slot first, so a failure in between
+	// leaves the slot served
+	if err := target.Do(ctx, "CLUSTER", "SETSLOT", slot, "NODE", to.ID).Err(); err != nil {
+		return err
+	}
+	if err := source.Do(ctx, "CLUSTER", "SETSLOT", slot, "NODE", to.ID).Err(); err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		if !n.IsMaster() || n.ID == from.ID || n.ID == to.ID || n.HasFlag("fail") || len(n.Slots) == 0 {
+			continue
+		}
+		if err := a.node(n.Address).Do(ctx, "CLUSTER", "SETSLOT", slot, "NODE", to.ID).Err(); err != nil {
+			// Gossip spreads the new owner anyway
+			logger.Warning("Failed to announce slot owner", utils.M{"node": n.Address, "slot": slot, "error": err.Error()})
+		}
+	}
+	return nil
+}
+
+// migrateOptions returns the options of MIGRATE up to KEYS. REPLACE lets an
+// interrupted migration run again over the keys that already reached the
+// target.
+func migrateOptions(opts *redis.ClusterOptions) []any {
+	args := []any{"REPLACE"}
+	switch {
+	case opts.Password == "":
+	case opts.Username == "":
+		args = append(args, "AUTH", opts.Password)
+	default:
+		args = append(args, "AUTH2", opts.Username, opts.Password)
+	}
+	return append(args, "KEYS")
+}
+
+// Failover promotes a replica to master of its shard. The mode is empty for
+// a coordinated failover, FailoverForce when the master is unreachable and
+// FailoverTakeover when the majority of masters is.
+func (a *ClusterAdmin) Failover(ctx context.Context, id, mode string) error {
+	nodes, err := a.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+	replica, err := findNode(nodes, id)
+	if err != nil {
+		return err
+	}
+	if replica.IsMaster() {
+		return fmt.Errorf("node %s is already a master", replica.ID)
+	}
+	args := []any{"CLUSTER", "FAILOVER"}
+	switch mode {
+	case "":
+	case FailoverForce, FailoverTakeover:
+		args = append(args, mode)
+	default:
+		return fmt.Errorf("unknown failover mode %s", mode)
+	}
+	n := a.node(replica.Address)
+	logger.Info("Promoting replica", utils.M{"address": replica.Address, "id": replica.ID, "master": replica.MasterID})
+	if err := n.Do(ctx, args...).Err(); err != nil {
+		return err
+	}
+	return a.Backoff.Retry(ctx, func() error {
+		role, err := n.Do(ctx, "ROLE").Slice()
+		if err != nil {
+			return err
+		}
+		if len(role) == 0 || role[0] != roleMaster {
+			return fmt.Errorf("%s is not a master yet", replica.Address)
+		}
+		return nil
+	})
+}
@@ -13,7 +13,7 @@ import (
 )
 
 const defaultReplica = 0
-const targetReadPort = 6373
+const hostNetworkName = "host"
 
 var logger = utils.NewLogger()
 
@@ -47,22 +47,31 @@ func MemEndpoints(network, domain string, numShards,
 	return endpoints, nil
 }
 
-func Client() (*redis.ClusterClient, error) {
-	tlsConfig := cert.TLSConfig(utils.HostName())
-	if !utils.IsHostNetwork() {
-		tlsConfig.InsecureSkipVerify = true
+// NewClient connects to the memory cluster of a domain whose nodes listen on
+// port. The first replicas of the shards seed the discovery of the other
+// nodes.
+func NewClient(network, domain string, port, numShards int) (*redis.ClusterClient, error) {
+	ep, err := MemEndpoints(network, domain, numShards, port)
+	if err != nil {
+		return nil, err
 	}
-	ep, err := MemEndpoints(utils.NetworkName(), utils.DomainName(), 2, targetReadPort)
-
 	var addrs []string
 	for _, e := range ep {
 		addrs = append(addrs, e.Endpoint())
 	}
+	return NewClusterClient(network, addrs), nil
+}
+
+// NewClusterClient connects to the memory cluster of a network through seed
+// addresses
+func NewClusterClient(network string, addrs []string) *redis.ClusterClient {
+	tlsConfig := cert.TLSConfig(utils.HostName())
+	if network != hostNetworkName {
+		tlsConfig.InsecureSkipVerify = true
+	}
 	logger.Debug("Redis endpoints", utils.M{"endpoints": addrs})
-	client := redis.NewClusterClient(&redis.ClusterOptions{
+	return redis.NewClusterClient(&redis.ClusterOptions{
 		Addrs:     addrs,
 		TLSConfig: tlsConfig,
 	})
-
-	return client, err
 }
@@ -7,83 +7,229 @@ package migration
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"sync"
 
-	"github.com/evgnomon/zygote/lib/cluster/container"
 	"github.com/evgnomon/zygote/lib/cluster/tables"
 	"github.com/evgnomon/zygote/lib/cluster/utils"
 	migrate "github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/mysql"
 )
 
-const routerReadWritePort = 6446
-const routerReadOnlyPort = 6447
-const defaultNumShards = 3
-
 var logger = utils.NewLogger()
 
+// Mode selects how migrations are rolled out over the shards
+type Mode string
+
+const (
+	// Parallel migrates all shards at the same time
+	Parallel Mode = "parallel"
+	// Rolling migrates one shard after the other
+	Rolling Mode = "rolling"
+)
+
+// ParseMode parses a rollout mode name
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case Parallel, Rolling:
+		return Mode(s), nil
+	}
+	return "", fmt.Errorf("unknown migration mode %q, expected %s or %s", s, Parallel, Rolling)
+}
+
 type Migration struct {
 	Directory string
 	Connector *tables.MultiDBConnector
+	// Mode defaults to Parallel
+	Mode Mode
+	// ContinueOnError keeps migrating the other shards after a shard failed,
+	// otherwise the rollout halts at the first failure
+	ContinueOnError bool
 }
 
-func (m *Migration) Up(_ context.Context) error {
-	sqlDirExists := utils.PathExists(m.Directory)
-	if !sqlDirExists {
-		return nil
+// ShardResult is the outcome of a migration on one shard
+type ShardResult struct {
+	Shard       int
+	FromVersion uint
+	ToVersion   uint
+	Dirty       bool
+	Skipped     bool
+	Err         error
+}
+
+// Status returns a short description of the result
+func (r ShardResult) Status() string {
+	switch {
+	case r.Skipped:
+		return "skipped"
+	case r.Err != nil:
+		return fmt.Sprintf("failed: %v", r.Err)
+	case r.FromVersion == r.ToVersion:
+		return "no change"
+	}
+	return "migrated"
+}
+
+// Failed returns the first failure of the results, if any
+func Failed(results []ShardResult) error {
+	for _, r := range results {
+		if r.Err != nil {
+			return fmt.Errorf("migration failed on shard %d: %w", r.Shard, r.Err)
+		}
 	}
-	logger.Info("migrations up start", nil)
-	db, err := m.Connector.GetWriteConnection(0)
+	return nil
+}
+
+func (m *Migration) Up(ctx context.Context) error {
+	results, err := m.Apply(ctx, (*migrate.Migrate).Up)
 	if err != nil {
 		return err
 	}
-	m2, err := m.Migrate(db)
+	return Failed(results)
+}
+
+// UpShard applies all pending migrations to the given shard
+func (m *Migration) UpShard(_ context.Context, shardIndex int) error {
+	if !utils.PathExists(m.Directory) {
+		return nil
+	}
+	r := m.applyShard(shardIndex, (*migrate.Migrate).Up, nil)
+	return r.Err
+}
+
+func (m *Migration) Down(ctx context.Context) error {
+	results, err := m.Apply(ctx, (*migrate.Migrate).Down)
 	if err != nil {
 		return err
 	}
-	err = m2.Up()
-	if err != nil {
-		if err.Error() != "no change" {
-			return err
+	return Failed(results)
+}
+
+// Apply runs a migration operation on every shard according to the rollout
+// mode and returns the result of each shard
+func (m *Migration) Apply(ctx context.Context, op func(*migrate.Migrate) error) ([]ShardResult, error) {
+	if !utils.PathExists(m.Directory) {
+		return nil, nil
+	}
+	mode := m.Mode
+	if mode == "" {
+		mode = Parallel
+	}
+	numShards := m.Connector.NumShards()
+	results := make([]ShardResult, numShards)
+	logger.Info("migrations start", utils.M{"mode": mode, "shards": numShards})
+
+	switch mode {
+	case Rolling:
+		halted := false
+		for shardIndex := 0; shardIndex < numShards; shardIndex++ {
+			if halted || ctx.Err() != nil {
+				results[shardIndex] = ShardResult{Shard: shardIndex, Skipped: true}
+				continue
+			}
+			results[shardIndex] = m.applyShard(shardIndex, op, nil)
+			halted = results[shardIndex].Err != nil && !m.ContinueOnError
+		}
+	case Parallel:
+		// on failure the other shards stop after the migration they are running
+		var stopMu sync.Mutex
+		var stops []chan bool
+		halted := false
+		stop := func(ch chan bool) {
+			stopMu.Lock()
+			defer stopMu.Unlock()
+			if ch != nil {
+				stops = append(stops, ch)
+			} else {
+				halted = true
+			}
+			if !halted {
+				return
+			}
+			for _, s := range stops {
+				select {
+				case s <- true:
+				default:
+				}
+			}
 		}
+		var wg sync.WaitGroup
+		for shardIndex := 0; shardIndex < numShards; shardIndex++ {
+			wg.Add(1)
+			go func(shardIndex int) {
+				defer wg.Done()
+				results[shardIndex] = m.applyShard(shardIndex, op, stop)
+				if results[shardIndex].Err != nil && !m.ContinueOnError {
+					stop(nil)
+				}
+			}(shardIndex)
+		}
+		wg.Wait()
+	default:
+		return nil, fmt.Errorf("unknown migration mode %q", mode)
 	}
-	logger.Info("migrations up done", nil)
-	return nil
+	logger.Info("migrations done", utils.M{"mode": mode, "shards": numShards})
+	return results, nil
 }
 
-func (m *Migration) Down(_ context.Context) error {
-	sqlDirExists := utils.PathExists(m.Directory)
-	if !sqlDirExists {
-		return nil
-	}
-	logger.Info("migrations down start", nil)
-	db, err := m.Connector.GetWriteConnection(0)
+// applyShard runs op on a shard, register is called with the graceful stop
+// channel of the shard migration
+func (m *Migration) applyShard(shardIndex int, op func(*migrate.Migrate) error, register func(chan bool)) ShardResult {
+	result := ShardResult{Shard: shardIndex}
+	db, err := m.Connector.GetWriteConnection(shardIndex)
 	if err != nil {
-		return err
+		result.Err = err
+		return result
 	}
-	m2, err := m.Migrate(db)
+	mm, err := m.Migrate(db)
 	if err != nil {
-		return err
+		result.Err = err
+		return result
 	}
-	err = m2.Down()
+	defer mm.Close()
+	if register != nil {
+		register(mm.GracefulStop)
+	}
+	result.FromVersion, _, err = version(mm)
 	if err != nil {
-		if err.Error() != "no change" {
-			return err
-		}
+		result.Err = err
+		return result
 	}
-	_, _, err = m2.Version()
-	if err != nil && err != migrate.ErrNilVersion {
-		return err
+	logger.Info("migrating shard", utils.M{"shard": shardIndex, "version": result.FromVersion})
+	err = op(mm)
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		result.Err = err
 	}
-	logger.Info("migrations down done", nil)
-	return nil
+	var verr error
+	result.ToVersion, result.Dirty, verr = version(mm)
+	if result.Err == nil {
+		result.Err = verr
+	}
+	return result
+}
+
+// version returns the current version, 0 when no migration was applied
+func version(mm *migrate.Migrate) (uint, bool, error) {
+	v, dirty, err := mm.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	return v, dirty, err
 }
 
-// Migrate *sql.DB
+// Migrate opens a migration over a connection taken from db. Closing the
+// migration returns the connection and keeps db open.
 func (m *Migration) Migrate(db *sql.DB) (*migrate.Migrate, error) {
-	driver, err := mysql.WithInstance(db, &mysql.Config{})
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("dbMigrate WithInstance: %w", err)
+		return nil, fmt.Errorf("dbMigrate Conn: %w", err)
+	}
+	driver, err := mysql.WithConnection(ctx, conn, &mysql.Config{})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("dbMigrate WithConnection: %w", err)
 	}
 	mm, err := migrate.NewWithDatabaseInstance(
 		fmt.Sprintf("file://%s", m.Directory),
@@ -91,21 +237,8 @@ func (m *Migration) Migrate(db *sql.DB) (*migrate.Migrate, error) {
 		driver,
 	)
 	if err != nil {
+		driver.Close()
 		return nil, fmt.Errorf("dbMigrate NewWithDatabaseInstance: %w", err)
 	}
 	return mm, nil
 }
-
-func NewMigration(directory string) *Migration {
-	ctx := context.Background()
-	connector := tables.NewMultiDBConnector(container.AppNetworkName(), "zygote", utils.DomainName(), "mysql",
-		routerReadOnlyPort, routerReadWritePort, defaultNumShards)
-	_, err := connector.ConnectAllShardsRead(ctx)
-	logger.FatalIfErr("migration: connect all shards read", err)
-	_, err = connector.ConnectAllShardsWrite(ctx)
-	logger.FatalIfErr("migration: connect all shards write", err)
-	return &Migration{
-		Directory: directory,
-		Connector: connector,
-	}
-}
@@ -0,0 +1,232 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/golang-migrate/migrate/v4/source"
+	_ "github.com/golang-migrate/migrate/v4/source/file" // file:// source driver
+)
+
+// Step is one migration file an operation would run
+type Step struct {
+	Version uint
+	Up      bool
+	// Identifier is the description part of the file name, empty when the
+	// version has no file for the direction
+	Identifier string
+	SQL        string
+}
+
+// File returns the name of the migration file of the step
+func (s Step) File() string {
+	if s.Identifier == "" {
+		return ""
+	}
+	direction := "down"
+	if s.Up {
+		direction = "up"
+	}
+	return fmt.Sprintf("%d_%s.%s.sql", s.Version, s.Identifier, direction)
+}
+
+// Planner lists the steps of an operation starting at the current version,
+// 0 when no migration was applied
+type Planner func(src source.Driver, current uint) ([]Step, error)
+
+// PlanUp plans applying all pending migrations
+func PlanUp() Planner {
+	return func(src source.Driver, current uint) ([]Step, error) {
+		return planUp(src, current, -1, 0)
+	}
+}
+
+// PlanDown plans reverting all applied migrations
+func PlanDown() Planner {
+	return func(src source.Driver, current uint) ([]Step, error) {
+		return planDown(src, current, -1, 0)
+	}
+}
+
+// PlanSteps plans applying n migrations, or reverting -n migrations when n
+// is negative
+func PlanSteps(n int) Planner {
+	return func(src source.Driver, current uint) ([]Step, error) {
+		if n < 0 {
+			return planDown(src, current, -n, 0)
+		}
+		return planUp(src, current, n, 0)
+	}
+}
+
+// PlanGoto plans migrating up or down to the given version
+func PlanGoto(target uint) Planner {
+	return func(src source.Driver, current uint) ([]Step, error) {
+		if err := versionExists(src, target); err != nil {
+			return nil, err
+		}
+		switch {
+		case target > current:
+			return planUp(src, current, -1, target)
+		case target < current:
+			return planDown(src, current, -1, target)
+		}
+		return nil, nil
+	}
+}
+
+// versionExists fails when the source has no migration with the version
+func versionExists(src source.Driver, v uint) error {
+	if _, err := src.Prev(v); err == nil || !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// Prev fails for the first version and for unknown versions alike
+	if first, err := src.First(); err != nil || first != v {
+		return fmt.Errorf("migration version %d not found", v)
+	}
+	return nil
+}
+
+// planUp lists up steps after current until limit steps are planned or the
+// stop version is reached, a negative limit and a zero stop mean no bound
+func planUp(src source.Driver, current uint, limit int, stop uint) ([]Step, error) {
+	var steps []Step
+	var v uint
+	var err error
+	if current == 0 {
+		v, err = src.First()
+	} else {
+		v, err = src.Next(current)
+	}
+	for err == nil && limit != 0 {
+		step, rerr := readStep(src, v, true)
+		if rerr != nil {
+			return nil, rerr
+		}
+		steps = append(steps, step)
+		limit--
+		if v == stop {
+			return steps, nil
+		}
+		v, err = src.Next(v)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read migrations after version %d: %w", v, err)
+	}
+	return steps, nil
+}
+
+// planDown lists down steps from current until limit steps are planned or
+// the stop version is reached, a negative limit and a zero stop mean no bound
+func planDown(src source.Driver, current uint, limit int, stop uint) ([]Step, error) {
+	var steps []Step
+	v := current
+	for v != 0 && v != stop && limit != 0 {
+		step, err := readStep(src, v, false)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+		limit--
+		prev, err := src.Prev(v)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read migrations before version %d: %w", v, err)
+		}
+		v = prev
+	}
+	return steps, nil
+}
+
+// readStep reads the migration file of a version, a missing file is an empty
+// step as migrate only records the version for it
+func readStep(src source.Driver, v uint, up bool) (Step, error) {
+	step := Step{Version: v, Up: up}
+	read := src.ReadDown
+	if up {
+		read = src.ReadUp
+	}
+	r, identifier, err := read(v)
+	if errors.Is(err, os.ErrNotExist) {
+		return step, nil
+	}
+	if err != nil {
+		return step, fmt.Errorf("read migration %d: %w", v, err)
+	}
+	defer r.Close()
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return step, fmt.Errorf("read migration %d: %w", v, err)
+	}
+	step.Identifier = identifier
+	step.SQL = string(body)
+	return step, nil
+}
+
+// ShardPlan lists the steps an operation would run on a shard
+type ShardPlan struct {
+	Shard   int
+	Version uint
+	Dirty   bool
+	Steps   []Step
+	Err     error
+}
+
+// Plan lists the steps the planned operation would run on every shard
+// without running them
+func (m *Migration) Plan(_ context.Context, planner Planner) ([]ShardPlan, error) {
+	src, err := source.Open(fmt.Sprintf("file://%s", m.Directory))
+	if err != nil {
+		return nil, fmt.Errorf("open migrations %s: %w", m.Directory, err)
+	}
+	defer src.Close()
+	shards := m.Connector.NumShards()
+	plans := make([]ShardPlan, shards)
+	for shardIndex := 0; shardIndex < shards; shardIndex++ {
+		plan := ShardPlan{Shard: shardIndex}
+		plan.Version, plan.Dirty, plan.Err = m.shardVersion(shardIndex)
+		if plan.Err == nil && plan.Dirty {
+			plan.Err = fmt.Errorf("dirty at version %d, force a version first", plan.Version)
+		}
+		if plan.Err == nil {
+			plan.Steps, plan.Err = planner(src, plan.Version)
+		}
+		plans[shardIndex] = plan
+	}
+	return plans, nil
+}
+
+// Versions returns the current version of every shard
+func (m *Migration) Versions(_ context.Context) []ShardResult {
+	results := make([]ShardResult, m.Connector.NumShards())
+	for shardIndex := range results {
+		r := ShardResult{Shard: shardIndex}
+		r.FromVersion, r.Dirty, r.Err = m.shardVersion(shardIndex)
+		r.ToVersion = r.FromVersion
+		results[shardIndex] = r
+	}
+	return results
+}
+
+// shardVersion reads the migration version of a shard
+func (m *Migration) shardVersion(shardIndex int) (uint, bool, error) {
+	db, err := m.Connector.GetWriteConnection(shardIndex)
+	if err != nil {
+		return 0, false, err
+	}
+	mm, err := m.Migrate(db)
+	if err != nil {
+		return 0, false, err
+	}
+	defer mm.Close()
+	return version(mm)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package migration
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestPlanners tests listing the migration files an operation would run
+func TestPlanners(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"100_users.up.sql":   "CREATE TABLE users (id INT);",
+		"100_users.down.sql": "DROP TABLE users;",
+		"200_age.up.sql":     "ALTER TABLE users ADD COLUMN age INT;",
+		"200_age.down.sql":   "ALTER TABLE users DROP COLUMN age;",
+		"300_seed.up.sql":    "INSERT INTO users VALUES (1, 2);",
+	}
+	for name, doc := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(doc), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := source.Open(fmt.Sprintf("file://%s", dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	up := func(v uint, id string) string { return fmt.Sprintf("up %d %s", v, id) }
+	down := func(v uint, id string) string { return fmt.Sprintf("down %d %s", v, id) }
+	tests := []struct {
+		name    string
+		planner Planner
+		current uint
+		want    []string
+		wantErr bool
+	}{
+		{"up from nil", PlanUp(), 0, []string{up(100, "users"), up(200, "age"), up(300, "seed")}, false},
+		{"up to date", PlanUp(), 300, nil, false},
+		{"down all", PlanDown(), 300, []string{down(300, ""), down(200, "age"), down(100, "users")}, false},
+		{"steps forward", PlanSteps(1), 100, []string{up(200, "age")}, false},
+		{"steps past latest", PlanSteps(5), 200, []string{up(300, "seed")}, false},
+		{"steps back", PlanSteps(-2), 300, []string{down(300, ""), down(200, "age")}, false},
+		{"goto up", PlanGoto(200), 0, []string{up(100, "users"), up(200, "age")}, false},
+		{"goto down", PlanGoto(100), 300, []string{down(300, ""), down(200, "age")}, false},
+		{"goto first", PlanGoto(100), 100, nil, false},
+		{"goto unknown", PlanGoto(150), 100, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, err := tt.planner(src, tt.current)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("planner error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, s := range steps {
+				if s.Up {
+					got = append(got, up(s.Version, s.Identifier))
+				} else {
+					got = append(got, down(s.Version, s.Identifier))
+				}
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("steps mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	step, err := readStep(src, 200, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step.File() != "200_age.up.sql" || step.SQL != files["200_age.up.sql"] {
+		t.Errorf("readStep() = %+v", step)
+	}
+}
@@ -0,0 +1,453 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+// Package reshard moves rows between two shard layouts of a cluster.
+package reshard
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"strings"
+
+	"github.com/evgnomon/zygote/lib/cluster/tables"
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+	"github.com/go-sql-driver/mysql"
+)
+
+const defaultBatchSize = 500
+const errDuplicateKey = 1062
+
+var logger = utils.NewLogger()
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// Table names a sharded table and the column holding its shard key. Rows are
+// copied by primary key, so it must be the shard key or, with UniqueKey,
+// unique across all shards.
+type Table struct {
+	Name      string
+	KeyColumn string
+	UniqueKey bool
+}
+
+// ParseTable parses a table given as name:key_column, or
+// name:key_column:unique when the primary key is unique across shards
+func ParseTable(s string) (Table, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" || len(parts) == 3 && parts[2] != "unique" {
+		return Table{}, fmt.Errorf("table %q must be given as name:key_column or name:key_column:unique", s)
+	}
+	return Table{Name: parts[0], KeyColumn: parts[1], UniqueKey: len(parts) == 3}, nil
+}
+
+// TableReport compares the rows a shard should own after resharding with the
+// rows it actually holds
+type TableReport struct {
+	Table            string
+	Shard            int
+	ExpectedRows     int64
+	ActualRows       int64
+	ExpectedChecksum uint64
+	ActualChecksum   uint64
+}
+
+// OK reports whether the shard holds exactly the expected rows
+func (r TableReport) OK() bool {
+	return r.ExpectedRows == r.ActualRows && r.ExpectedChecksum == r.ActualChecksum
+}
+
+// Resharder copies rows from the shards of the From router to the shards of
+// the To router. The connector must be connected to every shard of both.
+type Resharder struct {
+	Connector *tables.MultiDBConnector
+	From      tables.ShardRouter
+	To        tables.ShardRouter
+	Database  string
+	Tables    []Table
+	BatchSize int
+}
+
+// tableMeta holds the columns of a table that are copied between shards
+type tableMeta struct {
+	Table
+	columns    []string
+	primaryKey string
+}
+
+// digest accumulates an order independent checksum of rows
+type digest struct {
+	rows int64
+	sum  uint64
+}
+
+func (d *digest) add(columns []string, row map[string]any) {
+	d.rows++
+	d.sum += rowChecksum(columns, row)
+}
+
+func rowChecksum(columns []string, row map[string]any) uint64 {
+	var b strings.Builder
+	for _, column := range columns {
+		if row[column] == nil {
+			b.WriteString("\x00NULL")
+		} else {
+			fmt.Fprintf(&b, "%v", row[column])
+		}
+		b.WriteByte(0x1f)
+	}
+	return crc64.Checksum([]byte(b.String()), crcTable)
+}
+
+// partition groups rows by the shard the router assigns them to, skipping
+// rows that already live on the source shard
+func partition(rows []map[string]any, keyColumn string, router tables.ShardRouter, source int) (map[int][]map[string]any, error) {
+	moved := map[int][]map[string]any{}
+	for _, row := range rows {
+		target, err := router.Route(fmt.Sprint(row[keyColumn]))
+		if err != nil {
+			return nil, err
+		}
+		if target != source {
+			moved[target] = append(moved[target], row)
+		}
+	}
+	return moved, nil
+}
+
+func (r *Resharder) batchSize() int {
+	if r.BatchSize <= 0 {
+		return defaultBatchSize
+	}
+	return r.BatchSize
+}
+
+func (r *Resharder) qualified(table string) string {
+	return fmt.Sprintf("%s.%s", quoteIdent(r.Database), quoteIdent(table))
+}
+
+func quoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// describe reads the stored columns and primary key of a table from shard 0
+func (r *Resharder) describe(ctx context.Context, table Table) (*tableMeta, error) {
+	meta := &tableMeta{Table: table}
+	err := r.Connector.RetryReadOperation(ctx, 0, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, `SELECT COLUMN_NAME, COLUMN_KEY, EXTRA
+			FROM INFORMATION_SCHEMA.COLUMNS
+			WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?
+			ORDER BY ORDINAL_POSITION`, r.Database, table.Name)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		meta.columns = nil
+		var primaryKeys []string
+		autoIncrement := false
+		for rows.Next() {
+			var name, key, extra string
+			if err := rows.Scan(&name, &key, &extra); err != nil {
+				return err
+			}
+			if strings.Contains(strings.ToUpper(extra), "GENERATED") {
+				continue
+			}
+			meta.columns = append(meta.columns, name)
+			if key == "PRI" {
+				primaryKeys = append(primaryKeys, name)
+				autoIncrement = strings.Contains(strings.ToLower(extra), "auto_increment")
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(meta.columns) == 0 {
+			return fmt.Errorf("table %s.%s not found", r.Database, table.Name)
+		}
+		if len(primaryKeys) != 1 {
+			return fmt.Errorf("table %s must have a single column primary key, found %d", table.Name, len(primaryKeys))
+		}
+		meta.primaryKey = primaryKeys[0]
+		// ids generated on each shard repeat across shards, a moved row
+		// would take the id of an unrelated row on its new shard
+		if meta.primaryKey != table.KeyColumn && (!table.UniqueKey || autoIncrement) {
+			return fmt.Errorf("primary key %s of table %s is not the shard key and may repeat across shards",
+				meta.primaryKey, table.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range meta.columns {
+		if column == table.KeyColumn {
+			return meta, nil
+		}
+	}
+	return nil, fmt.Errorf("shard key column %s not found in table %s", table.KeyColumn, table.Name)
+}
+
+// scan walks all rows of a table on a shard in primary key order
+func (r *Resharder) scan(ctx context.Context, meta *tableMeta, shardIndex int, fn func([]map[string]any) error) error {
+	columns := make([]string, len(meta.columns))
+	for i, column := range meta.columns {
+		columns[i] = quoteIdent(column)
+	}
+	pk := quoteIdent(meta.primaryKey)
+	var last any
+	for {
+		query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), r.qualified(meta.Name))
+		var args []any
+		if last != nil {
+			query += fmt.Sprintf(" WHERE %s > ?", pk)
+			args = append(args, last)
+		}
+		query += fmt.Sprintf(" ORDER BY %s LIMIT %d", pk, r.batchSize())
+
+		var batch []map[string]any
+		err := r.Connector.RetryWriteOperation(ctx, shardIndex, func(db *sql.DB) error {
+			rows, err := db.QueryContext(ctx, query, args...)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			_, batch, err = tables.ScanRows(rows)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read %s on shard %d: %w", meta.Name, shardIndex, err)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+		if len(batch) < r.batchSize() {
+			return nil
+		}
+		last = batch[len(batch)-1][meta.primaryKey]
+	}
+}
+
+// insert writes rows to a shard. A row whose primary key is taken fails the
+// copy, unless the row holding it has the same shard key: then it is the
+// same row, double written or copied by an earlier run.
+func (r *Resharder) insert(ctx context.Context, meta *tableMeta, shardIndex int, rows []map[string]any) error {
+	duplicate, err := r.insertRows(ctx, meta, shardIndex, rows)
+	if err != nil || !duplicate {
+		return err
+	}
+	for _, row := range rows {
+		duplicate, err := r.insertRows(ctx, meta, shardIndex, []map[string]any{row})
+		if err != nil {
+			return err
+		}
+		if duplicate {
+			if err := r.checkSameRow(ctx, meta, shardIndex, row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// insertRows inserts rows in one statement and reports whether it failed on a
+// duplicate key, in which case none was inserted
+func (r *Resharder) insertRows(ctx context.Context, meta *tableMeta, shardIndex int, rows []map[string]any) (bool, error) {
+	columns := make([]string, len(meta.columns))
+	for i, column := range meta.columns {
+		columns[i] = quoteIdent(column)
+	}
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	values := make([]string, len(rows))
+	args := make([]any, 0, len(rows)*len(columns))
+	for i, row := range rows {
+		values[i] = placeholders
+		for _, column := range meta.columns {
+			args = append(args, row[column])
+		}
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s",
+		r.qualified(meta.Name), strings.Join(columns, ", "), strings.Join(values, ", "))
+	duplicate := false
+	err := r.Connector.RetryWriteOperation(ctx, shardIndex, func(db *sql.DB) error {
+		_, err := db.ExecContext(ctx, query, args...)
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == errDuplicateKey {
+			duplicate = true
+			return nil
+		}
+		return err
+	})
+	return duplicate, err
+}
+
+// checkSameRow fails if the row holding the primary key of row on a shard
+// has another shard key
+func (r *Resharder) checkSameRow(ctx context.Context, meta *tableMeta, shardIndex int, row map[string]any) error {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?", quoteIdent(meta.KeyColumn), r.qualified(meta.Name),
+		quoteIdent(meta.primaryKey))
+	var existing string
+	err := r.Connector.RetryWriteOperation(ctx, shardIndex, func(db *sql.DB) error {
+		var key sql.NullString
+		if err := db.QueryRowContext(ctx, query, row[meta.primaryKey]).Scan(&key); err != nil {
+			return err
+		}
+		existing = key.String
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if existing != fmt.Sprint(row[meta.KeyColumn]) {
+		return fmt.Errorf("primary key %v of %s is taken on shard %d by a row with shard key %s",
+			row[meta.primaryKey], meta.Name, shardIndex, existing)
+	}
+	return nil
+}
+
+// Copy copies every row whose shard changes from its current shard to the
+// shard assigned by the To router. Every table is checked before the first
+// row is copied.
+func (r *Resharder) Copy(ctx context.Context) error {
+	metas := make([]*tableMeta, len(r.Tables))
+	for i, table := range r.Tables {
+		meta, err := r.describe(ctx, table)
+		if err != nil {
+			return err
+		}
+		metas[i] = meta
+	}
+	for _, meta := range metas {
+		for source := 0; source < r.From.NumShards(); source++ {
+			var copied int
+			err := r.scan(ctx, meta, source, func(batch []map[string]any) error {
+				moved, err := partition(batch, meta.KeyColumn, r.To, source)
+				if err != nil {
+					return err
+				}
+				for target, rows := range moved {
+					if err := r.insert(ctx, meta, target, rows); err != nil {
+						return fmt.Errorf("failed to copy %s to shard %d: %w", meta.Name, target, err)
+					}
+					copied += len(rows)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			logger.Info("Copied rows", utils.M{"table": meta.Name, "shard": source, "rows": copied})
+		}
+	}
+	return nil
+}
+
+// Verify compares, for every table and shard of the To router, the row count
+// and checksum of the rows it should own with the rows it holds
+func (r *Resharder) Verify(ctx context.Context) ([]TableReport, error) {
+	var reports []TableReport
+	for _, table := range r.Tables {
+		meta, err := r.describe(ctx, table)
+		if err != nil {
+			return nil, err
+		}
+		expected := make([]digest, r.To.NumShards())
+		for source := 0; source < r.From.NumShards(); source++ {
+			err := r.scan(ctx, meta, source, func(batch []map[string]any) error {
+				for _, row := range batch {
+					target, err := r.To.Route(fmt.Sprint(row[meta.KeyColumn]))
+					if err != nil {
+						return err
+					}
+					if r.owns(source, row, meta) {
+						expected[target].add(meta.columns, row)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		for target := 0; target < r.To.NumShards(); target++ {
+			var actual digest
+			err := r.scan(ctx, meta, target, func(batch []map[string]any) error {
+				for _, row := range batch {
+					shardIndex, err := r.To.Route(fmt.Sprint(row[meta.KeyColumn]))
+					if err != nil {
+						return err
+					}
+					if shardIndex == target {
+						actual.add(meta.columns, row)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			reports = append(reports, TableReport{
+				Table:            meta.Name,
+				Shard:            target,
+				ExpectedRows:     expected[target].rows,
+				ActualRows:       actual.rows,
+				ExpectedChecksum: expected[target].sum,
+				ActualChecksum:   actual.sum,
+			})
+		}
+	}
+	return reports, nil
+}
+
+// owns reports whether the source shard is the owner of row under the From
+// router, copies left behind by double writes are not counted twice
+func (r *Resharder) owns(source int, row map[string]any, meta *tableMeta) bool {
+	shardIndex, err := r.From.Route(fmt.Sprint(row[meta.KeyColumn]))
+	return err == nil && shardIndex == source
+}
+
+// Cleanup deletes the rows that no longer belong to their shard
+func (r *Resharder) Cleanup(ctx context.Context) error {
+	for _, table := range r.Tables {
+		meta, err := r.describe(ctx, table)
+		if err != nil {
+			return err
+		}
+		for shardIndex := 0; shardIndex < r.To.NumShards(); shardIndex++ {
+			var deleted int
+			err := r.scan(ctx, meta, shardIndex, func(batch []map[string]any) error {
+				moved, err := partition(batch, meta.KeyColumn, r.To, shardIndex)
+				if err != nil {
+					return err
+				}
+				var keys []any
+				for _, rows := range moved {
+					for _, row := range rows {
+						keys = append(keys, row[meta.primaryKey])
+					}
+				}
+				if len(keys) == 0 {
+					return nil
+				}
+				query := fmt.Sprintf("DELETE FROM %s WHERE %s IN (%s)", r.qualified(meta.Name), quoteIdent(meta.primaryKey),
+					strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", "))
+				deleted += len(keys)
+				return r.Connector.RetryWriteOperation(ctx, shardIndex, func(db *sql.DB) error {
+					_, err := db.ExecContext(ctx, query, keys...)
+					return err
+				})
+			})
+			if err != nil {
+				return fmt.Errorf("failed to clean up %s on shard %d: %w", meta.Name, shardIndex, err)
+			}
+			logger.Info("Deleted moved rows", utils.M{"table": meta.Name, "shard": shardIndex, "rows": deleted})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package reshard
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/evgnomon/zygote/lib/cluster/tables"
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestParseTable tests parsing of name:key_column[:unique] table arguments
+func TestParseTable(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Table
+		wantErr bool
+	}{
+		{"users:id", Table{Name: "users", KeyColumn: "id"}, false},
+		{"orders:customer_id", Table{Name: "orders", KeyColumn: "customer_id"}, false},
+		{"users", Table{}, true},
+		{":id", Table{}, true},
+		{"users:", Table{}, true},
+		{"orders:customer_id:unique", Table{Name: "orders", KeyColumn: "customer_id", UniqueKey: true}, false},
+		{"orders:customer_id:global", Table{}, true},
+		{"orders:customer_id:unique:x", Table{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseTable(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTable(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseTable(%q) mismatch (-want +got):\n%s", tt.input, diff)
+			}
+		})
+	}
+}
+
+// TestPartition checks that only rows changing shard are moved
+func TestPartition(t *testing.T) {
+	to, err := tables.NewHashRouter(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []map[string]any
+	for i := 0; i < 100; i++ {
+		rows = append(rows, map[string]any{"id": fmt.Sprint(i)})
+	}
+	moved, err := partition(rows, "id", to, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := moved[0]; ok {
+		t.Error("rows staying on the source shard must not be moved")
+	}
+	total := 0
+	for target, targetRows := range moved {
+		for _, row := range targetRows {
+			got, _ := to.Route(row["id"].(string))
+			if got != target {
+				t.Errorf("row %v partitioned to %d, router says %d", row["id"], target, got)
+			}
+		}
+		total += len(targetRows)
+	}
+	if total == 0 || total == len(rows) {
+		t.Errorf("moved %d of %d rows, expected a strict subset", total, len(rows))
+	}
+}
+
+// TestDigest checks that the checksum ignores row order but not content
+func TestDigest(t *testing.T) {
+	columns := []string{"id", "name"}
+	a := map[string]any{"id": "1", "name": "alice"}
+	b := map[string]any{"id": "2", "name": nil}
+	c := map[string]any{"id": "2", "name": ""}
+
+	var d1, d2, d3 digest
+	d1.add(columns, a)
+	d1.add(columns, b)
+	d2.add(columns, b)
+	d2.add(columns, a)
+	d3.add(columns, a)
+	d3.add(columns, c)
+
+	if d1 != d2 {
+		t.Errorf("digest depends on row order: %v != %v", d1, d2)
+	}
+	if d1 == d3 {
+		t.Error("digest does not distinguish NULL from empty string")
+	}
+	report := TableReport{ExpectedRows: d1.rows, ActualRows: d3.rows, ExpectedChecksum: d1.sum, ActualChecksum: d3.sum}
+	if report.OK() {
+		t.Error("report with different checksums must not be OK")
+	}
+}
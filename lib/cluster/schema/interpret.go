@@ -0,0 +1,850 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package schema
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+)
+
+// MigrationFile is an up migration in the migrations directory
+type MigrationFile struct {
+	Version uint
+	Path    string
+}
+
+// MigrationFiles lists the up migrations of dir in version order
+func MigrationFiles(dir string) ([]MigrationFile, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.up.sql"))
+	if err != nil {
+		return nil, err
+	}
+	var files []MigrationFile
+	for _, path := range paths {
+		prefix, _, _ := strings.Cut(filepath.Base(path), "_")
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s has no numeric version", path)
+		}
+		files = append(files, MigrationFile{Version: uint(version), Path: path})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Version < files[j].Version })
+	return files, nil
+}
+
+// LatestVersion returns the highest migration version, or 0 if there is none
+func LatestVersion(files []MigrationFile) uint {
+	if len(files) == 0 {
+		return 0
+	}
+	return files[len(files)-1].Version
+}
+
+// Expected returns the schema produced by applying the migrations up to and
+// including version
+func Expected(files []MigrationFile, version uint) (*Schema, error) {
+	s := New()
+	for _, f := range files {
+		if f.Version > version {
+			break
+		}
+		doc, err := os.ReadFile(f.Path)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Apply(string(doc)); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Path, err)
+		}
+	}
+	return s, nil
+}
+
+// Apply interprets the DDL statements of a migration. Statements that do not
+// change tables, columns or indexes are ignored.
+func (s *Schema) Apply(doc string) error {
+	for _, stmt := range splitStatements(tokenize(doc)) {
+		if err := s.applyStatement(&parser{tokens: stmt}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type tokenKind int
+
+const (
+	wordToken tokenKind = iota
+	identToken
+	stringToken
+	punctToken
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// tokenize splits SQL into words, quoted identifiers, strings and
+// punctuation, comments are dropped
+func tokenize(doc string) []token {
+	var tokens []token
+	rs := []rune(doc)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '-' && i+1 < len(rs) && rs[i+1] == '-', r == '#':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			i += 2
+			for i+1 < len(rs) && (rs[i] != '*' || rs[i+1] != '/') {
+				i++
+			}
+			i += 2
+		case r == '`' || r == '\'' || r == '"':
+			j := i + 1
+			var b strings.Builder
+			for j < len(rs) {
+				if rs[j] == r && j+1 < len(rs) && rs[j+1] == r {
+					b.WriteRune(r)
+					j += 2
+					continue
+				}
+				if rs[j] == '\\' && r != '`' && j+1 < len(rs) {
+					b.WriteRune(rs[j+1])
+					j += 2
+					continue
+				}
+				if rs[j] == r {
+					break
+				}
+				b.WriteRune(rs[j])
+				j++
+			}
+			kind := stringToken
+			if r == '`' {
+				kind = identToken
+			}
+			tokens = append(tokens, token{kind: kind, text: b.String()})
+			i = j + 1
+		case isWord(r):
+			j := i
+			for j < len(rs) && isWord(rs[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: wordToken, text: string(rs[i:j])})
+			i = j
+		default:
+			tokens = append(tokens, token{kind: punctToken, text: string(r)})
+			i++
+		}
+	}
+	return tokens
+}
+
+func isWord(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func splitStatements(tokens []token) [][]token {
+	var stmts [][]token
+	var current []token
+	for _, t := range tokens {
+		if t.kind == punctToken && t.text == ";" {
+			if len(current) > 0 {
+				stmts = append(stmts, current)
+			}
+			current = nil
+			continue
+		}
+		current = append(current, t)
+	}
+	if len(current) > 0 {
+		stmts = append(stmts, current)
+	}
+	return stmts
+}
+
+// splitTopLevel splits tokens on commas outside parentheses
+func splitTopLevel(tokens []token) [][]token {
+	var items [][]token
+	var current []token
+	depth := 0
+	for _, t := range tokens {
+		if t.kind == punctToken {
+			switch t.text {
+			case "(":
+				depth++
+			case ")":
+				depth--
+			case ",":
+				if depth == 0 {
+					items = append(items, current)
+					current = nil
+					continue
+				}
+			}
+		}
+		current = append(current, t)
+	}
+	if len(current) > 0 {
+		items = append(items, current)
+	}
+	return items
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{}
+	}
+	return p.tokens[p.pos]
+}
+
+// accept consumes the keywords if the next tokens match them
+func (p *parser) accept(words ...string) bool {
+	if p.pos+len(words) > len(p.tokens) {
+		return false
+	}
+	for i, w := range words {
+		t := p.tokens[p.pos+i]
+		if t.kind != wordToken || !strings.EqualFold(t.text, w) {
+			return false
+		}
+	}
+	p.pos += len(words)
+	return true
+}
+
+func (p *parser) acceptPunct(s string) bool {
+	t := p.peek()
+	if t.kind == punctToken && t.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) name() (string, error) {
+	t := p.peek()
+	if t.kind != wordToken && t.kind != identToken {
+		return "", fmt.Errorf("expected a name, got %q", t.text)
+	}
+	p.pos++
+	return t.text, nil
+}
+
+// qualifiedName parses db.table, the database is empty when not given
+func (p *parser) qualifiedName() (string, string, error) {
+	first, err := p.name()
+	if err != nil {
+		return "", "", err
+	}
+	if !p.acceptPunct(".") {
+		return "", first, nil
+	}
+	second, err := p.name()
+	return first, second, err
+}
+
+// group returns the tokens inside the parentheses at the current position
+func (p *parser) group() ([]token, error) {
+	if !p.acceptPunct("(") {
+		return nil, fmt.Errorf("expected (, got %q", p.peek().text)
+	}
+	start, depth := p.pos, 1
+	for ; p.pos < len(p.tokens); p.pos++ {
+		t := p.tokens[p.pos]
+		if t.kind != punctToken {
+			continue
+		}
+		switch t.text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				p.pos++
+				return p.tokens[start : p.pos-1], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unbalanced parentheses")
+}
+
+func (p *parser) rest() []token {
+	r := p.tokens[p.pos:]
+	p.pos = len(p.tokens)
+	return r
+}
+
+func joinTokens(tokens []token) string {
+	var b strings.Builder
+	for i, t := range tokens {
+		if i > 0 && t.kind != punctToken && tokens[i-1].kind != punctToken {
+			b.WriteByte(' ')
+		}
+		switch t.kind {
+		case stringToken:
+			b.WriteString("'" + strings.ReplaceAll(t.text, "'", "''") + "'")
+		case identToken:
+			b.WriteString("`" + t.text + "`")
+		default:
+			b.WriteString(t.text)
+		}
+	}
+	return b.String()
+}
+
+func (s *Schema) database(name string) (*Database, error) {
+	if name == "" {
+		return nil, fmt.Errorf("table names must be qualified with their database")
+	}
+	d, ok := s.Databases[key(name)]
+	if !ok {
+		return nil, fmt.Errorf("database %s does not exist", name)
+	}
+	return d, nil
+}
+
+func (s *Schema) lookupTable(database, table string) (*Table, error) {
+	d, err := s.database(database)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := d.Tables[key(table)]
+	if !ok {
+		return nil, fmt.Errorf("table %s.%s does not exist", database, table)
+	}
+	return t, nil
+}
+
+func (s *Schema) applyStatement(p *parser) error {
+	switch {
+	case p.accept("CREATE", "DATABASE"), p.accept("CREATE", "SCHEMA"):
+		ifNotExists := p.accept("IF", "NOT", "EXISTS")
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		if _, ok := s.Databases[key(name)]; ok {
+			if ifNotExists {
+				return nil
+			}
+			return fmt.Errorf("database %s already exists", name)
+		}
+		s.Databases[key(name)] = &Database{Name: name, Tables: map[string]*Table{}}
+	case p.accept("DROP", "DATABASE"), p.accept("DROP", "SCHEMA"):
+		p.accept("IF", "EXISTS")
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		delete(s.Databases, key(name))
+	case p.accept("CREATE", "TABLE"), p.accept("CREATE", "TEMPORARY", "TABLE"):
+		return s.createTable(p)
+	case p.accept("DROP", "TABLE"):
+		p.accept("IF", "EXISTS")
+		for _, item := range splitTopLevel(p.rest()) {
+			ip := &parser{tokens: item}
+			database, table, err := ip.qualifiedName()
+			if err != nil {
+				return err
+			}
+			if d, ok := s.Databases[key(database)]; ok {
+				delete(d.Tables, key(table))
+			}
+		}
+	case p.accept("RENAME", "TABLE"):
+		for _, item := range splitTopLevel(p.rest()) {
+			ip := &parser{tokens: item}
+			if err := s.renameTable(ip); err != nil {
+				return err
+			}
+		}
+	case p.accept("ALTER", "TABLE"):
+		database, name, err := p.qualifiedName()
+		if err != nil {
+			return err
+		}
+		t, err := s.lookupTable(database, name)
+		if err != nil {
+			return err
+		}
+		for _, action := range splitTopLevel(p.rest()) {
+			if err := s.alterTable(database, t, &parser{tokens: action}); err != nil {
+				return err
+			}
+		}
+	case p.accept("CREATE", "UNIQUE", "INDEX"), p.accept("CREATE", "FULLTEXT", "INDEX"), p.accept("CREATE", "INDEX"):
+		unique := strings.EqualFold(p.tokens[1].text, "UNIQUE")
+		fullText := strings.EqualFold(p.tokens[1].text, "FULLTEXT")
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		if !p.accept("ON") {
+			return fmt.Errorf("expected ON in CREATE INDEX")
+		}
+		database, table, err := p.qualifiedName()
+		if err != nil {
+			return err
+		}
+		t, err := s.lookupTable(database, table)
+		if err != nil {
+			return err
+		}
+		columns, err := p.indexColumns()
+		if err != nil {
+			return err
+		}
+		t.addIndex(&Index{Name: name, Columns: columns, Unique: unique, FullText: fullText})
+	case p.accept("DROP", "INDEX"):
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		if !p.accept("ON") {
+			return fmt.Errorf("expected ON in DROP INDEX")
+		}
+		database, table, err := p.qualifiedName()
+		if err != nil {
+			return err
+		}
+		t, err := s.lookupTable(database, table)
+		if err != nil {
+			return err
+		}
+		delete(t.Indexes, key(name))
+	default:
+		logger.Debug("Ignoring statement", utils.M{"statement": joinTokens(p.tokens)})
+	}
+	return nil
+}
+
+func (s *Schema) renameTable(p *parser) error {
+	fromDB, from, err := p.qualifiedName()
+	if err != nil {
+		return err
+	}
+	if !p.accept("TO") {
+		return fmt.Errorf("expected TO in RENAME TABLE")
+	}
+	toDB, to, err := p.qualifiedName()
+	if err != nil {
+		return err
+	}
+	t, err := s.lookupTable(fromDB, from)
+	if err != nil {
+		return err
+	}
+	target, err := s.database(toDB)
+	if err != nil {
+		return err
+	}
+	delete(s.Databases[key(fromDB)].Tables, key(from))
+	t.Name = to
+	target.Tables[key(to)] = t
+	return nil
+}
+
+func (s *Schema) createTable(p *parser) error {
+	ifNotExists := p.accept("IF", "NOT", "EXISTS")
+	database, name, err := p.qualifiedName()
+	if err != nil {
+		return err
+	}
+	d, err := s.database(database)
+	if err != nil {
+		return err
+	}
+	if _, ok := d.Tables[key(name)]; ok {
+		if ifNotExists {
+			return nil
+		}
+		return fmt.Errorf("table %s.%s already exists", database, name)
+	}
+	if p.accept("LIKE") {
+		srcDB, src, err := p.qualifiedName()
+		if err != nil {
+			return err
+		}
+		st, err := s.lookupTable(srcDB, src)
+		if err != nil {
+			return err
+		}
+		d.Tables[key(name)] = st.clone(name)
+		return nil
+	}
+	body, err := p.group()
+	if err != nil {
+		return err
+	}
+	t := newTable(name)
+	for _, item := range splitTopLevel(body) {
+		ip := &parser{tokens: item}
+		if ip.addIndexDefinition(t) {
+			continue
+		}
+		if err := t.defineColumn(ip); err != nil {
+			return err
+		}
+	}
+	d.Tables[key(name)] = t
+	return nil
+}
+
+func (t *Table) clone(name string) *Table {
+	c := newTable(name)
+	for k, col := range t.Columns {
+		cc := *col
+		c.Columns[k] = &cc
+	}
+	for k, idx := range t.Indexes {
+		ci := *idx
+		ci.Columns = append([]string(nil), idx.Columns...)
+		c.Indexes[k] = &ci
+	}
+	return c
+}
+
+// addIndex adds an index, unnamed indexes are named after their first column
+// like MySQL does
+func (t *Table) addIndex(idx *Index) {
+	if idx.Name == "" {
+		idx.Name = idx.Columns[0]
+		for n := 2; t.Indexes[key(idx.Name)] != nil; n++ {
+			idx.Name = fmt.Sprintf("%s_%d", idx.Columns[0], n)
+		}
+	}
+	t.Indexes[key(idx.Name)] = idx
+}
+
+// hasIndexPrefix reports whether an index starts with the given columns
+func (t *Table) hasIndexPrefix(columns []string) bool {
+	for _, idx := range t.Indexes {
+		if len(idx.Columns) < len(columns) || idx.FullText {
+			continue
+		}
+		match := true
+		for i, c := range columns {
+			if !strings.EqualFold(idx.Columns[i], c) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// indexColumns parses (col[(len)] [ASC|DESC], ...)
+func (p *parser) indexColumns() ([]string, error) {
+	body, err := p.group()
+	if err != nil {
+		return nil, err
+	}
+	var columns []string
+	for _, item := range splitTopLevel(body) {
+		if len(item) == 0 || (item[0].kind != wordToken && item[0].kind != identToken) {
+			return nil, fmt.Errorf("unsupported index key part %q", joinTokens(item))
+		}
+		columns = append(columns, item[0].text)
+	}
+	return columns, nil
+}
+
+// addIndexDefinition parses a key or constraint definition, it returns false
+// if the tokens define a column instead
+func (p *parser) addIndexDefinition(t *Table) bool {
+	start := p.pos
+	var constraint string
+	if p.accept("CONSTRAINT") {
+		if t := p.peek(); !(t.kind == wordToken && isConstraintKeyword(t.text)) {
+			constraint, _ = p.name()
+		}
+	}
+	idx := &Index{}
+	switch {
+	case p.accept("PRIMARY", "KEY"):
+		idx.Name = primaryIndex
+		idx.Unique = true
+	case p.accept("UNIQUE"):
+		idx.Unique = true
+		_ = p.accept("INDEX") || p.accept("KEY")
+	case p.accept("FULLTEXT"):
+		idx.FullText = true
+		_ = p.accept("INDEX") || p.accept("KEY")
+	case p.accept("INDEX"), p.accept("KEY"):
+	case p.accept("FOREIGN", "KEY"):
+		if p.peek().kind != punctToken {
+			idx.Name, _ = p.name()
+		}
+		columns, err := p.indexColumns()
+		if err != nil {
+			return true
+		}
+		// InnoDB creates an index for a foreign key when none can be used
+		if !t.hasIndexPrefix(columns) {
+			if idx.Name == "" {
+				idx.Name = constraint
+			}
+			idx.Columns = columns
+			t.addIndex(idx)
+		}
+		return true
+	case p.accept("CHECK"):
+		return true
+	default:
+		p.pos = start
+		return false
+	}
+	if idx.Name == "" && p.peek().kind != punctToken {
+		idx.Name, _ = p.name()
+	}
+	if idx.Name == "" && constraint != "" && idx.Unique {
+		idx.Name = constraint
+	}
+	columns, err := p.indexColumns()
+	if err != nil {
+		return true
+	}
+	idx.Columns = columns
+	t.addIndex(idx)
+	for _, c := range columns {
+		if col, ok := t.Columns[key(c)]; ok && idx.Name == primaryIndex {
+			col.Nullable = false
+		}
+	}
+	return true
+}
+
+func isConstraintKeyword(s string) bool {
+	switch strings.ToUpper(s) {
+	case "PRIMARY", "UNIQUE", "FOREIGN", "CHECK":
+		return true
+	}
+	return false
+}
+
+// defineColumn parses a column definition and adds or replaces the column
+func (t *Table) defineColumn(p *parser) error {
+	name, err := p.name()
+	if err != nil {
+		return err
+	}
+	col, inlineIndex, err := parseColumn(name, p)
+	if err != nil {
+		return err
+	}
+	t.Columns[key(name)] = col
+	if inlineIndex != nil {
+		t.addIndex(inlineIndex)
+	}
+	return nil
+}
+
+// parseColumn parses the type and attributes of a column definition
+func parseColumn(name string, p *parser) (*Column, *Index, error) {
+	typeName := p.peek()
+	if typeName.kind != wordToken {
+		return nil, nil, fmt.Errorf("expected a type for column %s", name)
+	}
+	p.pos++
+	typeTokens := []token{typeName}
+	if p.peek().kind == punctToken && p.peek().text == "(" {
+		args, err := p.group()
+		if err != nil {
+			return nil, nil, err
+		}
+		typeTokens = append(typeTokens, token{kind: punctToken, text: "("})
+		typeTokens = append(typeTokens, args...)
+		typeTokens = append(typeTokens, token{kind: punctToken, text: ")"})
+	}
+	for _, modifier := range []string{"UNSIGNED", "ZEROFILL"} {
+		if p.accept(modifier) {
+			typeTokens = append(typeTokens, token{kind: wordToken, text: modifier})
+		}
+	}
+	col := &Column{Name: name, Type: NormalizeType(joinTokens(typeTokens)), Nullable: true}
+	var idx *Index
+	for !p.done() {
+		switch {
+		case p.accept("NOT", "NULL"):
+			col.Nullable = false
+		case p.accept("NULL"):
+			col.Nullable = true
+		case p.accept("PRIMARY", "KEY"):
+			col.Nullable = false
+			idx = &Index{Name: primaryIndex, Columns: []string{name}, Unique: true}
+		case p.accept("UNIQUE"):
+			p.accept("KEY")
+			idx = &Index{Columns: []string{name}, Unique: true}
+		case p.accept("GENERATED", "ALWAYS", "AS"), p.accept("AS"):
+			col.Generated = true
+			expr, err := p.group()
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, t := range expr {
+				if t.kind == stringToken && strings.HasPrefix(t.text, "$") {
+					col.JSONPath = t.text
+					break
+				}
+			}
+		case p.peek().kind == punctToken && p.peek().text == "(":
+			if _, err := p.group(); err != nil {
+				return nil, nil, err
+			}
+		default:
+			p.pos++
+		}
+	}
+	return col, idx, nil
+}
+
+// alterTable applies one ALTER TABLE action
+func (s *Schema) alterTable(database string, t *Table, p *parser) error {
+	switch {
+	case p.accept("ADD"):
+		if p.accept("COLUMN") {
+			return t.defineColumn(p)
+		}
+		if p.addIndexDefinition(t) {
+			return nil
+		}
+		return t.defineColumn(p)
+	case p.accept("DROP", "PRIMARY", "KEY"):
+		delete(t.Indexes, key(primaryIndex))
+	case p.accept("DROP", "INDEX"), p.accept("DROP", "KEY"):
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		delete(t.Indexes, key(name))
+	case p.accept("DROP", "FOREIGN", "KEY"), p.accept("DROP", "CHECK"), p.accept("DROP", "CONSTRAINT"):
+	case p.accept("DROP"):
+		p.accept("COLUMN")
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		t.dropColumn(name)
+	case p.accept("MODIFY"):
+		p.accept("COLUMN")
+		return t.defineColumn(p)
+	case p.accept("CHANGE"):
+		p.accept("COLUMN")
+		old, err := p.name()
+		if err != nil {
+			return err
+		}
+		start := p.pos
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		p.pos = start
+		t.renameColumn(old, name)
+		return t.defineColumn(p)
+	case p.accept("RENAME", "COLUMN"):
+		old, err := p.name()
+		if err != nil {
+			return err
+		}
+		p.accept("TO")
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		t.renameColumn(old, name)
+	case p.accept("RENAME", "INDEX"), p.accept("RENAME", "KEY"):
+		old, err := p.name()
+		if err != nil {
+			return err
+		}
+		p.accept("TO")
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		if idx, ok := t.Indexes[key(old)]; ok {
+			delete(t.Indexes, key(old))
+			idx.Name = name
+			t.Indexes[key(name)] = idx
+		}
+	case p.accept("RENAME"):
+		_ = p.accept("TO") || p.accept("AS")
+		toDB, to, err := p.qualifiedName()
+		if err != nil {
+			return err
+		}
+		if toDB == "" {
+			toDB = database
+		}
+		return s.renameTable(&parser{tokens: []token{
+			{kind: identToken, text: database}, {kind: punctToken, text: "."}, {kind: identToken, text: t.Name},
+			{kind: wordToken, text: "TO"},
+			{kind: identToken, text: toDB}, {kind: punctToken, text: "."}, {kind: identToken, text: to},
+		}})
+	default:
+		logger.Debug("Ignoring ALTER TABLE action", utils.M{"action": joinTokens(p.tokens)})
+	}
+	return nil
+}
+
+// dropColumn removes a column and its parts of indexes
+func (t *Table) dropColumn(name string) {
+	delete(t.Columns, key(name))
+	for k, idx := range t.Indexes {
+		var columns []string
+		for _, c := range idx.Columns {
+			if !strings.EqualFold(c, name) {
+				columns = append(columns, c)
+			}
+		}
+		if len(columns) == 0 {
+			delete(t.Indexes, k)
+		} else {
+			idx.Columns = columns
+		}
+	}
+}
+
+// renameColumn renames a column and its parts of indexes
+func (t *Table) renameColumn(old, name string) {
+	if col, ok := t.Columns[key(old)]; ok {
+		delete(t.Columns, key(old))
+		col.Name = name
+		t.Columns[key(name)] = col
+	}
+	for _, idx := range t.Indexes {
+		for i, c := range idx.Columns {
+			if strings.EqualFold(c, old) {
+				idx.Columns[i] = name
+			}
+		}
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/evgnomon/zygote/lib/cluster/tables"
+	"github.com/go-sql-driver/mysql"
+)
+
+const errNoSuchTable = 1146
+
+// Introspect reads the tables, columns and indexes of the given databases
+// from INFORMATION_SCHEMA
+func Introspect(ctx context.Context, db *sql.DB, databases []string) (*Schema, error) {
+	s := New()
+	if len(databases) == 0 {
+		return s, nil
+	}
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(databases)), ", ")
+	args := make([]any, len(databases))
+	for i, d := range databases {
+		args[i] = d
+	}
+
+	err := queryEach(ctx, db, fmt.Sprintf(`SELECT SCHEMA_NAME FROM INFORMATION_SCHEMA.SCHEMATA
+		WHERE SCHEMA_NAME IN (%s)`, in), args, func(rows *sql.Rows) error {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		s.Databases[key(name)] = &Database{Name: name, Tables: map[string]*Table{}}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryEach(ctx, db, fmt.Sprintf(`SELECT TABLE_SCHEMA, TABLE_NAME FROM INFORMATION_SCHEMA.TABLES
+		WHERE TABLE_SCHEMA IN (%s) AND TABLE_TYPE = 'BASE TABLE'`, in), args, func(rows *sql.Rows) error {
+		var database, name string
+		if err := rows.Scan(&database, &name); err != nil {
+			return err
+		}
+		if d, ok := s.Databases[key(database)]; ok {
+			d.Tables[key(name)] = newTable(name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryEach(ctx, db, fmt.Sprintf(`SELECT TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, EXTRA, GENERATION_EXPRESSION
+		FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA IN (%s)`, in), args, func(rows *sql.Rows) error {
+		var database, table, name, columnType, nullable, extra, expr string
+		if err := rows.Scan(&database, &table, &name, &columnType, &nullable, &extra, &expr); err != nil {
+			return err
+		}
+		t := s.lookup(database, table)
+		if t == nil {
+			return nil
+		}
+		t.Columns[key(name)] = &Column{
+			Name:      name,
+			Type:      NormalizeType(columnType),
+			Nullable:  nullable == "YES",
+			Generated: strings.Contains(strings.ToUpper(extra), "GENERATED"),
+			JSONPath:  tables.GeneratedJSONPath(expr),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryEach(ctx, db, fmt.Sprintf(`SELECT TABLE_SCHEMA, TABLE_NAME, INDEX_NAME, COLUMN_NAME, NON_UNIQUE, INDEX_TYPE
+		FROM INFORMATION_SCHEMA.STATISTICS WHERE TABLE_SCHEMA IN (%s)
+		ORDER BY TABLE_SCHEMA, TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX`, in), args, func(rows *sql.Rows) error {
+		var database, table, name, indexType string
+		var column sql.NullString
+		var nonUnique int
+		if err := rows.Scan(&database, &table, &name, &column, &nonUnique, &indexType); err != nil {
+			return err
+		}
+		t := s.lookup(database, table)
+		if t == nil {
+			return nil
+		}
+		idx, ok := t.Indexes[key(name)]
+		if !ok {
+			idx = &Index{Name: name, Unique: nonUnique == 0, FullText: indexType == "FULLTEXT"}
+			t.Indexes[key(name)] = idx
+		}
+		if column.Valid {
+			idx.Columns = append(idx.Columns, column.String)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Schema) lookup(database, table string) *Table {
+	d, ok := s.Databases[key(database)]
+	if !ok {
+		return nil
+	}
+	return d.Tables[key(table)]
+}
+
+func queryEach(ctx context.Context, db *sql.DB, query string, args []any, fn func(*sql.Rows) error) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Version reads the migration version golang-migrate recorded in the
+// connection database, a shard that was never migrated is at version 0
+func Version(ctx context.Context, db *sql.DB) (version uint, dirty bool, err error) {
+	err = db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	var mysqlErr *mysql.MySQLError
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return 0, false, nil
+	case errors.As(err, &mysqlErr) && mysqlErr.Number == errNoSuchTable:
+		return 0, false, nil
+	case err != nil:
+		return 0, false, err
+	}
+	return version, dirty, nil
+}
+
+// ShardReport is the migration version and schema drift of one shard
+type ShardReport struct {
+	Shard   int
+	Version uint
+	Dirty   bool
+	Drift   []Drift
+	Err     error
+}
+
+// Skewed reports whether the reachable shards are at different versions
+func Skewed(reports []ShardReport) bool {
+	var first *ShardReport
+	for i := range reports {
+		if reports[i].Err != nil {
+			continue
+		}
+		if first == nil {
+			first = &reports[i]
+		} else if reports[i].Version != first.Version {
+			return true
+		}
+	}
+	return false
+}
+
+// Check compares the schema of every shard with the schema implied by the
+// migrations it has applied
+func Check(ctx context.Context, connector *tables.MultiDBConnector, dir string) ([]ShardReport, error) {
+	files, err := MigrationFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := Expected(files, LatestVersion(files))
+	if err != nil {
+		return nil, err
+	}
+	scope := latest.DatabaseNames()
+
+	reports := make([]ShardReport, connector.NumShards())
+	actual := make([]*Schema, connector.NumShards())
+	var wg sync.WaitGroup
+	for shardIndex := range reports {
+		wg.Add(1)
+		go func(shardIndex int) {
+			defer wg.Done()
+			r := &reports[shardIndex]
+			r.Shard = shardIndex
+			r.Err = connector.RetryWriteOperation(ctx, shardIndex, func(db *sql.DB) error {
+				var err error
+				r.Version, r.Dirty, err = Version(ctx, db)
+				if err != nil {
+					return err
+				}
+				actual[shardIndex], err = Introspect(ctx, db, scope)
+				return err
+			})
+		}(shardIndex)
+	}
+	wg.Wait()
+
+	expected := map[uint]*Schema{}
+	for i := range reports {
+		r := &reports[i]
+		if r.Err != nil {
+			continue
+		}
+		e, ok := expected[r.Version]
+		if !ok {
+			e, err = Expected(files, r.Version)
+			if err != nil {
+				return nil, err
+			}
+			expected[r.Version] = e
+		}
+		r.Drift = Diff(e, actual[i], scope)
+	}
+	return reports, nil
+}
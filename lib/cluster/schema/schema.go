@@ -0,0 +1,263 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+// Package schema compares the schema of the shards with the schema implied by
+// the migration files.
+package schema
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+)
+
+var logger = utils.NewLogger()
+
+const primaryIndex = "PRIMARY"
+
+// Column is a stored or generated column of a table
+type Column struct {
+	Name      string
+	Type      string
+	Nullable  bool
+	Generated bool
+	// JSONPath is the path a generated column extracts from a JSON column
+	JSONPath string
+}
+
+// Index is a primary, unique, full text or plain index of a table
+type Index struct {
+	Name     string
+	Columns  []string
+	Unique   bool
+	FullText bool
+}
+
+// Table holds the columns and indexes of a table keyed by lower case name
+type Table struct {
+	Name    string
+	Columns map[string]*Column
+	Indexes map[string]*Index
+}
+
+// Database holds the tables of a database keyed by lower case name
+type Database struct {
+	Name   string
+	Tables map[string]*Table
+}
+
+// Schema holds databases keyed by lower case name
+type Schema struct {
+	Databases map[string]*Database
+}
+
+// New returns an empty schema
+func New() *Schema {
+	return &Schema{Databases: map[string]*Database{}}
+}
+
+func newTable(name string) *Table {
+	return &Table{Name: name, Columns: map[string]*Column{}, Indexes: map[string]*Index{}}
+}
+
+func key(name string) string {
+	return strings.ToLower(name)
+}
+
+// DatabaseNames returns the sorted names of the databases in the schema
+func (s *Schema) DatabaseNames() []string {
+	names := make([]string, 0, len(s.Databases))
+	for _, d := range s.Databases {
+		names = append(names, d.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	intWidthRe     = regexp.MustCompile(`^(bigint|int|mediumint|smallint|tinyint)\((\d+)\)`)
+	decimalScaleRe = regexp.MustCompile(`^decimal\((\d+)\)`)
+	spacesRe       = regexp.MustCompile(`\s+`)
+)
+
+// NormalizeType maps a column type to the form reported by
+// INFORMATION_SCHEMA.COLUMNS.COLUMN_TYPE
+func NormalizeType(t string) string {
+	t = strings.ToLower(strings.TrimSpace(t))
+	t = spacesRe.ReplaceAllString(t, " ")
+	t = strings.ReplaceAll(t, ", ", ",")
+	for _, r := range [][2]string{{" (", "("}, {"( ", "("}, {" )", ")"}, {" ,", ","}} {
+		t = strings.ReplaceAll(t, r[0], r[1])
+	}
+	switch {
+	case t == "bool" || t == "boolean":
+		return "tinyint(1)"
+	case strings.HasPrefix(t, "integer"):
+		t = "int" + strings.TrimPrefix(t, "integer")
+	case strings.HasPrefix(t, "numeric"):
+		t = "decimal" + strings.TrimPrefix(t, "numeric")
+	}
+	// integer display widths are deprecated and not reported, except tinyint(1)
+	t = intWidthRe.ReplaceAllStringFunc(t, func(m string) string {
+		if m == "tinyint(1)" {
+			return m
+		}
+		return m[:strings.Index(m, "(")]
+	})
+	if t == "decimal" || strings.HasPrefix(t, "decimal ") {
+		t = "decimal(10,0)" + strings.TrimPrefix(t, "decimal")
+	}
+	t = decimalScaleRe.ReplaceAllString(t, "decimal($1,0)")
+	if strings.Contains(t, " zerofill") && !strings.Contains(t, " unsigned") {
+		t = strings.Replace(t, " zerofill", " unsigned zerofill", 1)
+	}
+	return t
+}
+
+// DriftKind classifies a difference between expected and actual schema
+type DriftKind string
+
+const (
+	MissingDatabase    DriftKind = "missing_database"
+	UnexpectedDatabase DriftKind = "unexpected_database"
+	MissingTable       DriftKind = "missing_table"
+	UnexpectedTable    DriftKind = "unexpected_table"
+	MissingColumn      DriftKind = "missing_column"
+	UnexpectedColumn   DriftKind = "unexpected_column"
+	ColumnMismatch     DriftKind = "column_mismatch"
+	MissingIndex       DriftKind = "missing_index"
+	UnexpectedIndex    DriftKind = "unexpected_index"
+	IndexMismatch      DriftKind = "index_mismatch"
+)
+
+// Drift is one difference between the expected and the actual schema
+type Drift struct {
+	Kind     DriftKind `json:"kind"`
+	Object   string    `json:"object"`
+	Expected string    `json:"expected,omitempty"`
+	Actual   string    `json:"actual,omitempty"`
+}
+
+func (d Drift) String() string {
+	s := fmt.Sprintf("%s %s", d.Kind, d.Object)
+	if d.Expected != "" || d.Actual != "" {
+		s += fmt.Sprintf(": expected %q, got %q", d.Expected, d.Actual)
+	}
+	return s
+}
+
+func (c *Column) String() string {
+	s := c.Type
+	if !c.Nullable {
+		s += " not null"
+	}
+	if c.Generated {
+		s += " generated"
+	}
+	return s
+}
+
+func (i *Index) String() string {
+	kind := "index"
+	switch {
+	case i.Name == primaryIndex:
+		kind = "primary key"
+	case i.Unique:
+		kind = "unique index"
+	case i.FullText:
+		kind = "fulltext index"
+	}
+	return fmt.Sprintf("%s (%s)", kind, strings.Join(i.Columns, ", "))
+}
+
+// Diff lists the differences of actual from expected in the databases named
+// by scope
+func Diff(expected, actual *Schema, scope []string) []Drift {
+	var drifts []Drift
+	for _, name := range scope {
+		e, eok := expected.Databases[key(name)]
+		a, aok := actual.Databases[key(name)]
+		switch {
+		case !eok && !aok:
+			continue
+		case !aok:
+			drifts = append(drifts, Drift{Kind: MissingDatabase, Object: name})
+			continue
+		case !eok:
+			drifts = append(drifts, Drift{Kind: UnexpectedDatabase, Object: name})
+			continue
+		}
+		drifts = append(drifts, diffDatabase(e, a)...)
+	}
+	return drifts
+}
+
+func diffDatabase(e, a *Database) []Drift {
+	var drifts []Drift
+	for _, name := range sortedKeys(e.Tables) {
+		et := e.Tables[name]
+		object := fmt.Sprintf("%s.%s", e.Name, et.Name)
+		at, ok := a.Tables[name]
+		if !ok {
+			drifts = append(drifts, Drift{Kind: MissingTable, Object: object})
+			continue
+		}
+		drifts = append(drifts, diffTable(object, et, at)...)
+	}
+	for _, name := range sortedKeys(a.Tables) {
+		if _, ok := e.Tables[name]; !ok {
+			drifts = append(drifts, Drift{Kind: UnexpectedTable, Object: fmt.Sprintf("%s.%s", a.Name, a.Tables[name].Name)})
+		}
+	}
+	return drifts
+}
+
+func diffTable(object string, e, a *Table) []Drift {
+	var drifts []Drift
+	for _, name := range sortedKeys(e.Columns) {
+		ec := e.Columns[name]
+		ac, ok := a.Columns[name]
+		switch {
+		case !ok:
+			drifts = append(drifts, Drift{Kind: MissingColumn, Object: object + "." + ec.Name, Expected: ec.String()})
+		case ec.String() != ac.String():
+			drifts = append(drifts, Drift{Kind: ColumnMismatch, Object: object + "." + ec.Name, Expected: ec.String(), Actual: ac.String()})
+		}
+	}
+	for _, name := range sortedKeys(a.Columns) {
+		if _, ok := e.Columns[name]; !ok {
+			ac := a.Columns[name]
+			drifts = append(drifts, Drift{Kind: UnexpectedColumn, Object: object + "." + ac.Name, Actual: ac.String()})
+		}
+	}
+	for _, name := range sortedKeys(e.Indexes) {
+		ei := e.Indexes[name]
+		ai, ok := a.Indexes[name]
+		switch {
+		case !ok:
+			drifts = append(drifts, Drift{Kind: MissingIndex, Object: object + "." + ei.Name, Expected: ei.String()})
+		case !strings.EqualFold(ei.String(), ai.String()):
+			drifts = append(drifts, Drift{Kind: IndexMismatch, Object: object + "." + ei.Name, Expected: ei.String(), Actual: ai.String()})
+		}
+	}
+	for _, name := range sortedKeys(a.Indexes) {
+		if _, ok := e.Indexes[name]; !ok {
+			ai := a.Indexes[name]
+			drifts = append(drifts, Drift{Kind: UnexpectedIndex, Object: object + "." + ai.Name, Actual: ai.String()})
+		}
+	}
+	return drifts
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
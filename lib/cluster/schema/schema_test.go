@@ -0,0 +1,146 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package schema
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var testMigrations = map[string]string{
+	"100_db_shop.up.sql": "CREATE DATABASE shop DEFAULT CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;",
+	"200_table_shop_users.up.sql": "CREATE TABLE IF NOT EXISTS `shop`.`users` (\n" +
+		"    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,\n" +
+		"    data JSON NOT NULL,\n" +
+		"    PRIMARY KEY (id)\n" +
+		");",
+	"300_column_shop_users_age.up.sql": "ALTER TABLE `shop`.`users` ADD COLUMN `age` INT NOT NULL DEFAULT 0;",
+	"400_property_shop_users_email.up.sql": "ALTER TABLE `shop`.`users`\n" +
+		"ADD COLUMN `email` VARCHAR(255) GENERATED ALWAYS AS (data->>'$.email') STORED;",
+	"500_index_shop_users_email.up.sql": "ALTER TABLE `shop`.`users`\nADD UNIQUE INDEX `idx_email` (\n  `email`\n);",
+	"600_rename_shop_users_age.up.sql":  "-- keep the old name around\nALTER TABLE `shop`.`users` RENAME COLUMN `age` TO `years`;",
+}
+
+func writeMigrations(t *testing.T) []MigrationFile {
+	dir := t.TempDir()
+	for name, doc := range testMigrations {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(doc), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := MigrationFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// TestExpected tests interpreting the migrations up to a version
+func TestExpected(t *testing.T) {
+	files := writeMigrations(t)
+	if got := LatestVersion(files); got != 600 {
+		t.Fatalf("LatestVersion() = %d, want 600", got)
+	}
+
+	s, err := Expected(files, 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := s.Databases["shop"].Tables["users"]
+	want := map[string]*Column{
+		"id":    {Name: "id", Type: "bigint unsigned"},
+		"data":  {Name: "data", Type: "json"},
+		"years": {Name: "years", Type: "int"},
+		"email": {Name: "email", Type: "varchar(255)", Nullable: true, Generated: true, JSONPath: "$.email"},
+	}
+	if diff := cmp.Diff(want, users.Columns); diff != "" {
+		t.Errorf("columns mismatch (-want +got):\n%s", diff)
+	}
+	wantIndexes := map[string]*Index{
+		"primary":   {Name: "PRIMARY", Columns: []string{"id"}, Unique: true},
+		"idx_email": {Name: "idx_email", Columns: []string{"email"}, Unique: true},
+	}
+	if diff := cmp.Diff(wantIndexes, users.Indexes); diff != "" {
+		t.Errorf("indexes mismatch (-want +got):\n%s", diff)
+	}
+
+	s, err = Expected(files, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Databases["shop"].Tables["users"].Columns["email"]; ok {
+		t.Error("version 300 must not have the email column")
+	}
+}
+
+// TestDiff tests drift detection between expected and actual schema
+func TestDiff(t *testing.T) {
+	files := writeMigrations(t)
+	expected, err := Expected(files, 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := Expected(files, 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drift := Diff(expected, actual, expected.DatabaseNames()); len(drift) != 0 {
+		t.Fatalf("identical schemas drift: %v", drift)
+	}
+
+	users := actual.Databases["shop"].Tables["users"]
+	delete(users.Columns, "years")
+	users.Columns["data"].Type = "text"
+	users.Columns["extra"] = &Column{Name: "extra", Type: "int", Nullable: true}
+	users.Indexes["idx_email"].Unique = false
+
+	want := []Drift{
+		{Kind: ColumnMismatch, Object: "shop.users.data", Expected: "json not null", Actual: "text not null"},
+		{Kind: MissingColumn, Object: "shop.users.years", Expected: "int not null"},
+		{Kind: UnexpectedColumn, Object: "shop.users.extra", Actual: "int"},
+		{Kind: IndexMismatch, Object: "shop.users.idx_email", Expected: "unique index (email)", Actual: "index (email)"},
+	}
+	if diff := cmp.Diff(want, Diff(expected, actual, expected.DatabaseNames())); diff != "" {
+		t.Errorf("Diff() mismatch (-want +got):\n%s", diff)
+	}
+
+	empty := New()
+	want = []Drift{{Kind: MissingDatabase, Object: "shop"}}
+	if diff := cmp.Diff(want, Diff(expected, empty, []string{"shop"})); diff != "" {
+		t.Errorf("Diff() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestNormalizeType tests mapping declared types to INFORMATION_SCHEMA types
+func TestNormalizeType(t *testing.T) {
+	tests := map[string]string{
+		"INT(11)":                   "int",
+		"BIGINT UNSIGNED":           "bigint unsigned",
+		"bigint(20) unsigned":       "bigint unsigned",
+		"tinyint(1)":                "tinyint(1)",
+		"tinyint(4)":                "tinyint",
+		"BOOLEAN":                   "tinyint(1)",
+		"integer unsigned":          "int unsigned",
+		"INT(10) UNSIGNED ZEROFILL": "int unsigned zerofill",
+		"INT ZEROFILL":              "int unsigned zerofill",
+		"DECIMAL":                   "decimal(10,0)",
+		"DECIMAL(12)":               "decimal(12,0)",
+		"decimal(10, 2)":            "decimal(10,2)",
+		"NUMERIC(8,3)":              "decimal(8,3)",
+		"VARCHAR(255)":              "varchar(255)",
+		"  varbinary( 16 )  ":       "varbinary(16)",
+		"ENUM('a', 'b')":            "enum('a','b')",
+		"DATETIME(6)":               "datetime(6)",
+		"JSON":                      "json",
+	}
+	for input, want := range tests {
+		if got := NormalizeType(input); got != want {
+			t.Errorf("NormalizeType(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+// Package secrets generates and stores the credentials of the SQL users of a
+// tenant in the vault.
+package secrets
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+	toml "github.com/pelletier/go-toml/v2"
+)
+
+var logger = utils.NewLogger()
+
+// Environment variables that override the vault, for hosts and services
+// that run without access to it
+const (
+	RootPasswordEnv        = "ZYGOTE_SQL_ROOT_PASSWORD"
+	AppPasswordEnv         = "ZYGOTE_SQL_APP_PASSWORD"
+	ReplicationPasswordEnv = "ZYGOTE_SQL_REPLICATION_PASSWORD"
+)
+
+// Passwords are alphanumeric so they are safe in DSNs, SQL literals,
+// templates and environment variables
+const passwordLength = 32
+const passwordLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// Credentials are the passwords of the SQL users of a tenant
+type Credentials struct {
+	Root        string `toml:"root"`
+	App         string `toml:"app"`
+	Replication string `toml:"replication"`
+	// Next holds the credentials of a rotation until every node accepts
+	// them, they are not used before
+	Next *Credentials `toml:"next,omitempty"`
+	// Previous holds the credentials a rotation replaced, the nodes accept
+	// them until they are discarded
+	Previous *Credentials `toml:"previous,omitempty"`
+}
+
+// cached are credentials read from the vault with the modification time of
+// the vault file
+type cached struct {
+	credentials *Credentials
+	modTime     time.Time
+}
+
+var (
+	cacheMu sync.Mutex
+	cache   = map[string]cached{}
+)
+
+// VaultName returns the name of the vault file of the credentials of a tenant
+func VaultName(tenant string) string {
+	return fmt.Sprintf("%s.sql-credentials", tenant)
+}
+
+// Generate returns new random credentials
+func Generate() (*Credentials, error) {
+	c := &Credentials{}
+	for _, p := range []*string{&c.Root, &c.App, &c.Replication} {
+		password, err := randomPassword()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate password: %w", err)
+		}
+		*p = password
+	}
+	return c, nil
+}
+
+func randomPassword() (string, error) {
+	b := make([]byte, passwordLength)
+	limit := big.NewInt(int64(len(passwordLetters)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", err
+		}
+		b[i] = passwordLetters[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// Load returns the credentials of a tenant from the environment or the vault.
+// The vault is read again when it changes, so that a rotation is picked up.
+func Load(tenant string) (*Credentials, error) {
+	if c := fromEnv(); c != nil {
+		if err := c.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid credentials in the environment: %w", err)
+		}
+		return c, nil
+	}
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	name := VaultName(tenant)
+	modTime, err := utils.SecretModTime(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no credentials for tenant %s, run zygote init or set %s: %w", tenant, RootPasswordEnv, os.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := cache[tenant]; ok && c.modTime.Equal(modTime) {
+		return c.credentials, nil
+	}
+	doc, err := utils.DecryptContent(name)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse([]byte(doc))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials of tenant %s: %w", tenant, err)
+	}
+	cache[tenant] = cached{credentials: c, modTime: modTime}
+	return c, nil
+}
+
+// Ensure returns the credentials of a tenant, generating and saving them on
+// first use
+func Ensure(tenant string) (*Credentials, error) {
+	if fromEnv() == nil && !utils.SecretExists(VaultName(tenant)) {
+		c, err := Generate()
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("Generated SQL credentials", utils.M{"tenant": tenant, "vault": VaultName(tenant)})
+		if err := Save(tenant, c); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return Load(tenant)
+}
+
+// Save encrypts the credentials of a tenant into the vault
+func Save(tenant string, c *Credentials) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	doc, err := toml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if _, err := utils.EncryptContent(string(doc), VaultName(tenant), ""); err != nil {
+		return fmt.Errorf("failed to save credentials of tenant %s: %w", tenant, err)
+	}
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if modTime, err := utils.SecretModTime(VaultName(tenant)); err == nil {
+		cache[tenant] = cached{credentials: c, modTime: modTime}
+	}
+	return nil
+}
+
+// Current returns the passwords in use without the ones of a rotation
+func (c *Credentials) Current() *Credentials {
+	return &Credentials{Root: c.Root, App: c.App, Replication: c.Replication}
+}
+
+// Parse reads credentials saved by Save
+func Parse(doc []byte) (*Credentials, error) {
+	c := &Credentials{}
+	if err := toml.Unmarshal(doc, c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate checks that the passwords are set and alphanumeric
+func (c *Credentials) Validate() error {
+	for name, password := range map[string]string{"root": c.Root, "app": c.App, "replication": c.Replication} {
+		if password == "" {
+			return fmt.Errorf("%s password is empty", name)
+		}
+		for _, r := range password {
+			if !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') {
+				return fmt.Errorf("%s password must be alphanumeric", name)
+			}
+		}
+	}
+	if c.Next != nil && c.Previous != nil {
+		return fmt.Errorf("a rotation cannot start before the previous credentials are discarded")
+	}
+	for name, other := range map[string]*Credentials{"next": c.Next, "previous": c.Previous} {
+		if other == nil {
+			continue
+		}
+		if other.Next != nil || other.Previous != nil {
+			return fmt.Errorf("%s credentials must not have next or previous credentials", name)
+		}
+		if err := other.Validate(); err != nil {
+			return fmt.Errorf("%s credentials: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// fromEnv returns the credentials set in the environment, the app and
+// replication passwords default to the root password
+func fromEnv() *Credentials {
+	root := os.Getenv(RootPasswordEnv)
+	if root == "" {
+		return nil
+	}
+	c := &Credentials{Root: root, App: os.Getenv(AppPasswordEnv), Replication: os.Getenv(ReplicationPasswordEnv)}
+	if c.App == "" {
+		c.App = root
+	}
+	if c.Replication == "" {
+		c.Replication = root
+	}
+	return c
+}
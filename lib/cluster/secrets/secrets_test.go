@@ -0,0 +1,90 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package secrets
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	toml "github.com/pelletier/go-toml/v2"
+)
+
+// TestGenerate tests that generated passwords are long, alphanumeric and distinct
+func TestGenerate(t *testing.T) {
+	c, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	seen := map[string]bool{}
+	for _, p := range []string{c.Root, c.App, c.Replication} {
+		if len(p) != passwordLength {
+			t.Errorf("len(%q) = %d, want %d", p, len(p), passwordLength)
+		}
+		if seen[p] {
+			t.Errorf("password %q generated twice", p)
+		}
+		seen[p] = true
+	}
+}
+
+// TestParse tests reading saved credentials
+func TestParse(t *testing.T) {
+	rotating := &Credentials{Root: "r2", App: "a2", Replication: "p2", Previous: &Credentials{Root: "r1", App: "a1", Replication: "p1"}}
+	tests := []struct {
+		name    string
+		c       *Credentials
+		wantErr bool
+	}{
+		{name: "current", c: &Credentials{Root: "r", App: "a", Replication: "p"}},
+		{name: "rotating", c: rotating},
+		{name: "pending", c: &Credentials{Root: "r1", App: "a1", Replication: "p1", Next: rotating.Current()}},
+		{name: "pending and previous", c: &Credentials{Root: "r", App: "a", Replication: "p", Next: rotating.Current(),
+			Previous: rotating.Previous}, wantErr: true},
+		{name: "empty", c: &Credentials{Root: "r", App: "a"}, wantErr: true},
+		{name: "quote", c: &Credentials{Root: "r'", App: "a", Replication: "p"}, wantErr: true},
+		{name: "nested previous", c: &Credentials{Root: "r", App: "a", Replication: "p", Previous: rotating}, wantErr: true},
+		{name: "nested next", c: &Credentials{Root: "r", App: "a", Replication: "p", Next: rotating}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := toml.Marshal(tt.c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Parse(doc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tt.c, got); diff != "" {
+				t.Errorf("Parse() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestLoadFromEnv tests that the environment overrides the vault
+func TestLoadFromEnv(t *testing.T) {
+	t.Setenv(RootPasswordEnv, "root")
+	t.Setenv(ReplicationPasswordEnv, "repl")
+	got, err := Load("missing-tenant")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	want := &Credentials{Root: "root", App: "root", Replication: "repl"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Load() mismatch (-want +got):\n%s", diff)
+	}
+
+	t.Setenv(RootPasswordEnv, "not safe")
+	if _, err := Load("missing-tenant"); err == nil {
+		t.Errorf("Load() accepted a password that is not alphanumeric")
+	}
+}
@@ -196,6 +196,13 @@ func (c *Context) SendError(msg string) error {
 	})
 }
 
+// SendNotFoundError implements http.Context.
+func (c *Context) SendNotFoundError(msg string) error {
+	return c.JSON(nethttp.StatusNotFound, map[string]any{
+		"error": msg,
+	})
+}
+
 // SendInternalError implements http.Context.
 func (c *Context) SendInternalError(msg string, err error) error {
 	logger.Error(msg, err)
@@ -221,6 +228,11 @@ func (c *Context) SendUnauthorizedError() error {
 	return c.String(nethttp.StatusUnauthorized, "Unauthorized")
 }
 
+// SendForbiddenError implements http.Context.
+func (c *Context) SendForbiddenError(response any) error {
+	return c.JSON(nethttp.StatusForbidden, response)
+}
+
 func NewContext(c echo.Context) http.Context {
 	return &Context{
 		c,
@@ -0,0 +1,417 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package tables
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultPageSize = 100
+const maxPageSize = 1000
+
+// PropertiesTTL is how long a collection keeps the generated columns it read
+const PropertiesTTL = time.Minute
+
+// ErrDocumentNotFound is returned when no document has the requested id
+var ErrDocumentNotFound = errors.New("document not found")
+
+// ErrCollectionNotFound is returned when the table of a collection does not
+// exist
+var ErrCollectionNotFound = errors.New("collection not found")
+
+// ErrInvalidDocument is returned for documents and patches that are not JSON
+// objects
+var ErrInvalidDocument = errors.New("document must be a JSON object")
+
+// ErrInvalidQuery is returned for malformed filters, limits and cursors
+var ErrInvalidQuery = errors.New("invalid query")
+
+var collectionNameRe = regexp.MustCompile(`^[A-Za-z0-9_$]+$`)
+
+// filterOps maps the filter operators to SQL
+var filterOps = map[string]string{
+	"eq":   "=",
+	"ne":   "<>",
+	"lt":   "<",
+	"lte":  "<=",
+	"gt":   ">",
+	"gte":  ">=",
+	"like": "LIKE",
+}
+
+// Document is a row of a document table
+type Document struct {
+	ID   uint64          `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Filter compares the value at a JSON path of the documents
+type Filter struct {
+	Path  string `json:"path"`
+	Op    string `json:"op"`
+	Value any    `json:"value"`
+}
+
+// Query selects a page of documents matching all the filters
+type Query struct {
+	Filters []Filter `json:"filters"`
+	Limit   int      `json:"limit"`
+	// Cursor is the Next value of the previous page
+	Cursor string `json:"cursor"`
+}
+
+// Page is a page of documents ordered by id
+type Page struct {
+	Documents []Document `json:"documents"`
+	// Next is the cursor of the next page, empty on the last page
+	Next string `json:"next,omitempty"`
+}
+
+// Collection stores JSON documents in a table with an id primary key and a
+// JSON data column, as created by gen table. Every operation is routed to the
+// shard that owns its key. Ids are generated on each shard, so documents do
+// not move when shards are added and writes to a moving key fail with
+// ErrKeyMoving.
+type Collection struct {
+	Connector *MultiDBConnector
+	Database  string
+	Table     string
+
+	mu sync.Mutex
+	// properties maps the JSON paths extracted by generated columns to the
+	// columns, it is nil until loaded
+	properties         map[string]string
+	propertiesLoadedAt time.Time
+}
+
+// NewCollection creates a collection over a document table
+func NewCollection(connector *MultiDBConnector, database, table string) (*Collection, error) {
+	if !collectionNameRe.MatchString(database) {
+		return nil, fmt.Errorf("invalid database name %q", database)
+	}
+	if !collectionNameRe.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q", table)
+	}
+	return &Collection{Connector: connector, Database: database, Table: table}, nil
+}
+
+func (c *Collection) qualified() string {
+	return fmt.Sprintf("`%s`.`%s`", c.Database, c.Table)
+}
+
+// Check returns ErrCollectionNotFound if the table does not exist on the
+// shard of the key
+func (c *Collection) Check(ctx context.Context, key string) error {
+	found := false
+	err := c.Connector.ReadByKey(ctx, key, func(db *sql.DB) error {
+		var n int
+		err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM INFORMATION_SCHEMA.TABLES
+			WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?`, c.Database, c.Table).Scan(&n)
+		found = n > 0
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check %s.%s: %w", c.Database, c.Table, err)
+	}
+	if !found {
+		return fmt.Errorf("%w: %s.%s", ErrCollectionNotFound, c.Database, c.Table)
+	}
+	return nil
+}
+
+// Insert stores a JSON object as a new document and returns its id
+func (c *Collection) Insert(ctx context.Context, key string, data json.RawMessage) (uint64, error) {
+	if err := requireObject(data); err != nil {
+		return 0, err
+	}
+	var id uint64
+	err := c.Connector.WriteByKeyInPlace(ctx, key, func(db *sql.DB) error {
+		res, err := db.ExecContext(ctx, "INSERT INTO "+c.qualified()+" (`data`) VALUES (?)", string(data))
+		if err != nil {
+			// a retried insert would store the document twice
+			return retryUnsent(err)
+		}
+		lastID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		id = uint64(lastID) // #nosec G115
+		return nil
+	})
+	return id, err
+}
+
+// Get returns the document with the id, ErrDocumentNotFound if there is none
+func (c *Collection) Get(ctx context.Context, key string, id uint64) (*Document, error) {
+	var doc *Document
+	err := c.Connector.ReadByKey(ctx, key, func(db *sql.DB) error {
+		var data []byte
+		err := db.QueryRowContext(ctx, "SELECT `data` FROM "+c.qualified()+" WHERE `id` = ?", id).Scan(&data)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		doc = &Document{ID: id, Data: data}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, ErrDocumentNotFound
+	}
+	return doc, nil
+}
+
+// Patch applies a JSON merge patch (RFC 7396) to a document and returns the
+// patched document
+func (c *Collection) Patch(ctx context.Context, key string, id uint64, patch json.RawMessage) (*Document, error) {
+	if err := requireObject(patch); err != nil {
+		return nil, err
+	}
+	var doc *Document
+	err := c.Connector.WriteByKeyInPlace(ctx, key, func(db *sql.DB) error {
+		doc = nil
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback() //nolint:errcheck
+		_, err = tx.ExecContext(ctx, "UPDATE "+c.qualified()+" SET `data` = JSON_MERGE_PATCH(`data`, ?) WHERE `id` = ?",
+			string(patch), id)
+		if err != nil {
+			return err
+		}
+		// a patch that changes nothing affects no rows, so existence is
+		// checked by reading the document back on the primary
+		var data []byte
+		err = tx.QueryRowContext(ctx, "SELECT `data` FROM "+c.qualified()+" WHERE `id` = ?", id).Scan(&data)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		doc = &Document{ID: id, Data: data}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, ErrDocumentNotFound
+	}
+	return doc, nil
+}
+
+// Delete removes a document, ErrDocumentNotFound if there is none
+func (c *Collection) Delete(ctx context.Context, key string, id uint64) error {
+	deleted := false
+	err := c.Connector.WriteByKeyInPlace(ctx, key, func(db *sql.DB) error {
+		res, err := db.ExecContext(ctx, "DELETE FROM "+c.qualified()+" WHERE `id` = ?", id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		deleted = deleted || n > 0
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrDocumentNotFound
+	}
+	return nil
+}
+
+// Find returns a page of the documents matching the query. Filters on a path
+// extracted by a generated column compare the column so its index is used.
+func (c *Collection) Find(ctx context.Context, key string, q Query) (*Page, error) {
+	properties, err := c.loadProperties(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	query, args, limit, err := c.findSQL(q, properties)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidQuery, err)
+	}
+	page := &Page{Documents: []Document{}}
+	err = c.Connector.ReadByKey(ctx, key, func(db *sql.DB) error {
+		page.Documents = page.Documents[:0]
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var doc Document
+			var data []byte
+			if err := rows.Scan(&doc.ID, &data); err != nil {
+				return err
+			}
+			doc.Data = data
+			page.Documents = append(page.Documents, doc)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Documents) > limit {
+		page.Documents = page.Documents[:limit]
+		page.Next = encodeCursor(page.Documents[limit-1].ID)
+	}
+	return page, nil
+}
+
+// findSQL builds the SELECT of a query, it fetches one extra row to tell
+// whether there is a next page
+func (c *Collection) findSQL(q Query, properties map[string]string) (query string, args []any, limit int, err error) {
+	limit = q.Limit
+	switch {
+	case limit <= 0:
+		limit = defaultPageSize
+	case limit > maxPageSize:
+		return "", nil, 0, fmt.Errorf("limit %d exceeds %d", limit, maxPageSize)
+	}
+	var where []string
+	if q.Cursor != "" {
+		after, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		where = append(where, "`id` > ?")
+		args = append(args, after)
+	}
+	for _, f := range q.Filters {
+		op, ok := filterOps[strings.ToLower(f.Op)]
+		if !ok {
+			return "", nil, 0, fmt.Errorf("unsupported filter operator %q", f.Op)
+		}
+		expr, err := filterExpr(f.Path, properties)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		where = append(where, fmt.Sprintf("%s %s ?", expr, op))
+		args = append(args, f.Value)
+	}
+	query = "SELECT `id`, `data` FROM " + c.qualified()
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY `id` LIMIT ?"
+	args = append(args, limit+1)
+	return query, args, limit, nil
+}
+
+// filterExpr returns the SQL expression of the value at a JSON path
+func filterExpr(path string, properties map[string]string) (string, error) {
+	elements, err := ParseJSONPath(path)
+	if err != nil {
+		return "", err
+	}
+	if len(elements) == 0 {
+		return "", fmt.Errorf("cannot filter on the whole document")
+	}
+	canonical := FormatJSONPath(elements)
+	if column, ok := properties[canonical]; ok {
+		return fmt.Sprintf("`%s`", column), nil
+	}
+	// the path is inlined as ->> only takes a literal
+	if strings.ContainsAny(canonical, `'\`) {
+		return "", fmt.Errorf("JSON path %q must not contain quotes or backslashes", path)
+	}
+	return fmt.Sprintf("`data`->>'%s'", canonical), nil
+}
+
+// loadProperties reads the generated columns of the table that extract a
+// path of the data column, again once they are older than PropertiesTTL
+func (c *Collection) loadProperties(ctx context.Context, key string) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.properties != nil && time.Since(c.propertiesLoadedAt) < PropertiesTTL {
+		return c.properties, nil
+	}
+	properties := map[string]string{}
+	err := c.Connector.ReadByKey(ctx, key, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, `SELECT COLUMN_NAME, GENERATION_EXPRESSION FROM INFORMATION_SCHEMA.COLUMNS
+			WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND GENERATION_EXPRESSION <> ''`, c.Database, c.Table)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var name, expr string
+			if err := rows.Scan(&name, &expr); err != nil {
+				return err
+			}
+			elements, err := ParseJSONPath(GeneratedJSONPath(expr))
+			if err != nil || len(elements) == 0 {
+				continue
+			}
+			properties[FormatJSONPath(elements)] = name
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the properties of %s.%s: %w", c.Database, c.Table, err)
+	}
+	c.properties = properties
+	c.propertiesLoadedAt = time.Now()
+	return properties, nil
+}
+
+// ParseFilterValue converts the text of a filter value to a number when it is
+// one, so documents compare numerically
+func ParseFilterValue(s string) any {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+func requireObject(data json.RawMessage) error {
+	var object map[string]any
+	if err := json.Unmarshal(data, &object); err != nil || object == nil {
+		return ErrInvalidDocument
+	}
+	return nil
+}
+
+func encodeCursor(id uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(id, 10)))
+}
+
+func decodeCursor(cursor string) (uint64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		if id, err := strconv.ParseUint(string(b), 10, 64); err == nil {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid cursor %q", cursor)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package tables
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestFindSQL tests translating document queries to SQL
+func TestFindSQL(t *testing.T) {
+	c, err := NewCollection(nil, "shop", "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	properties := map[string]string{"$.email": "email"}
+	tests := []struct {
+		name      string
+		query     Query
+		wantSQL   string
+		wantArgs  []any
+		wantLimit int
+		wantErr   bool
+	}{
+		{
+			name:      "all",
+			query:     Query{},
+			wantSQL:   "SELECT `id`, `data` FROM `shop`.`users` ORDER BY `id` LIMIT ?",
+			wantArgs:  []any{101},
+			wantLimit: 100,
+		},
+		{
+			name: "filters and cursor",
+			query: Query{
+				Filters: []Filter{
+					{Path: `$."email"`, Op: "eq", Value: "a@b.c"},
+					{Path: "$.address.zip", Op: "GTE", Value: int64(1000)},
+				},
+				Limit:  10,
+				Cursor: encodeCursor(42),
+			},
+			wantSQL: "SELECT `id`, `data` FROM `shop`.`users` WHERE `id` > ? AND `email` = ? AND " +
+				"`data`->>'$.address.zip' >= ? ORDER BY `id` LIMIT ?",
+			wantArgs:  []any{uint64(42), "a@b.c", int64(1000), 11},
+			wantLimit: 10,
+		},
+		{
+			name:      "quoted member",
+			query:     Query{Filters: []Filter{{Path: `$."first name"`, Op: "like", Value: "A%"}}},
+			wantSQL:   "SELECT `id`, `data` FROM `shop`.`users` WHERE `data`->>'$.\"first name\"' LIKE ? ORDER BY `id` LIMIT ?",
+			wantArgs:  []any{"A%", 101},
+			wantLimit: 100,
+		},
+		{name: "quote in path", query: Query{Filters: []Filter{{Path: `$."it's"`, Op: "eq"}}}, wantErr: true},
+		{name: "whole document", query: Query{Filters: []Filter{{Path: "$", Op: "eq"}}}, wantErr: true},
+		{name: "unknown operator", query: Query{Filters: []Filter{{Path: "$.a", Op: "in"}}}, wantErr: true},
+		{name: "bad cursor", query: Query{Cursor: "-1"}, wantErr: true},
+		{name: "limit too large", query: Query{Limit: maxPageSize + 1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, limit, err := c.findSQL(tt.query, properties)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("findSQL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if query != tt.wantSQL {
+				t.Errorf("findSQL() query = %q, want %q", query, tt.wantSQL)
+			}
+			if diff := cmp.Diff(tt.wantArgs, args); diff != "" {
+				t.Errorf("findSQL() args mismatch (-want +got):\n%s", diff)
+			}
+			if limit != tt.wantLimit {
+				t.Errorf("findSQL() limit = %d, want %d", limit, tt.wantLimit)
+			}
+		})
+	}
+}
+
+// TestNewCollection tests rejecting names that would need quoting
+func TestNewCollection(t *testing.T) {
+	if _, err := NewCollection(nil, "shop", "users`; DROP"); err == nil {
+		t.Error("table name with a backtick must fail")
+	}
+	if _, err := NewCollection(nil, "", "users"); err == nil {
+		t.Error("empty database name must fail")
+	}
+}
+
+// TestParseFilterValue tests converting filter values
+func TestParseFilterValue(t *testing.T) {
+	for input, want := range map[string]any{"12": int64(12), "-1.5": -1.5, "a@b.c": "a@b.c", "": ""} {
+		if got := ParseFilterValue(input); got != want {
+			t.Errorf("ParseFilterValue(%q) = %#v, want %#v", input, got, want)
+		}
+	}
+}
@@ -7,16 +7,18 @@ package tables
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"net"
 	"reflect"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/cenkalti/backoff"
 	"github.com/evgnomon/zygote/lib/cluster/cert"
 	"github.com/evgnomon/zygote/lib/cluster/http"
+	"github.com/evgnomon/zygote/lib/cluster/secrets"
 	"github.com/evgnomon/zygote/lib/cluster/utils"
 	"github.com/go-sql-driver/mysql"
 )
@@ -73,10 +75,23 @@ type MultiDBConnector struct {
 	tenant          string
 	databsae        string
 	numShards       int
+	router          ShardRouter
+	nextRouter      ShardRouter
+	shardMapVersion uint64
+	// id names the connector in the shard map acknowledgements
+	id string
+	// shardMapLoadedAt is when the shared shard map was last loaded
+	shardMapLoadedAt time.Time
+	// reloadMutex serializes reloads of the shared shard map
+	reloadMutex sync.Mutex
 }
 
 // NewMultiDBConnector creates a new multi-connection manager
 func NewMultiDBConnector(network, tenant, baseHost, database string, targetReadPort, targetWritePort, numShards int) *MultiDBConnector {
+	var router ShardRouter
+	if hashRouter, err := NewHashRouter(numShards); err == nil {
+		router = hashRouter
+	}
 	return &MultiDBConnector{
 		configs:         make(map[int]*ClientConfig),
 		readConns:       make(map[int]*sql.DB),
@@ -88,6 +103,8 @@ func NewMultiDBConnector(network, tenant, baseHost, database string, targetReadP
 		tenant:          tenant,
 		databsae:        database,
 		numShards:       numShards,
+		router:          router,
+		id:              newConnectorID(),
 	}
 }
 
@@ -95,7 +112,7 @@ func NewMultiDBConnector(network, tenant, baseHost, database string, targetReadP
 func NewClientConfig(targetReadPort, targetWritePort int) *ClientConfig {
 	return &ClientConfig{
 		User:            "root",
-		Password:        "root1234",
+		Password:        "",
 		Host:            "127.0.0.1",
 		ReadPort:        targetReadPort,
 		WritePort:       targetWritePort,
@@ -128,8 +145,15 @@ func SQLEndpoints(network, domain string, numShards,
 	return endpoints, nil
 }
 
-// AddConfig adds a new database configuration
+// AddConfig adds a new database configuration. With an empty password the
+// root password of the tenant is loaded for every new connection, so that
+// connections opened after a rotation use the new one.
 func (m *MultiDBConnector) AddConfig(shardIndex int, config *ClientConfig) error {
+	if config.Password == "" {
+		if _, err := secrets.Load(m.tenant); err != nil {
+			return fmt.Errorf("failed to load SQL credentials: %w", err)
+		}
+	}
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
@@ -202,7 +226,7 @@ func (m *MultiDBConnector) connect(ctx context.Context, shardIndex int, connType
 		)
 
 		// Create connection
-		db, err = sql.Open("mysql", dsn)
+		db, err = m.open(dsn, config.Password == "")
 		if err != nil {
 			return fmt.Errorf("failed to connect to shard %d %s: %v", shardIndex, connType, err)
 		}
@@ -225,6 +249,33 @@ func (m *MultiDBConnector) connect(ctx context.Context, shardIndex int, connType
 	return db, err
 }
 
+// open creates a connection pool for a DSN, loading the password of the
+// tenant on each new connection if tenantPassword is set
+func (m *MultiDBConnector) open(dsn string, tenantPassword bool) (*sql.DB, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if tenantPassword {
+		err = cfg.Apply(mysql.BeforeConnect(func(_ context.Context, cfg *mysql.Config) error {
+			c, err := secrets.Load(m.tenant)
+			if err != nil {
+				return fmt.Errorf("failed to load SQL credentials: %w", err)
+			}
+			cfg.Passwd = c.Root
+			return nil
+		}))
+		if err != nil {
+			return nil, err
+		}
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(connector), nil
+}
+
 // ConnectRead establishes a read connection for a shard
 func (m *MultiDBConnector) ConnectRead(ctx context.Context, shardIndex int) (*sql.DB, error) {
 	return m.connect(ctx, shardIndex, readConn)
@@ -237,7 +288,7 @@ func (m *MultiDBConnector) ConnectWrite(ctx context.Context, shardIndex int) (*s
 
 // ConnectAllShardsRead connects to all shards for read in parallel
 func (m *MultiDBConnector) ConnectAllShardsRead(ctx context.Context) (map[int]*sql.DB, error) {
-	endpoints, err := SQLEndpoints(m.network, m.domain, m.numShards, m.taregtReadPort, m.targetWritePort)
+	endpoints, err := SQLEndpoints(m.network, m.domain, m.NumShards(), m.taregtReadPort, m.targetWritePort)
 	if err != nil {
 		return nil, fmt.Errorf("failed to calculate shard endpoints: %v", err)
 	}
@@ -294,7 +345,7 @@ func (m *MultiDBConnector) ConnectAllShardsRead(ctx context.Context) (map[int]*s
 
 // ConnectAllShardsWrite connects to all shards for write in parallel
 func (m *MultiDBConnector) ConnectAllShardsWrite(ctx context.Context) (map[int]*sql.DB, error) {
-	endpoints, err := SQLEndpoints(m.network, m.domain, m.numShards, m.taregtReadPort, m.targetWritePort)
+	endpoints, err := SQLEndpoints(m.network, m.domain, m.NumShards(), m.taregtReadPort, m.targetWritePort)
 	if err != nil {
 		return nil, fmt.Errorf("failed to calculate shard endpoints: %v", err)
 	}
@@ -449,6 +500,22 @@ func isTransientError(err error) bool {
 		strings.Contains(strings.ToLower(err.Error()), "network")
 }
 
+// notSent tells whether a write failed before it reached the server. The
+// driver reports driver.ErrBadConn only when nothing was written.
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.Is(err, driver.ErrBadConn) || (errors.As(err, &opErr) && opErr.Op == "dial")
+}
+
+// retryUnsent keeps the error of a write retryable only when the write never
+// reached the server, so a statement that is not idempotent runs at most once
+func retryUnsent(err error) error {
+	if err == nil || notSent(err) {
+		return err
+	}
+	return utils.Permanent(err)
+}
+
 // RetryOperation executes a database operation (read or write) with retries and backoff
 func (m *MultiDBConnector) RetryOperation(ctx context.Context, shardIndex int, operation func(*sql.DB) error, isWrite bool) error {
 	var db *sql.DB
@@ -483,20 +550,36 @@ func (m *MultiDBConnector) RetryOperation(ctx context.Context, shardIndex int, o
 			if isWrite {
 				opType = "write"
 			}
-			return backoff.Permanent(fmt.Errorf("%s operation failed for shard %d: %v", opType, shardIndex, err))
+			return utils.Permanent(fmt.Errorf("%s operation failed for shard %d: %v", opType, shardIndex, err))
 		}
 		return nil
 	})
 }
 
-// RetryReadOperation executes a read operation with retries and backoff
-func (m *MultiDBConnector) RetryReadOperation(ctx context.Context, shardIndex int, operation func(*sql.DB) error) error {
+// RetryReadOperation executes a read operation with retries and backoff. By
+// default reads are eventually consistent, WithReadYourWrites makes them
+// observe the earlier writes of a session. WithDatabase runs the operation on
+// another database.
+func (m *MultiDBConnector) RetryReadOperation(ctx context.Context, shardIndex int, operation func(*sql.DB) error,
+	opts ...OperationOption) error {
+	o := newOperationOptions(opts)
+	operation = m.inDatabase(ctx, shardIndex, o.database, operation)
+	if o.session != nil {
+		return m.consistentRead(ctx, shardIndex, operation, o.session)
+	}
 	return m.RetryOperation(ctx, shardIndex, operation, false)
 }
 
-// RetryWriteOperation executes a write operation with retries and backoff
-func (m *MultiDBConnector) RetryWriteOperation(ctx context.Context, shardIndex int, operation func(*sql.DB) error) error {
-	return m.RetryOperation(ctx, shardIndex, operation, true)
+// RetryWriteOperation executes a write operation with retries and backoff.
+// WithReadYourWrites records the GTIDs of the write in a session.
+func (m *MultiDBConnector) RetryWriteOperation(ctx context.Context, shardIndex int, operation func(*sql.DB) error,
+	opts ...OperationOption) error {
+	o := newOperationOptions(opts)
+	err := m.RetryOperation(ctx, shardIndex, m.inDatabase(ctx, shardIndex, o.database, operation), true)
+	if err == nil && o.session != nil {
+		m.captureGTIDs(ctx, shardIndex, o.session)
+	}
+	return err
 }
 
 // GenericQueryHandler handles SQL queries with a provided query and struct type
@@ -0,0 +1,272 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package tables
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+)
+
+const defaultGTIDWaitTimeout = time.Second
+
+// errSwitchBack discards a connection that is left on another database
+var errSwitchBack = fmt.Errorf("failed to switch back the database: %w", driver.ErrBadConn)
+
+// Session tracks the writes of a client so that its later reads observe them.
+// It is safe for concurrent use.
+type Session struct {
+	// WaitTimeout bounds how long a read waits for a secondary to apply the
+	// writes of the session before it is sent to the primary
+	WaitTimeout time.Duration
+
+	mu     sync.Mutex
+	shards map[int]sessionShard
+}
+
+type sessionShard struct {
+	gtidSet string
+	// primary is set when the GTIDs of a write could not be captured, reads
+	// then go to the primary
+	primary bool
+}
+
+// NewSession creates a session, a zero timeout waits one second
+func NewSession(waitTimeout time.Duration) *Session {
+	if waitTimeout <= 0 {
+		waitTimeout = defaultGTIDWaitTimeout
+	}
+	return &Session{WaitTimeout: waitTimeout, shards: map[int]sessionShard{}}
+}
+
+func (s *Session) set(shardIndex int, state sessionShard) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shards == nil {
+		s.shards = map[int]sessionShard{}
+	}
+	s.shards[shardIndex] = state
+}
+
+func (s *Session) get(shardIndex int) (sessionShard, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.shards[shardIndex]
+	return state, ok
+}
+
+// GTIDSet returns the GTID set a read from the shard waits for
+func (s *Session) GTIDSet(shardIndex int) string {
+	state, _ := s.get(shardIndex)
+	return state.gtidSet
+}
+
+// OperationOption configures the consistency or the database of a retried
+// operation
+type OperationOption func(*operationOptions)
+
+type operationOptions struct {
+	session  *Session
+	database string
+}
+
+// WithReadYourWrites makes writes record their GTIDs in the session and reads
+// wait until the secondary serving them has applied those GTIDs. Reads fall
+// back to the primary when the secondary does not catch up within the wait
+// timeout of the session.
+func WithReadYourWrites(session *Session) OperationOption {
+	return func(o *operationOptions) {
+		o.session = session
+	}
+}
+
+// WithDatabase runs the operation with another default database than the
+// one of the connection
+func WithDatabase(database string) OperationOption {
+	return func(o *operationOptions) {
+		o.database = database
+	}
+}
+
+func newOperationOptions(opts []OperationOption) *operationOptions {
+	o := &operationOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// captureGTIDs records the transactions executed by the primary of the shard
+// after a write of the session
+func (m *MultiDBConnector) captureGTIDs(ctx context.Context, shardIndex int, session *Session) {
+	db, err := m.GetWriteConnection(shardIndex)
+	var gtidSet string
+	if err == nil {
+		err = db.QueryRowContext(ctx, "SELECT @@GLOBAL.gtid_executed").Scan(&gtidSet)
+	}
+	if err != nil {
+		logger.Warning("Failed to capture GTIDs, reads of the session go to the primary", utils.M{"shard": shardIndex, "error": err})
+		session.set(shardIndex, sessionShard{primary: true})
+		return
+	}
+	session.set(shardIndex, sessionShard{gtidSet: gtidSet})
+}
+
+// consistentRead runs a read of a session on a secondary that has applied the
+// writes of the session, or on the primary if none catches up in time
+func (m *MultiDBConnector) consistentRead(ctx context.Context, shardIndex int, operation func(*sql.DB) error, session *Session) error {
+	state, ok := session.get(shardIndex)
+	if !ok {
+		return m.RetryOperation(ctx, shardIndex, operation, false)
+	}
+	if state.primary {
+		return m.RetryOperation(ctx, shardIndex, operation, true)
+	}
+	caughtUp := false
+	err := m.RetryOperation(ctx, shardIndex, func(db *sql.DB) error {
+		// the read only port balances connections, so waiting and reading
+		// must share one connection
+		return withPinnedConnection(ctx, db, func(pinned *sql.DB) error {
+			var timedOut int
+			err := pinned.QueryRowContext(ctx, "SELECT WAIT_FOR_EXECUTED_GTID_SET(?, ?)",
+				state.gtidSet, session.WaitTimeout.Seconds()).Scan(&timedOut)
+			if err != nil {
+				logger.Debug("GTID wait failed", utils.M{"shard": shardIndex, "error": err})
+				return nil
+			}
+			if timedOut != 0 {
+				return nil
+			}
+			caughtUp = true
+			return operation(pinned)
+		})
+	}, false)
+	if err != nil || caughtUp {
+		return err
+	}
+	logger.Debug("Secondary is behind the session, reading from the primary", utils.M{"shard": shardIndex})
+	return m.RetryOperation(ctx, shardIndex, operation, true)
+}
+
+// inDatabase wraps an operation so that it runs on one connection switched to
+// a database, the connection is switched back before it returns to the pool
+func (m *MultiDBConnector) inDatabase(ctx context.Context, shardIndex int, database string,
+	operation func(*sql.DB) error) func(*sql.DB) error {
+	if database == "" {
+		return operation
+	}
+	m.mutex.RLock()
+	original := m.databsae
+	if config, ok := m.configs[shardIndex]; ok {
+		original = config.Database
+	}
+	m.mutex.RUnlock()
+	return func(db *sql.DB) error {
+		var opErr error
+		err := withPinnedConnection(ctx, db, func(pinned *sql.DB) error {
+			if _, err := pinned.ExecContext(ctx, "USE "+quoteIdent(database)); err != nil {
+				return err
+			}
+			opErr = operation(pinned)
+			if _, err := pinned.ExecContext(context.WithoutCancel(ctx), "USE "+quoteIdent(original)); err != nil {
+				return errSwitchBack
+			}
+			return opErr
+		})
+		if errors.Is(err, errSwitchBack) {
+			return opErr
+		}
+		return err
+	}
+}
+
+func quoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// withPinnedConnection runs f with a pool that holds a single connection of db
+func withPinnedConnection(ctx context.Context, db *sql.DB, f func(*sql.DB) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn any) error {
+		dc, ok := driverConn.(driver.Conn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		pinned := sql.OpenDB(pinnedConnector{conn: &pinnedConn{Conn: dc}, driver: db.Driver()})
+		pinned.SetMaxOpenConns(1)
+		defer pinned.Close()
+		return f(pinned)
+	})
+}
+
+// pinnedConnector hands out the same connection every time
+type pinnedConnector struct {
+	conn   *pinnedConn
+	driver driver.Driver
+}
+
+func (c pinnedConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.conn, nil
+}
+
+func (c pinnedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// pinnedConn borrows a connection of another pool, it is never closed by the
+// pinned pool and forwards the optional driver interfaces
+type pinnedConn struct {
+	driver.Conn
+}
+
+func (c *pinnedConn) Close() error {
+	return nil
+}
+
+func (c *pinnedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if q, ok := c.Conn.(driver.QueryerContext); ok {
+		return q.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *pinnedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if e, ok := c.Conn.(driver.ExecerContext); ok {
+		return e.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *pinnedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Prepare(query)
+}
+
+func (c *pinnedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Begin() //nolint:staticcheck
+}
+
+func (c *pinnedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
@@ -0,0 +1,170 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package tables
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer answers the statements of the consistency protocol
+type fakeServer struct {
+	name     string
+	gtidSet  string
+	lagging  bool
+	waitedOn []string
+	database string
+}
+
+type fakeConn struct {
+	server   *fakeServer
+	waited   bool
+	database string
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, fmt.Errorf("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return nil, fmt.Errorf("not supported") }
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	switch {
+	case query == "SELECT DATABASE()":
+		return &fakeRows{values: []driver.Value{c.database}}, nil
+	case strings.Contains(query, "gtid_executed"):
+		return &fakeRows{values: []driver.Value{c.server.gtidSet}}, nil
+	case strings.Contains(query, "WAIT_FOR_EXECUTED_GTID_SET"):
+		c.server.waitedOn = append(c.server.waitedOn, args[0].Value.(string))
+		if c.server.lagging {
+			return &fakeRows{values: []driver.Value{int64(1)}}, nil
+		}
+		c.waited = true
+		return &fakeRows{values: []driver.Value{int64(0)}}, nil
+	}
+	// the source query tells which server and whether the connection waited
+	// right before it
+	source := fmt.Sprintf("%s waited=%v", c.server.name, c.waited)
+	c.waited = false
+	return &fakeRows{values: []driver.Value{source}}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	name, ok := strings.CutPrefix(query, "USE ")
+	if !ok {
+		return nil, fmt.Errorf("unexpected statement %q", query)
+	}
+	c.database = strings.ReplaceAll(strings.Trim(name, "`"), "``", "`")
+	return driver.RowsAffected(0), nil
+}
+
+type fakeRows struct {
+	values []driver.Value
+	done   bool
+}
+
+func (r *fakeRows) Columns() []string { return []string{"v"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.values)
+	return nil
+}
+
+type fakeConnector struct {
+	server *fakeServer
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{server: c.server, database: c.server.database}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver { return nil }
+
+// TestReadYourWrites tests that session reads wait for the GTIDs of earlier
+// writes on the same connection and fall back to the primary
+func TestReadYourWrites(t *testing.T) {
+	primary := &fakeServer{name: "primary", gtidSet: "uuid:1-7"}
+	secondary := &fakeServer{name: "secondary"}
+	m := NewMultiDBConnector("", "", "", "", 0, 0, 1)
+	m.writeConns[0] = sql.OpenDB(fakeConnector{server: primary})
+	m.readConns[0] = sql.OpenDB(fakeConnector{server: secondary})
+	defer m.CloseAll()
+
+	ctx := context.Background()
+	read := func(opts ...OperationOption) string {
+		var source string
+		err := m.RetryReadOperation(ctx, 0, func(db *sql.DB) error {
+			return db.QueryRowContext(ctx, "SELECT source").Scan(&source)
+		}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return source
+	}
+
+	session := NewSession(10 * time.Millisecond)
+	if got := read(WithReadYourWrites(session)); got != "secondary waited=false" {
+		t.Errorf("read before any write = %q, want a plain secondary read", got)
+	}
+
+	err := m.RetryWriteOperation(ctx, 0, func(*sql.DB) error { return nil }, WithReadYourWrites(session))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := session.GTIDSet(0); got != "uuid:1-7" {
+		t.Fatalf("GTIDSet() = %q, want uuid:1-7", got)
+	}
+
+	if got := read(WithReadYourWrites(session)); got != "secondary waited=true" {
+		t.Errorf("read after write = %q, want the waiting secondary connection", got)
+	}
+	if len(secondary.waitedOn) != 1 || secondary.waitedOn[0] != "uuid:1-7" {
+		t.Errorf("waited on %v, want [uuid:1-7]", secondary.waitedOn)
+	}
+
+	secondary.lagging = true
+	if got := read(WithReadYourWrites(session)); got != "primary waited=false" {
+		t.Errorf("read from lagging secondary = %q, want the primary", got)
+	}
+	if got := read(); got != "secondary waited=false" {
+		t.Errorf("eventual read = %q, want a plain secondary read", got)
+	}
+}
+
+// TestWithDatabase tests that an operation runs on another database and
+// leaves the connection on its own database
+func TestWithDatabase(t *testing.T) {
+	primary := &fakeServer{name: "primary", database: "mysql"}
+	m := NewMultiDBConnector("", "", "", "mysql", 0, 0, 1)
+	m.writeConns[0] = sql.OpenDB(fakeConnector{server: primary})
+	m.writeConns[0].SetMaxOpenConns(1)
+	defer m.CloseAll()
+
+	ctx := context.Background()
+	database := func(opts ...OperationOption) string {
+		var name string
+		err := m.RetryWriteOperation(ctx, 0, func(db *sql.DB) error {
+			return db.QueryRowContext(ctx, "SELECT DATABASE()").Scan(&name)
+		}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	if got := database(WithDatabase("shop`s")); got != "shop`s" {
+		t.Errorf("DATABASE() = %q, want shop`s", got)
+	}
+	if got := database(); got != "mysql" {
+		t.Errorf("DATABASE() after the operation = %q, want mysql", got)
+	}
+}
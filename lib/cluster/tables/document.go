@@ -0,0 +1,209 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package tables
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PathElement is a member name or an array index of a JSON path
+type PathElement struct {
+	Key   string
+	Index int
+	// IsIndex is set for array elements
+	IsIndex bool
+}
+
+// ParseJSONPath parses a MySQL JSON path of member and array accesses such as
+// $.address.city, $."first name" or $.tags[0]
+func ParseJSONPath(path string) ([]PathElement, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("JSON path %q must start with $", path)
+	}
+	var elements []PathElement
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			var k string
+			if strings.HasPrefix(rest, `"`) {
+				end := strings.Index(rest[1:], `"`)
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated member name in JSON path %q", path)
+				}
+				k, rest = rest[1:end+1], rest[end+2:]
+			} else {
+				end := strings.IndexAny(rest, ".[")
+				if end < 0 {
+					end = len(rest)
+				}
+				k, rest = rest[:end], rest[end:]
+				if !isPathIdentifier(k) {
+					return nil, fmt.Errorf("invalid member name %q in JSON path %q", k, path)
+				}
+			}
+			if k == "" {
+				return nil, fmt.Errorf("empty member name in JSON path %q", path)
+			}
+			elements = append(elements, PathElement{Key: k})
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated array index in JSON path %q", path)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("invalid array index %q in JSON path %q", rest[1:end], path)
+			}
+			elements = append(elements, PathElement{Index: i, IsIndex: true})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q in JSON path %q", rest[0], path)
+		}
+	}
+	return elements, nil
+}
+
+// FormatJSONPath formats path elements as a MySQL JSON path, quoting the
+// member names that are not identifiers
+func FormatJSONPath(elements []PathElement) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, e := range elements {
+		switch {
+		case e.IsIndex:
+			fmt.Fprintf(&b, "[%d]", e.Index)
+		case isPathIdentifier(e.Key):
+			b.WriteString("." + e.Key)
+		default:
+			b.WriteString(`."` + e.Key + `"`)
+		}
+	}
+	return b.String()
+}
+
+// jsonPathRe matches the path literal of an expression such as
+// json_unquote(json_extract(`data`,_utf8mb4'$.email'))
+var jsonPathRe = regexp.MustCompile(`'(\$[^']*)'`)
+
+// GeneratedJSONPath returns the JSON path a generation expression extracts
+func GeneratedJSONPath(expr string) string {
+	m := jsonPathRe.FindStringSubmatch(expr)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+func isPathIdentifier(s string) bool {
+	for i, r := range s {
+		if r != '_' && r != '$' && !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// lookupPath returns the raw value at the path of a decoded document
+func lookupPath(doc any, elements []PathElement) (any, bool) {
+	v := doc
+	for _, e := range elements {
+		if e.IsIndex {
+			a, ok := v.([]any)
+			if !ok || e.Index >= len(a) {
+				return nil, false
+			}
+			v = a[e.Index]
+			continue
+		}
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[e.Key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// DocValue returns the value at the JSON path of a document converted to T.
+// It returns false when the path is missing, null or not convertible to T.
+func DocValue[T any](doc map[string]any, path string) (T, bool) {
+	var value T
+	elements, err := ParseJSONPath(path)
+	if err != nil {
+		return value, false
+	}
+	raw, ok := lookupPath(doc, elements)
+	if !ok || raw == nil {
+		return value, false
+	}
+	if v, ok := raw.(T); ok {
+		return v, true
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return value, false
+	}
+	// MySQL extracts scalars as text, so numbers stored as strings convert
+	if s, ok := raw.(string); ok {
+		if err := json.Unmarshal([]byte(s), &value); err == nil {
+			return value, true
+		}
+	}
+	if err := json.Unmarshal(b, &value); err != nil {
+		return value, false
+	}
+	return value, true
+}
+
+// SetDocValue sets the value at the JSON path of a document, creating the
+// missing objects on the way. Arrays are not grown.
+func SetDocValue(doc map[string]any, path string, value any) error {
+	elements, err := ParseJSONPath(path)
+	if err != nil {
+		return err
+	}
+	if len(elements) == 0 {
+		return fmt.Errorf("cannot replace the whole document")
+	}
+	var parent any = doc
+	for i, e := range elements {
+		last := i == len(elements)-1
+		if e.IsIndex {
+			a, ok := parent.([]any)
+			if !ok || e.Index >= len(a) {
+				return fmt.Errorf("no array element at %s", path)
+			}
+			if last {
+				a[e.Index] = value
+				return nil
+			}
+			parent = a[e.Index]
+			continue
+		}
+		m, ok := parent.(map[string]any)
+		if !ok {
+			return fmt.Errorf("no object at %s", path)
+		}
+		if last {
+			m[e.Key] = value
+			return nil
+		}
+		next, ok := m[e.Key]
+		if !ok || next == nil {
+			next = map[string]any{}
+			m[e.Key] = next
+		}
+		parent = next
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package tables
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestParseJSONPath tests parsing member and array accesses
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    []PathElement
+		wantErr bool
+	}{
+		{"$", nil, false},
+		{"$.a.b_2", []PathElement{{Key: "a"}, {Key: "b_2"}}, false},
+		{`$."first name".x`, []PathElement{{Key: "first name"}, {Key: "x"}}, false},
+		{"$.tags[1]", []PathElement{{Key: "tags"}, {Index: 1, IsIndex: true}}, false},
+		{"a.b", nil, true},
+		{"$.a'b", nil, true},
+		{"$.", nil, true},
+		{"$.a[x]", nil, true},
+		{`$."open`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := ParseJSONPath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseJSONPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseJSONPath() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestFormatJSONPath tests formatting paths as generated columns store them
+func TestFormatJSONPath(t *testing.T) {
+	for path, want := range map[string]string{
+		"$":                 "$",
+		`$."email"`:         "$.email",
+		`$."first name"[2]`: `$."first name"[2]`,
+		"$.a.b[0].c":        "$.a.b[0].c",
+	} {
+		elements, err := ParseJSONPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := FormatJSONPath(elements); got != want {
+			t.Errorf("FormatJSONPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+	expr := "json_unquote(json_extract(`data`,_utf8mb4'$.email'))"
+	if got := GeneratedJSONPath(expr); got != "$.email" {
+		t.Errorf("GeneratedJSONPath() = %q, want $.email", got)
+	}
+}
+
+// TestDocValue tests reading and writing typed values of a document
+func TestDocValue(t *testing.T) {
+	doc := map[string]any{
+		"name": "box",
+		"size": map[string]any{"width": float64(3)},
+		"tags": []any{"a", "b"},
+		"code": "42",
+	}
+	if v, ok := DocValue[string](doc, "$.name"); !ok || v != "box" {
+		t.Errorf("DocValue(name) = %q, %v", v, ok)
+	}
+	if v, ok := DocValue[int64](doc, "$.size.width"); !ok || v != 3 {
+		t.Errorf("DocValue(size.width) = %d, %v", v, ok)
+	}
+	if v, ok := DocValue[int64](doc, "$.code"); !ok || v != 42 {
+		t.Errorf("DocValue(code) = %d, %v", v, ok)
+	}
+	if v, ok := DocValue[string](doc, "$.tags[1]"); !ok || v != "b" {
+		t.Errorf("DocValue(tags[1]) = %q, %v", v, ok)
+	}
+	if _, ok := DocValue[int64](doc, "$.name"); ok {
+		t.Error("DocValue(name) as int64 must fail")
+	}
+	if _, ok := DocValue[string](doc, "$.missing"); ok {
+		t.Error("DocValue(missing) must fail")
+	}
+
+	if err := SetDocValue(doc, "$.address.city", "Oslo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetDocValue(doc, "$.tags[0]", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetDocValue(doc, "$.tags[5]", "x"); err == nil {
+		t.Error("SetDocValue past the end of an array must fail")
+	}
+	want := map[string]any{
+		"name":    "box",
+		"size":    map[string]any{"width": float64(3)},
+		"tags":    []any{"c", "b"},
+		"code":    "42",
+		"address": map[string]any{"city": "Oslo"},
+	}
+	if diff := cmp.Diff(want, doc); diff != "" {
+		t.Errorf("document mismatch (-want +got):\n%s", diff)
+	}
+}
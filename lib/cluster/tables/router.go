@@ -0,0 +1,333 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package tables
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+)
+
+// ShardRouter maps a shard key to the index of the shard that owns it
+type ShardRouter interface {
+	Route(key string) (int, error)
+	NumShards() int
+}
+
+// HashRouter spreads keys uniformly over the shards using FNV-1a
+type HashRouter struct {
+	numShards int
+}
+
+// NewHashRouter creates a hash router over numShards shards
+func NewHashRouter(numShards int) (*HashRouter, error) {
+	if numShards <= 0 {
+		return nil, fmt.Errorf("shard count must be positive")
+	}
+	return &HashRouter{numShards: numShards}, nil
+}
+
+// Route returns the shard index for key
+func (r *HashRouter) Route(key string) (int, error) {
+	h := fnv.New32a()
+	_, err := h.Write([]byte(key))
+	if err != nil {
+		return 0, err
+	}
+	return int(h.Sum32() % uint32(r.numShards)), nil // #nosec G115
+}
+
+// NumShards returns the number of shards the router spreads keys over
+func (r *HashRouter) NumShards() int {
+	return r.numShards
+}
+
+// RangeRouter assigns contiguous key ranges to shards. Keys lower than
+// bounds[i] belong to shard i, keys from the last bound onward belong to the
+// last shard. Keys and bounds are compared as integers when both parse as
+// integers, otherwise lexicographically.
+type RangeRouter struct {
+	bounds []string
+}
+
+// NewRangeRouter creates a range router with len(bounds)+1 shards
+func NewRangeRouter(bounds []string) (*RangeRouter, error) {
+	for i := 1; i < len(bounds); i++ {
+		if compareKeys(bounds[i-1], bounds[i]) >= 0 {
+			return nil, fmt.Errorf("range bounds must be strictly ascending: %q >= %q", bounds[i-1], bounds[i])
+		}
+	}
+	return &RangeRouter{bounds: append([]string(nil), bounds...)}, nil
+}
+
+// Route returns the shard index for key
+func (r *RangeRouter) Route(key string) (int, error) {
+	lo, hi := 0, len(r.bounds)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if compareKeys(key, r.bounds[mid]) < 0 {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo, nil
+}
+
+// NumShards returns the number of ranges
+func (r *RangeRouter) NumShards() int {
+	return len(r.bounds) + 1
+}
+
+func compareKeys(a, b string) int {
+	ai, errA := strconv.ParseInt(a, 10, 64)
+	bi, errB := strconv.ParseInt(b, 10, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case ai < bi:
+			return -1
+		case ai > bi:
+			return 1
+		default:
+			return 0
+		}
+	}
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// DirectoryRouter looks keys up in an explicit key to shard directory. Keys
+// missing from the directory are passed to the fallback router, if any.
+type DirectoryRouter struct {
+	mu        sync.RWMutex
+	numShards int
+	entries   map[string]int
+	fallback  ShardRouter
+}
+
+// NewDirectoryRouter creates a directory router over numShards shards
+func NewDirectoryRouter(numShards int, entries map[string]int, fallback ShardRouter) (*DirectoryRouter, error) {
+	if numShards <= 0 {
+		return nil, fmt.Errorf("shard count must be positive")
+	}
+	if fallback != nil && fallback.NumShards() > numShards {
+		return nil, fmt.Errorf("fallback router uses %d shards, directory has %d", fallback.NumShards(), numShards)
+	}
+	r := &DirectoryRouter{
+		numShards: numShards,
+		entries:   make(map[string]int, len(entries)),
+		fallback:  fallback,
+	}
+	for key, shardIndex := range entries {
+		if err := r.Assign(key, shardIndex); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Assign places key on the given shard
+func (r *DirectoryRouter) Assign(key string, shardIndex int) error {
+	if shardIndex < 0 || shardIndex >= r.numShards {
+		return fmt.Errorf("shard index %d out of range [0, %d)", shardIndex, r.numShards)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[key] = shardIndex
+	return nil
+}
+
+// Remove deletes key from the directory
+func (r *DirectoryRouter) Remove(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, key)
+}
+
+// Route returns the shard index for key
+func (r *DirectoryRouter) Route(key string) (int, error) {
+	r.mu.RLock()
+	shardIndex, ok := r.entries[key]
+	r.mu.RUnlock()
+	if ok {
+		return shardIndex, nil
+	}
+	if r.fallback == nil {
+		return 0, fmt.Errorf("no shard assigned to key %q", key)
+	}
+	return r.fallback.Route(key)
+}
+
+// NumShards returns the number of shards in the directory
+func (r *DirectoryRouter) NumShards() int {
+	return r.numShards
+}
+
+// SetRouter replaces the shard key router, by default keys are hashed over all shards
+func (m *MultiDBConnector) SetRouter(router ShardRouter) error {
+	if router == nil {
+		return fmt.Errorf("router must not be nil")
+	}
+	if numShards := m.NumShards(); router.NumShards() > numShards {
+		return fmt.Errorf("router uses %d shards, connector has %d", router.NumShards(), numShards)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.router = router
+	return nil
+}
+
+// Router returns the shard key router of the connector
+func (m *MultiDBConnector) Router() ShardRouter {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.router
+}
+
+// ShardForKey returns the index of the shard that owns key
+func (m *MultiDBConnector) ShardForKey(key string) (int, error) {
+	router := m.Router()
+	if router == nil {
+		return 0, fmt.Errorf("no shard router configured")
+	}
+	shardIndex, err := router.Route(key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to route key %q: %w", key, err)
+	}
+	if numShards := m.NumShards(); shardIndex < 0 || shardIndex >= numShards {
+		return 0, fmt.Errorf("key %q routed to shard %d out of range [0, %d)", key, shardIndex, numShards)
+	}
+	return shardIndex, nil
+}
+
+// ReadByKey executes a read operation on the shard that owns key
+func (m *MultiDBConnector) ReadByKey(ctx context.Context, key string, operation func(*sql.DB) error, opts ...OperationOption) error {
+	shardIndex, err := m.ShardForKey(key)
+	if err != nil {
+		return err
+	}
+	return m.RetryReadOperation(ctx, shardIndex, operation, opts...)
+}
+
+// WriteByKey executes a write operation on the shard that owns key. During a
+// cutover the write is applied to the shard of the next router as well.
+func (m *MultiDBConnector) WriteByKey(ctx context.Context, key string, operation func(*sql.DB) error, opts ...OperationOption) error {
+	if err := m.refreshShardMap(ctx); err != nil {
+		return err
+	}
+	shardIndex, err := m.ShardForKey(key)
+	if err != nil {
+		return err
+	}
+	err = m.RetryWriteOperation(ctx, shardIndex, operation, opts...)
+	if err != nil {
+		return err
+	}
+	m.mutex.RLock()
+	next := m.nextRouter
+	m.mutex.RUnlock()
+	if next == nil {
+		return nil
+	}
+	nextIndex, err := next.Route(key)
+	if err != nil {
+		return fmt.Errorf("failed to route key %q with the next router: %w", key, err)
+	}
+	if nextIndex == shardIndex {
+		return nil
+	}
+	err = m.RetryWriteOperation(ctx, nextIndex, operation, opts...)
+	if err != nil {
+		return fmt.Errorf("cutover write to shard %d failed: %w", nextIndex, err)
+	}
+	return nil
+}
+
+// ErrKeyMoving is returned for writes to rows that cannot be copied to the
+// shard their key is moving to
+var ErrKeyMoving = errors.New("the key is moving to another shard")
+
+// WriteByKeyInPlace executes a write operation on the shard that owns key,
+// for tables whose rows cannot move between shards, such as tables with ids
+// generated on each shard. It fails with ErrKeyMoving during a cutover that
+// moves the key, rather than double writing a row that may take the id of
+// another one.
+func (m *MultiDBConnector) WriteByKeyInPlace(ctx context.Context, key string, operation func(*sql.DB) error,
+	opts ...OperationOption) error {
+	if err := m.refreshShardMap(ctx); err != nil {
+		return err
+	}
+	shardIndex, err := m.ShardForKey(key)
+	if err != nil {
+		return err
+	}
+	m.mutex.RLock()
+	next := m.nextRouter
+	m.mutex.RUnlock()
+	if next != nil {
+		nextIndex, err := next.Route(key)
+		if err != nil {
+			return fmt.Errorf("failed to route key %q with the next router: %w", key, err)
+		}
+		if nextIndex != shardIndex {
+			return fmt.Errorf("%w: key %q, shard %d to %d", ErrKeyMoving, key, shardIndex, nextIndex)
+		}
+	}
+	return m.RetryWriteOperation(ctx, shardIndex, operation, opts...)
+}
+
+// BeginCutover starts moving to the next router. Reads keep using the
+// current router while writes go to the shards of both routers.
+func (m *MultiDBConnector) BeginCutover(next ShardRouter) error {
+	if next == nil {
+		return fmt.Errorf("router must not be nil")
+	}
+	if numShards := m.NumShards(); next.NumShards() > numShards {
+		return fmt.Errorf("router uses %d shards, connector has %d", next.NumShards(), numShards)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.nextRouter != nil {
+		return fmt.Errorf("a cutover is already in progress")
+	}
+	m.nextRouter = next
+	return nil
+}
+
+// CommitCutover makes the next router the current one
+func (m *MultiDBConnector) CommitCutover() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.nextRouter == nil {
+		return fmt.Errorf("no cutover in progress")
+	}
+	m.router = m.nextRouter
+	m.nextRouter = nil
+	return nil
+}
+
+// AbortCutover stops double writing and keeps the current router
+func (m *MultiDBConnector) AbortCutover() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.nextRouter = nil
+}
+
+// NumShards returns the number of shards the connector knows about
+func (m *MultiDBConnector) NumShards() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.numShards
+}
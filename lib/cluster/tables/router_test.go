@@ -0,0 +1,154 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package tables
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// TestHashRouter checks that keys are stable and spread over every shard
+func TestHashRouter(t *testing.T) {
+	r, err := NewHashRouter(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make([]int, r.NumShards())
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		shardIndex, err := r.Route(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, _ := r.Route(key)
+		if again != shardIndex {
+			t.Fatalf("Route(%q) is not stable: %d != %d", key, shardIndex, again)
+		}
+		counts[shardIndex]++
+	}
+	for shardIndex, count := range counts {
+		if count < 800 {
+			t.Errorf("shard %d got %d of 3000 keys", shardIndex, count)
+		}
+	}
+	if _, err := NewHashRouter(0); err == nil {
+		t.Error("NewHashRouter(0) should fail")
+	}
+}
+
+// TestRangeRouter tests numeric and lexicographic range lookups
+func TestRangeRouter(t *testing.T) {
+	tests := []struct {
+		name   string
+		bounds []string
+		key    string
+		want   int
+	}{
+		{"numeric below first", []string{"100", "1000"}, "5", 0},
+		{"numeric on bound", []string{"100", "1000"}, "100", 1},
+		{"numeric middle", []string{"100", "1000"}, "999", 1},
+		{"numeric last", []string{"100", "1000"}, "20000", 2},
+		{"lexical first", []string{"h", "p"}, "apple", 0},
+		{"lexical middle", []string{"h", "p"}, "kiwi", 1},
+		{"lexical last", []string{"h", "p"}, "zucchini", 2},
+		{"single shard", nil, "anything", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRangeRouter(tt.bounds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := r.Route(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Route(%q) = %d, want %d", tt.key, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewRangeRouter([]string{"1000", "100"}); err == nil {
+		t.Error("descending bounds should fail")
+	}
+}
+
+// TestDirectoryRouter tests explicit assignments and the fallback router
+func TestDirectoryRouter(t *testing.T) {
+	fallback, _ := NewRangeRouter([]string{"m"})
+	r, err := NewDirectoryRouter(3, map[string]int{"acme": 2}, fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := r.Route("acme"); got != 2 {
+		t.Errorf("Route(acme) = %d, want 2", got)
+	}
+	if got, _ := r.Route("zeta"); got != 1 {
+		t.Errorf("Route(zeta) = %d, want 1 from fallback", got)
+	}
+	if err := r.Assign("zeta", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := r.Route("zeta"); got != 0 {
+		t.Errorf("Route(zeta) = %d, want 0 after assign", got)
+	}
+	if err := r.Assign("bad", 3); err == nil {
+		t.Error("Assign to shard 3 of 3 should fail")
+	}
+
+	noFallback, _ := NewDirectoryRouter(2, nil, nil)
+	if _, err := noFallback.Route("missing"); err == nil {
+		t.Error("Route without fallback should fail for unknown keys")
+	}
+}
+
+// TestShardForKey tests routing through the connector
+func TestShardForKey(t *testing.T) {
+	m := NewMultiDBConnector("mynet", "zygote", "zygote.run", "zygote", 6447, 6446, 3)
+	hashRouter, _ := NewHashRouter(3)
+	want, _ := hashRouter.Route("user-42")
+	if got, err := m.ShardForKey("user-42"); err != nil || got != want {
+		t.Errorf("ShardForKey(user-42) = %d, %v, want %d", got, err, want)
+	}
+
+	tooWide, _ := NewHashRouter(4)
+	if err := m.SetRouter(tooWide); err == nil {
+		t.Error("SetRouter should reject a router with more shards than the connector")
+	}
+
+	directory, _ := NewDirectoryRouter(3, map[string]int{"user-42": 1}, nil)
+	if err := m.SetRouter(directory); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := m.ShardForKey("user-42"); got != 1 {
+		t.Errorf("ShardForKey(user-42) = %d, want 1", got)
+	}
+}
+
+// TestWriteByKeyInPlace tests that writes to a moving key are refused
+func TestWriteByKeyInPlace(t *testing.T) {
+	m := NewMultiDBConnector("mynet", "zygote", "zygote.run", "zygote", 6447, 6446, 3)
+	next, _ := NewHashRouter(2)
+	if err := m.BeginCutover(next); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		from, _ := m.ShardForKey(key)
+		if to, _ := next.Route(key); to == from {
+			continue
+		}
+		err := m.WriteByKeyInPlace(context.Background(), key, func(*sql.DB) error { return nil })
+		if !errors.Is(err, ErrKeyMoving) {
+			t.Errorf("WriteByKeyInPlace(%s) error = %v, want ErrKeyMoving", key, err)
+		}
+		return
+	}
+}
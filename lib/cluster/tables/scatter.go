@@ -0,0 +1,662 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package tables
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+)
+
+// ShardError reports the failure of a scatter query on one shard
+type ShardError struct {
+	Shard int    `json:"shard"`
+	Error string `json:"error"`
+}
+
+// ScatterResult holds the merged rows of a query run on every shard
+type ScatterResult struct {
+	Columns []string         `json:"columns"`
+	Rows    []map[string]any `json:"rows"`
+	Errors  []ShardError     `json:"errors,omitempty"`
+}
+
+type aggregateFunc string
+
+const (
+	aggCount aggregateFunc = "COUNT"
+	aggSum   aggregateFunc = "SUM"
+	aggMin   aggregateFunc = "MIN"
+	aggMax   aggregateFunc = "MAX"
+)
+
+type orderKey struct {
+	expr string
+	desc bool
+}
+
+// scatterPlan describes how a SELECT is sent to the shards and how the
+// partial results are merged back
+type scatterPlan struct {
+	shardQuery string
+	aggregates []aggregateFunc
+	orderBy    []orderKey
+	limit      int
+	offset     int
+}
+
+// textTypes are compared by their collation, which merging cannot reproduce
+var textTypes = map[string]bool{"CHAR": true, "VARCHAR": true, "TEXT": true, "TINYTEXT": true, "MEDIUMTEXT": true,
+	"LONGTEXT": true, "ENUM": true, "SET": true, "JSON": true}
+
+// binaryTypes are compared byte by byte
+var binaryTypes = map[string]bool{"BINARY": true, "VARBINARY": true, "BLOB": true, "TINYBLOB": true,
+	"MEDIUMBLOB": true, "LONGBLOB": true, "BIT": true, "GEOMETRY": true}
+
+var (
+	aggregateCallRe = regexp.MustCompile(`(?i)\b(COUNT|SUM|MIN|MAX|AVG)\s*\(`)
+	aliasRe         = regexp.MustCompile("(?i)^\\s*((AS\\s+)?([A-Za-z_][A-Za-z0-9_$]*|`[^`]+`))?\\s*$")
+	distinctRe      = regexp.MustCompile(`(?i)^\s*DISTINCT\b`)
+)
+
+// ScanRows reads all rows into maps keyed by column name, byte slices are
+// returned as strings
+func ScanRows(rows *sql.Rows) ([]string, []map[string]any, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+	var results []map[string]any
+	for rows.Next() {
+		values := make([]any, len(columns))
+		valuePtrs := make([]any, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		row := make(map[string]any, len(columns))
+		for i, col := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[col] = string(b)
+			} else {
+				row[col] = values[i]
+			}
+		}
+		results = append(results, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return columns, results, nil
+}
+
+// ScatterQuery runs a SELECT on every shard concurrently and merges the
+// results. ORDER BY and LIMIT are applied to the merged rows and COUNT, SUM,
+// MIN and MAX are combined per GROUP BY group. Text columns compare by their
+// collation, so ORDER BY, MIN and MAX of text are rejected. Shards that fail
+// are reported in the result, an error is returned only if no shard answered.
+func (m *MultiDBConnector) ScatterQuery(ctx context.Context, query string, args ...any) (*ScatterResult, error) {
+	plan, err := planScatter(query)
+	if err != nil {
+		return nil, err
+	}
+
+	type shardResult struct {
+		columns []string
+		types   []string
+		rows    []map[string]any
+		err     error
+	}
+	numShards := m.NumShards()
+	shardResults := make([]shardResult, numShards)
+	var wg sync.WaitGroup
+	for shardIndex := 0; shardIndex < numShards; shardIndex++ {
+		wg.Add(1)
+		go func(shardIndex int) {
+			defer wg.Done()
+			r := &shardResults[shardIndex]
+			r.err = m.RetryReadOperation(ctx, shardIndex, func(db *sql.DB) error {
+				rows, err := db.QueryContext(ctx, plan.shardQuery, args...)
+				if err != nil {
+					return err
+				}
+				defer rows.Close()
+				columnTypes, err := rows.ColumnTypes()
+				if err != nil {
+					return err
+				}
+				r.types = make([]string, len(columnTypes))
+				for i, ct := range columnTypes {
+					r.types[i] = ct.DatabaseTypeName()
+				}
+				r.columns, r.rows, err = ScanRows(rows)
+				return err
+			})
+		}(shardIndex)
+	}
+	wg.Wait()
+
+	result := &ScatterResult{}
+	var types []string
+	var parts [][]map[string]any
+	for shardIndex, r := range shardResults {
+		if r.err != nil {
+			logger.Warning("Scatter query failed on shard", utils.M{"shard": shardIndex, "error": r.err.Error()})
+			result.Errors = append(result.Errors, ShardError{Shard: shardIndex, Error: r.err.Error()})
+			continue
+		}
+		if result.Columns == nil {
+			result.Columns, types = r.columns, r.types
+		}
+		parts = append(parts, r.rows)
+	}
+	if len(parts) == 0 {
+		return result, fmt.Errorf("query failed on all %d shards: %v", numShards, result.Errors)
+	}
+	result.Rows, err = plan.merge(result.Columns, types, parts)
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// planScatter parses the clauses of a SELECT that matter for merging
+func planScatter(query string) (*scatterPlan, error) {
+	q := strings.TrimRight(strings.TrimSpace(query), "; \t\n")
+	selectEnd, ok := matchKeyword(q, 0, "SELECT")
+	if !ok {
+		return nil, fmt.Errorf("only SELECT queries can be scattered")
+	}
+	plan := &scatterPlan{limit: -1}
+
+	fromStart, _ := findTopLevel(q, "FROM")
+	groupStart, _ := findTopLevel(q, "GROUP", "BY")
+	havingStart, _ := findTopLevel(q, "HAVING")
+	orderStart, orderEnd := findTopLevel(q, "ORDER", "BY")
+	limitStart, limitEnd := findTopLevel(q, "LIMIT")
+
+	selectList := q[selectEnd:]
+	if fromStart >= 0 {
+		selectList = q[selectEnd:fromStart]
+	}
+	for _, item := range splitTopLevel(selectList) {
+		agg, err := parseAggregate(item)
+		if err != nil {
+			return nil, err
+		}
+		plan.aggregates = append(plan.aggregates, agg)
+	}
+	hasAggregates := plan.hasAggregates()
+	if hasAggregates && havingStart >= 0 {
+		return nil, fmt.Errorf("HAVING cannot be merged across shards")
+	}
+	if !hasAggregates && groupStart >= 0 {
+		return nil, fmt.Errorf("GROUP BY without COUNT, SUM, MIN or MAX cannot be merged across shards")
+	}
+
+	if orderStart >= 0 {
+		end := len(q)
+		if limitStart > orderStart {
+			end = limitStart
+		}
+		for _, item := range splitTopLevel(q[orderEnd:end]) {
+			key := orderKey{expr: strings.TrimSpace(item)}
+			fields := strings.Fields(key.expr)
+			if len(fields) > 1 {
+				switch strings.ToUpper(fields[len(fields)-1]) {
+				case "DESC":
+					key.desc = true
+					key.expr = strings.TrimSpace(key.expr[:strings.LastIndex(key.expr, fields[len(fields)-1])])
+				case "ASC":
+					key.expr = strings.TrimSpace(key.expr[:strings.LastIndex(key.expr, fields[len(fields)-1])])
+				}
+			}
+			plan.orderBy = append(plan.orderBy, key)
+		}
+	}
+
+	base := q
+	if limitStart >= 0 {
+		var err error
+		plan.limit, plan.offset, err = parseLimit(q[limitEnd:])
+		if err != nil {
+			return nil, err
+		}
+		base = strings.TrimSpace(q[:limitStart])
+	}
+	switch {
+	case hasAggregates && orderStart >= 0:
+		plan.shardQuery = strings.TrimSpace(q[:orderStart])
+	case hasAggregates:
+		plan.shardQuery = base
+	case plan.limit >= 0:
+		plan.shardQuery = fmt.Sprintf("%s LIMIT %d", base, plan.offset+plan.limit)
+	default:
+		plan.shardQuery = base
+	}
+	return plan, nil
+}
+
+func (p *scatterPlan) hasAggregates() bool {
+	for _, agg := range p.aggregates {
+		if agg != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// merge combines the rows returned by each shard into one result set. The
+// database types of the columns, when known, decide how values compare.
+func (p *scatterPlan) merge(columns, types []string, parts [][]map[string]any) ([]map[string]any, error) {
+	var rows []map[string]any
+	if p.hasAggregates() {
+		if len(p.aggregates) != len(columns) {
+			return nil, fmt.Errorf("select list has %d items but the query returned %d columns", len(p.aggregates), len(columns))
+		}
+		var err error
+		rows, err = p.combine(columns, types, parts)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for _, part := range parts {
+			rows = append(rows, part...)
+		}
+	}
+
+	if len(p.orderBy) > 0 {
+		keys := make([]string, len(p.orderBy))
+		compares := make([]func(a, b any) int, len(p.orderBy))
+		for i, key := range p.orderBy {
+			column, err := resolveColumn(columns, key.expr)
+			if err != nil {
+				return nil, err
+			}
+			keys[i] = column
+			compares[i], err = columnCompare(columnType(columns, types, column))
+			if err != nil {
+				return nil, fmt.Errorf("ORDER BY %s cannot be merged across shards: %w", key.expr, err)
+			}
+		}
+		sort.SliceStable(rows, func(i, j int) bool {
+			for k, column := range keys {
+				c := compares[k](rows[i][column], rows[j][column])
+				if c == 0 {
+					continue
+				}
+				if p.orderBy[k].desc {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+	}
+
+	if p.offset >= len(rows) {
+		return []map[string]any{}, nil
+	}
+	rows = rows[p.offset:]
+	if p.limit >= 0 && p.limit < len(rows) {
+		rows = rows[:p.limit]
+	}
+	return rows, nil
+}
+
+// combine folds the partial aggregates of every shard per group
+func (p *scatterPlan) combine(columns, types []string, parts [][]map[string]any) ([]map[string]any, error) {
+	compares := make([]func(a, b any) int, len(columns))
+	for i, column := range columns {
+		if p.aggregates[i] != aggMin && p.aggregates[i] != aggMax {
+			continue
+		}
+		var err error
+		compares[i], err = columnCompare(columnType(columns, types, column))
+		if err != nil {
+			return nil, fmt.Errorf("%s(%s) cannot be merged across shards: %w", p.aggregates[i], column, err)
+		}
+	}
+	groups := map[string]map[string]any{}
+	var order []string
+	for _, part := range parts {
+		for _, row := range part {
+			var key strings.Builder
+			for i, column := range columns {
+				if p.aggregates[i] == "" {
+					fmt.Fprintf(&key, "%v\x00", row[column])
+				}
+			}
+			acc, ok := groups[key.String()]
+			if !ok {
+				acc = make(map[string]any, len(row))
+				for column, value := range row {
+					acc[column] = value
+				}
+				groups[key.String()] = acc
+				order = append(order, key.String())
+				continue
+			}
+			for i, column := range columns {
+				var err error
+				switch p.aggregates[i] {
+				case aggCount, aggSum:
+					acc[column], err = addValues(acc[column], row[column])
+				case aggMin:
+					if row[column] != nil && (acc[column] == nil || compares[i](row[column], acc[column]) < 0) {
+						acc[column] = row[column]
+					}
+				case aggMax:
+					if row[column] != nil && (acc[column] == nil || compares[i](row[column], acc[column]) > 0) {
+						acc[column] = row[column]
+					}
+				}
+				if err != nil {
+					return nil, fmt.Errorf("failed to combine %s: %w", column, err)
+				}
+			}
+		}
+	}
+	rows := make([]map[string]any, 0, len(order))
+	for _, key := range order {
+		rows = append(rows, groups[key])
+	}
+	return rows, nil
+}
+
+// columnType returns the database type of a column, empty if unknown
+func columnType(columns, types []string, column string) string {
+	for i, c := range columns {
+		if c == column && i < len(types) {
+			return types[i]
+		}
+	}
+	return ""
+}
+
+// columnCompare returns the comparison of the values of a database type.
+// Text is compared by a collation, so it cannot be ordered after the fact.
+func columnCompare(columnType string) (func(a, b any) int, error) {
+	switch {
+	case textTypes[columnType]:
+		return nil, fmt.Errorf("%s values compare by their collation, order by a numeric, temporal or binary column", columnType)
+	case binaryTypes[columnType]:
+		return compareBytes, nil
+	}
+	return compareValues, nil
+}
+
+// parseAggregate returns the aggregate function of a select item, or an
+// empty string if the item is a plain column
+func parseAggregate(item string) (aggregateFunc, error) {
+	item = strings.TrimSpace(item)
+	loc := aggregateCallRe.FindStringSubmatchIndex(item)
+	if loc == nil {
+		return "", nil
+	}
+	name := aggregateFunc(strings.ToUpper(item[loc[2]:loc[3]]))
+	closing := matchingParen(item, loc[1]-1)
+	if loc[0] != 0 || closing < 0 || !aliasRe.MatchString(item[closing+1:]) {
+		return "", fmt.Errorf("expression %q cannot be merged across shards", item)
+	}
+	if name == "AVG" {
+		return "", fmt.Errorf("AVG cannot be merged across shards, select SUM and COUNT instead")
+	}
+	if distinctRe.MatchString(item[loc[1]:closing]) && (name == aggCount || name == aggSum) {
+		return "", fmt.Errorf("%s(DISTINCT ...) cannot be merged across shards", name)
+	}
+	return name, nil
+}
+
+// parseLimit parses "n", "offset, n" and "n OFFSET offset"
+func parseLimit(clause string) (limit, offset int, err error) {
+	clause = strings.TrimSpace(clause)
+	fields := strings.Fields(strings.ReplaceAll(clause, ",", " , "))
+	atoi := func(s string) (int, error) {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid LIMIT clause %q", clause)
+		}
+		return v, nil
+	}
+	switch {
+	case len(fields) == 1:
+		limit, err = atoi(fields[0])
+	case len(fields) == 3 && fields[1] == ",":
+		if offset, err = atoi(fields[0]); err == nil {
+			limit, err = atoi(fields[2])
+		}
+	case len(fields) == 3 && strings.EqualFold(fields[1], "OFFSET"):
+		if limit, err = atoi(fields[0]); err == nil {
+			offset, err = atoi(fields[2])
+		}
+	default:
+		err = fmt.Errorf("invalid LIMIT clause %q", clause)
+	}
+	return limit, offset, err
+}
+
+// resolveColumn maps an ORDER BY expression to a result column
+func resolveColumn(columns []string, expr string) (string, error) {
+	if n, err := strconv.Atoi(expr); err == nil {
+		if n < 1 || n > len(columns) {
+			return "", fmt.Errorf("ORDER BY position %d out of range", n)
+		}
+		return columns[n-1], nil
+	}
+	name := strings.Trim(expr, "`")
+	for _, column := range columns {
+		if strings.EqualFold(column, name) {
+			return column, nil
+		}
+	}
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		unqualified := strings.Trim(name[i+1:], "`")
+		for _, column := range columns {
+			if strings.EqualFold(column, unqualified) {
+				return column, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("ORDER BY %s must appear in the select list to be merged across shards", expr)
+}
+
+// findTopLevel returns the start and end of the first occurrence of the
+// keyword outside quotes and parentheses, or -1, -1
+func findTopLevel(q string, words ...string) (start, end int) {
+	depth := 0
+	var quote rune
+	for i, r := range q {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case depth == 0 && (i == 0 || !isWordRune(rune(q[i-1]))):
+			if end, ok := matchKeyword(q, i, words...); ok {
+				return i, end
+			}
+		}
+	}
+	return -1, -1
+}
+
+// matchKeyword matches the keyword words at position i separated by spaces
+func matchKeyword(q string, i int, words ...string) (int, bool) {
+	for n, word := range words {
+		if n > 0 {
+			j := i
+			for j < len(q) && unicode.IsSpace(rune(q[j])) {
+				j++
+			}
+			if j == i {
+				return 0, false
+			}
+			i = j
+		}
+		if len(q) < i+len(word) || !strings.EqualFold(q[i:i+len(word)], word) {
+			return 0, false
+		}
+		i += len(word)
+	}
+	if i < len(q) && isWordRune(rune(q[i])) {
+		return 0, false
+	}
+	return i, true
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// splitTopLevel splits a list on commas outside quotes and parentheses
+func splitTopLevel(s string) []string {
+	var items []string
+	depth, start := 0, 0
+	var quote rune
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		items = append(items, last)
+	}
+	return items
+}
+
+// matchingParen returns the index of the parenthesis closing the one at open
+func matchingParen(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// toNumber converts numeric values and numeric strings to int64 or float64
+func toNumber(v any) (i int64, f float64, isInt, ok bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, float64(n), true, true
+	case int:
+		return int64(n), float64(n), true, true
+	case int32:
+		return int64(n), float64(n), true, true
+	case uint64:
+		return int64(n), float64(n), true, true // #nosec G115
+	case float64:
+		return 0, n, false, true
+	case float32:
+		return 0, float64(n), false, true
+	case string:
+		if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+			return i, float64(i), true, true
+		}
+		if f, err := strconv.ParseFloat(n, 64); err == nil {
+			return 0, f, false, true
+		}
+	}
+	return 0, 0, false, false
+}
+
+// addValues sums two partial COUNT or SUM values, NULL is the identity
+func addValues(a, b any) (any, error) {
+	if a == nil {
+		return b, nil
+	}
+	if b == nil {
+		return a, nil
+	}
+	ai, af, aInt, aOk := toNumber(a)
+	bi, bf, bInt, bOk := toNumber(b)
+	if !aOk || !bOk {
+		return nil, fmt.Errorf("cannot add %v and %v", a, b)
+	}
+	if aInt && bInt {
+		return ai + bi, nil
+	}
+	return af + bf, nil
+}
+
+// compareValues orders two column values, NULL sorts first
+func compareValues(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	if _, af, _, aOk := toNumber(a); aOk {
+		if _, bf, _, bOk := toNumber(b); bOk {
+			switch {
+			case af < bf:
+				return -1
+			case af > bf:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+	if at, ok := a.(time.Time); ok {
+		if bt, ok := b.(time.Time); ok {
+			return at.Compare(bt)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// compareBytes orders two values byte by byte, NULL sorts first
+func compareBytes(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
@@ -0,0 +1,154 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package tables
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestPlanScatter tests the per shard query rewrite
+func TestPlanScatter(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantQuery  string
+		wantLimit  int
+		wantOffset int
+		wantErr    bool
+	}{
+		{"plain", "SELECT id FROM users;", "SELECT id FROM users", -1, 0, false},
+		{"limit", "SELECT id FROM users ORDER BY id LIMIT 10", "SELECT id FROM users ORDER BY id LIMIT 10", 10, 0, false},
+		{"limit offset", "SELECT id FROM users ORDER BY id LIMIT 5, 10", "SELECT id FROM users ORDER BY id LIMIT 15", 10, 5, false},
+		{"offset keyword", "select id from users order by id limit 10 offset 20", "select id from users order by id LIMIT 30", 10, 20, false},
+		{"aggregate strips order and limit", "SELECT region, COUNT(*) AS n FROM users GROUP BY region ORDER BY n DESC LIMIT 2",
+			"SELECT region, COUNT(*) AS n FROM users GROUP BY region", 2, 0, false},
+		{"subquery limit untouched", "SELECT id FROM (SELECT id FROM users LIMIT 3) u", "SELECT id FROM (SELECT id FROM users LIMIT 3) u", -1, 0, false},
+		{"not a select", "DELETE FROM users", "", 0, 0, true},
+		{"avg", "SELECT AVG(age) FROM users", "", 0, 0, true},
+		{"count distinct", "SELECT COUNT(DISTINCT region) FROM users", "", 0, 0, true},
+		{"having", "SELECT region, COUNT(*) FROM users GROUP BY region HAVING COUNT(*) > 1", "", 0, 0, true},
+		{"expression", "SELECT COUNT(*) + 1 FROM users", "", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := planScatter(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("planScatter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if plan.shardQuery != tt.wantQuery {
+				t.Errorf("shardQuery = %q, want %q", plan.shardQuery, tt.wantQuery)
+			}
+			if plan.limit != tt.wantLimit || plan.offset != tt.wantOffset {
+				t.Errorf("limit, offset = %d, %d, want %d, %d", plan.limit, plan.offset, tt.wantLimit, tt.wantOffset)
+			}
+		})
+	}
+}
+
+// TestScatterMerge tests merging the partial results of several shards
+func TestScatterMerge(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		columns []string
+		types   []string
+		parts   [][]map[string]any
+		want    []map[string]any
+		wantErr bool
+	}{
+		{
+			name:    "order and limit",
+			query:   "SELECT id, name FROM users ORDER BY id DESC LIMIT 1, 2",
+			columns: []string{"id", "name"},
+			parts: [][]map[string]any{
+				{{"id": "9", "name": "a"}, {"id": "3", "name": "b"}},
+				{{"id": "10", "name": "c"}, {"id": "4", "name": "d"}},
+			},
+			want: []map[string]any{{"id": "9", "name": "a"}, {"id": "4", "name": "d"}},
+		},
+		{
+			name:    "global aggregates",
+			query:   "SELECT COUNT(*), SUM(total) AS total, MIN(age), MAX(age) FROM users",
+			columns: []string{"COUNT(*)", "total", "MIN(age)", "MAX(age)"},
+			parts: [][]map[string]any{
+				{{"COUNT(*)": "2", "total": "10.5", "MIN(age)": "30", "MAX(age)": "40"}},
+				{{"COUNT(*)": "3", "total": "4", "MIN(age)": "21", "MAX(age)": "35"}},
+				{{"COUNT(*)": "0", "total": nil, "MIN(age)": nil, "MAX(age)": nil}},
+			},
+			want: []map[string]any{{"COUNT(*)": int64(5), "total": 14.5, "MIN(age)": "21", "MAX(age)": "40"}},
+		},
+		{
+			name:    "group by",
+			query:   "SELECT region, COUNT(*) AS n FROM users GROUP BY region ORDER BY n DESC",
+			columns: []string{"region", "n"},
+			parts: [][]map[string]any{
+				{{"region": "eu", "n": int64(1)}, {"region": "us", "n": int64(2)}},
+				{{"region": "eu", "n": int64(4)}},
+			},
+			want: []map[string]any{{"region": "eu", "n": int64(5)}, {"region": "us", "n": int64(2)}},
+		},
+		{
+			name:    "offset past end",
+			query:   "SELECT id FROM users LIMIT 10 OFFSET 5",
+			columns: []string{"id"},
+			parts:   [][]map[string]any{{{"id": "1"}}},
+			want:    []map[string]any{},
+		},
+		{
+			name:    "numeric column",
+			query:   "SELECT id FROM users ORDER BY id",
+			columns: []string{"id"},
+			types:   []string{"BIGINT"},
+			parts:   [][]map[string]any{{{"id": "10"}}, {{"id": "9"}}},
+			want:    []map[string]any{{"id": "9"}, {"id": "10"}},
+		},
+		{
+			name:    "binary column",
+			query:   "SELECT code FROM users ORDER BY code",
+			columns: []string{"code"},
+			types:   []string{"VARBINARY"},
+			parts:   [][]map[string]any{{{"code": "9"}}, {{"code": "10"}}},
+			want:    []map[string]any{{"code": "10"}, {"code": "9"}},
+		},
+		{
+			name:    "text column",
+			query:   "SELECT name FROM users ORDER BY name",
+			columns: []string{"name"},
+			types:   []string{"VARCHAR"},
+			parts:   [][]map[string]any{{{"name": "b"}}, {{"name": "A"}}},
+			wantErr: true,
+		},
+		{
+			name:    "min of text",
+			query:   "SELECT MIN(name) FROM users",
+			columns: []string{"MIN(name)"},
+			types:   []string{"VARCHAR"},
+			parts:   [][]map[string]any{{{"MIN(name)": "b"}}, {{"MIN(name)": "A"}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := planScatter(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := plan.merge(tt.columns, tt.types, tt.parts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("merge() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("merge() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,354 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package tables
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+	"github.com/go-sql-driver/mysql"
+)
+
+// DefaultShardMapInterval is how often connectors reload the shared shard map
+const DefaultShardMapInterval = 5 * time.Second
+
+// ShardMapLease is how long a connector writes by the shard map it loaded.
+// Once the lease runs out the connector reloads the map before its next write,
+// so a connector that stopped acknowledging cannot write by an outdated map.
+const ShardMapLease = 3 * DefaultShardMapInterval
+
+// Acknowledgements older than this are removed when a shard map is published
+const staleConnectorAge = 24 * time.Hour
+
+// The shard map is kept on shard 0, where every connector can read it
+const shardMapShard = 0
+
+const (
+	errUnknownDatabase = 1049
+	errUnknownTable    = 1146
+)
+
+var shardMapSchema = []string{
+	"CREATE DATABASE IF NOT EXISTS zygote_meta",
+	`CREATE TABLE IF NOT EXISTS zygote_meta.shard_map (
+		id TINYINT UNSIGNED NOT NULL PRIMARY KEY,
+		shards INT NOT NULL,
+		next_shards INT NOT NULL DEFAULT 0,
+		version BIGINT UNSIGNED NOT NULL,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS zygote_meta.shard_map_connectors (
+		id VARCHAR(128) NOT NULL PRIMARY KEY,
+		version BIGINT UNSIGNED NOT NULL,
+		seen_at TIMESTAMP(6) NOT NULL,
+		KEY seen_at (seen_at)
+	)`,
+}
+
+// newConnectorID names a connector in the shard map acknowledgements
+func newConnectorID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// isUnknownTable tells whether err reports a missing database or table
+func isUnknownTable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && (mysqlErr.Number == errUnknownDatabase || mysqlErr.Number == errUnknownTable)
+}
+
+// createShardMapSchema creates the metadata tables of the shard map
+func createShardMapSchema(ctx context.Context, db *sql.DB) error {
+	for _, query := range shardMapSchema {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ShardMap is the shard layout shared by every connector of a cluster. Keys
+// are hashed over Shards. During a cutover NextShards is set and writes go to
+// the shards of both layouts.
+type ShardMap struct {
+	Shards     int
+	NextShards int
+	Version    uint64
+}
+
+// Validate checks the shard counts
+func (sm *ShardMap) Validate() error {
+	if sm.Shards <= 0 {
+		return fmt.Errorf("shard count must be positive")
+	}
+	if sm.NextShards < 0 {
+		return fmt.Errorf("next shard count must not be negative")
+	}
+	return nil
+}
+
+// LoadShardMap reads the shared shard map, nil if none was published
+func (m *MultiDBConnector) LoadShardMap(ctx context.Context) (*ShardMap, error) {
+	var sm *ShardMap
+	err := m.RetryWriteOperation(ctx, shardMapShard, func(db *sql.DB) error {
+		row := db.QueryRowContext(ctx, "SELECT shards, next_shards, version FROM zygote_meta.shard_map WHERE id = 1")
+		loaded := &ShardMap{}
+		err := row.Scan(&loaded.Shards, &loaded.NextShards, &loaded.Version)
+		switch {
+		case errors.Is(err, sql.ErrNoRows), isUnknownTable(err):
+			return nil
+		case err != nil:
+			return err
+		}
+		sm = loaded
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load shard map: %w", err)
+	}
+	return sm, nil
+}
+
+// PublishShardMap stores a shard map for every connector and applies it to
+// this one. It returns the published version, WaitForShardMap tells when the
+// other connectors loaded it.
+func (m *MultiDBConnector) PublishShardMap(ctx context.Context, sm *ShardMap) (uint64, error) {
+	if err := sm.Validate(); err != nil {
+		return 0, err
+	}
+	err := m.RetryWriteOperation(ctx, shardMapShard, func(db *sql.DB) error {
+		if err := createShardMapSchema(ctx, db); err != nil {
+			return err
+		}
+		_, err := db.ExecContext(ctx, `DELETE FROM zygote_meta.shard_map_connectors
+			WHERE seen_at < NOW(6) - INTERVAL ? SECOND`, int64(staleConnectorAge.Seconds()))
+		if err != nil {
+			return err
+		}
+		_, err = db.ExecContext(ctx, `INSERT INTO zygote_meta.shard_map (id, shards, next_shards, version)
+			VALUES (1, ?, ?, 1) AS new
+			ON DUPLICATE KEY UPDATE shards = new.shards, next_shards = new.next_shards, version = shard_map.version + 1`,
+			sm.Shards, sm.NextShards)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to publish shard map: %w", err)
+	}
+	if err := m.ReloadShardMap(ctx); err != nil {
+		return 0, err
+	}
+	return m.ShardMapVersion(), nil
+}
+
+// ShardMapVersion returns the version of the shard map the connector routes by
+func (m *MultiDBConnector) ShardMapVersion() uint64 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.shardMapVersion
+}
+
+// ReloadShardMap loads the shared shard map, applies it if it changed and
+// acknowledges the loaded version
+func (m *MultiDBConnector) ReloadShardMap(ctx context.Context) error {
+	m.reloadMutex.Lock()
+	defer m.reloadMutex.Unlock()
+	return m.reloadShardMap(ctx)
+}
+
+func (m *MultiDBConnector) reloadShardMap(ctx context.Context) error {
+	loadedAt := time.Now()
+	sm, err := m.LoadShardMap(ctx)
+	if err != nil {
+		return err
+	}
+	if sm != nil && sm.Version != m.ShardMapVersion() {
+		if err := m.ApplyShardMap(ctx, sm); err != nil {
+			return err
+		}
+	}
+	if err := m.ackShardMap(ctx); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	m.shardMapLoadedAt = loadedAt
+	m.mutex.Unlock()
+	return nil
+}
+
+// ackShardMap records the shard map version the connector routes by. The
+// acknowledgement doubles as the heartbeat of the connector.
+func (m *MultiDBConnector) ackShardMap(ctx context.Context) error {
+	version := m.ShardMapVersion()
+	ack := func(db *sql.DB) error {
+		_, err := db.ExecContext(ctx, `INSERT INTO zygote_meta.shard_map_connectors (id, version, seen_at)
+			VALUES (?, ?, NOW(6)) AS new
+			ON DUPLICATE KEY UPDATE version = new.version, seen_at = new.seen_at`, m.id, version)
+		return err
+	}
+	err := m.RetryWriteOperation(ctx, shardMapShard, func(db *sql.DB) error {
+		err := ack(db)
+		if !isUnknownTable(err) {
+			return err
+		}
+		if err := createShardMapSchema(ctx, db); err != nil {
+			return err
+		}
+		return ack(db)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to acknowledge shard map: %w", err)
+	}
+	return nil
+}
+
+// refreshShardMap reloads the shard map once the lease of the loaded one ran
+// out. Connectors that never loaded the shared map are left alone.
+func (m *MultiDBConnector) refreshShardMap(ctx context.Context) error {
+	if !m.shardMapExpired() {
+		return nil
+	}
+	m.reloadMutex.Lock()
+	defer m.reloadMutex.Unlock()
+	if !m.shardMapExpired() {
+		return nil
+	}
+	if err := m.reloadShardMap(ctx); err != nil {
+		return fmt.Errorf("shard map lease expired: %w", err)
+	}
+	return nil
+}
+
+func (m *MultiDBConnector) shardMapExpired() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return !m.shardMapLoadedAt.IsZero() && time.Since(m.shardMapLoadedAt) >= ShardMapLease
+}
+
+// PendingConnectors lists the live connectors that have not loaded the shard
+// map version yet. A connector is live while its lease runs.
+func (m *MultiDBConnector) PendingConnectors(ctx context.Context, version uint64) ([]string, error) {
+	var pending []string
+	err := m.RetryWriteOperation(ctx, shardMapShard, func(db *sql.DB) error {
+		pending = nil
+		rows, err := db.QueryContext(ctx, `SELECT id FROM zygote_meta.shard_map_connectors
+			WHERE version < ? AND seen_at > NOW(6) - INTERVAL ? MICROSECOND ORDER BY id`,
+			version, ShardMapLease.Microseconds())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			pending = append(pending, id)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list connectors: %w", err)
+	}
+	return pending, nil
+}
+
+// WaitForShardMap waits until every live connector acknowledged the shard
+// map version. Connectors whose lease ran out reload the map before they
+// write again, so they are not waited for.
+func (m *MultiDBConnector) WaitForShardMap(ctx context.Context, version uint64) error {
+	ticker := time.NewTicker(DefaultShardMapInterval)
+	defer ticker.Stop()
+	for {
+		pending, err := m.PendingConnectors(ctx, version)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		logger.Info("Waiting for connectors to load the shard map", utils.M{"version": version, "connectors": pending})
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ApplyShardMap routes keys by a shard map, connecting to the shards the
+// connector does not know yet
+func (m *MultiDBConnector) ApplyShardMap(ctx context.Context, sm *ShardMap) error {
+	if err := sm.Validate(); err != nil {
+		return err
+	}
+	router, err := NewHashRouter(sm.Shards)
+	if err != nil {
+		return err
+	}
+	var next ShardRouter
+	if sm.NextShards > 0 {
+		if next, err = NewHashRouter(sm.NextShards); err != nil {
+			return err
+		}
+	}
+	if err := m.growShards(ctx, max(sm.Shards, sm.NextShards)); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.router = router
+	m.nextRouter = next
+	m.shardMapVersion = sm.Version
+	logger.Info("Applied shard map", utils.M{"shards": sm.Shards, "nextShards": sm.NextShards, "version": sm.Version})
+	return nil
+}
+
+// growShards connects to the shards up to numShards
+func (m *MultiDBConnector) growShards(ctx context.Context, numShards int) error {
+	m.mutex.Lock()
+	if numShards <= m.numShards {
+		m.mutex.Unlock()
+		return nil
+	}
+	m.numShards = numShards
+	m.mutex.Unlock()
+	if _, err := m.ConnectAllShardsRead(ctx); err != nil {
+		return err
+	}
+	_, err := m.ConnectAllShardsWrite(ctx)
+	return err
+}
+
+// WatchShardMap reloads and acknowledges the shared shard map every interval
+// until ctx is done. Errors are logged and the current map is kept until its
+// lease runs out. The interval must be shorter than ShardMapLease.
+func (m *MultiDBConnector) WatchShardMap(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.ReloadShardMap(ctx); err != nil {
+				logger.Error("Failed to reload shard map, keeping the current one", err)
+			}
+		}
+	}
+}
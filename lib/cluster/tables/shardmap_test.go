@@ -0,0 +1,60 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package tables
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestApplyShardMap tests routing by a shared shard map within the known shards
+func TestApplyShardMap(t *testing.T) {
+	m := NewMultiDBConnector("mynet", "zygote", "zygote.run", "zygote", 6447, 6446, 3)
+	if err := m.ApplyShardMap(context.Background(), &ShardMap{Shards: 2, NextShards: 3, Version: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Router().NumShards(); got != 2 {
+		t.Errorf("Router().NumShards() = %d, want 2", got)
+	}
+	if err := m.BeginCutover(m.Router()); err == nil {
+		t.Error("BeginCutover should fail while the shard map has a cutover")
+	}
+	if err := m.ApplyShardMap(context.Background(), &ShardMap{Shards: 3, Version: 8}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CommitCutover(); err == nil {
+		t.Error("CommitCutover should fail after the shard map ended the cutover")
+	}
+	if m.shardMapVersion != 8 {
+		t.Errorf("shardMapVersion = %d, want 8", m.shardMapVersion)
+	}
+	if err := m.ApplyShardMap(context.Background(), &ShardMap{}); err == nil {
+		t.Error("ApplyShardMap should reject an empty shard map")
+	}
+}
+
+// TestShardMapExpired tests that only a shard map loaded longer than the lease
+// ago expires
+func TestShardMapExpired(t *testing.T) {
+	tests := []struct {
+		name     string
+		loadedAt time.Time
+		want     bool
+	}{
+		{"never loaded", time.Time{}, false},
+		{"within lease", time.Now().Add(-DefaultShardMapInterval), false},
+		{"lease ran out", time.Now().Add(-ShardMapLease), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMultiDBConnector("mynet", "zygote", "zygote.run", "zygote", 6447, 6446, 3)
+			m.shardMapLoadedAt = tt.loadedAt
+			if got := m.shardMapExpired(); got != tt.want {
+				t.Errorf("shardMapExpired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
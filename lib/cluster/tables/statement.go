@@ -0,0 +1,378 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package tables
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+)
+
+// Args are the parameters of a statement. A JSON array binds the ?
+// placeholders in order and a JSON object binds :name placeholders.
+type Args struct {
+	Positional []any
+	Named      map[string]any
+}
+
+// IsZero reports whether there are no parameters
+func (a Args) IsZero() bool {
+	return a.Positional == nil && a.Named == nil
+}
+
+// MarshalJSON encodes the parameters as an array or an object
+func (a Args) MarshalJSON() ([]byte, error) {
+	if a.Named != nil {
+		return json.Marshal(a.Named)
+	}
+	return json.Marshal(a.Positional)
+}
+
+// UnmarshalJSON decodes an array of positional or an object of named
+// parameters. Numbers are kept exact.
+func (a *Args) UnmarshalJSON(data []byte) error {
+	*a = Args{}
+	data = bytes.TrimSpace(data)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	switch {
+	case bytes.Equal(data, []byte("null")):
+		return nil
+	case len(data) > 0 && data[0] == '[':
+		return dec.Decode(&a.Positional)
+	case len(data) > 0 && data[0] == '{':
+		return dec.Decode(&a.Named)
+	default:
+		return fmt.Errorf("args must be an array or an object")
+	}
+}
+
+// Statement is a query with its parameters
+type Statement struct {
+	Query string `json:"query"`
+	Args  Args   `json:"args,omitzero"`
+}
+
+// Bind returns the query and the arguments to pass to database/sql. Named
+// placeholders are rewritten to ? since the MySQL driver binds by position
+// only.
+func (s Statement) Bind() (string, []any, error) {
+	if s.Args.Named == nil {
+		args, err := sqlArgs(s.Args.Positional)
+		return s.Query, args, err
+	}
+	query, values, err := bindNamed(s.Query, s.Args.Named)
+	if err != nil {
+		return "", nil, err
+	}
+	args, err := sqlArgs(values)
+	return query, args, err
+}
+
+// sqlArgs converts JSON values to driver values, objects and arrays are
+// passed as JSON text
+func sqlArgs(values []any) ([]any, error) {
+	args := make([]any, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				args[i] = n
+			} else if f, err := v.Float64(); err == nil {
+				args[i] = f
+			} else {
+				return nil, fmt.Errorf("invalid number %s", v)
+			}
+		case map[string]any, []any:
+			data, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode argument %d: %w", i+1, err)
+			}
+			args[i] = string(data)
+		default:
+			args[i] = v
+		}
+	}
+	return args, nil
+}
+
+// bindNamed replaces the :name placeholders outside quotes and comments with
+// ? and returns their values in order
+func bindNamed(query string, named map[string]any) (string, []any, error) {
+	var b strings.Builder
+	var values []any
+	used := make(map[string]bool, len(named))
+	rs := []rune(query)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '\'' || r == '"' || r == '`':
+			j := i + 1
+			for j < len(rs) && rs[j] != r {
+				if rs[j] == '\\' && r != '`' {
+					j++
+				}
+				j++
+			}
+			b.WriteString(string(rs[i:min(j+1, len(rs))]))
+			i = j
+		case utils.IsSQLLineComment(rs, i):
+			j := i
+			for j < len(rs) && rs[j] != '\n' {
+				j++
+			}
+			b.WriteString(string(rs[i:j]))
+			i = j - 1
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			j := i + 2
+			for j+1 < len(rs) && (rs[j] != '*' || rs[j+1] != '/') {
+				j++
+			}
+			b.WriteString(string(rs[i:min(j+2, len(rs))]))
+			i = j + 1
+		case r == '?':
+			return "", nil, fmt.Errorf("? placeholders cannot be mixed with named args")
+		case r == ':' && i+1 < len(rs) && (rs[i+1] == '_' || unicode.IsLetter(rs[i+1])) &&
+			(i == 0 || !isWordRune(rs[i-1]) && rs[i-1] != ':'):
+			j := i + 1
+			for j < len(rs) && isWordRune(rs[j]) {
+				j++
+			}
+			name := string(rs[i+1 : j])
+			v, ok := named[name]
+			if !ok {
+				return "", nil, fmt.Errorf("missing value for :%s", name)
+			}
+			used[name] = true
+			values = append(values, v)
+			b.WriteRune('?')
+			i = j - 1
+		default:
+			b.WriteRune(r)
+		}
+	}
+	for name := range named {
+		if !used[name] {
+			return "", nil, fmt.Errorf("unused arg %s", name)
+		}
+	}
+	return b.String(), values, nil
+}
+
+// Column describes a column of a result
+type Column struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Nullable  *bool  `json:"nullable,omitempty"`
+	Length    *int64 `json:"length,omitempty"`
+	Precision *int64 `json:"precision,omitempty"`
+	Scale     *int64 `json:"scale,omitempty"`
+}
+
+// StatementResult is the outcome of a statement, the columns and rows of a
+// query or the rows affected by a change. Rows are omitted when there are
+// none.
+type StatementResult struct {
+	Columns      []Column         `json:"columns,omitempty"`
+	Rows         []map[string]any `json:"rows,omitempty"`
+	RowsAffected *int64           `json:"rowsAffected,omitempty"`
+	LastInsertID *int64           `json:"lastInsertId,omitempty"`
+}
+
+// queryer is a connection pool or a transaction
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// rowKeywords start the statements that return rows
+var rowKeywords = []string{"SELECT", "SHOW", "DESCRIBE", "DESC", "EXPLAIN", "WITH", "VALUES", "TABLE"}
+
+// ReturnsRows reports whether a statement is a query rather than a change
+func ReturnsRows(query string) bool {
+	return slices.Contains(rowKeywords, leadingKeyword(query))
+}
+
+// leadingKeyword returns the first word of a statement in upper case,
+// skipping comments and parentheses
+func leadingKeyword(query string) string {
+	q := query
+	for {
+		q = strings.TrimLeftFunc(q, func(r rune) bool { return unicode.IsSpace(r) || r == '(' })
+		switch {
+		case utils.IsSQLLineComment([]rune(q), 0):
+			_, q, _ = strings.Cut(q, "\n")
+		case strings.HasPrefix(q, "/*!"):
+			q = strings.TrimLeftFunc(q[3:], unicode.IsDigit)
+		case strings.HasPrefix(q, "/*"):
+			_, q, _ = strings.Cut(q[2:], "*/")
+		default:
+			end := strings.IndexFunc(q, func(r rune) bool { return !isWordRune(r) })
+			if end < 0 {
+				end = len(q)
+			}
+			return strings.ToUpper(q[:end])
+		}
+	}
+}
+
+// runStatement binds the parameters of a statement and runs it as a query or
+// an exec
+func runStatement(ctx context.Context, q queryer, s Statement) (*StatementResult, error) {
+	query, args, err := s.Bind()
+	if err != nil {
+		return nil, err
+	}
+	if !ReturnsRows(query) {
+		res, err := q.ExecContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		result := &StatementResult{}
+		if n, err := res.RowsAffected(); err == nil {
+			result.RowsAffected = &n
+		}
+		if id, err := res.LastInsertId(); err == nil && id != 0 {
+			result.LastInsertID = &id
+		}
+		return result, nil
+	}
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, results, err := ScanTypedRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &StatementResult{Columns: columns, Rows: results}, nil
+}
+
+// ScanTypedRows reads all rows like ScanRows and describes their columns.
+// Integers, floats and JSON documents read as text are decoded by the type
+// of their column.
+func ScanTypedRows(rows *sql.Rows) ([]Column, []map[string]any, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get column types: %w", err)
+	}
+	names, results, err := ScanRows(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := make([]Column, len(types))
+	for i, ct := range types {
+		columns[i] = Column{Name: names[i], Type: ct.DatabaseTypeName()}
+		if nullable, ok := ct.Nullable(); ok {
+			columns[i].Nullable = &nullable
+		}
+		if length, ok := ct.Length(); ok {
+			columns[i].Length = &length
+		}
+		if precision, scale, ok := ct.DecimalSize(); ok {
+			columns[i].Precision, columns[i].Scale = &precision, &scale
+		}
+	}
+	for _, row := range results {
+		for _, c := range columns {
+			row[c.Name] = typedValue(c.Type, row[c.Name])
+		}
+	}
+	return columns, results, nil
+}
+
+// typedValue decodes a text value of a column by its database type
+func typedValue(columnType string, v any) any {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	unsigned := strings.HasPrefix(columnType, "UNSIGNED ")
+	switch strings.TrimPrefix(columnType, "UNSIGNED ") {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR":
+		if unsigned {
+			if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+				return n
+			}
+		} else if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	case "FLOAT", "DOUBLE":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case "JSON":
+		if json.Valid([]byte(s)) {
+			return json.RawMessage(s)
+		}
+	}
+	return v
+}
+
+// Execute runs a statement on a shard, on the write pool if write is set
+func (m *MultiDBConnector) Execute(ctx context.Context, shardIndex int, s Statement, write bool,
+	opts ...OperationOption) (*StatementResult, error) {
+	var result *StatementResult
+	operation := func(db *sql.DB) error {
+		var err error
+		result, err = runStatement(ctx, db, s)
+		return err
+	}
+	var err error
+	if write {
+		// a write that may have reached the server is not run again
+		err = m.RetryWriteOperation(ctx, shardIndex, func(db *sql.DB) error {
+			return retryUnsent(operation(db))
+		}, opts...)
+	} else {
+		err = m.RetryReadOperation(ctx, shardIndex, operation, opts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ExecuteBatch runs statements in one transaction on the write pool of a
+// shard. The transaction is rolled back if any statement fails. It is retried
+// only before COMMIT, as the server rolls back a transaction whose connection
+// broke.
+func (m *MultiDBConnector) ExecuteBatch(ctx context.Context, shardIndex int, stmts []Statement,
+	opts ...OperationOption) ([]StatementResult, error) {
+	var results []StatementResult
+	err := m.RetryWriteOperation(ctx, shardIndex, func(db *sql.DB) error {
+		results = make([]StatementResult, 0, len(stmts))
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback() //nolint:errcheck
+		for i, s := range stmts {
+			result, err := runStatement(ctx, tx, s)
+			if err != nil {
+				return fmt.Errorf("statement %d: %w", i+1, err)
+			}
+			results = append(results, *result)
+		}
+		if err := tx.Commit(); err != nil {
+			// the transaction may have committed
+			return utils.Permanent(err)
+		}
+		return nil
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
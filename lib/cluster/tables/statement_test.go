@@ -0,0 +1,196 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package tables
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestStatementBind tests decoding args from JSON and binding them
+func TestStatementBind(t *testing.T) {
+	tests := []struct {
+		name      string
+		statement string
+		wantQuery string
+		wantArgs  []any
+		wantErr   bool
+	}{
+		{
+			name:      "positional",
+			statement: `{"query": "SELECT * FROM t WHERE id = ? AND score > ? AND name = ?", "args": [42, 1.5, "bob"]}`,
+			wantQuery: "SELECT * FROM t WHERE id = ? AND score > ? AND name = ?",
+			wantArgs:  []any{int64(42), 1.5, "bob"},
+		},
+		{
+			name:      "large integer is exact",
+			statement: `{"query": "SELECT ?", "args": [9007199254740993]}`,
+			wantQuery: "SELECT ?",
+			wantArgs:  []any{int64(9007199254740993)},
+		},
+		{
+			name:      "null, bool and JSON document",
+			statement: `{"query": "INSERT INTO t VALUES (?, ?, ?)", "args": [null, true, {"a": [1, 2]}]}`,
+			wantQuery: "INSERT INTO t VALUES (?, ?, ?)",
+			wantArgs:  []any{nil, true, `{"a":[1,2]}`},
+		},
+		{
+			name:      "no args",
+			statement: `{"query": "SELECT 1"}`,
+			wantQuery: "SELECT 1",
+			wantArgs:  []any{},
+		},
+		{
+			name:      "named",
+			statement: `{"query": "UPDATE t SET name = :name WHERE id = :id OR parent = :id", "args": {"id": 7, "name": "x"}}`,
+			wantQuery: "UPDATE t SET name = ? WHERE id = ? OR parent = ?",
+			wantArgs:  []any{"x", int64(7), int64(7)},
+		},
+		{
+			name: "named skips quotes and comments",
+			statement: `{"query": "SELECT ':a', ` + "`:b`" + `, \"it\\'s :c\" /* :d */ FROM t WHERE x = :e AND @v := 1 -- :f\n",` +
+				` "args": {"e": 1}}`,
+			wantQuery: "SELECT ':a', `:b`, \"it\\'s :c\" /* :d */ FROM t WHERE x = ? AND @v := 1 -- :f\n",
+			wantArgs:  []any{int64(1)},
+		},
+		{
+			name:      "missing named arg",
+			statement: `{"query": "SELECT :a, :b", "args": {"a": 1}}`,
+			wantErr:   true,
+		},
+		{
+			name:      "unused named arg",
+			statement: `{"query": "SELECT :a", "args": {"a": 1, "b": 2}}`,
+			wantErr:   true,
+		},
+		{
+			name:      "mixed placeholders",
+			statement: `{"query": "SELECT :a, ?", "args": {"a": 1}}`,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s Statement
+			if err := json.Unmarshal([]byte(tt.statement), &s); err != nil {
+				t.Fatal(err)
+			}
+			query, args, err := s.Bind()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Bind() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if query != tt.wantQuery {
+				t.Errorf("Bind() query = %q, want %q", query, tt.wantQuery)
+			}
+			if diff := cmp.Diff(tt.wantArgs, args); diff != "" {
+				t.Errorf("Bind() args mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestArgsJSON tests that args keep their form through JSON
+func TestArgsJSON(t *testing.T) {
+	for _, doc := range []string{`{"query":"SELECT ?","args":[1,"a"]}`, `{"query":"SELECT :a","args":{"a":1}}`, `{"query":"SELECT 1"}`} {
+		var s Statement
+		if err := json.Unmarshal([]byte(doc), &s); err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != doc {
+			t.Errorf("Marshal() = %s, want %s", data, doc)
+		}
+	}
+	var a Args
+	if err := json.Unmarshal([]byte(`"x"`), &a); err == nil {
+		t.Error("Unmarshal() accepted a string")
+	}
+}
+
+// TestReturnsRows tests telling queries from changes
+func TestReturnsRows(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"SELECT 1", true},
+		{"  (select id from t) union (select id from u)", true},
+		{"-- list\nSHOW TABLES", true},
+		{"--\nSELECT 1", true},
+		{"--1\nSELECT 1", false},
+		{"/* hint */ WITH x AS (SELECT 1) SELECT * FROM x", true},
+		{"desc t", true},
+		{"/*!40101 SELECT 1 */", true},
+		{"INSERT INTO t VALUES (1)", false},
+		{"# note\nUPDATE t SET a = 1", false},
+		{"CREATE TABLE t (id INT)", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ReturnsRows(tt.query); got != tt.want {
+			t.Errorf("ReturnsRows(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+// TestTypedValue tests decoding text values by column type
+func TestTypedValue(t *testing.T) {
+	tests := []struct {
+		columnType string
+		value      any
+		want       any
+	}{
+		{"BIGINT", "-12", int64(-12)},
+		{"UNSIGNED BIGINT", "18446744073709551615", uint64(18446744073709551615)},
+		{"DOUBLE", "2.5", 2.5},
+		{"DECIMAL", "10.10", "10.10"},
+		{"JSON", `{"a":1}`, json.RawMessage(`{"a":1}`)},
+		{"VARCHAR", "12", "12"},
+		{"INT", int64(3), int64(3)},
+		{"INT", nil, nil},
+	}
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, typedValue(tt.columnType, tt.value)); diff != "" {
+			t.Errorf("typedValue(%s, %v) mismatch (-want +got):\n%s", tt.columnType, tt.value, diff)
+		}
+	}
+}
+
+// TestRetryUnsent tests that only writes that never reached the server stay
+// retryable
+func TestRetryUnsent(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		permanent bool
+	}{
+		{"no error", nil, false},
+		{"bad connection", fmt.Errorf("exec: %w", driver.ErrBadConn), false},
+		{"dial", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, false},
+		{"read", &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, true},
+		{"invalid connection", errors.New("invalid connection"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var permanent *utils.PermanentError
+			if got := errors.As(retryUnsent(tt.err), &permanent); got != tt.permanent {
+				t.Errorf("retryUnsent() permanent = %v, want %v", got, tt.permanent)
+			}
+		})
+	}
+}
@@ -0,0 +1,524 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package tables
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+)
+
+const xaPrefix = "zygote-"
+const xaLogDirPermission = 0700
+const xaLogFilePermission = 0600
+
+var xidPartRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+var coordinatorRe = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// XAState is the coordinator decision recorded for a distributed transaction
+type XAState string
+
+const (
+	// XACommit records that every branch prepared and the transaction must commit
+	XACommit XAState = "commit"
+	// XADone records that every branch committed
+	XADone XAState = "done"
+)
+
+// XALogEntry is one record of the coordinator log
+type XALogEntry struct {
+	GTRID  string    `json:"gtrid"`
+	Shards []int     `json:"shards"`
+	State  XAState   `json:"state"`
+	Time   time.Time `json:"time"`
+}
+
+// CoordinatorLog durably records commit decisions of distributed transactions.
+// Coordinator names the coordinator that writes the log, it is part of the
+// id of every transaction so recovery resolves only its own branches.
+type CoordinatorLog interface {
+	Record(entry XALogEntry) error
+	Pending() ([]XALogEntry, error)
+	Coordinator() (string, error)
+}
+
+// FileLog is a coordinator log stored as JSON lines in a local file. The
+// coordinator id is kept next to it in a .id file.
+type FileLog struct {
+	mu          sync.Mutex
+	path        string
+	coordinator string
+}
+
+// NewFileLog creates a coordinator log at path
+func NewFileLog(path string) *FileLog {
+	return &FileLog{path: path}
+}
+
+// DefaultXALogPath returns the coordinator log path of a tenant
+func DefaultXALogPath(tenant string) string {
+	return filepath.Join(utils.UserHome(), ".config", "zygote", "xa", fmt.Sprintf("%s.log", tenant))
+}
+
+// Record appends an entry and syncs it to disk
+func (l *FileLog) Record(entry XALogEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), xaLogDirPermission); err != nil {
+		return fmt.Errorf("failed to create coordinator log directory: %w", err)
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, xaLogFilePermission)
+	if err != nil {
+		return fmt.Errorf("failed to open coordinator log: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write coordinator log: %w", err)
+	}
+	return f.Sync()
+}
+
+// Coordinator returns the id of the coordinator, created with the log
+func (l *FileLog) Coordinator() (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.coordinator != "" {
+		return l.coordinator, nil
+	}
+	path := l.path + ".id"
+	if err := os.MkdirAll(filepath.Dir(path), xaLogDirPermission); err != nil {
+		return "", fmt.Errorf("failed to create coordinator log directory: %w", err)
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate coordinator id: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, xaLogFilePermission)
+	switch {
+	case err == nil:
+		_, err = f.WriteString(hex.EncodeToString(b))
+		if err == nil {
+			err = f.Sync()
+		}
+		f.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to write coordinator id: %w", err)
+		}
+	case !errors.Is(err, os.ErrExist):
+		return "", fmt.Errorf("failed to create coordinator id: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read coordinator id: %w", err)
+	}
+	id := strings.TrimSpace(string(data))
+	if !coordinatorRe.MatchString(id) {
+		return "", fmt.Errorf("invalid coordinator id %q in %s", id, path)
+	}
+	l.coordinator = id
+	return id, nil
+}
+
+// Pending returns the transactions decided to commit that are not done yet
+func (l *FileLog) Pending() ([]XALogEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open coordinator log: %w", err)
+	}
+	defer f.Close()
+	return pendingEntries(f)
+}
+
+// pendingEntries keeps the last state of every transaction and returns the
+// ones not done. A torn last line left by a crash is ignored.
+func pendingEntries(f *os.File) ([]XALogEntry, error) {
+	last := map[string]XALogEntry{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry XALogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logger.Warning("Skipping unreadable coordinator log line", utils.M{"line": scanner.Text()})
+			continue
+		}
+		last[entry.GTRID] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	var pending []XALogEntry
+	for _, entry := range last {
+		if entry.State != XADone {
+			pending = append(pending, entry)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Time.Before(pending[j].Time) })
+	return pending, nil
+}
+
+// xid formats the identifier of a transaction branch for XA statements
+func xid(gtrid string, shardIndex int) string {
+	return fmt.Sprintf("'%s','%d'", gtrid, shardIndex)
+}
+
+// DistributedTx is a transaction spanning several shards committed with XA
+// two phase commit. Branches are started lazily on first use of a shard.
+type DistributedTx struct {
+	mu        sync.Mutex
+	connector *MultiDBConnector
+	log       CoordinatorLog
+	gtrid     string
+	branches  map[int]*sql.Conn
+	failed    map[int]bool
+	done      bool
+}
+
+// BeginDistributed starts a distributed transaction that records its commit
+// decision in log
+func (m *MultiDBConnector) BeginDistributed(log CoordinatorLog) (*DistributedTx, error) {
+	if log == nil {
+		return nil, fmt.Errorf("coordinator log is required")
+	}
+	coordinator, err := log.Coordinator()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate transaction id: %w", err)
+	}
+	return &DistributedTx{
+		connector: m,
+		log:       log,
+		gtrid:     coordinatorPrefix(coordinator) + hex.EncodeToString(b),
+		branches:  map[int]*sql.Conn{},
+		failed:    map[int]bool{},
+	}, nil
+}
+
+// ID returns the global transaction id
+func (tx *DistributedTx) ID() string {
+	return tx.gtrid
+}
+
+// branch returns the connection of a shard, starting its XA branch if needed
+func (tx *DistributedTx) branch(ctx context.Context, shardIndex int) (*sql.Conn, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return nil, fmt.Errorf("transaction %s already finished", tx.gtrid)
+	}
+	if conn, ok := tx.branches[shardIndex]; ok {
+		return conn, nil
+	}
+	db, err := tx.connector.GetWriteConnection(shardIndex)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection for shard %d: %w", shardIndex, err)
+	}
+	if _, err := conn.ExecContext(ctx, "XA START "+xid(tx.gtrid, shardIndex)); err != nil {
+		discard(conn)
+		return nil, fmt.Errorf("failed to start XA branch on shard %d: %w", shardIndex, err)
+	}
+	tx.branches[shardIndex] = conn
+	return conn, nil
+}
+
+// Exec executes a statement on a shard inside the transaction
+func (tx *DistributedTx) Exec(ctx context.Context, shardIndex int, query string, args ...any) (sql.Result, error) {
+	conn, err := tx.branch(ctx, shardIndex)
+	if err != nil {
+		return nil, err
+	}
+	return conn.ExecContext(ctx, query, args...)
+}
+
+// Query runs a query on a shard inside the transaction
+func (tx *DistributedTx) Query(ctx context.Context, shardIndex int, query string, args ...any) (*sql.Rows, error) {
+	conn, err := tx.branch(ctx, shardIndex)
+	if err != nil {
+		return nil, err
+	}
+	return conn.QueryContext(ctx, query, args...)
+}
+
+// ExecByKey executes a statement on the shard that owns key
+func (tx *DistributedTx) ExecByKey(ctx context.Context, key, query string, args ...any) (sql.Result, error) {
+	if err := tx.connector.refreshShardMap(ctx); err != nil {
+		return nil, err
+	}
+	shardIndex, err := tx.connector.ShardForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return tx.Exec(ctx, shardIndex, query, args...)
+}
+
+func (tx *DistributedTx) shards() []int {
+	shards := make([]int, 0, len(tx.branches))
+	for shardIndex := range tx.branches {
+		shards = append(shards, shardIndex)
+	}
+	sort.Ints(shards)
+	return shards
+}
+
+// discard closes a connection without returning it to the pool, for
+// branches left in an unknown XA state
+func discard(conn *sql.Conn) {
+	_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+}
+
+// finish returns the branches that ended cleanly to the pool and discards
+// the others
+func (tx *DistributedTx) finish() {
+	for shardIndex, conn := range tx.branches {
+		if tx.failed[shardIndex] {
+			discard(conn)
+			continue
+		}
+		conn.Close()
+	}
+	tx.done = true
+}
+
+// Commit prepares every branch and commits them once all prepared. The
+// decision is logged before the first XA COMMIT, so branches left prepared by
+// a crash are committed by RecoverXA.
+func (tx *DistributedTx) Commit(ctx context.Context) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return fmt.Errorf("transaction %s already finished", tx.gtrid)
+	}
+	defer tx.finish()
+	shards := tx.shards()
+	if len(shards) == 0 {
+		return nil
+	}
+	for _, shardIndex := range shards {
+		if _, err := tx.branches[shardIndex].ExecContext(ctx, "XA END "+xid(tx.gtrid, shardIndex)); err != nil {
+			tx.failed[shardIndex] = true
+			tx.rollback(ctx, shards)
+			return fmt.Errorf("failed to end XA branch on shard %d: %w", shardIndex, err)
+		}
+	}
+	if len(shards) == 1 {
+		shardIndex := shards[0]
+		if _, err := tx.branches[shardIndex].ExecContext(ctx, "XA COMMIT "+xid(tx.gtrid, shardIndex)+" ONE PHASE"); err != nil {
+			tx.failed[shardIndex] = true
+			return fmt.Errorf("failed to commit XA branch on shard %d: %w", shardIndex, err)
+		}
+		return nil
+	}
+	for _, shardIndex := range shards {
+		if _, err := tx.branches[shardIndex].ExecContext(ctx, "XA PREPARE "+xid(tx.gtrid, shardIndex)); err != nil {
+			tx.rollback(ctx, shards)
+			return fmt.Errorf("failed to prepare XA branch on shard %d: %w", shardIndex, err)
+		}
+	}
+	err := tx.log.Record(XALogEntry{GTRID: tx.gtrid, Shards: shards, State: XACommit})
+	if err != nil {
+		tx.rollback(ctx, shards)
+		return fmt.Errorf("failed to record commit decision: %w", err)
+	}
+	var errs []error
+	for _, shardIndex := range shards {
+		if _, err := tx.branches[shardIndex].ExecContext(ctx, "XA COMMIT "+xid(tx.gtrid, shardIndex)); err != nil {
+			tx.failed[shardIndex] = true
+			errs = append(errs, fmt.Errorf("shard %d: %w", shardIndex, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("transaction %s is in doubt, run recovery to finish it: %v", tx.gtrid, errs)
+	}
+	return tx.log.Record(XALogEntry{GTRID: tx.gtrid, Shards: shards, State: XADone})
+}
+
+// Rollback rolls back every branch of the transaction
+func (tx *DistributedTx) Rollback(ctx context.Context) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.done {
+		return nil
+	}
+	defer tx.finish()
+	shards := tx.shards()
+	for _, shardIndex := range shards {
+		// XA ROLLBACK needs the branch ended, an error means it already was
+		_, _ = tx.branches[shardIndex].ExecContext(ctx, "XA END "+xid(tx.gtrid, shardIndex))
+	}
+	return tx.rollback(ctx, shards)
+}
+
+func (tx *DistributedTx) rollback(ctx context.Context, shards []int) error {
+	var errs []error
+	for _, shardIndex := range shards {
+		if _, err := tx.branches[shardIndex].ExecContext(ctx, "XA ROLLBACK "+xid(tx.gtrid, shardIndex)); err != nil {
+			tx.failed[shardIndex] = true
+			errs = append(errs, fmt.Errorf("shard %d: %w", shardIndex, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to roll back transaction %s: %v", tx.gtrid, errs)
+	}
+	return nil
+}
+
+// XARecovery reports the in-doubt branches resolved by RecoverXA. Skipped
+// branches belong to other coordinators.
+type XARecovery struct {
+	Committed  []string `json:"committed"`
+	RolledBack []string `json:"rolled_back"`
+	Skipped    []string `json:"skipped,omitempty"`
+}
+
+// coordinatorPrefix starts the ids of the transactions of a coordinator
+func coordinatorPrefix(coordinator string) string {
+	return xaPrefix + coordinator + "-"
+}
+
+// xaResolution returns the statement that resolves a prepared branch, empty
+// for branches of other coordinators. A branch is committed if its decision
+// is in the log, and rolled back if this coordinator started it.
+func xaResolution(gtrid, prefix string, decided map[string]XALogEntry) string {
+	if _, ok := decided[gtrid]; ok {
+		return "XA COMMIT "
+	}
+	if strings.HasPrefix(gtrid, prefix) {
+		return "XA ROLLBACK "
+	}
+	return ""
+}
+
+// parseXID splits the data column of XA RECOVER into its gtrid and shard
+func parseXID(data string, gtridLength, bqualLength int) (string, int, error) {
+	if gtridLength < 0 || bqualLength < 0 || gtridLength+bqualLength > len(data) {
+		return "", 0, fmt.Errorf("invalid xid %q", data)
+	}
+	gtrid := data[:gtridLength]
+	shardIndex, err := strconv.Atoi(data[gtridLength : gtridLength+bqualLength])
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid branch qualifier in xid %q", data)
+	}
+	if !xidPartRe.MatchString(gtrid) {
+		return "", 0, fmt.Errorf("invalid gtrid in xid %q", data)
+	}
+	return gtrid, shardIndex, nil
+}
+
+// RecoverXA resolves branches left prepared on the shards by the coordinator
+// of the log. Branches whose commit decision is in the log are committed, the
+// other branches of the coordinator are rolled back and the branches of other
+// coordinators are left alone. It must not run while the coordinator is
+// committing distributed transactions.
+func (m *MultiDBConnector) RecoverXA(ctx context.Context, log CoordinatorLog) (*XARecovery, error) {
+	coordinator, err := log.Coordinator()
+	if err != nil {
+		return nil, err
+	}
+	prefix := coordinatorPrefix(coordinator)
+	pending, err := log.Pending()
+	if err != nil {
+		return nil, err
+	}
+	decided := map[string]XALogEntry{}
+	for _, entry := range pending {
+		decided[entry.GTRID] = entry
+	}
+
+	report := &XARecovery{}
+	failed := map[string]bool{}
+	for shardIndex := 0; shardIndex < m.NumShards(); shardIndex++ {
+		err := m.RetryWriteOperation(ctx, shardIndex, func(db *sql.DB) error {
+			rows, err := db.QueryContext(ctx, "XA RECOVER")
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			type branch struct {
+				gtrid      string
+				shardIndex int
+			}
+			var branches []branch
+			for rows.Next() {
+				var formatID, gtridLength, bqualLength int
+				var data string
+				if err := rows.Scan(&formatID, &gtridLength, &bqualLength, &data); err != nil {
+					return err
+				}
+				gtrid, branchShard, err := parseXID(data, gtridLength, bqualLength)
+				if err != nil || len(gtrid) < len(xaPrefix) || gtrid[:len(xaPrefix)] != xaPrefix {
+					continue
+				}
+				branches = append(branches, branch{gtrid: gtrid, shardIndex: branchShard})
+			}
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			for _, b := range branches {
+				statement := xaResolution(b.gtrid, prefix, decided)
+				if statement == "" {
+					report.Skipped = append(report.Skipped, b.gtrid)
+					continue
+				}
+				committed := statement == "XA COMMIT "
+				if _, err := db.ExecContext(ctx, statement+xid(b.gtrid, b.shardIndex)); err != nil {
+					failed[b.gtrid] = true
+					logger.Error("Failed to resolve XA branch", err, utils.M{"gtrid": b.gtrid, "shard": shardIndex})
+					continue
+				}
+				if committed {
+					report.Committed = append(report.Committed, b.gtrid)
+				} else {
+					report.RolledBack = append(report.RolledBack, b.gtrid)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return report, fmt.Errorf("failed to recover shard %d: %w", shardIndex, err)
+		}
+	}
+	for gtrid, entry := range decided {
+		if failed[gtrid] {
+			continue
+		}
+		if err := log.Record(XALogEntry{GTRID: gtrid, Shards: entry.Shards, State: XADone}); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,132 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package tables
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestFileLogPending checks that only undone commit decisions are pending
+func TestFileLogPending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "xa", "test.log")
+	log := NewFileLog(path)
+
+	pending, err := log.Pending()
+	if err != nil || pending != nil {
+		t.Fatalf("Pending() on missing log = %v, %v", pending, err)
+	}
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []XALogEntry{
+		{GTRID: "zygote-a", Shards: []int{0, 1}, State: XACommit, Time: start},
+		{GTRID: "zygote-b", Shards: []int{1, 2}, State: XACommit, Time: start.Add(time.Second)},
+		{GTRID: "zygote-a", Shards: []int{0, 1}, State: XADone, Time: start.Add(2 * time.Second)},
+	}
+	for _, entry := range entries {
+		if err := log.Record(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a crash may leave a torn line at the end of the log
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"gtrid":"zygote-c","sha`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	pending, err = log.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]XALogEntry{entries[1]}, pending); diff != "" {
+		t.Errorf("Pending() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestFileLogCoordinator checks that the coordinator id is kept with the log
+func TestFileLogCoordinator(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "xa", "test.log")
+	id, err := NewFileLog(path).Coordinator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !coordinatorRe.MatchString(id) {
+		t.Errorf("Coordinator() = %q, want 16 hex digits", id)
+	}
+	again, err := NewFileLog(path).Coordinator()
+	if err != nil || again != id {
+		t.Errorf("Coordinator() of the same log = %q, %v, want %q", again, err, id)
+	}
+	other, err := NewFileLog(filepath.Join(dir, "xa", "other.log")).Coordinator()
+	if err != nil || other == id {
+		t.Errorf("Coordinator() of another log = %q, %v, want a new id", other, err)
+	}
+}
+
+// TestXAResolution tests that only the branches of the coordinator are
+// resolved
+func TestXAResolution(t *testing.T) {
+	prefix := coordinatorPrefix("0123456789abcdef")
+	decided := map[string]XALogEntry{
+		prefix + "aa": {State: XACommit},
+		"zygote-bb":   {State: XACommit},
+	}
+	tests := []struct {
+		gtrid string
+		want  string
+	}{
+		{prefix + "aa", "XA COMMIT "},
+		{"zygote-bb", "XA COMMIT "},
+		{prefix + "cc", "XA ROLLBACK "},
+		{coordinatorPrefix("fedcba9876543210") + "cc", ""},
+		{"zygote-dd", ""},
+	}
+	for _, tt := range tests {
+		if got := xaResolution(tt.gtrid, prefix, decided); got != tt.want {
+			t.Errorf("xaResolution(%q) = %q, want %q", tt.gtrid, got, tt.want)
+		}
+	}
+}
+
+// TestParseXID tests splitting XA RECOVER data into gtrid and shard
+func TestParseXID(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		gtridLength int
+		bqualLength int
+		wantGTRID   string
+		wantShard   int
+		wantErr     bool
+	}{
+		{"single digit shard", "zygote-abc1", 10, 1, "zygote-abc", 1, false},
+		{"two digit shard", "zygote-abc12", 10, 2, "zygote-abc", 12, false},
+		{"too short", "zygote", 10, 1, "", 0, true},
+		{"non numeric bqual", "zygote-abcx", 10, 1, "", 0, true},
+		{"quote in gtrid", "zyg'te-abc1", 10, 1, "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gtrid, shardIndex, err := parseXID(tt.data, tt.gtridLength, tt.bqualLength)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseXID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gtrid != tt.wantGTRID || shardIndex != tt.wantShard {
+				t.Errorf("parseXID() = %q, %d, want %q, %d", gtrid, shardIndex, tt.wantGTRID, tt.wantShard)
+			}
+		})
+	}
+}
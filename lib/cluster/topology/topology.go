@@ -0,0 +1,236 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+// Package topology describes the cluster shape declared in a zygote.toml file.
+package topology
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/evgnomon/zygote/lib/cluster/container"
+	"github.com/evgnomon/zygote/lib/cluster/db"
+	"github.com/evgnomon/zygote/lib/cluster/mem"
+	"github.com/evgnomon/zygote/lib/cluster/utils"
+	toml "github.com/pelletier/go-toml/v2"
+)
+
+// CurrentVersion is the topology file format version understood by this build.
+const CurrentVersion = 1
+
+// DefaultFileName is the topology file looked up in the current repo.
+const DefaultFileName = "zygote.toml"
+
+// FileEnvVar names the topology file of the servers, which do not run in the repo.
+const FileEnvVar = "ZYGOTE_TOPOLOGY_FILE"
+
+const defaultShards = 3
+const defaultReplicas = 3
+const maxReplicas = 9
+const defaultGroupName = "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+const defaultSQLUser = "admin"
+const defaultMigrationDir = "sqls"
+const fileMode = 0644
+
+var logger = utils.NewLogger()
+
+// Topology is the typed model of a zygote.toml file.
+type Topology struct {
+	Version  int        `toml:"version"`
+	Tenant   string     `toml:"tenant"`
+	Domain   string     `toml:"domain"`
+	Network  string     `toml:"network"`
+	Shards   int        `toml:"shards"`
+	Replicas int        `toml:"replicas"`
+	SQL      SQLService `toml:"sql"`
+	Mem      MemService `toml:"mem"`
+}
+
+// SQLService holds the options of the SQL nodes and their routers.
+type SQLService struct {
+	Image                string `toml:"image"`
+	Port                 int    `toml:"port"`
+	GroupReplicationPort int    `toml:"group_replication_port"`
+	RouterReadWritePort  int    `toml:"router_read_write_port"`
+	RouterReadOnlyPort   int    `toml:"router_read_only_port"`
+	GroupName            string `toml:"group_name"`
+	Database             string `toml:"database"`
+	User                 string `toml:"user"`
+	MigrationDir         string `toml:"migrations"`
+}
+
+// MemService holds the options of the memory nodes.
+type MemService struct {
+	Image       string `toml:"image"`
+	Port        int    `toml:"port"`
+	NodeTimeout int    `toml:"node_timeout"`
+	AppendOnly  bool   `toml:"append_only"`
+}
+
+// Default returns the topology used when the repo has no topology file.
+func Default() *Topology {
+	return &Topology{
+		Version:  CurrentVersion,
+		Tenant:   utils.TenantName(),
+		Domain:   utils.DomainName(),
+		Network:  container.AppNetworkName(),
+		Shards:   defaultShards,
+		Replicas: defaultReplicas,
+		SQL: SQLService{
+			Image:                db.DefaultImage,
+			Port:                 db.DefaultPort,
+			GroupReplicationPort: db.DefaultGroupReplicationPort,
+			RouterReadWritePort:  db.DefaultRouterReadWritePort,
+			RouterReadOnlyPort:   db.DefaultRouterReadOnlyPort,
+			GroupName:            defaultGroupName,
+			Database:             utils.TenantName(),
+			User:                 defaultSQLUser,
+			MigrationDir:         defaultMigrationDir,
+		},
+		Mem: MemService{
+			Image:       mem.DefaultImage,
+			Port:        mem.DefaultPort,
+			NodeTimeout: mem.DefaultNodeTimeout,
+			AppendOnly:  true,
+		},
+	}
+}
+
+// Parse decodes a topology document. Fields missing from the document keep
+// their default values.
+func Parse(doc []byte) (*Topology, error) {
+	t := Default()
+	if err := toml.Unmarshal(doc, t); err != nil {
+		return nil, fmt.Errorf("failed to parse topology: %w", err)
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// DefaultPath returns the topology file set by ZYGOTE_TOPOLOGY_FILE, or the
+// one in the current directory.
+func DefaultPath() string {
+	if path := os.Getenv(FileEnvVar); path != "" {
+		return path
+	}
+	return DefaultFileName
+}
+
+// Load reads and validates the topology file at path.
+func Load(path string) (*Topology, error) {
+	doc, err := os.ReadFile(path) // #nosec G304
+	if err != nil {
+		return nil, fmt.Errorf("failed to read topology file %s: %w", path, err)
+	}
+	t, err := Parse(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// LoadOrDefault loads the topology file at path if it exists, otherwise it
+// returns the default topology. The second value reports whether the file was found.
+func LoadOrDefault(path string) (*Topology, bool, error) {
+	if !utils.PathExists(path) {
+		logger.Debug("Topology file not found, using defaults", utils.M{"path": path})
+		return Default(), false, nil
+	}
+	t, err := Load(path)
+	if err != nil {
+		return nil, false, err
+	}
+	return t, true, nil
+}
+
+// Save writes the topology to path.
+func (t *Topology) Save(path string) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	doc, err := toml.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("failed to encode topology: %w", err)
+	}
+	if err := os.WriteFile(path, doc, fileMode); err != nil {
+		return fmt.Errorf("failed to write topology file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks that the topology describes a cluster that can be created.
+func (t *Topology) Validate() error {
+	if t.Version != CurrentVersion {
+		return fmt.Errorf("unsupported topology version %d, expected %d", t.Version, CurrentVersion)
+	}
+	if t.Tenant == "" {
+		return fmt.Errorf("tenant is required")
+	}
+	if t.Domain == "" {
+		return fmt.Errorf("domain is required")
+	}
+	if t.Shards <= 0 {
+		return fmt.Errorf("shards must be positive, got %d", t.Shards)
+	}
+	if t.Replicas <= 0 || t.Replicas > maxReplicas {
+		return fmt.Errorf("replicas must be between 1 and %d, got %d", maxReplicas, t.Replicas)
+	}
+	ports := map[string]int{
+		"sql.port":                   t.SQL.Port,
+		"sql.group_replication_port": t.SQL.GroupReplicationPort,
+		"sql.router_read_write_port": t.SQL.RouterReadWritePort,
+		"sql.router_read_only_port":  t.SQL.RouterReadOnlyPort,
+		"mem.port":                   t.Mem.Port,
+	}
+	for name, port := range ports {
+		if port <= 0 {
+			return fmt.Errorf("%s must be positive, got %d", name, port)
+		}
+	}
+	if t.SQL.Image == "" || t.Mem.Image == "" {
+		return fmt.Errorf("sql.image and mem.image are required")
+	}
+	return nil
+}
+
+// SQLNode returns the SQL node of the given shard and replica.
+func (t *Topology) SQLNode(shardIndex, repIndex int) *db.SQLNode {
+	sn := db.NewSQLNode()
+	sn.Tenant = t.Tenant
+	sn.Domain = t.Domain
+	sn.NetworkName = t.Network
+	sn.DatabaseName = t.SQL.Database
+	sn.User = t.SQL.User
+	sn.GroupName = t.SQL.GroupName
+	sn.MigrationDir = t.SQL.MigrationDir
+	sn.Image = t.SQL.Image
+	sn.Port = t.SQL.Port
+	sn.GroupReplicationPort = t.SQL.GroupReplicationPort
+	sn.RouterReadWritePort = t.SQL.RouterReadWritePort
+	sn.RouterReadOnlyPort = t.SQL.RouterReadOnlyPort
+	sn.NumShards = t.Shards
+	sn.ShardSize = t.Replicas
+	sn.ShardIndex = shardIndex
+	sn.RepIndex = repIndex
+	return sn
+}
+
+// MemNode returns the memory node of the given shard and replica.
+func (t *Topology) MemNode(shardIndex, repIndex int) *mem.MemNode {
+	mn := mem.NewMemNode()
+	mn.Tenant = t.Tenant
+	mn.Domain = t.Domain
+	mn.NetworkName = t.Network
+	mn.Image = t.Mem.Image
+	mn.Port = t.Mem.Port
+	mn.NodeTimeout = t.Mem.NodeTimeout
+	mn.AppendOnly = t.Mem.AppendOnly
+	mn.NumShards = t.Shards
+	mn.ShardSize = t.Replicas
+	mn.ShardIndex = shardIndex
+	mn.RepIndex = repIndex
+	return mn
+}
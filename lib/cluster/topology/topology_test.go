@@ -0,0 +1,129 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package topology
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/evgnomon/zygote/lib/cluster/db"
+	"github.com/evgnomon/zygote/lib/cluster/mem"
+)
+
+func TestParse(t *testing.T) {
+	doc := []byte(`
+version = 1
+tenant = "acme"
+domain = "acme.dev"
+shards = 2
+replicas = 1
+
+[sql]
+image = "mysql:custom"
+port = 3307
+
+[mem]
+append_only = false
+`)
+	got, err := Parse(doc)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.Tenant != "acme" || got.Domain != "acme.dev" {
+		t.Errorf("Parse() tenant/domain = %q/%q", got.Tenant, got.Domain)
+	}
+	if got.Shards != 2 || got.Replicas != 1 {
+		t.Errorf("Parse() shards/replicas = %d/%d, want 2/1", got.Shards, got.Replicas)
+	}
+	if got.SQL.Image != "mysql:custom" || got.SQL.Port != 3307 {
+		t.Errorf("Parse() sql = %+v", got.SQL)
+	}
+	if got.SQL.GroupReplicationPort != db.DefaultGroupReplicationPort {
+		t.Errorf("Parse() kept group replication port %d, want default %d",
+			got.SQL.GroupReplicationPort, db.DefaultGroupReplicationPort)
+	}
+	if got.Mem.AppendOnly {
+		t.Errorf("Parse() mem.append_only = true, want false")
+	}
+	if got.Mem.Port != mem.DefaultPort {
+		t.Errorf("Parse() mem.port = %d, want default %d", got.Mem.Port, mem.DefaultPort)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"unsupported version", "version = 2"},
+		{"zero shards", "shards = 0"},
+		{"too many replicas", "replicas = 10"},
+		{"negative port", "[sql]\nport = -1"},
+		{"empty tenant", `tenant = ""`},
+		{"malformed", "shards = "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.doc)); err == nil {
+				t.Errorf("Parse(%q) error = nil, want error", tt.doc)
+			}
+		})
+	}
+}
+
+func TestLoadOrDefault(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, DefaultFileName)
+
+	got, found, err := LoadOrDefault(path)
+	if err != nil || found {
+		t.Fatalf("LoadOrDefault() missing file = %v, %v", found, err)
+	}
+	if got.Shards != defaultShards || got.Replicas != defaultReplicas {
+		t.Errorf("LoadOrDefault() default shape = %dx%d", got.Shards, got.Replicas)
+	}
+
+	if err := os.WriteFile(path, []byte("shards = 5\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, found, err = LoadOrDefault(path)
+	if err != nil || !found {
+		t.Fatalf("LoadOrDefault() existing file = %v, %v", found, err)
+	}
+	if got.Shards != 5 {
+		t.Errorf("LoadOrDefault() shards = %d, want 5", got.Shards)
+	}
+
+	if got := DefaultPath(); got != DefaultFileName {
+		t.Errorf("DefaultPath() = %q, want %q", got, DefaultFileName)
+	}
+	t.Setenv(FileEnvVar, path)
+	if got := DefaultPath(); got != path {
+		t.Errorf("DefaultPath() = %q, want %q", got, path)
+	}
+}
+
+func TestNodes(t *testing.T) {
+	topo := Default()
+	topo.Tenant = "acme"
+	topo.Shards = 4
+	topo.Replicas = 2
+	topo.SQL.Port = 3307
+	topo.Mem.Port = 7000
+
+	sn := topo.SQLNode(3, 1)
+	if sn.Tenant != "acme" || sn.ShardIndex != 3 || sn.RepIndex != 1 {
+		t.Errorf("SQLNode() = %+v", sn)
+	}
+	if sn.NumShards != 4 || sn.ShardSize != 2 || sn.Port != 3307 {
+		t.Errorf("SQLNode() shape = %d/%d port %d", sn.NumShards, sn.ShardSize, sn.Port)
+	}
+
+	mn := topo.MemNode(3, 1)
+	if mn.Tenant != "acme" || mn.ShardIndex != 3 || mn.RepIndex != 1 || mn.Port != 7000 {
+		t.Errorf("MemNode() = %+v", mn)
+	}
+}
@@ -6,6 +6,7 @@ package utils
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"time"
@@ -18,6 +19,24 @@ type BackoffConfig struct {
 	MaxDelay     time.Duration
 }
 
+// PermanentError stops Retry, which returns the wrapped error
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks an error that must not be retried
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
 // ExponentialBackoff executes a function with exponential backoff retry
 func (config BackoffConfig) Retry(ctx context.Context, fn func() error) error {
 	for attempt := 0; attempt < config.MaxAttempts; attempt++ {
@@ -25,6 +44,10 @@ func (config BackoffConfig) Retry(ctx context.Context, fn func() error) error {
 		if err == nil {
 			return nil
 		}
+		var permanent *PermanentError
+		if errors.As(err, &permanent) {
+			return permanent.Err
+		}
 
 		// Log the error for this attempt
 		logger.Debug("Backoff attempt failed", M{
@@ -0,0 +1,40 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPermanent(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name      string
+		err       error
+		wantCalls int
+	}{
+		{"retried", errFailed, 3},
+		{"permanent", Permanent(errFailed), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := BackoffConfig{MaxAttempts: 3, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}
+			calls := 0
+			err := b.Retry(context.Background(), func() error {
+				calls++
+				return tt.err
+			})
+			if !errors.Is(err, errFailed) {
+				t.Errorf("Retry() error = %v, want %v", err, errFailed)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("Retry() called the function %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
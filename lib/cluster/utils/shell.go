@@ -16,6 +16,7 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"time"
 
 	toml "github.com/pelletier/go-toml/v2"
 )
@@ -237,8 +238,8 @@ func EncryptContent(content, filename, gpgKey string) (string, error) {
 	// Construct output filename
 	outputFile := filepath.Join(secretsDir, filename+".asc")
 
-	// Run gpg command
-	cmd := exec.Command("gpg", "-e", "-r", gpgKey, "--armor", "-o", outputFile)
+	// Run gpg command, replacing the previous content of the file
+	cmd := exec.Command("gpg", "--yes", "-e", "-r", gpgKey, "--armor", "-o", outputFile)
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
 		return "", fmt.Errorf("failed to create stdin: %v", err)
@@ -284,6 +285,39 @@ func DecryptFile(filename string) error {
 	return nil
 }
 
+// DecryptContent returns the decrypted content of a file in the secrets
+// directory
+func DecryptContent(filename string) (string, error) {
+	if filename == "" {
+		return "", fmt.Errorf("please provide a file to decrypt")
+	}
+	inputFile := filepath.Join(secretsDir, filename+".asc")
+	cmd := exec.Command("gpg", "--quiet", "-d", inputFile)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("decryption of %s failed: %v", inputFile, err)
+	}
+	return out.String(), nil
+}
+
+// SecretExists reports whether an encrypted file exists in the secrets
+// directory
+func SecretExists(filename string) bool {
+	return PathExists(filepath.Join(secretsDir, filename+".asc"))
+}
+
+// SecretModTime returns the modification time of an encrypted file in the
+// secrets directory
+func SecretModTime(filename string) (time.Time, error) {
+	info, err := os.Stat(filepath.Join(secretsDir, filename+".asc"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
 func RepoFullName() string {
 	repoPath, err := os.Getwd()
 	logger.FatalIfErr("Error getting current directory", err)
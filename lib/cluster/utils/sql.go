@@ -0,0 +1,19 @@
+/*
+Copyright (C) 2025- Hamed Ghasemzadeh. All rights reserved.
+License: HGL General License <https://evgnomon.org/docs/hgl>
+*/
+package utils
+
+import "unicode"
+
+// IsSQLLineComment reports whether a # or -- comment starts at i. MySQL needs
+// a space after --, so that a--1 is an expression.
+func IsSQLLineComment(rs []rune, i int) bool {
+	if i >= len(rs) {
+		return false
+	}
+	if rs[i] == '#' {
+		return true
+	}
+	return rs[i] == '-' && i+1 < len(rs) && rs[i+1] == '-' && (i+2 == len(rs) || unicode.IsSpace(rs[i+2]))
+}